@@ -0,0 +1,147 @@
+package api
+
+import (
+	"github.com/AndersKaae/legaldesk_psp_sync/money"
+	"time"
+)
+
+// AdditionalCostResponse models the additional cost API response
+type AdditionalCostResponse struct {
+	Handle        string     `json:"handle"`
+	Customer      string     `json:"customer"`
+	Subscription  string     `json:"subscription"`
+	State         string     `json:"state"`
+	Invoice       string     `json:"invoice"`
+	Amount        int64      `json:"amount"`
+	Vat           float64    `json:"vat"`
+	Quantity      int        `json:"quantity"`
+	Ordertext     string     `json:"ordertext"`
+	AmountInclVat bool       `json:"amount_incl_vat"`
+	Created       time.Time  `json:"created"`
+	Transferred   *time.Time `json:"transferred"`
+	Cancelled     *time.Time `json:"cancelled"`
+}
+
+// AdditionalCostListResponse models the additional cost list API response
+type AdditionalCostListResponse struct {
+	AdditionalCosts []AdditionalCostResponse `json:"content"`
+	NextPage        string                   `json:"next_page_token"`
+}
+
+// CreditResponse models the customer credit API response
+type CreditResponse struct {
+	Handle       string     `json:"handle"`
+	Customer     string     `json:"customer"`
+	Subscription string     `json:"subscription"`
+	State        string     `json:"state"`
+	Invoice      string     `json:"invoice"`
+	Amount       int64      `json:"amount"`
+	Text         string     `json:"text"`
+	Created      time.Time  `json:"created"`
+	Transferred  *time.Time `json:"transferred"`
+	Cancelled    *time.Time `json:"cancelled"`
+}
+
+// CreditListResponse models the credit list API response
+type CreditListResponse struct {
+	Credits  []CreditResponse `json:"content"`
+	NextPage string           `json:"next_page_token"`
+}
+
+// AdditionalCost is your domain model
+type AdditionalCost struct {
+	Handle        string
+	Customer      string
+	Subscription  string
+	State         string
+	Invoice       string
+	Amount        money.Money
+	Vat           float64
+	Quantity      int
+	Ordertext     string
+	AmountInclVat bool
+	Created       time.Time
+	Transferred   *time.Time
+	Cancelled     *time.Time
+	Country       string
+}
+
+// Credit is your domain model
+type Credit struct {
+	Handle       string
+	Customer     string
+	Subscription string
+	State        string
+	Invoice      string
+	Amount       money.Money
+	Text         string
+	Created      time.Time
+	Transferred  *time.Time
+	Cancelled    *time.Time
+	Country      string
+}
+
+// mapAdditionalCost maps API response -> domain AdditionalCost
+func mapAdditionalCost(r AdditionalCostResponse, country string) AdditionalCost {
+	return AdditionalCost{
+		Handle:        r.Handle,
+		Customer:      r.Customer,
+		Subscription:  r.Subscription,
+		State:         r.State,
+		Invoice:       r.Invoice,
+		Amount:        money.New(r.Amount, AccountCurrency(country)),
+		Vat:           r.Vat,
+		Quantity:      r.Quantity,
+		Ordertext:     r.Ordertext,
+		AmountInclVat: r.AmountInclVat,
+		Created:       r.Created,
+		Transferred:   r.Transferred,
+		Cancelled:     r.Cancelled,
+		Country:       country,
+	}
+}
+
+// mapCredit maps API response -> domain Credit
+func mapCredit(r CreditResponse, country string) Credit {
+	return Credit{
+		Handle:       r.Handle,
+		Customer:     r.Customer,
+		Subscription: r.Subscription,
+		State:        r.State,
+		Invoice:      r.Invoice,
+		Amount:       money.New(r.Amount, AccountCurrency(country)),
+		Text:         r.Text,
+		Created:      r.Created,
+		Transferred:  r.Transferred,
+		Cancelled:    r.Cancelled,
+		Country:      country,
+	}
+}
+
+// GetAdditionalCostList fetches one page of additional costs for the given country
+func GetAdditionalCostList(nextPage string, country string, dateRange DateRange) ([]AdditionalCost, string, error) {
+	var apiResp AdditionalCostListResponse
+	if err := getListPage("additional_cost", nextPage, country, dateRange, &apiResp); err != nil {
+		return nil, "", err
+	}
+
+	additionalCosts := make([]AdditionalCost, len(apiResp.AdditionalCosts))
+	for i, r := range apiResp.AdditionalCosts {
+		additionalCosts[i] = mapAdditionalCost(r, country)
+	}
+	return additionalCosts, apiResp.NextPage, nil
+}
+
+// GetCreditList fetches one page of customer credits for the given country
+func GetCreditList(nextPage string, country string, dateRange DateRange) ([]Credit, string, error) {
+	var apiResp CreditListResponse
+	if err := getListPage("credit", nextPage, country, dateRange, &apiResp); err != nil {
+		return nil, "", err
+	}
+
+	credits := make([]Credit, len(apiResp.Credits))
+	for i, r := range apiResp.Credits {
+		credits[i] = mapCredit(r, country)
+	}
+	return credits, apiResp.NextPage, nil
+}
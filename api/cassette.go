@@ -0,0 +1,323 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/AndersKaae/legaldesk_psp_sync/config"
+)
+
+// Cassette modes, selected with PSP_HTTP_MODE
+const (
+	CassetteRecord = "record"
+	CassetteReplay = "replay"
+)
+
+// piiFields are JSON keys whose string values are replaced before an interaction is written
+var piiFields = map[string]bool{
+	"email":       true,
+	"phone":       true,
+	"first_name":  true,
+	"last_name":   true,
+	"address":     true,
+	"address2":    true,
+	"company":     true,
+	"postal_code": true,
+	"city":        true,
+	"vat":         true,
+	"ip":          true,
+	"card_holder": true,
+	"masked_card": true,
+	"fingerprint": true,
+}
+
+// Interaction is one recorded request/response pair, stored as a line of a cassette file
+type Interaction struct {
+	Account    string          `json:"account"`
+	Method     string          `json:"method"`
+	Path       string          `json:"path"`
+	Query      string          `json:"query"`
+	Status     int             `json:"status"`
+	Headers    http.Header     `json:"headers,omitempty"`
+	Body       json.RawMessage `json:"body"`
+	RecordedAt time.Time       `json:"recorded_at"`
+}
+
+func (i Interaction) key() string {
+	return i.Account + " " + i.Method + " " + i.Path + "?" + i.Query
+}
+
+// accountsByKey maps the private API keys in cfg back to their account, so cassettes never
+// contain the keys themselves
+func accountsByKey(cfg config.Config) map[string]string {
+	return map[string]string{
+		cfg.Psp_api_key_dk: "DK",
+		cfg.Psp_api_key_se: "SE",
+		cfg.Psp_api_key_no: "NO",
+	}
+}
+
+// Recorder is an http.RoundTripper that passes requests on and appends each scrubbed
+// interaction to a JSON-lines cassette file
+type Recorder struct {
+	next     http.RoundTripper
+	accounts map[string]string
+	secret   []byte // Keys the PII placeholders of this recording; never written anywhere
+
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewRecorder opens (or creates) the cassette at path for appending
+func NewRecorder(path string, cfg config.Config, next http.RoundTripper) (*Recorder, error) {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("create cassette dir: %w", err)
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("generate scrub secret: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("open cassette: %w", err)
+	}
+	return &Recorder{next: next, accounts: accountsByKey(cfg), secret: secret, file: f}, nil
+}
+
+// RoundTrip performs the request and records the response
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := r.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("read body: %w", err)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	key, _, _ := req.BasicAuth()
+	interaction := Interaction{
+		Account:    r.accounts[key],
+		Method:     req.Method,
+		Path:       req.URL.Path,
+		Query:      req.URL.Query().Encode(),
+		Status:     resp.StatusCode,
+		Headers:    http.Header{"Content-Type": resp.Header.Values("Content-Type")},
+		Body:       ScrubJSON(body, r.secret),
+		RecordedAt: time.Now().UTC(),
+	}
+	line, err := json.Marshal(interaction)
+	if err != nil {
+		return nil, fmt.Errorf("marshal interaction: %w", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, err := r.file.Write(append(line, '\n')); err != nil {
+		return nil, fmt.Errorf("write cassette: %w", err)
+	}
+	return resp, nil
+}
+
+// Close closes the cassette file
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.file.Close()
+}
+
+// Replayer is an http.RoundTripper serving responses from a cassette without network
+// access. Identical requests get the recorded responses in order, and the last one once
+// they run out.
+type Replayer struct {
+	accounts map[string]string
+
+	mu           sync.Mutex
+	interactions map[string][]Interaction
+	served       map[string]int
+}
+
+// NewReplayer loads the cassette at path
+func NewReplayer(path string, cfg config.Config) (*Replayer, error) {
+	interactions, err := LoadCassette(path)
+	if err != nil {
+		return nil, err
+	}
+	r := &Replayer{
+		accounts:     accountsByKey(cfg),
+		interactions: make(map[string][]Interaction),
+		served:       make(map[string]int),
+	}
+	for _, i := range interactions {
+		r.interactions[i.key()] = append(r.interactions[i.key()], i)
+	}
+	return r, nil
+}
+
+// RoundTrip returns the recorded response for the request
+func (r *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	key, _, _ := req.BasicAuth()
+	lookup := Interaction{
+		Account: r.accounts[key],
+		Method:  req.Method,
+		Path:    req.URL.Path,
+		Query:   req.URL.Query().Encode(),
+	}.key()
+
+	r.mu.Lock()
+	recorded := r.interactions[lookup]
+	if len(recorded) == 0 {
+		r.mu.Unlock()
+		return nil, fmt.Errorf("no recorded interaction for %s", lookup)
+	}
+	n := r.served[lookup]
+	if n >= len(recorded) {
+		n = len(recorded) - 1
+	}
+	r.served[lookup]++
+	interaction := recorded[n]
+	r.mu.Unlock()
+
+	header := interaction.Headers.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", interaction.Status, http.StatusText(interaction.Status)),
+		StatusCode:    interaction.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(interaction.Body)),
+		ContentLength: int64(len(interaction.Body)),
+		Request:       req,
+	}, nil
+}
+
+// LoadCassette reads all interactions from a cassette file
+func LoadCassette(path string) ([]Interaction, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open cassette: %w", err)
+	}
+	defer f.Close()
+
+	var interactions []Interaction
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var i Interaction
+		if err := json.Unmarshal(line, &i); err != nil {
+			return nil, fmt.Errorf("unmarshal interaction: %w", err)
+		}
+		interactions = append(interactions, i)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read cassette: %w", err)
+	}
+	return interactions, nil
+}
+
+// UseCassette installs a recording or replaying HTTP client for the given mode and returns
+// a function releasing it
+func UseCassette(mode, path string, cfg config.Config) (func() error, error) {
+	switch mode {
+	case CassetteRecord:
+		rec, err := NewRecorder(path, cfg, nil)
+		if err != nil {
+			return nil, err
+		}
+		SetHTTPClient(&http.Client{Transport: rec})
+		return func() error {
+			SetHTTPClient(nil)
+			return rec.Close()
+		}, nil
+	case CassetteReplay:
+		rep, err := NewReplayer(path, cfg)
+		if err != nil {
+			return nil, err
+		}
+		SetHTTPClient(&http.Client{Transport: rep})
+		return func() error {
+			SetHTTPClient(nil)
+			return nil
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown cassette mode: %s", mode)
+	}
+}
+
+// ScrubJSON replaces the values of PII fields anywhere in a JSON document with placeholders
+// keyed by secret, so records still join up across the interactions of a recording while the
+// values cannot be recovered by hashing guesses. Numbers are kept exactly as sent. Non-JSON
+// bodies are dropped.
+func ScrubJSON(body []byte, secret []byte) json.RawMessage {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return json.RawMessage("null")
+	}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var doc interface{}
+	if err := dec.Decode(&doc); err != nil {
+		return json.RawMessage(`"<non-json body scrubbed>"`)
+	}
+	scrubbed, err := json.Marshal(scrubValue(doc, secret))
+	if err != nil {
+		return json.RawMessage("null")
+	}
+	return scrubbed
+}
+
+func scrubValue(v interface{}, secret []byte) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, child := range t {
+			if s, ok := child.(string); ok && piiFields[k] && s != "" {
+				t[k] = placeholder(k, s, secret)
+				continue
+			}
+			t[k] = scrubValue(child, secret)
+		}
+		return t
+	case []interface{}:
+		for i, child := range t {
+			t[i] = scrubValue(child, secret)
+		}
+		return t
+	default:
+		return v
+	}
+}
+
+func placeholder(field, value string, secret []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(value))
+	short := hex.EncodeToString(mac.Sum(nil))[:16]
+	if field == "email" {
+		return "scrubbed-" + short + "@example.com"
+	}
+	return "scrubbed-" + short
+}
@@ -0,0 +1,142 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/AndersKaae/legaldesk_psp_sync/config"
+)
+
+// invoiceJSON is a Frisbii invoice with an amount beyond float64 precision and PII in a
+// nested object
+const invoiceJSON = `{
+	"id": "inv_1", "handle": "inv-1", "customer": "cust-1", "subscription": "sub-1", "currency": "DKK",
+	"created": "2025-03-01T10:00:00.000+00:00", "settled": "2025-03-01T10:05:30.250+00:00",
+	"org_amount": 9007199254740993, "amount_vat": 2500, "amount_ex_vat": 10000, "discount_amount": 0,
+	"refunded_amount": 0, "authorized_amount": 12500, "state": "settled", "plan": "plan-basic",
+	"billing_address": {"email": "jane@example.dk", "first_name": "Jane", "phone": "+4512345678"}
+}`
+
+// useClient points the api package at baseURL through transport for the rest of the test
+func useClient(t *testing.T, baseURL string, transport http.RoundTripper) {
+	t.Helper()
+	SetBaseURL(baseURL)
+	SetHTTPClient(&http.Client{Transport: transport})
+	t.Cleanup(func() {
+		SetBaseURL("")
+		SetHTTPClient(nil)
+	})
+}
+
+func TestCassetteReplaysInvoice(t *testing.T) {
+	cfg := config.Config{Psp_api_key_dk: "priv_secret_dk", Backfill_from: "2025-01-01"}
+	Configure(cfg)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if key, _, _ := r.BasicAuth(); key != cfg.Psp_api_key_dk || r.URL.Path != "/invoice/inv_1" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(invoiceJSON))
+	}))
+	defer srv.Close()
+
+	var response InvoiceResponse
+	if err := json.Unmarshal([]byte(invoiceJSON), &response); err != nil {
+		t.Fatalf("unmarshal invoice: %v", err)
+	}
+	want := mapInvoice(response, "DK")
+
+	path := filepath.Join(t.TempDir(), "frisbii.jsonl")
+	rec, err := NewRecorder(path, cfg, nil)
+	if err != nil {
+		t.Fatalf("NewRecorder: %v", err)
+	}
+	useClient(t, srv.URL, rec)
+	if _, err := GetInvoice("inv_1", "DK"); err != nil {
+		t.Fatalf("GetInvoice while recording: %v", err)
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatalf("close recorder: %v", err)
+	}
+	srv.Close()
+
+	cassette, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read cassette: %v", err)
+	}
+	for _, secret := range []string{"priv_secret_dk", "jane@example.dk", "Jane", "+4512345678"} {
+		if bytes.Contains(cassette, []byte(secret)) {
+			t.Errorf("cassette contains %q: %s", secret, cassette)
+		}
+	}
+
+	rep, err := NewReplayer(path, cfg)
+	if err != nil {
+		t.Fatalf("NewReplayer: %v", err)
+	}
+	useClient(t, srv.URL, rep)
+	got, err := GetInvoice("inv_1", "DK")
+	if err != nil {
+		t.Fatalf("GetInvoice while replaying: %v", err)
+	}
+
+	if got.ID != want.ID || got.Handle != want.Handle || got.Customer != want.Customer ||
+		got.Subscription != want.Subscription || got.State != want.State || got.Plan != want.Plan || got.Country != want.Country {
+		t.Errorf("replayed invoice = %+v, want %+v", got, want)
+	}
+	for name, pair := range map[string][2]int64{
+		"org_amount":        {got.OrgAmount.Amount, want.OrgAmount.Amount},
+		"amount_vat":        {got.AmountVAT.Amount, want.AmountVAT.Amount},
+		"amount_ex_vat":     {got.AmountExVAT.Amount, want.AmountExVAT.Amount},
+		"authorized_amount": {got.AuthorizedAmount.Amount, want.AuthorizedAmount.Amount},
+	} {
+		if pair[0] != pair[1] {
+			t.Errorf("%s = %d, want %d", name, pair[0], pair[1])
+		}
+	}
+	if got.OrgAmount.Amount != 9007199254740993 {
+		t.Errorf("org_amount lost precision: %d", got.OrgAmount.Amount)
+	}
+	if len(got.States) != len(want.States) {
+		t.Errorf("states = %v, want %v", got.States, want.States)
+	}
+	for state, at := range want.States {
+		if got.States[state] == nil || !got.States[state].Equal(*at) {
+			t.Errorf("state %s = %v, want %v", state, got.States[state], at)
+		}
+	}
+	if settled := got.States["settled"]; settled == nil || !settled.Equal(time.Date(2025, 3, 1, 10, 5, 30, 250e6, time.UTC)) {
+		t.Errorf("settled = %v", settled)
+	}
+}
+
+func TestScrubJSONPlaceholders(t *testing.T) {
+	body := []byte(`{"email": "jane@example.dk", "customer": {"email": "jane@example.dk"}, "amount": 12345678901234567}`)
+	first := ScrubJSON(body, []byte("recording one"))
+	second := ScrubJSON(body, []byte("recording two"))
+
+	var doc struct {
+		Email    string                 `json:"email"`
+		Customer struct{ Email string } `json:"customer"`
+		Amount   json.Number            `json:"amount"`
+	}
+	if err := json.Unmarshal(first, &doc); err != nil {
+		t.Fatalf("unmarshal scrubbed: %v", err)
+	}
+	if doc.Email == "jane@example.dk" || doc.Email != doc.Customer.Email {
+		t.Errorf("emails scrubbed to %q and %q, want one placeholder", doc.Email, doc.Customer.Email)
+	}
+	if doc.Amount != "12345678901234567" {
+		t.Errorf("amount = %s, want it unchanged", doc.Amount)
+	}
+	if bytes.Equal(first, second) {
+		t.Errorf("placeholders do not depend on the recording secret: %s", first)
+	}
+}
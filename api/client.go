@@ -0,0 +1,131 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"github.com/AndersKaae/legaldesk_psp_sync/config"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+const defaultBaseURL = "https://api.frisbii.com/v1"
+
+// ErrNotFound is wrapped by the errors of single entity lookups that Frisbii answers with 404
+var ErrNotFound = errors.New("not found")
+
+var (
+	clientMu   sync.RWMutex
+	baseURL    = defaultBaseURL
+	httpClient = http.DefaultClient
+	clientCfg  *config.Config
+)
+
+// Configure sets the config used for API keys and the backfill start date. Without it the
+// config is loaded from the environment on first use.
+func Configure(cfg config.Config) {
+	clientMu.Lock()
+	defer clientMu.Unlock()
+	clientCfg = &cfg
+}
+
+// SetBaseURL points the client at another Frisbii API root, e.g. a frisbiitest server.
+// An empty url restores the default.
+func SetBaseURL(u string) {
+	clientMu.Lock()
+	defer clientMu.Unlock()
+	if u == "" {
+		u = defaultBaseURL
+	}
+	baseURL = u
+}
+
+// SetHTTPClient replaces the HTTP client used for all API calls. A nil client restores
+// http.DefaultClient.
+func SetHTTPClient(c *http.Client) {
+	clientMu.Lock()
+	defer clientMu.Unlock()
+	if c == nil {
+		c = http.DefaultClient
+	}
+	httpClient = c
+}
+
+func currentConfig() config.Config {
+	clientMu.RLock()
+	cfg := clientCfg
+	clientMu.RUnlock()
+	if cfg != nil {
+		return *cfg
+	}
+
+	loaded := config.LoadConfig()
+	Configure(loaded)
+	return loaded
+}
+
+// apiKey returns the private API key of the Frisbii account for a country
+func apiKey(cfg config.Config, country string) (string, error) {
+	switch country {
+	case "DK":
+		return cfg.Psp_api_key_dk, nil
+	case "SE":
+		return cfg.Psp_api_key_se, nil
+	case "NO":
+		return cfg.Psp_api_key_no, nil
+	default:
+		return "", fmt.Errorf("unsupported country: %s", country)
+	}
+}
+
+// AccountCurrency returns the currency of the Frisbii account for a country, which its
+// customer, discount, credit and additional cost amounts are in
+func AccountCurrency(country string) string {
+	switch country {
+	case "DK":
+		return "DKK"
+	case "SE":
+		return "SEK"
+	case "NO":
+		return "NOK"
+	default:
+		return ""
+	}
+}
+
+// get performs an authenticated GET against the API and returns the status code and body
+func get(path string, query url.Values, country string) (int, []byte, error) {
+	cfg := currentConfig()
+	key, err := apiKey(cfg, country)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	clientMu.RLock()
+	u := baseURL + path
+	client := httpClient
+	clientMu.RUnlock()
+
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return 0, nil, fmt.Errorf("build request: %w", err)
+	}
+	if len(query) > 0 {
+		req.URL.RawQuery = query.Encode()
+	}
+	req.SetBasicAuth(key, "")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, fmt.Errorf("read body: %w", err)
+	}
+	return resp.StatusCode, body, nil
+}
@@ -3,10 +3,9 @@ package api
 import (
 	"encoding/json"
 	"fmt"
-	"github.com/AndersKaae/legaldesk_psp_sync/config"
-	"io"
-	"log"
+	"github.com/AndersKaae/legaldesk_psp_sync/money"
 	"net/http"
+	"net/url"
 	"time"
 )
 
@@ -66,85 +65,88 @@ type Customer struct {
 	ActiveSubscriptions             int
 	Address                         string
 	Address2                        string
-	CancelledAmount                 int64
+	CancelledAmount                 money.Money
 	CancelledInvoices               int
 	CancelledSubscriptions          int
 	City                            string
 	Company                         string
 	Country                         string
 	Created                         time.Time
-	DunningAmount                   int64
+	Currency                        string
+	DunningAmount                   money.Money
 	DunningInvoices                 int
 	Email                           string
 	ExpiredSubscriptions            int
-	FailedAmount                    int64
+	FailedAmount                    money.Money
 	FailedInvoices                  int
 	FirstName                       string
 	Handle                          string
 	LastName                        string
 	NonRenewingSubscriptions        int
 	OnHoldSubscriptions             int
-	PendingAdditionalCostAmount     int64
+	PendingAdditionalCostAmount     money.Money
 	PendingAdditionalCosts          int
-	PendingAmount                   int64
-	PendingCreditAmount             int64
+	PendingAmount                   money.Money
+	PendingCreditAmount             money.Money
 	PendingCredits                  int
 	PendingInvoices                 int
 	Phone                           string
 	PostalCode                      string
-	RefundedAmount                  int64
-	SettledAmount                   int64
+	RefundedAmount                  money.Money
+	SettledAmount                   money.Money
 	SettledInvoices                 int
 	Subscriptions                   int
 	Test                            bool
-	TransferredAdditionalCostAmount int64
+	TransferredAdditionalCostAmount money.Money
 	TransferredAdditionalCosts      int
-	TransferredCreditAmount         int64
+	TransferredCreditAmount         money.Money
 	TransferredCredits              int
 	TrialActiveSubscriptions        int
 	TrialCancelledSubscriptions     int
 }
 
-// mapCustomer maps API response -> domain Customer
-func mapCustomer(r CustomerResponse) Customer {
+// mapCustomer maps API response -> domain Customer; its amounts are in the account currency
+func mapCustomer(r CustomerResponse, country string) Customer {
+	currency := AccountCurrency(country)
 	return Customer{
 		ActiveSubscriptions:             r.ActiveSubscriptions,
 		Address:                         r.Address,
 		Address2:                        r.Address2,
-		CancelledAmount:                 r.CancelledAmount,
+		CancelledAmount:                 money.New(r.CancelledAmount, currency),
 		CancelledInvoices:               r.CancelledInvoices,
 		CancelledSubscriptions:          r.CancelledSubscriptions,
 		City:                            r.City,
 		Company:                         r.Company,
 		Country:                         r.Country,
 		Created:                         r.Created,
-		DunningAmount:                   r.DunningAmount,
+		Currency:                        currency,
+		DunningAmount:                   money.New(r.DunningAmount, currency),
 		DunningInvoices:                 r.DunningInvoices,
 		Email:                           r.Email,
 		ExpiredSubscriptions:            r.ExpiredSubscriptions,
-		FailedAmount:                    r.FailedAmount,
+		FailedAmount:                    money.New(r.FailedAmount, currency),
 		FailedInvoices:                  r.FailedInvoices,
 		FirstName:                       r.FirstName,
 		Handle:                          r.Handle,
 		LastName:                        r.LastName,
 		NonRenewingSubscriptions:        r.NonRenewingSubscriptions,
 		OnHoldSubscriptions:             r.OnHoldSubscriptions,
-		PendingAdditionalCostAmount:     r.PendingAdditionalCostAmount,
+		PendingAdditionalCostAmount:     money.New(r.PendingAdditionalCostAmount, currency),
 		PendingAdditionalCosts:          r.PendingAdditionalCosts,
-		PendingAmount:                   r.PendingAmount,
-		PendingCreditAmount:             r.PendingCreditAmount,
+		PendingAmount:                   money.New(r.PendingAmount, currency),
+		PendingCreditAmount:             money.New(r.PendingCreditAmount, currency),
 		PendingCredits:                  r.PendingCredits,
 		PendingInvoices:                 r.PendingInvoices,
 		Phone:                           r.Phone,
 		PostalCode:                      r.PostalCode,
-		RefundedAmount:                  r.RefundedAmount,
-		SettledAmount:                   r.SettledAmount,
+		RefundedAmount:                  money.New(r.RefundedAmount, currency),
+		SettledAmount:                   money.New(r.SettledAmount, currency),
 		SettledInvoices:                 r.SettledInvoices,
 		Subscriptions:                   r.Subscriptions,
 		Test:                            r.Test,
-		TransferredAdditionalCostAmount: r.TransferredAdditionalCostAmount,
+		TransferredAdditionalCostAmount: money.New(r.TransferredAdditionalCostAmount, currency),
 		TransferredAdditionalCosts:      r.TransferredAdditionalCosts,
-		TransferredCreditAmount:         r.TransferredCreditAmount,
+		TransferredCreditAmount:         money.New(r.TransferredCreditAmount, currency),
 		TransferredCredits:              r.TransferredCredits,
 		TrialActiveSubscriptions:        r.TrialActiveSubscriptions,
 		TrialCancelledSubscriptions:     r.TrialCancelledSubscriptions,
@@ -152,44 +154,16 @@ func mapCustomer(r CustomerResponse) Customer {
 }
 
 func GetCustomer(customerId string, country string) (Customer, error) {
-	url := "https://api.frisbii.com/v1/customer/" + customerId
-
-	cfg := config.LoadConfig()
-
-	req, err := http.NewRequest("GET", url, nil)
+	status, body, err := get("/customer/"+url.PathEscape(customerId), nil, country)
 	if err != nil {
-		return Customer{}, fmt.Errorf("build request: %w", err)
-	}
-
-	switch country {
-	case "DK":
-		req.SetBasicAuth(cfg.Psp_api_key_dk, "")
-	case "SE":
-		req.SetBasicAuth(cfg.Psp_api_key_se, "")
-	case "NO":
-		req.SetBasicAuth(cfg.Psp_api_key_no, "")
-	default:
-		log.Fatal("unsupported country: " + country)
+		return Customer{}, err
 	}
 
-	req.Header.Set("Accept", "application/json")
-
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return Customer{}, fmt.Errorf("request failed: %w", err)
+	if status == http.StatusNotFound {
+		return Customer{}, fmt.Errorf("customer %s %w", customerId, ErrNotFound)
 	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return Customer{}, fmt.Errorf("read body: %w", err)
-	}
-
-	if resp.StatusCode == http.StatusNotFound {
-		return Customer{}, fmt.Errorf("customer %s not found", customerId)
-	}
-	if resp.StatusCode != http.StatusOK {
-		return Customer{}, fmt.Errorf("unexpected status %d: %s", resp.StatusCode, string(body))
+	if status != http.StatusOK {
+		return Customer{}, fmt.Errorf("unexpected status %d: %s", status, string(body))
 	}
 
 	var apiResp CustomerResponse
@@ -197,62 +171,18 @@ func GetCustomer(customerId string, country string) (Customer, error) {
 		return Customer{}, fmt.Errorf("unmarshal response: %w", err)
 	}
 
-	return mapCustomer(apiResp), nil
+	return mapCustomer(apiResp, country), nil
 }
 
-func GetCustomerList(nextPage string, country string) ([]Customer, string, error) {
-	cfg := config.LoadConfig()
-
-	url := "https://api.frisbii.com/v1/list/customer?from=" + cfg.Backfill_from
-
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, "", fmt.Errorf("build request: %w", err)
-	}
-
-	// Add next_page token if provided
-	if nextPage != "" {
-		q := req.URL.Query()
-		q.Add("next_page_token", nextPage)
-		req.URL.RawQuery = q.Encode()
-	}
-
-	switch country {
-	case "DK":
-		req.SetBasicAuth(cfg.Psp_api_key_dk, "")
-	case "SE":
-		req.SetBasicAuth(cfg.Psp_api_key_se, "")
-	case "NO":
-		req.SetBasicAuth(cfg.Psp_api_key_no, "")
-	default:
-		return nil, "", fmt.Errorf("unsupported country: %s", country)
-	}
-
-	req.Header.Set("Accept", "application/json")
-
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return nil, "", fmt.Errorf("request failed: %w", err)
-	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, "", fmt.Errorf("read body: %w", err)
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, "", fmt.Errorf("unexpected status %d: %s", resp.StatusCode, string(body))
-	}
-
+func GetCustomerList(nextPage string, country string, dateRange DateRange) ([]Customer, string, error) {
 	var apiResp CustomerListResponse
-	if err := json.Unmarshal(body, &apiResp); err != nil {
-		return nil, "", fmt.Errorf("unmarshal response: %w", err)
+	if err := getListPage("customer", nextPage, country, dateRange, &apiResp); err != nil {
+		return nil, "", err
 	}
 
 	customers := make([]Customer, len(apiResp.Customers))
 	for i, r := range apiResp.Customers {
-		customers[i] = mapCustomer(r)
+		customers[i] = mapCustomer(r, country)
 	}
 
 	return customers, apiResp.NextPage, nil
@@ -0,0 +1,205 @@
+package api
+
+import (
+	"github.com/AndersKaae/legaldesk_psp_sync/money"
+	"time"
+)
+
+// DiscountResponse models the discount API response
+type DiscountResponse struct {
+	Handle          string     `json:"handle"`
+	Name            string     `json:"name"`
+	Description     string     `json:"description"`
+	State           string     `json:"state"`
+	Amount          int64      `json:"amount"`
+	Percentage      int        `json:"percentage"`
+	FixedCount      int        `json:"fixed_count"`
+	FixedPeriodUnit string     `json:"fixed_period_unit"`
+	FixedPeriod     int        `json:"fixed_period"`
+	Created         time.Time  `json:"created"`
+	Deleted         *time.Time `json:"deleted"`
+}
+
+// DiscountListResponse models the discount list API response
+type DiscountListResponse struct {
+	Discounts []DiscountResponse `json:"content"`
+	NextPage  string             `json:"next_page_token"`
+}
+
+// CouponResponse models the coupon API response
+type CouponResponse struct {
+	Handle         string     `json:"handle"`
+	Name           string     `json:"name"`
+	Code           string     `json:"code"`
+	Discount       string     `json:"discount"`
+	State          string     `json:"state"`
+	Redemptions    int        `json:"redemptions"`
+	MaxRedemptions int        `json:"max_redemptions"`
+	ValidUntil     *time.Time `json:"valid_until"`
+	Expired        *time.Time `json:"expired"`
+	Created        time.Time  `json:"created"`
+}
+
+// CouponListResponse models the coupon list API response
+type CouponListResponse struct {
+	Coupons  []CouponResponse `json:"content"`
+	NextPage string           `json:"next_page_token"`
+}
+
+// SubscriptionDiscountResponse models a discount applied to a subscription,
+// either directly or through a coupon redemption
+type SubscriptionDiscountResponse struct {
+	Handle       string     `json:"handle"`
+	Subscription string     `json:"subscription"`
+	Discount     string     `json:"discount"`
+	Coupon       string     `json:"coupon"`
+	State        string     `json:"state"`
+	Amount       int64      `json:"amount"`
+	Percentage   int        `json:"percentage"`
+	Created      time.Time  `json:"created"`
+	Deleted      *time.Time `json:"deleted"`
+}
+
+// SubscriptionDiscountListResponse models the subscription discount list API response
+type SubscriptionDiscountListResponse struct {
+	SubscriptionDiscounts []SubscriptionDiscountResponse `json:"content"` // Assuming the list endpoint mirrors the others
+	NextPage              string                         `json:"next_page_token"`
+}
+
+// Discount is your domain model
+type Discount struct {
+	Handle          string
+	Name            string
+	Description     string
+	State           string
+	Amount          money.Money
+	Percentage      int
+	FixedCount      int
+	FixedPeriodUnit string
+	FixedPeriod     int
+	Created         time.Time
+	Deleted         *time.Time
+	Country         string
+}
+
+// Coupon is your domain model
+type Coupon struct {
+	Handle         string
+	Name           string
+	Code           string
+	Discount       string
+	State          string
+	Redemptions    int
+	MaxRedemptions int
+	ValidUntil     *time.Time
+	Expired        *time.Time
+	Created        time.Time
+	Country        string
+}
+
+// SubscriptionDiscount is your domain model
+type SubscriptionDiscount struct {
+	Handle       string
+	Subscription string
+	Discount     string
+	Coupon       string
+	State        string
+	Amount       money.Money
+	Percentage   int
+	Created      time.Time
+	Deleted      *time.Time
+	Country      string
+}
+
+// mapDiscount maps API response -> domain Discount
+func mapDiscount(r DiscountResponse, country string) Discount {
+	return Discount{
+		Handle:          r.Handle,
+		Name:            r.Name,
+		Description:     r.Description,
+		State:           r.State,
+		Amount:          money.New(r.Amount, AccountCurrency(country)),
+		Percentage:      r.Percentage,
+		FixedCount:      r.FixedCount,
+		FixedPeriodUnit: r.FixedPeriodUnit,
+		FixedPeriod:     r.FixedPeriod,
+		Created:         r.Created,
+		Deleted:         r.Deleted,
+		Country:         country,
+	}
+}
+
+// mapCoupon maps API response -> domain Coupon
+func mapCoupon(r CouponResponse, country string) Coupon {
+	return Coupon{
+		Handle:         r.Handle,
+		Name:           r.Name,
+		Code:           r.Code,
+		Discount:       r.Discount,
+		State:          r.State,
+		Redemptions:    r.Redemptions,
+		MaxRedemptions: r.MaxRedemptions,
+		ValidUntil:     r.ValidUntil,
+		Expired:        r.Expired,
+		Created:        r.Created,
+		Country:        country,
+	}
+}
+
+// mapSubscriptionDiscount maps API response -> domain SubscriptionDiscount
+func mapSubscriptionDiscount(r SubscriptionDiscountResponse, country string) SubscriptionDiscount {
+	return SubscriptionDiscount{
+		Handle:       r.Handle,
+		Subscription: r.Subscription,
+		Discount:     r.Discount,
+		Coupon:       r.Coupon,
+		State:        r.State,
+		Amount:       money.New(r.Amount, AccountCurrency(country)),
+		Percentage:   r.Percentage,
+		Created:      r.Created,
+		Deleted:      r.Deleted,
+		Country:      country,
+	}
+}
+
+// GetDiscountList fetches one page of discounts for the given country
+func GetDiscountList(nextPage string, country string, dateRange DateRange) ([]Discount, string, error) {
+	var apiResp DiscountListResponse
+	if err := getListPage("discount", nextPage, country, dateRange, &apiResp); err != nil {
+		return nil, "", err
+	}
+
+	discounts := make([]Discount, len(apiResp.Discounts))
+	for i, r := range apiResp.Discounts {
+		discounts[i] = mapDiscount(r, country)
+	}
+	return discounts, apiResp.NextPage, nil
+}
+
+// GetCouponList fetches one page of coupons for the given country
+func GetCouponList(nextPage string, country string, dateRange DateRange) ([]Coupon, string, error) {
+	var apiResp CouponListResponse
+	if err := getListPage("coupon", nextPage, country, dateRange, &apiResp); err != nil {
+		return nil, "", err
+	}
+
+	coupons := make([]Coupon, len(apiResp.Coupons))
+	for i, r := range apiResp.Coupons {
+		coupons[i] = mapCoupon(r, country)
+	}
+	return coupons, apiResp.NextPage, nil
+}
+
+// GetSubscriptionDiscountList fetches one page of subscription discounts for the given country
+func GetSubscriptionDiscountList(nextPage string, country string, dateRange DateRange) ([]SubscriptionDiscount, string, error) {
+	var apiResp SubscriptionDiscountListResponse
+	if err := getListPage("subscription_discount", nextPage, country, dateRange, &apiResp); err != nil {
+		return nil, "", err
+	}
+
+	subscriptionDiscounts := make([]SubscriptionDiscount, len(apiResp.SubscriptionDiscounts))
+	for i, r := range apiResp.SubscriptionDiscounts {
+		subscriptionDiscounts[i] = mapSubscriptionDiscount(r, country)
+	}
+	return subscriptionDiscounts, apiResp.NextPage, nil
+}
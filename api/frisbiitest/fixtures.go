@@ -0,0 +1,39 @@
+package frisbiitest
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Fixtures seeds a server with raw API objects, keyed by account and then entity, e.g.
+//
+//	{"DK": {"invoice": [{"id": "...", "handle": "inv-1", ...}], "customer": [...]}}
+type Fixtures map[string]map[string][]json.RawMessage
+
+// Seed stores all objects in f
+func (s *Server) Seed(f Fixtures) error {
+	for account, entities := range f {
+		for entity, objects := range entities {
+			for _, obj := range objects {
+				if err := s.Add(account, entity, obj); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// LoadFixtures seeds a server from a JSON file in the Fixtures format
+func (s *Server) LoadFixtures(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read fixtures: %w", err)
+	}
+	var f Fixtures
+	if err := json.Unmarshal(data, &f); err != nil {
+		return fmt.Errorf("unmarshal fixtures: %w", err)
+	}
+	return s.Seed(f)
+}
@@ -0,0 +1,397 @@
+// Package frisbiitest provides an in-memory stand-in for the Frisbii API, for tests and for
+// running the service locally without real API keys or network access.
+package frisbiitest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AndersKaae/legaldesk_psp_sync/api"
+	"github.com/AndersKaae/legaldesk_psp_sync/config"
+)
+
+// DefaultPageSize is the list page size used when the request does not set "size"
+const DefaultPageSize = 20
+
+// Entities served under /v1/list/{entity}
+const (
+	EntityInvoice              = "invoice"
+	EntityCustomer             = "customer"
+	EntityDiscount             = "discount"
+	EntityCoupon               = "coupon"
+	EntitySubscriptionDiscount = "subscription_discount"
+	EntityAdditionalCost       = "additional_cost"
+	EntityCredit               = "credit"
+)
+
+// Fault makes matching requests fail or stall. Path is matched as a prefix of the request
+// path below /v1, e.g. "/list/invoice"; an empty Path matches every request.
+type Fault struct {
+	Path    string
+	Account string        // Only match requests for this account; empty matches all
+	Status  int           // Status to respond with, e.g. 429 or 500; 0 only applies Delay
+	Delay   time.Duration // Wait before responding
+	Times   int           // Number of requests to affect; 0 means until cleared
+}
+
+// Request is a request observed by the server
+type Request struct {
+	Method  string
+	Path    string
+	Query   string
+	Account string
+	Status  int
+}
+
+// record is a stored API object with the fields needed for lookup and list ordering
+type record struct {
+	ID      string
+	Handle  string
+	Created time.Time
+	Body    json.RawMessage
+}
+
+// Server is a fake Frisbii API backed by httptest.Server
+type Server struct {
+	*httptest.Server
+
+	// Keys maps private API keys to accounts (DK, SE, NO)
+	Keys map[string]string
+	// WebhookSecret signs webhooks sent with SendWebhook
+	WebhookSecret string
+
+	mu       sync.Mutex
+	data     map[string]map[string][]record // account -> entity -> records
+	faults   []*Fault
+	requests []Request
+}
+
+// NewServer starts a fake Frisbii API with one test key per account on a random local
+// port. Close it when done.
+func NewServer() *Server {
+	s := newServer()
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Listen starts a fake Frisbii API like NewServer, but on addr, e.g. "127.0.0.1:6970"
+func Listen(addr string) (*Server, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("listen on %s: %w", addr, err)
+	}
+	s := newServer()
+	s.Server = &httptest.Server{Listener: l, Config: &http.Server{Handler: http.HandlerFunc(s.serveHTTP)}}
+	s.Server.Start()
+	return s, nil
+}
+
+func newServer() *Server {
+	return &Server{
+		Keys: map[string]string{
+			"priv_test_dk": "DK",
+			"priv_test_se": "SE",
+			"priv_test_no": "NO",
+		},
+		WebhookSecret: "webhook_secret_test",
+		data:          make(map[string]map[string][]record),
+	}
+}
+
+// Config returns a config whose API keys authenticate against this server
+func (s *Server) Config() config.Config {
+	cfg := config.Config{Backfill_from: "2000-01-01"}
+	for key, account := range s.Keys {
+		switch account {
+		case "DK":
+			cfg.Psp_api_key_dk = key
+		case "SE":
+			cfg.Psp_api_key_se = key
+		case "NO":
+			cfg.Psp_api_key_no = key
+		}
+	}
+	return cfg
+}
+
+// Install points the api package at this server and returns a function restoring the
+// default base URL
+func (s *Server) Install() func() {
+	api.SetBaseURL(s.URL + "/v1")
+	api.Configure(s.Config())
+	return func() { api.SetBaseURL("") }
+}
+
+// Add stores an API object for an account. The object is marshalled to JSON, and its
+// "id", "handle" and "created" fields are used for lookup and list ordering.
+func (s *Server) Add(account, entity string, obj interface{}) error {
+	body, err := json.Marshal(obj)
+	if err != nil {
+		return fmt.Errorf("marshal %s: %w", entity, err)
+	}
+	var keys struct {
+		ID      string    `json:"id"`
+		Handle  string    `json:"handle"`
+		Created time.Time `json:"created"`
+	}
+	if err := json.Unmarshal(body, &keys); err != nil {
+		return fmt.Errorf("read %s keys: %w", entity, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.data[account] == nil {
+		s.data[account] = make(map[string][]record)
+	}
+	rec := record{ID: keys.ID, Handle: keys.Handle, Created: keys.Created, Body: body}
+	records := s.data[account][entity]
+	for i, existing := range records {
+		if existing.ID == rec.ID && existing.Handle == rec.Handle {
+			records[i] = rec
+			return nil
+		}
+	}
+	records = append(records, rec)
+	sort.SliceStable(records, func(i, j int) bool { return records[i].Created.Before(records[j].Created) })
+	s.data[account][entity] = records
+	return nil
+}
+
+// AddInvoice stores an invoice for an account
+func (s *Server) AddInvoice(account string, invoice api.InvoiceResponse) {
+	if err := s.Add(account, EntityInvoice, invoice); err != nil {
+		panic(err)
+	}
+}
+
+// AddCustomer stores a customer for an account
+func (s *Server) AddCustomer(account string, customer api.CustomerResponse) {
+	if err := s.Add(account, EntityCustomer, customer); err != nil {
+		panic(err)
+	}
+}
+
+// Reset removes all stored objects, faults and recorded requests
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data = make(map[string]map[string][]record)
+	s.faults = nil
+	s.requests = nil
+}
+
+// InjectFault adds a fault; faults are checked in the order they were added
+func (s *Server) InjectFault(f Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = append(s.faults, &f)
+}
+
+// ClearFaults removes all injected faults
+func (s *Server) ClearFaults() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = nil
+}
+
+// Requests returns the requests served so far
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	key, _, _ := r.BasicAuth()
+	account := s.Keys[key]
+	path := strings.TrimPrefix(r.URL.Path, "/v1")
+
+	status := s.handle(w, r, account, path)
+
+	s.mu.Lock()
+	s.requests = append(s.requests, Request{
+		Method:  r.Method,
+		Path:    r.URL.Path,
+		Query:   r.URL.RawQuery,
+		Account: account,
+		Status:  status,
+	})
+	s.mu.Unlock()
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request, account, path string) int {
+	if account == "" {
+		return writeError(w, http.StatusUnauthorized, "Unauthorized")
+	}
+	if r.Method != http.MethodGet {
+		return writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+
+	if fault := s.matchFault(account, path); fault != nil {
+		if fault.Delay > 0 {
+			select {
+			case <-time.After(fault.Delay):
+			case <-r.Context().Done():
+				return 0
+			}
+		}
+		if fault.Status != 0 {
+			if fault.Status == http.StatusTooManyRequests {
+				w.Header().Set("Retry-After", "1")
+			}
+			return writeError(w, fault.Status, http.StatusText(fault.Status))
+		}
+	}
+
+	switch {
+	case strings.HasPrefix(path, "/list/"):
+		return s.serveList(w, r, account, strings.TrimPrefix(path, "/list/"))
+	case strings.HasPrefix(path, "/invoice/"):
+		return s.serveGet(w, account, EntityInvoice, strings.TrimPrefix(path, "/invoice/"))
+	case strings.HasPrefix(path, "/customer/"):
+		return s.serveGet(w, account, EntityCustomer, strings.TrimPrefix(path, "/customer/"))
+	default:
+		return writeError(w, http.StatusNotFound, "Not found")
+	}
+}
+
+// matchFault returns the first active fault matching the request and uses up one of its times
+func (s *Server) matchFault(account, path string) *Fault {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, f := range s.faults {
+		if f.Account != "" && f.Account != account {
+			continue
+		}
+		if !strings.HasPrefix(path, f.Path) {
+			continue
+		}
+		matched := *f
+		if f.Times > 0 {
+			f.Times--
+			if f.Times == 0 {
+				s.faults = append(s.faults[:i], s.faults[i+1:]...)
+			}
+		}
+		return &matched
+	}
+	return nil
+}
+
+func (s *Server) serveGet(w http.ResponseWriter, account, entity, idOrHandle string) int {
+	s.mu.Lock()
+	var found *record
+	for _, rec := range s.data[account][entity] {
+		if rec.ID == idOrHandle || rec.Handle == idOrHandle {
+			found = &rec
+			break
+		}
+	}
+	s.mu.Unlock()
+
+	if found == nil {
+		return writeError(w, http.StatusNotFound, entity+" not found")
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(found.Body)
+	return http.StatusOK
+}
+
+// serveList pages through records created within [from, to) in creation order. The
+// next_page_token is the offset of the next page.
+func (s *Server) serveList(w http.ResponseWriter, r *http.Request, account, entity string) int {
+	q := r.URL.Query()
+
+	var from, to time.Time
+	var err error
+	if v := q.Get("from"); v != "" {
+		if from, err = parseDate(v); err != nil {
+			return writeError(w, http.StatusBadRequest, "invalid from")
+		}
+	}
+	if v := q.Get("to"); v != "" {
+		if to, err = parseDate(v); err != nil {
+			return writeError(w, http.StatusBadRequest, "invalid to")
+		}
+	}
+
+	size := DefaultPageSize
+	if v := q.Get("size"); v != "" {
+		if size, err = strconv.Atoi(v); err != nil || size <= 0 {
+			return writeError(w, http.StatusBadRequest, "invalid size")
+		}
+	}
+
+	offset := 0
+	if v := q.Get("next_page_token"); v != "" {
+		if offset, err = strconv.Atoi(v); err != nil || offset < 0 {
+			return writeError(w, http.StatusBadRequest, "invalid next_page_token")
+		}
+	}
+
+	s.mu.Lock()
+	var matching []json.RawMessage
+	for _, rec := range s.data[account][entity] {
+		if !from.IsZero() && rec.Created.Before(from) {
+			continue
+		}
+		if !to.IsZero() && !rec.Created.Before(to) {
+			continue
+		}
+		matching = append(matching, rec.Body)
+	}
+	s.mu.Unlock()
+
+	page := []json.RawMessage{}
+	if offset < len(matching) {
+		end := offset + size
+		if end > len(matching) {
+			end = len(matching)
+		}
+		page = matching[offset:end]
+	}
+
+	resp := struct {
+		Content       []json.RawMessage `json:"content"`
+		NextPageToken string            `json:"next_page_token,omitempty"`
+		Size          int               `json:"size"`
+		Count         int               `json:"count"`
+	}{Content: page, Size: size, Count: len(page)}
+	if offset+size < len(matching) {
+		resp.NextPageToken = strconv.Itoa(offset + size)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+	return http.StatusOK
+}
+
+// parseDate accepts the date formats of the Frisbii list API
+func parseDate(v string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02"} {
+		if t, err := time.Parse(layout, v); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q", v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) int {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"http_status": status,
+		"error":       msg,
+	})
+	return status
+}
@@ -0,0 +1,74 @@
+package frisbiitest
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+var webhookSeq atomic.Int64
+
+// Webhook is a Frisbii webhook body
+type Webhook struct {
+	ID           string `json:"id"`
+	Timestamp    string `json:"timestamp"`
+	Signature    string `json:"signature"`
+	Invoice      string `json:"invoice,omitempty"`
+	Customer     string `json:"customer,omitempty"`
+	Subscription string `json:"subscription,omitempty"`
+	Transaction  string `json:"transaction,omitempty"`
+	EventType    string `json:"event_type"`
+	EventID      string `json:"event_id"`
+}
+
+// InvoiceWebhook builds an invoice event such as "invoice_settled"
+func InvoiceWebhook(eventType, invoiceID, customer string) Webhook {
+	return Webhook{EventType: eventType, Invoice: invoiceID, Customer: customer}
+}
+
+// CustomerWebhook builds a customer event such as "customer_changed"
+func CustomerWebhook(eventType, customer string) Webhook {
+	return Webhook{EventType: eventType, Customer: customer}
+}
+
+// Sign computes the Frisbii webhook signature: hex(HMAC-SHA256(secret, timestamp + id))
+func Sign(secret, timestamp, id string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + id))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SendWebhook fills in the id, event id, timestamp and signature if missing and posts the
+// webhook to target, e.g. an httptest server running handlers.Webhook
+func (s *Server) SendWebhook(target string, wh Webhook) (*http.Response, error) {
+	seq := strconv.FormatInt(webhookSeq.Add(1), 10)
+	if wh.ID == "" {
+		wh.ID = "wh_test_" + seq
+	}
+	if wh.EventID == "" {
+		wh.EventID = "ev_test_" + seq
+	}
+	if wh.Timestamp == "" {
+		wh.Timestamp = time.Now().UTC().Format("2006-01-02T15:04:05.000Z")
+	}
+	if wh.Signature == "" {
+		wh.Signature = Sign(s.WebhookSecret, wh.Timestamp, wh.ID)
+	}
+
+	body, err := json.Marshal(wh)
+	if err != nil {
+		return nil, fmt.Errorf("marshal webhook: %w", err)
+	}
+	resp, err := http.Post(target, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("send webhook: %w", err)
+	}
+	return resp, nil
+}
@@ -3,10 +3,9 @@ package api
 import (
 	"encoding/json"
 	"fmt"
-	"github.com/AndersKaae/legaldesk_psp_sync/config"
-	"io"
-	"log"
+	"github.com/AndersKaae/legaldesk_psp_sync/money"
 	"net/http"
+	"net/url"
 	"time"
 )
 
@@ -21,11 +20,22 @@ type Transaction struct {
 	Refunded   time.Time `json:"refunded,omitempty"`
 }
 
+// OrderLine holds the invoice order line fields needed to link lines to their origin
+type OrderLine struct {
+	ID           string `json:"id"`
+	Ordertext    string `json:"ordertext"`
+	Amount       int64  `json:"amount"`
+	Quantity     int    `json:"quantity"`
+	Origin       string `json:"origin"`
+	OriginHandle string `json:"origin_handle"`
+}
+
 // InvoiceResponse models the API response fields we care about
 type InvoiceResponse struct {
 	ID               string        `json:"id"`
 	Handle           string        `json:"handle"`
 	Customer         string        `json:"customer"`
+	Subscription     string        `json:"subscription"`
 	Currency         string        `json:"currency"`
 	Created          time.Time     `json:"created"`
 	DiscountAmount   int64         `json:"discount_amount"`
@@ -35,6 +45,7 @@ type InvoiceResponse struct {
 	RefundedAmount   int64         `json:"refunded_amount"`
 	AuthorizedAmount int64         `json:"authorized_amount"`
 	Transactions     []Transaction `json:"transactions"`
+	OrderLines       []OrderLine   `json:"order_lines"`
 	State            string        `json:"state"`
 	Plan             string        `json:"plan"`
 	Authorized       time.Time     `json:"authorized,omitempty"`
@@ -53,23 +64,25 @@ type InvoiceListResponse struct {
 // InvoiceStates maps state names to timestamps (nil if not occurred)
 type InvoiceStates map[string]*time.Time
 
-// Invoice is your domain model
+// Invoice is your domain model; amounts are in minor units of Currency
 type Invoice struct {
 	ID               string
 	Handle           string
 	Customer         string
+	Subscription     string
 	Currency         string
 	Created          time.Time
-	DiscountAmount   int64
-	OrgAmount        int64
-	AmountVAT        int64
-	AmountExVAT      int64
-	RefundedAmount   int64
-	AuthorizedAmount int64
+	DiscountAmount   money.Money
+	OrgAmount        money.Money
+	AmountVAT        money.Money
+	AmountExVAT      money.Money
+	RefundedAmount   money.Money
+	AuthorizedAmount money.Money
 	Country          string
 	States           InvoiceStates
 	State            string
 	Plan             string
+	OrderLines       []OrderLine
 }
 
 // mapStates converts invoice response to InvoiceStates map
@@ -100,60 +113,46 @@ func mapInvoice(r InvoiceResponse, country string) Invoice {
 		ID:               r.ID,
 		Handle:           r.Handle,
 		Customer:         r.Customer,
+		Subscription:     r.Subscription,
 		Currency:         r.Currency,
 		Created:          r.Created,
-		DiscountAmount:   r.DiscountAmount,
-		OrgAmount:        r.OrgAmount,
-		AmountVAT:        r.AmountVAT,
-		AmountExVAT:      r.AmountExVAT,
-		RefundedAmount:   r.RefundedAmount,
-		AuthorizedAmount: r.AuthorizedAmount,
+		DiscountAmount:   money.New(r.DiscountAmount, r.Currency),
+		OrgAmount:        money.New(r.OrgAmount, r.Currency),
+		AmountVAT:        money.New(r.AmountVAT, r.Currency),
+		AmountExVAT:      money.New(r.AmountExVAT, r.Currency),
+		RefundedAmount:   money.New(r.RefundedAmount, r.Currency),
+		AuthorizedAmount: money.New(r.AuthorizedAmount, r.Currency),
 		Country:          country,
 		States:           mapStates(r),
 		State:            r.State,
 		Plan:             r.Plan,
+		OrderLines:       r.OrderLines,
 	}
 }
 
-// GetInvoice fetches an invoice from Frisbii API and returns typed Invoice
-func GetInvoice(invoiceId string, country string) (Invoice, error) {
-	url := "https://api.frisbii.com/v1/invoice/" + invoiceId
-	cfg := config.LoadConfig()
-
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return Invoice{}, fmt.Errorf("build request: %w", err)
-	}
-
-	switch country {
-	case "DK":
-		req.SetBasicAuth(cfg.Psp_api_key_dk, "")
-	case "SE":
-		req.SetBasicAuth(cfg.Psp_api_key_se, "")
-	case "NO":
-		req.SetBasicAuth(cfg.Psp_api_key_no, "")
-	default:
-		log.Fatal("unsupported country: " + country)
+// DiscountLines returns the order lines produced by subscription discounts
+func (i Invoice) DiscountLines() []OrderLine {
+	var lines []OrderLine
+	for _, line := range i.OrderLines {
+		if line.Origin == "discount" {
+			lines = append(lines, line)
+		}
 	}
+	return lines
+}
 
-	req.Header.Set("Accept", "application/json")
-
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return Invoice{}, fmt.Errorf("request failed: %w", err)
-	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
+// GetInvoice fetches an invoice from Frisbii API and returns typed Invoice
+func GetInvoice(invoiceId string, country string) (Invoice, error) {
+	status, body, err := get("/invoice/"+url.PathEscape(invoiceId), nil, country)
 	if err != nil {
-		return Invoice{}, fmt.Errorf("read body: %w", err)
+		return Invoice{}, err
 	}
 
-	if resp.StatusCode == http.StatusNotFound {
-		return Invoice{}, fmt.Errorf("invoice %s not found", invoiceId)
+	if status == http.StatusNotFound {
+		return Invoice{}, fmt.Errorf("invoice %s %w", invoiceId, ErrNotFound)
 	}
-	if resp.StatusCode != http.StatusOK {
-		return Invoice{}, fmt.Errorf("unexpected status %d: %s", resp.StatusCode, string(body))
+	if status != http.StatusOK {
+		return Invoice{}, fmt.Errorf("unexpected status %d: %s", status, string(body))
 	}
 
 	var apiResp InvoiceResponse
@@ -165,54 +164,10 @@ func GetInvoice(invoiceId string, country string) (Invoice, error) {
 	return mapInvoice(apiResp, country), nil
 }
 
-func GetInvoiceList(nextPage string, country string) ([]Invoice, string, error) {
-	cfg := config.LoadConfig()
-
-	url := "https://api.frisbii.com/v1/list/invoice?from=" + cfg.Backfill_from
-
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, "", fmt.Errorf("build request: %w", err)
-	}
-
-	// Add next_page token if provided
-	if nextPage != "" {
-		q := req.URL.Query()
-		q.Add("next_page_token", nextPage)
-		req.URL.RawQuery = q.Encode()
-	}
-
-	switch country {
-	case "DK":
-		req.SetBasicAuth(cfg.Psp_api_key_dk, "")
-	case "SE":
-		req.SetBasicAuth(cfg.Psp_api_key_se, "")
-	case "NO":
-		req.SetBasicAuth(cfg.Psp_api_key_no, "")
-	default:
-		return nil, "", fmt.Errorf("unsupported country: %s", country)
-	}
-
-	req.Header.Set("Accept", "application/json")
-
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return nil, "", fmt.Errorf("request failed: %w", err)
-	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, "", fmt.Errorf("read body: %w", err)
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, "", fmt.Errorf("unexpected status %d: %s", resp.StatusCode, string(body))
-	}
-
+func GetInvoiceList(nextPage string, country string, dateRange DateRange) ([]Invoice, string, error) {
 	var apiResp InvoiceListResponse
-	if err := json.Unmarshal(body, &apiResp); err != nil {
-		return nil, "", fmt.Errorf("unmarshal response: %w", err)
+	if err := getListPage("invoice", nextPage, country, dateRange, &apiResp); err != nil {
+		return nil, "", err
 	}
 
 	invoices := make([]Invoice, len(apiResp.Invoices))
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// listDateLayout is how list date range bounds are sent, in UTC
+const listDateLayout = "2006-01-02T15:04:05"
+
+// DateRange limits a list to entities created in [From, To). A zero From means
+// BACKFILL_FROM and a zero To leaves the range open ended.
+type DateRange struct {
+	From time.Time
+	To   time.Time
+}
+
+// IsZero reports whether the range is the default BACKFILL_FROM onwards
+func (r DateRange) IsZero() bool {
+	return r.From.IsZero() && r.To.IsZero()
+}
+
+// BackfillFrom returns BACKFILL_FROM, the start of the default range, in UTC
+func BackfillFrom() (time.Time, error) {
+	value := currentConfig().Backfill_from
+	for _, layout := range []string{time.DateOnly, listDateLayout, time.RFC3339} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid BACKFILL_FROM %q, expected YYYY-MM-DD", value)
+}
+
+// getListPage fetches one page of /list/{entity} created within dateRange and unmarshals it
+// into out
+func getListPage(entity string, nextPage string, country string, dateRange DateRange, out interface{}) error {
+	query := url.Values{}
+	if dateRange.From.IsZero() {
+		query.Set("from", currentConfig().Backfill_from)
+	} else {
+		query.Set("from", dateRange.From.UTC().Format(listDateLayout))
+	}
+	if !dateRange.To.IsZero() {
+		query.Set("to", dateRange.To.UTC().Format(listDateLayout))
+	}
+
+	// Add next_page token if provided
+	if nextPage != "" {
+		query.Set("next_page_token", nextPage)
+	}
+
+	status, body, err := get("/list/"+entity, query, country)
+	if err != nil {
+		return err
+	}
+
+	if status != http.StatusOK {
+		return fmt.Errorf("unexpected status %d: %s", status, string(body))
+	}
+
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("unmarshal response: %w", err)
+	}
+	return nil
+}
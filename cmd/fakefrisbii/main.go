@@ -0,0 +1,44 @@
+// Command fakefrisbii serves the in-memory Frisbii API of package frisbiitest, for running
+// psp-sync locally without real API keys or network access. Point psp-sync at it with the
+// environment it prints:
+//
+//	go run ./cmd/fakefrisbii -fixtures fixtures.json
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/AndersKaae/legaldesk_psp_sync/api/frisbiitest"
+)
+
+func main() {
+	addr := flag.String("addr", "127.0.0.1:6970", "Address to serve the fake Frisbii API on")
+	fixtures := flag.String("fixtures", "", "JSON fixtures file to seed the fake Frisbii API with")
+	flag.Parse()
+
+	fake, err := frisbiitest.Listen(*addr)
+	if err != nil {
+		log.Fatalf("Failed to start fake Frisbii API: %v", err)
+	}
+	defer fake.Close()
+	if *fixtures != "" {
+		if err := fake.LoadFixtures(*fixtures); err != nil {
+			log.Fatalf("Failed to load fixtures: %v", err)
+		}
+	}
+
+	cfg := fake.Config()
+	log.Printf("Serving fake Frisbii API at %s; run psp-sync with:", fake.URL)
+	fmt.Printf("PSP_API_URL=%s/v1\n", fake.URL)
+	fmt.Printf("PSP_API_KEY_DK=%s\nPSP_API_KEY_SE=%s\nPSP_API_KEY_NO=%s\n", cfg.Psp_api_key_dk, cfg.Psp_api_key_se, cfg.Psp_api_key_no)
+	fmt.Printf("WEBHOOK_SECRET_DK=%s\nWEBHOOK_SECRET_SE=%s\nWEBHOOK_SECRET_NO=%s\n", fake.WebhookSecret, fake.WebhookSecret, fake.WebhookSecret)
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	<-stop
+}
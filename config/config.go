@@ -4,16 +4,35 @@ import (
 	"github.com/joho/godotenv"
 	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type Config struct {
-	Psp_api_key_dk string
-	Psp_api_key_se string
-	Psp_api_key_no string
-	DatabaseDSN    string
-	Backfill_from  string
-	BasicAuthUser  string
-	BasicAuthPass  string
+	Psp_api_key_dk    string
+	Psp_api_key_se    string
+	Psp_api_key_no    string
+	PspAPIURL         string // Frisbii API root, e.g. a cmd/fakefrisbii server; empty for api.frisbii.com
+	WebhookSecretDK   string // Secrets webhooks are signed with per account; empty accepts them unsigned
+	WebhookSecretSE   string
+	WebhookSecretNO   string
+	DatabaseDSN       string // DSN for the driver of DatabaseBackend, without the scheme
+	DatabaseBackend   string // mysql, sqlite, postgres or memory, from the DATABASE_DSN scheme
+	Backfill_from     string
+	BasicAuthUser     string
+	BasicAuthPass     string
+	AdminAuthUser     string // Credentials of the admin API, separate from the read API's; empty disables it
+	AdminAuthPass     string
+	PspHTTPMode       string        // "record" or "replay" Frisbii traffic; empty for normal operation
+	PspCassette       string        // Cassette file used by PspHTTPMode
+	BackfillBatchSize int           // Rows per multi-row upsert during backfill
+	BackfillWorkers   int           // Account/entity pairs backfilled concurrently
+	BackfillRateLimit int           // Frisbii list requests per second per account during backfill
+	BackfillWindow    string        // Size of the date windows a backfill is split into, e.g. month
+	SyncInterval      time.Duration // Time between incremental syncs while serving; 0 disables them
+	SyncJitter        time.Duration // Random delay of up to this much added to SyncInterval
+	RefreshLookback   time.Duration // Age of the oldest open invoice an incremental sync refreshes
 }
 
 func loadEnvFile() {
@@ -26,17 +45,55 @@ func loadEnvFile() {
 func LoadConfig() Config {
 	loadEnvFile()
 	cfg := Config{
-		Psp_api_key_dk: mustGetenv("PSP_API_KEY_DK"),
-		Psp_api_key_se: mustGetenv("PSP_API_KEY_SE"),
-		Psp_api_key_no: mustGetenv("PSP_API_KEY_NO"),
-		DatabaseDSN:    mustGetenv("DATABASE_DSN"),
-		Backfill_from:  mustGetenv("BACKFILL_FROM"),
-		BasicAuthUser:  mustGetenv("BASIC_AUTH_USER"),
-		BasicAuthPass:  mustGetenv("BASIC_AUTH_PASS"),
+		Psp_api_key_dk:    mustGetenv("PSP_API_KEY_DK"),
+		Psp_api_key_se:    mustGetenv("PSP_API_KEY_SE"),
+		Psp_api_key_no:    mustGetenv("PSP_API_KEY_NO"),
+		PspAPIURL:         os.Getenv("PSP_API_URL"),
+		WebhookSecretDK:   os.Getenv("WEBHOOK_SECRET_DK"),
+		WebhookSecretSE:   os.Getenv("WEBHOOK_SECRET_SE"),
+		WebhookSecretNO:   os.Getenv("WEBHOOK_SECRET_NO"),
+		Backfill_from:     mustGetenv("BACKFILL_FROM"),
+		BasicAuthUser:     mustGetenv("BASIC_AUTH_USER"),
+		BasicAuthPass:     mustGetenv("BASIC_AUTH_PASS"),
+		AdminAuthUser:     os.Getenv("ADMIN_AUTH_USER"),
+		AdminAuthPass:     os.Getenv("ADMIN_AUTH_PASS"),
+		PspHTTPMode:       os.Getenv("PSP_HTTP_MODE"),
+		PspCassette:       getenvDefault("PSP_CASSETTE", "cassettes/frisbii.jsonl"),
+		BackfillBatchSize: getenvInt("BACKFILL_BATCH_SIZE", 100),
+		BackfillWorkers:   getenvInt("BACKFILL_WORKERS", 4),
+		BackfillRateLimit: getenvInt("BACKFILL_RATE_LIMIT", 10),
+		BackfillWindow:    getenvDefault("BACKFILL_WINDOW", "month"),
+		SyncInterval:      getenvDuration("SYNC_INTERVAL", 0),
+		SyncJitter:        getenvDuration("SYNC_JITTER", time.Minute),
+		RefreshLookback:   getenvDuration("SYNC_REFRESH_LOOKBACK", 30*24*time.Hour),
 	}
+	cfg.DatabaseBackend, cfg.DatabaseDSN = ParseDatabaseDSN(mustGetenv("DATABASE_DSN"))
 	return cfg
 }
 
+// Database backends selectable by DATABASE_DSN scheme
+const (
+	BackendMySQL    = "mysql"
+	BackendSQLite   = "sqlite"
+	BackendPostgres = "postgres"
+	BackendMemory   = "memory"
+)
+
+// ParseDatabaseDSN splits a DSN such as sqlite://data/psp.db into its backend and the DSN
+// its driver expects. A DSN without a scheme is a MySQL DSN, as before backends were added.
+// Postgres URLs are passed to the driver as they are.
+func ParseDatabaseDSN(dsn string) (backend, driverDSN string) {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		return BackendPostgres, dsn
+	}
+	for _, b := range []string{BackendMySQL, BackendSQLite, BackendMemory} {
+		if rest, ok := strings.CutPrefix(dsn, b+"://"); ok {
+			return b, rest
+		}
+	}
+	return BackendMySQL, dsn
+}
+
 func mustGetenv(key string) string {
 	val := os.Getenv(key)
 	if val == "" {
@@ -44,3 +101,34 @@ func mustGetenv(key string) string {
 	}
 	return val
 }
+
+func getenvDefault(key, def string) string {
+	if val := os.Getenv(key); val != "" {
+		return val
+	}
+	return def
+}
+
+func getenvInt(key string, def int) int {
+	val := os.Getenv(key)
+	if val == "" {
+		return def
+	}
+	n, err := strconv.Atoi(val)
+	if err != nil {
+		log.Fatalf("invalid integer in env var %s: %v", key, err)
+	}
+	return n
+}
+
+func getenvDuration(key string, def time.Duration) time.Duration {
+	val := os.Getenv(key)
+	if val == "" {
+		return def
+	}
+	d, err := time.ParseDuration(val)
+	if err != nil {
+		log.Fatalf("invalid duration in env var %s: %v", key, err)
+	}
+	return d
+}
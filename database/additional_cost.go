@@ -0,0 +1,65 @@
+package database
+
+import (
+	"context"
+	"github.com/AndersKaae/legaldesk_psp_sync/money"
+	"time"
+)
+
+// AdditionalCost is a one-off fee added to a customer, transferred to an invoice when billed
+type AdditionalCost struct {
+	Handle        string
+	Customer      string
+	Subscription  string
+	State         string
+	Invoice       string
+	Amount        money.Money
+	Vat           float64
+	Quantity      int
+	Ordertext     string
+	AmountInclVat bool
+	Created       time.Time
+	Transferred   *time.Time
+	Cancelled     *time.Time
+	Country       string
+}
+
+// Credit is a customer credit, transferred to an invoice when used
+type Credit struct {
+	Handle       string
+	Customer     string
+	Subscription string
+	State        string
+	Invoice      string
+	Amount       money.Money
+	Text         string
+	Created      time.Time
+	Transferred  *time.Time
+	Cancelled    *time.Time
+	Country      string
+}
+
+func (s *SQLStore) CreateOrUpdateAdditionalCost(ctx context.Context, additionalCost *AdditionalCost) error {
+	query := upsertQuery(s.dialect, "additional_costs", []string{"handle", "country"}, []string{
+		"handle", "customer", "subscription", "state", "invoice", "amount", "vat", "quantity",
+		"ordertext", "amount_incl_vat", "created", "transferred", "cancelled", "country",
+	}, 1)
+	_, err := s.db.ExecContext(ctx, query,
+		additionalCost.Handle, additionalCost.Customer, additionalCost.Subscription, additionalCost.State, additionalCost.Invoice,
+		additionalCost.Amount.Amount, additionalCost.Vat, additionalCost.Quantity, additionalCost.Ordertext, additionalCost.AmountInclVat,
+		additionalCost.Created, additionalCost.Transferred, additionalCost.Cancelled, additionalCost.Country,
+	)
+	return err
+}
+
+func (s *SQLStore) CreateOrUpdateCredit(ctx context.Context, credit *Credit) error {
+	query := upsertQuery(s.dialect, "credits", []string{"handle", "country"}, []string{
+		"handle", "customer", "subscription", "state", "invoice", "amount", "text",
+		"created", "transferred", "cancelled", "country",
+	}, 1)
+	_, err := s.db.ExecContext(ctx, query,
+		credit.Handle, credit.Customer, credit.Subscription, credit.State, credit.Invoice, credit.Amount.Amount, credit.Text,
+		credit.Created, credit.Transferred, credit.Cancelled, credit.Country,
+	)
+	return err
+}
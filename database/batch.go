@@ -0,0 +1,139 @@
+package database
+
+import (
+	"context"
+	"fmt"
+)
+
+// RowError is a row of a batch that could not be saved; the rest of the batch was
+type RowError struct {
+	Key string // Handle or id of the row
+	Err error
+}
+
+func (e RowError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Key, e.Err)
+}
+
+// CreateOrUpdateCustomers upserts customers in one transaction, batchSize rows per
+// statement. Customers the database rejects are returned as RowErrors; the rest are saved.
+func (s *SQLStore) CreateOrUpdateCustomers(ctx context.Context, customers []Customer, batchSize int) ([]RowError, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var failed []RowError
+	for _, batch := range batches(customers, batchSize) {
+		rowErrors, err := saveBatch(ctx, tx, len(batch),
+			func() error { return s.writeCustomers(ctx, tx, batch) },
+			func(i int) (string, error) { return batch[i].Handle, s.writeCustomers(ctx, tx, batch[i:i+1]) },
+		)
+		if err != nil {
+			return nil, err
+		}
+		failed = append(failed, rowErrors...)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit customers: %w", err)
+	}
+	return failed, nil
+}
+
+// CreateOrUpdateInvoices upserts invoices in one transaction, batchSize rows per statement,
+// recording state transitions as CreateOrUpdateInvoice does. Invoices that fail validation
+// or that the database rejects are returned as RowErrors; the rest are saved.
+func (s *SQLStore) CreateOrUpdateInvoices(ctx context.Context, invoices []Invoice, source string, batchSize int) ([]RowError, error) {
+	var failed []RowError
+	var valid []Invoice
+	for i := range invoices {
+		if err := validateInvoice(&invoices[i]); err != nil {
+			failed = append(failed, RowError{Key: invoices[i].ID, Err: err})
+			continue
+		}
+		valid = append(valid, invoices[i])
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, batch := range batches(valid, batchSize) {
+		rowErrors, err := saveBatch(ctx, tx, len(batch),
+			func() error { return s.writeInvoices(ctx, tx, batch, source) },
+			func(i int) (string, error) { return batch[i].ID, s.writeInvoices(ctx, tx, batch[i:i+1], source) },
+		)
+		if err != nil {
+			return nil, err
+		}
+		failed = append(failed, rowErrors...)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit invoices: %w", err)
+	}
+	return failed, nil
+}
+
+// batches splits rows into slices of at most size rows; size 0 or less means one batch
+func batches[T any](rows []T, size int) [][]T {
+	if size <= 0 {
+		size = len(rows)
+	}
+	var out [][]T
+	for len(rows) > 0 {
+		n := min(size, len(rows))
+		out = append(out, rows[:n])
+		rows = rows[n:]
+	}
+	return out
+}
+
+// saveBatch runs writeAll under a savepoint. If it fails, each of the n rows is written on
+// its own by writeOne under a savepoint of its own, so a bad row fails only itself. The
+// error is for failures of the transaction rather than of a row.
+func saveBatch(ctx context.Context, tx *txn, n int, writeAll func() error, writeOne func(i int) (string, error)) ([]RowError, error) {
+	batchErr, err := withSavepoint(ctx, tx, "batch", writeAll)
+	if err != nil || batchErr == nil {
+		return nil, err
+	}
+
+	var failed []RowError
+	for i := 0; i < n; i++ {
+		var key string
+		rowErr, err := withSavepoint(ctx, tx, "batch_row", func() error {
+			var err error
+			key, err = writeOne(i)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		if rowErr != nil {
+			failed = append(failed, RowError{Key: key, Err: rowErr})
+		}
+	}
+	return failed, nil
+}
+
+// withSavepoint runs fn, rolling back to before it if it fails. fnErr is the error of fn;
+// err is set if the savepoint itself failed and the transaction can't be trusted.
+func withSavepoint(ctx context.Context, tx *txn, name string, fn func() error) (fnErr, err error) {
+	if _, err := tx.ExecContext(ctx, "SAVEPOINT "+name); err != nil {
+		return nil, fmt.Errorf("failed to create savepoint: %w", err)
+	}
+	if fnErr := fn(); fnErr != nil {
+		if _, err := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+name); err != nil {
+			return fnErr, fmt.Errorf("failed to roll back to savepoint: %w", err)
+		}
+		return fnErr, nil
+	}
+	if _, err := tx.ExecContext(ctx, "RELEASE SAVEPOINT "+name); err != nil {
+		return nil, fmt.Errorf("failed to release savepoint: %w", err)
+	}
+	return nil, nil
+}
@@ -0,0 +1,181 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SyncCheckpoint is how far the backfill of one entity of one account has come, summed over
+// its windows. It is Completed once every window is; NextPageToken is only left by backfills
+// that predate windows, which resume from it.
+type SyncCheckpoint struct {
+	Account       string     `json:"account"`
+	Entity        string     `json:"entity"`
+	NextPageToken string     `json:"next_page_token"`
+	LastCreated   *time.Time `json:"last_created"` // Newest created timestamp saved so far
+	Pages         int        `json:"pages"`
+	Items         int64      `json:"items"`
+	Completed     bool       `json:"completed"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+var syncCheckpointColumns = []string{
+	"account", "entity", "next_page_token", "last_created", "pages", "items", "completed", "updated_at",
+}
+
+func scanSyncCheckpoint(row interface{ Scan(...interface{}) error }) (SyncCheckpoint, error) {
+	var cp SyncCheckpoint
+	var nextPageToken sql.NullString
+	var lastCreated sql.NullTime
+	err := row.Scan(&cp.Account, &cp.Entity, &nextPageToken, &lastCreated, &cp.Pages, &cp.Items, &cp.Completed, &cp.UpdatedAt)
+	cp.NextPageToken = nextPageToken.String
+	if lastCreated.Valid {
+		cp.LastCreated = &lastCreated.Time
+	}
+	return cp, err
+}
+
+// GetSyncCheckpoint returns the checkpoint of an entity for an account, or nil if its
+// backfill has not started
+func (s *SQLStore) GetSyncCheckpoint(ctx context.Context, account, entity string) (*SyncCheckpoint, error) {
+	cp, err := scanSyncCheckpoint(s.db.QueryRowContext(ctx,
+		"SELECT account, entity, next_page_token, last_created, pages, items, completed, updated_at FROM sync_checkpoints WHERE account = ? AND entity = ?",
+		account, entity,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query sync checkpoint: %w", err)
+	}
+	return &cp, nil
+}
+
+// SaveSyncCheckpoint stores a checkpoint, stamping its UpdatedAt
+func (s *SQLStore) SaveSyncCheckpoint(ctx context.Context, cp *SyncCheckpoint) error {
+	cp.UpdatedAt = time.Now().UTC()
+	query := upsertQuery(s.dialect, "sync_checkpoints", []string{"account", "entity"}, syncCheckpointColumns, 1)
+	_, err := s.db.ExecContext(ctx, query,
+		cp.Account, cp.Entity, cp.NextPageToken, cp.LastCreated, cp.Pages, cp.Items, cp.Completed, cp.UpdatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save sync checkpoint: %w", err)
+	}
+	return nil
+}
+
+// ResetSyncCheckpoint deletes the checkpoint and windows of an entity for an account so its
+// next backfill starts over; an empty entity resets every entity of the account
+func (s *SQLStore) ResetSyncCheckpoint(ctx context.Context, account, entity string) error {
+	where := " WHERE account = ?"
+	args := []interface{}{account}
+	if entity != "" {
+		where += " AND entity = ?"
+		args = append(args, entity)
+	}
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM sync_windows"+where, args...); err != nil {
+		return fmt.Errorf("failed to reset sync windows: %w", err)
+	}
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM sync_checkpoints"+where, args...); err != nil {
+		return fmt.Errorf("failed to reset sync checkpoint: %w", err)
+	}
+	return nil
+}
+
+// GetSyncCheckpoints returns every checkpoint ordered by account and entity
+func (s *SQLStore) GetSyncCheckpoints(ctx context.Context) ([]SyncCheckpoint, error) {
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT account, entity, next_page_token, last_created, pages, items, completed, updated_at FROM sync_checkpoints ORDER BY account, entity",
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query sync checkpoints: %w", err)
+	}
+	defer rows.Close()
+
+	var checkpoints []SyncCheckpoint
+	for rows.Next() {
+		cp, err := scanSyncCheckpoint(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan sync checkpoint row: %w", err)
+		}
+		checkpoints = append(checkpoints, cp)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during rows iteration: %w", err)
+	}
+
+	return checkpoints, nil
+}
+
+// SyncWindow is how far the backfill of one date window of an entity of an account has come.
+// Windows are checkpointed independently, so a failed window is retried on its own.
+type SyncWindow struct {
+	Account       string     `json:"account"`
+	Entity        string     `json:"entity"`
+	From          time.Time  `json:"from"`
+	To            *time.Time `json:"to"` // Nil for an open ended last window
+	NextPageToken string     `json:"next_page_token"`
+	Pages         int        `json:"pages"`
+	Items         int64      `json:"items"`
+	Failed        int64      `json:"failed"`   // Items that could not be saved
+	Attempts      int        `json:"attempts"` // Failed attempts so far
+	Completed     bool       `json:"completed"`
+	LastError     string     `json:"last_error"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+var syncWindowColumns = []string{
+	"account", "entity", "window_from", "window_to", "next_page_token", "pages", "items", "failed",
+	"attempts", "completed", "last_error", "updated_at",
+}
+
+// GetSyncWindows returns the windows of an entity for an account ordered by start
+func (s *SQLStore) GetSyncWindows(ctx context.Context, account, entity string) ([]SyncWindow, error) {
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT "+strings.Join(syncWindowColumns, ", ")+" FROM sync_windows WHERE account = ? AND entity = ? ORDER BY window_from",
+		account, entity,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query sync windows: %w", err)
+	}
+	defer rows.Close()
+
+	var windows []SyncWindow
+	for rows.Next() {
+		var w SyncWindow
+		var to sql.NullTime
+		var nextPageToken, lastError sql.NullString
+		if err := rows.Scan(&w.Account, &w.Entity, &w.From, &to, &nextPageToken, &w.Pages, &w.Items, &w.Failed,
+			&w.Attempts, &w.Completed, &lastError, &w.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan sync window row: %w", err)
+		}
+		w.To = nullTimePtr(to)
+		w.NextPageToken, w.LastError = nextPageToken.String, lastError.String
+		windows = append(windows, w)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during rows iteration: %w", err)
+	}
+
+	return windows, nil
+}
+
+// SaveSyncWindow stores a window, stamping its UpdatedAt
+func (s *SQLStore) SaveSyncWindow(ctx context.Context, w *SyncWindow) error {
+	w.UpdatedAt = time.Now().UTC()
+	query := upsertQuery(s.dialect, "sync_windows", []string{"account", "entity", "window_from"}, syncWindowColumns, 1)
+	_, err := s.db.ExecContext(ctx, query,
+		w.Account, w.Entity, w.From, w.To, w.NextPageToken, w.Pages, w.Items, w.Failed,
+		w.Attempts, w.Completed, w.LastError, w.UpdatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save sync window: %w", err)
+	}
+	return nil
+}
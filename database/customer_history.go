@@ -0,0 +1,200 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// CustomerVersion is a snapshot of a customer's tracked fields, valid from ValidFrom until
+// ValidTo (nil for the current version)
+type CustomerVersion struct {
+	ID         int64      `json:"id"`
+	Handle     string     `json:"handle"`
+	Email      string     `json:"email"`
+	FirstName  string     `json:"first_name"`
+	LastName   string     `json:"last_name"`
+	Company    string     `json:"company"`
+	Address    string     `json:"address"`
+	Address2   string     `json:"address2"`
+	PostalCode string     `json:"postal_code"`
+	City       string     `json:"city"`
+	Country    string     `json:"country"`
+	Phone      string     `json:"phone"`
+	ValidFrom  time.Time  `json:"valid_from"`
+	ValidTo    *time.Time `json:"valid_to"`
+}
+
+// CustomerFieldChange is a tracked field whose value differs between two versions
+type CustomerFieldChange struct {
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+// CustomerVersionDiff lists the fields changed when a version took effect
+type CustomerVersionDiff struct {
+	VersionID int64                 `json:"version_id"`
+	ChangedAt time.Time             `json:"changed_at"`
+	Changes   []CustomerFieldChange `json:"changes"`
+}
+
+// trackedFields returns the versioned fields in a stable order
+func (v CustomerVersion) trackedFields() [][2]string {
+	return [][2]string{
+		{"email", v.Email},
+		{"first_name", v.FirstName},
+		{"last_name", v.LastName},
+		{"company", v.Company},
+		{"address", v.Address},
+		{"address2", v.Address2},
+		{"postal_code", v.PostalCode},
+		{"city", v.City},
+		{"country", v.Country},
+		{"phone", v.Phone},
+	}
+}
+
+func customerVersionOf(c *Customer) CustomerVersion {
+	return CustomerVersion{
+		Handle:     c.Handle,
+		Email:      c.Email,
+		FirstName:  c.FirstName,
+		LastName:   c.LastName,
+		Company:    c.Company,
+		Address:    c.Address,
+		Address2:   c.Address2,
+		PostalCode: c.PostalCode,
+		City:       c.City,
+		Country:    c.Country,
+		Phone:      c.Phone,
+	}
+}
+
+// DiffCustomerVersions returns the tracked fields that differ from old to new
+func DiffCustomerVersions(old, new CustomerVersion) []CustomerFieldChange {
+	var changes []CustomerFieldChange
+	oldFields := old.trackedFields()
+	for i, f := range new.trackedFields() {
+		if oldFields[i][1] != f[1] {
+			changes = append(changes, CustomerFieldChange{Field: f[0], Old: oldFields[i][1], New: f[1]})
+		}
+	}
+	return changes
+}
+
+const customerVersionColumns = `id, handle, email, first_name, last_name, company, address, address2,
+	postal_code, city, country, phone, valid_from, valid_to`
+
+func scanCustomerVersion(row interface{ Scan(...interface{}) error }) (CustomerVersion, error) {
+	var v CustomerVersion
+	var validTo sql.NullTime
+	err := row.Scan(&v.ID, &v.Handle, &v.Email, &v.FirstName, &v.LastName, &v.Company, &v.Address, &v.Address2,
+		&v.PostalCode, &v.City, &v.Country, &v.Phone, &v.ValidFrom, &validTo)
+	if validTo.Valid {
+		v.ValidTo = &validTo.Time
+	}
+	return v, err
+}
+
+// versionCustomer closes the current version and opens a new one if a tracked field changed.
+// The first version starts when the customer is first observed: what its fields were before
+// that is unknown, as Frisbii only reports their current values.
+func (s *SQLStore) versionCustomer(ctx context.Context, tx *txn, customer *Customer) error {
+	next := customerVersionOf(customer)
+	now := time.Now().UTC()
+
+	current, err := scanCustomerVersion(tx.QueryRowContext(ctx,
+		"SELECT "+customerVersionColumns+" FROM customer_versions WHERE handle = ? AND valid_to IS NULL"+s.dialect.forUpdate(),
+		customer.Handle,
+	))
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		next.ValidFrom = now
+	case err != nil:
+		return fmt.Errorf("failed to read current customer version: %w", err)
+	default:
+		if len(DiffCustomerVersions(current, next)) == 0 {
+			return nil
+		}
+		next.ValidFrom = now
+		if _, err := tx.ExecContext(ctx, "UPDATE customer_versions SET valid_to = ? WHERE id = ?", now, current.ID); err != nil {
+			return fmt.Errorf("failed to close customer version: %w", err)
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `
+	INSERT INTO customer_versions (
+		handle, email, first_name, last_name, company, address, address2, postal_code, city, country, phone, valid_from
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, next.Handle, next.Email, next.FirstName, next.LastName, next.Company, next.Address, next.Address2,
+		next.PostalCode, next.City, next.Country, next.Phone, next.ValidFrom)
+	if err != nil {
+		return fmt.Errorf("failed to insert customer version: %w", err)
+	}
+	return nil
+}
+
+// GetCustomerAsOf returns the version of a customer valid at t, or nil if there was none,
+// including before the customer was first observed
+func (s *SQLStore) GetCustomerAsOf(ctx context.Context, handle string, t time.Time) (*CustomerVersion, error) {
+	v, err := scanCustomerVersion(s.db.QueryRowContext(ctx,
+		"SELECT "+customerVersionColumns+` FROM customer_versions
+		WHERE handle = ? AND valid_from <= ? AND (valid_to IS NULL OR valid_to > ?)
+		ORDER BY valid_from DESC, id DESC LIMIT 1`,
+		handle, t, t,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query customer version: %w", err)
+	}
+	return &v, nil
+}
+
+// GetCustomerVersions returns all versions of a customer, oldest first
+func (s *SQLStore) GetCustomerVersions(ctx context.Context, handle string) ([]CustomerVersion, error) {
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT "+customerVersionColumns+" FROM customer_versions WHERE handle = ? ORDER BY valid_from, id",
+		handle,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query customer versions: %w", err)
+	}
+	defer rows.Close()
+
+	var versions []CustomerVersion
+	for rows.Next() {
+		v, err := scanCustomerVersion(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan customer version row: %w", err)
+		}
+		versions = append(versions, v)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during rows iteration: %w", err)
+	}
+
+	return versions, nil
+}
+
+// GetCustomerDiffs returns the changes between consecutive versions of a customer
+func (s *SQLStore) GetCustomerDiffs(ctx context.Context, handle string) ([]CustomerVersionDiff, error) {
+	versions, err := s.GetCustomerVersions(ctx, handle)
+	if err != nil {
+		return nil, err
+	}
+	diffs := []CustomerVersionDiff{}
+	for i := 1; i < len(versions); i++ {
+		diffs = append(diffs, CustomerVersionDiff{
+			VersionID: versions[i].ID,
+			ChangedAt: versions[i].ValidFrom,
+			Changes:   DiffCustomerVersions(versions[i-1], versions[i]),
+		})
+	}
+	return diffs, nil
+}
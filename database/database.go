@@ -1,324 +1,321 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
-	"github.com/AndersKaae/legaldesk_psp_sync/config"
-	"github.com/go-sql-driver/mysql"
+	"github.com/AndersKaae/legaldesk_psp_sync/money"
 	"strings"
 	"time"
 )
 
 type InvoiceStates map[string]*time.Time
 
+// Customer amounts are aggregates in Currency, the currency of the Frisbii account
 type Customer struct {
 	ActiveSubscriptions             int
 	Address                         string
 	Address2                        string
-	CancelledAmount                 int64
+	CancelledAmount                 money.Money
 	CancelledInvoices               int
 	CancelledSubscriptions          int
 	City                            string
 	Company                         string
 	Country                         string
 	Created                         time.Time
-	DunningAmount                   int64
+	Currency                        string
+	DunningAmount                   money.Money
 	DunningInvoices                 int
 	Email                           string
 	ExpiredSubscriptions            int
-	FailedAmount                    int64
+	FailedAmount                    money.Money
 	FailedInvoices                  int
 	FirstName                       string
 	Handle                          string
 	LastName                        string
 	NonRenewingSubscriptions        int
 	OnHoldSubscriptions             int
-	PendingAdditionalCostAmount     int64
+	PendingAdditionalCostAmount     money.Money
 	PendingAdditionalCosts          int
-	PendingAmount                   int64
-	PendingCreditAmount             int64
+	PendingAmount                   money.Money
+	PendingCreditAmount             money.Money
 	PendingCredits                  int
 	PendingInvoices                 int
 	Phone                           string
 	PostalCode                      string
-	RefundedAmount                  int64
-	SettledAmount                   int64
+	RefundedAmount                  money.Money
+	SettledAmount                   money.Money
 	SettledInvoices                 int
 	Subscriptions                   int
 	Test                            bool
-	TransferredAdditionalCostAmount int64
+	TransferredAdditionalCostAmount money.Money
 	TransferredAdditionalCosts      int
-	TransferredCreditAmount         int64
+	TransferredCreditAmount         money.Money
 	TransferredCredits              int
 	TrialActiveSubscriptions        int
 	TrialCancelledSubscriptions     int
 }
 
 type Invoice struct {
-	ID               string        `json:"id"`
-	Handle           string        `json:"handle"`
-	Customer         string        `json:"customer"`
-	CustomerEmail    sql.NullString `json:"customer_email"`
-	Currency         string        `json:"currency"`
-	Created          time.Time     `json:"created"`
-	DiscountAmount   int64         `json:"discount_amount"`
-	OrgAmount        int64         `json:"org_amount"`
-	AmountVAT        int64         `json:"amount_vat"`
-	AmountExVAT      int64         `json:"amount_ex_vat"`
-	RefundedAmount   int64         `json:"refunded_amount"`
-	AuthorizedAmount int64         `json:"authorized_amount"`
-	Country          string        `json:"country"`
-	Plan             string        `json:"plan"`
-	States           InvoiceStates `json:"states"`
+	ID               string            `json:"id"`
+	Handle           string            `json:"handle"`
+	Customer         string            `json:"customer"`
+	Subscription     string            `json:"subscription"`
+	CustomerEmail    sql.NullString    `json:"customer_email"`
+	Currency         string            `json:"currency"`
+	Created          time.Time         `json:"created"`
+	DiscountAmount   money.Money       `json:"discount_amount"`
+	OrgAmount        money.Money       `json:"org_amount"`
+	AmountVAT        money.Money       `json:"amount_vat"`
+	AmountExVAT      money.Money       `json:"amount_ex_vat"`
+	RefundedAmount   money.Money       `json:"refunded_amount"`
+	AuthorizedAmount money.Money       `json:"authorized_amount"`
+	Country          string            `json:"country"`
+	Plan             string            `json:"plan"`
+	State            string            `json:"state"`
+	States           InvoiceStates     `json:"states"`
+	Discounts        []InvoiceDiscount `json:"discounts"`
 }
 
-var db *sql.DB
+// setCurrency stamps the invoice currency on its amounts after scanning them as minor units
+func (i *Invoice) setCurrency() {
+	for _, m := range []*money.Money{&i.DiscountAmount, &i.OrgAmount, &i.AmountVAT, &i.AmountExVAT, &i.RefundedAmount, &i.AuthorizedAmount} {
+		m.Currency = i.Currency
+	}
+	for d := range i.Discounts {
+		i.Discounts[d].Amount.Currency = i.Currency
+	}
+}
 
-func InitDB(cfg config.Config) error {
-	if err := createDatabaseIfNotExists(cfg.DatabaseDSN); err != nil {
-		return err
+// setCurrency stamps the customer currency on its amounts after scanning them as minor units
+func (c *Customer) setCurrency() {
+	for _, m := range c.amounts() {
+		m.Currency = c.Currency
 	}
-	var err error
-	db, err = sql.Open("mysql", cfg.DatabaseDSN)
-	if err != nil {
-		return fmt.Errorf("failed to open database: %w", err)
+}
+
+// amounts returns the customer's aggregate amounts
+func (c *Customer) amounts() []*money.Money {
+	return []*money.Money{
+		&c.CancelledAmount, &c.DunningAmount, &c.FailedAmount, &c.PendingAdditionalCostAmount, &c.PendingAmount,
+		&c.PendingCreditAmount, &c.RefundedAmount, &c.SettledAmount, &c.TransferredAdditionalCostAmount, &c.TransferredCreditAmount,
 	}
+}
 
-	if err = db.Ping(); err != nil {
-		return fmt.Errorf("failed to connect to database: %w", err)
+// SQLStore is the Store implementation for SQL databases; dialect covers the SQL that
+// differs between them
+type SQLStore struct {
+	db      *conn
+	dialect dialect
+}
+
+func newSQLStore(db *sql.DB, d dialect) (*SQLStore, error) {
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
 	}
 
-	if err = createTables(); err != nil {
-		return fmt.Errorf("failed to create tables: %w", err)
+	s := &SQLStore{db: &conn{DB: db, dialect: d}, dialect: d}
+	if err := s.createMigrationsTable(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create migrations table: %w", err)
 	}
-	return nil
+	return s, nil
 }
 
-func createDatabaseIfNotExists(dsn string) error {
-	cfg, err := mysql.ParseDSN(dsn)
+// Backend returns the name of the database backend
+func (s *SQLStore) Backend() string {
+	return s.dialect.name()
+}
+
+// Close closes the database connection pool
+func (s *SQLStore) Close() error {
+	return s.db.Close()
+}
+
+var customerColumns = []string{
+	"handle", "active_subscriptions", "address", "address2", "cancelled_amount", "cancelled_invoices",
+	"cancelled_subscriptions", "city", "company", "country", "created", "dunning_amount", "dunning_invoices",
+	"email", "expired_subscriptions", "failed_amount", "failed_invoices", "first_name", "last_name",
+	"non_renewing_subscriptions", "on_hold_subscriptions", "pending_additional_cost_amount",
+	"pending_additional_costs", "pending_amount", "pending_credit_amount", "pending_credits",
+	"pending_invoices", "phone", "postal_code", "refunded_amount", "settled_amount", "settled_invoices",
+	"subscriptions", "test", "transferred_additional_cost_amount", "transferred_additional_costs",
+	"transferred_credit_amount", "transferred_credits", "trial_active_subscriptions",
+	"trial_cancelled_subscriptions", "currency",
+}
+
+var invoiceColumns = []string{
+	"id", "handle", "customer", "currency", "created", "discount_amount", "org_amount",
+	"amount_vat", "amount_ex_vat", "refunded_amount", "authorized_amount", "country", "plan", "states", "subscription", "state",
+}
+
+// CreateOrUpdateCustomer upserts a customer and starts a new customer version if any
+// tracked field changed
+func (s *SQLStore) CreateOrUpdateCustomer(ctx context.Context, customer *Customer) error {
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
-		return fmt.Errorf("failed to parse DSN: %w", err)
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := s.writeCustomers(ctx, tx, []Customer{*customer}); err != nil {
+		return err
 	}
 
-	dbName := cfg.DBName
-	cfg.DBName = ""
+	return tx.Commit()
+}
 
-	db, err := sql.Open("mysql", cfg.FormatDSN())
-	if err != nil {
-		return fmt.Errorf("failed to open database for creation check: %w", err)
+func customerArgs(customer *Customer) []interface{} {
+	return []interface{}{
+		customer.Handle, customer.ActiveSubscriptions, customer.Address, customer.Address2, customer.CancelledAmount.Amount, customer.CancelledInvoices,
+		customer.CancelledSubscriptions, customer.City, customer.Company, customer.Country, customer.Created, customer.DunningAmount.Amount, customer.DunningInvoices,
+		customer.Email, customer.ExpiredSubscriptions, customer.FailedAmount.Amount, customer.FailedInvoices, customer.FirstName, customer.LastName,
+		customer.NonRenewingSubscriptions, customer.OnHoldSubscriptions, customer.PendingAdditionalCostAmount.Amount,
+		customer.PendingAdditionalCosts, customer.PendingAmount.Amount, customer.PendingCreditAmount.Amount, customer.PendingCredits,
+		customer.PendingInvoices, customer.Phone, customer.PostalCode, customer.RefundedAmount.Amount, customer.SettledAmount.Amount, customer.SettledInvoices,
+		customer.Subscriptions, customer.Test, customer.TransferredAdditionalCostAmount.Amount, customer.TransferredAdditionalCosts,
+		customer.TransferredCreditAmount.Amount, customer.TransferredCredits, customer.TrialActiveSubscriptions,
+		customer.TrialCancelledSubscriptions, customer.Currency,
 	}
-	defer db.Close()
+}
 
-	_, err = db.Exec(fmt.Sprintf("CREATE DATABASE IF NOT EXISTS %s", dbName))
-	if err != nil {
-		return fmt.Errorf("failed to create database: %w", err)
+// writeCustomers upserts customers with one statement and versions each of them
+func (s *SQLStore) writeCustomers(ctx context.Context, tx *txn, customers []Customer) error {
+	var args []interface{}
+	for i := range customers {
+		args = append(args, customerArgs(&customers[i])...)
+	}
+	query := upsertQuery(s.dialect, "customers", []string{"handle"}, customerColumns, len(customers))
+	if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+		return err
 	}
 
+	for i := range customers {
+		if err := s.versionCustomer(ctx, tx, &customers[i]); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-func createTables() error {
-	customerTable := `
-	CREATE TABLE IF NOT EXISTS customers (
-		handle VARCHAR(255) PRIMARY KEY,
-		active_subscriptions INTEGER,
-		address TEXT,
-		address2 TEXT,
-		cancelled_amount INTEGER,
-		cancelled_invoices INTEGER,
-		cancelled_subscriptions INTEGER,
-		city VARCHAR(255),
-		company VARCHAR(255),
-		country VARCHAR(255),
-		created DATETIME,
-		dunning_amount INTEGER,
-		dunning_invoices INTEGER,
-		email VARCHAR(255),
-		expired_subscriptions INTEGER,
-		failed_amount INTEGER,
-		failed_invoices INTEGER,
-		first_name VARCHAR(255),
-		last_name VARCHAR(255),
-		non_renewing_subscriptions INTEGER,
-		on_hold_subscriptions INTEGER,
-		pending_additional_cost_amount INTEGER,
-		pending_additional_costs INTEGER,
-		pending_amount INTEGER,
-		pending_credit_amount INTEGER,
-		pending_credits INTEGER,
-		pending_invoices INTEGER,
-		phone VARCHAR(255),
-		postal_code VARCHAR(255),
-		refunded_amount INTEGER,
-		settled_amount INTEGER,
-		settled_invoices INTEGER,
-		subscriptions INTEGER,
-		test BOOLEAN,
-		transferred_additional_cost_amount INTEGER,
-		transferred_additional_costs INTEGER,
-		transferred_credit_amount INTEGER,
-		transferred_credits INTEGER,
-		trial_active_subscriptions INTEGER,
-		trial_cancelled_subscriptions INTEGER
-	);`
-
-	invoiceTable := `
-	CREATE TABLE IF NOT EXISTS invoices (
-		id VARCHAR(255) PRIMARY KEY,
-		handle VARCHAR(255),
-		customer VARCHAR(255),
-		currency VARCHAR(255),
-		created DATETIME,
-		discount_amount INTEGER,
-		org_amount INTEGER,
-		amount_vat INTEGER,
-		amount_ex_vat INTEGER,
-		refunded_amount INTEGER,
-		authorized_amount INTEGER,
-		country VARCHAR(255),
-		plan VARCHAR(255),
-		states JSON
-	);`
-
-	if _, err := db.Exec(customerTable); err != nil {
+// validateInvoice rejects invoices that would break the virtual office export
+func validateInvoice(invoice *Invoice) error {
+	if strings.Contains(invoice.Handle, "inv") && invoice.Plan == "" {
+		return fmt.Errorf("invoice plan cannot be empty for invoices with 'inv' in handle")
+	}
+	return nil
+}
+
+// CreateOrUpdateInvoice upserts an invoice and records a state transition if its state
+// changed; source says where the invoice was observed (webhook, backfill or reconcile)
+func (s *SQLStore) CreateOrUpdateInvoice(ctx context.Context, invoice *Invoice, source string) error {
+	if err := validateInvoice(invoice); err != nil {
 		return err
 	}
 
-	if _, err := db.Exec(invoiceTable); err != nil {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := s.writeInvoices(ctx, tx, []Invoice{*invoice}, source); err != nil {
 		return err
 	}
 
-	return nil
+	return tx.Commit()
 }
 
-func CreateOrUpdateCustomer(customer *Customer) error {
-	query := `
-	INSERT INTO customers (
-		handle, active_subscriptions, address, address2, cancelled_amount, cancelled_invoices,
-		cancelled_subscriptions, city, company, country, created, dunning_amount, dunning_invoices,
-		email, expired_subscriptions, failed_amount, failed_invoices, first_name, last_name,
-		non_renewing_subscriptions, on_hold_subscriptions, pending_additional_cost_amount,
-		pending_additional_costs, pending_amount, pending_credit_amount, pending_credits,
-		pending_invoices, phone, postal_code, refunded_amount, settled_amount, settled_invoices,
-		subscriptions, test, transferred_additional_cost_amount, transferred_additional_costs,
-		transferred_credit_amount, transferred_credits, trial_active_subscriptions,
-		trial_cancelled_subscriptions
-	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-	ON DUPLICATE KEY UPDATE
-		active_subscriptions = VALUES(active_subscriptions),
-		address = VALUES(address),
-		address2 = VALUES(address2),
-		cancelled_amount = VALUES(cancelled_amount),
-		cancelled_invoices = VALUES(cancelled_invoices),
-		cancelled_subscriptions = VALUES(cancelled_subscriptions),
-		city = VALUES(city),
-		company = VALUES(company),
-		country = VALUES(country),
-		created = VALUES(created),
-		dunning_amount = VALUES(dunning_amount),
-		dunning_invoices = VALUES(dunning_invoices),
-		email = VALUES(email),
-		expired_subscriptions = VALUES(expired_subscriptions),
-		failed_amount = VALUES(failed_amount),
-		failed_invoices = VALUES(failed_invoices),
-		first_name = VALUES(first_name),
-		last_name = VALUES(last_name),
-		non_renewing_subscriptions = VALUES(non_renewing_subscriptions),
-		on_hold_subscriptions = VALUES(on_hold_subscriptions),
-		pending_additional_cost_amount = VALUES(pending_additional_cost_amount),
-		pending_additional_costs = VALUES(pending_additional_costs),
-		pending_amount = VALUES(pending_amount),
-		pending_credit_amount = VALUES(pending_credit_amount),
-		pending_credits = VALUES(pending_credits),
-		pending_invoices = VALUES(pending_invoices),
-		phone = VALUES(phone),
-		postal_code = VALUES(postal_code),
-		refunded_amount = VALUES(refunded_amount),
-		settled_amount = VALUES(settled_amount),
-		settled_invoices = VALUES(settled_invoices),
-		subscriptions = VALUES(subscriptions),
-		test = VALUES(test),
-		transferred_additional_cost_amount = VALUES(transferred_additional_cost_amount),
-		transferred_additional_costs = VALUES(transferred_additional_costs),
-		transferred_credit_amount = VALUES(transferred_credit_amount),
-		transferred_credits = VALUES(transferred_credits),
-		trial_active_subscriptions = VALUES(trial_active_subscriptions),
-		trial_cancelled_subscriptions = VALUES(trial_cancelled_subscriptions);
-	`
-	_, err := db.Exec(query,
-		customer.Handle, customer.ActiveSubscriptions, customer.Address, customer.Address2, customer.CancelledAmount, customer.CancelledInvoices,
-		customer.CancelledSubscriptions, customer.City, customer.Company, customer.Country, customer.Created, customer.DunningAmount, customer.DunningInvoices,
-		customer.Email, customer.ExpiredSubscriptions, customer.FailedAmount, customer.FailedInvoices, customer.FirstName, customer.LastName,
-		customer.NonRenewingSubscriptions, customer.OnHoldSubscriptions, customer.PendingAdditionalCostAmount,
-		customer.PendingAdditionalCosts, customer.PendingAmount, customer.PendingCreditAmount, customer.PendingCredits,
-		customer.PendingInvoices, customer.Phone, customer.PostalCode, customer.RefundedAmount, customer.SettledAmount, customer.SettledInvoices,
-		customer.Subscriptions, customer.Test, customer.TransferredAdditionalCostAmount, customer.TransferredAdditionalCosts,
-		customer.TransferredCreditAmount, customer.TransferredCredits, customer.TrialActiveSubscriptions,
-		customer.TrialCancelledSubscriptions,
-	)
-	return err
+func invoiceArgs(invoice *Invoice) ([]interface{}, error) {
+	statesJSON, err := json.Marshal(invoice.States)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal invoice states: %w", err)
+	}
+	return []interface{}{
+		invoice.ID, invoice.Handle, invoice.Customer, invoice.Currency, invoice.Created, invoice.DiscountAmount.Amount, invoice.OrgAmount.Amount,
+		invoice.AmountVAT.Amount, invoice.AmountExVAT.Amount, invoice.RefundedAmount.Amount, invoice.AuthorizedAmount.Amount, invoice.Country, invoice.Plan, string(statesJSON),
+		invoice.Subscription, invoice.State,
+	}, nil
 }
 
-func CreateOrUpdateInvoice(invoice *Invoice) error {
-	if strings.Contains(invoice.Handle, "inv") && invoice.Plan == "" {
-		return fmt.Errorf("invoice plan cannot be empty for invoices with 'inv' in handle")
+// writeInvoices upserts validated invoices with one statement each for the invoices, their
+// discount links and their state transitions
+func (s *SQLStore) writeInvoices(ctx context.Context, tx *txn, invoices []Invoice, source string) error {
+	ids := make([]string, len(invoices))
+	var args []interface{}
+	for i := range invoices {
+		ids[i] = invoices[i].ID
+		invoiceArgs, err := invoiceArgs(&invoices[i])
+		if err != nil {
+			return err
+		}
+		args = append(args, invoiceArgs...)
 	}
-	statesJSON, err := json.Marshal(invoice.States)
+
+	previousStates, err := s.lockInvoiceStates(ctx, tx, ids)
 	if err != nil {
-		return fmt.Errorf("failed to marshal invoice states: %w", err)
+		return err
 	}
 
-	query := `
-	INSERT INTO invoices (
-		id, handle, customer, currency, created, discount_amount, org_amount,
-		amount_vat, amount_ex_vat, refunded_amount, authorized_amount, country, plan, states
-	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-	ON DUPLICATE KEY UPDATE
-		handle = VALUES(handle),
-		customer = VALUES(customer),
-		currency = VALUES(currency),
-		created = VALUES(created),
-		discount_amount = VALUES(discount_amount),
-		org_amount = VALUES(org_amount),
-		amount_vat = VALUES(amount_vat),
-		amount_ex_vat = VALUES(amount_ex_vat),
-		refunded_amount = VALUES(refunded_amount),
-		authorized_amount = VALUES(authorized_amount),
-		country = VALUES(country),
-		plan = VALUES(plan),
-		states = VALUES(states);
-	`
-	_, err = db.Exec(query,
-		invoice.ID, invoice.Handle, invoice.Customer, invoice.Currency, invoice.Created, invoice.DiscountAmount, invoice.OrgAmount,
-		invoice.AmountVAT, invoice.AmountExVAT, invoice.RefundedAmount, invoice.AuthorizedAmount, invoice.Country, invoice.Plan, statesJSON,
-	)
-	return err
+	query := upsertQuery(s.dialect, "invoices", []string{"id"}, invoiceColumns, len(invoices))
+	if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+		return err
+	}
+
+	if err := replaceInvoiceDiscounts(ctx, tx, invoices); err != nil {
+		return err
+	}
+
+	return recordStateTransitions(ctx, tx, invoices, previousStates, source)
+}
+
+// GetAccountInvoices returns the invoices of an account (country) created in [from, to),
+// ordered by creation
+func (s *SQLStore) GetAccountInvoices(ctx context.Context, country string, from, to time.Time) ([]Invoice, error) {
+	return s.queryInvoices(ctx, "country = ? AND created >= ? AND created < ? ORDER BY created, id", country, from, to)
 }
 
-func GetInvoicesByDateRange(from, to time.Time) ([]Invoice, error) {
+// GetInvoicesByID returns the stored invoices among ids, in no particular order
+func (s *SQLStore) GetInvoicesByID(ctx context.Context, ids []string) ([]Invoice, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	return s.queryInvoices(ctx, "id IN ("+placeholders(len(ids))+")", args...)
+}
+
+// queryInvoices returns every stored column of the invoices matching where, with their
+// discounts
+func (s *SQLStore) queryInvoices(ctx context.Context, where string, args ...interface{}) ([]Invoice, error) {
 	query := `
-	SELECT id, handle, customer, currency, created, discount_amount, org_amount,
-	amount_vat, amount_ex_vat, refunded_amount, authorized_amount, country, plan, states
-	FROM invoices WHERE created >= ? AND created < ? ORDER BY created DESC
-	`
-	rows, err := db.Query(query, from, to)
+	SELECT id, handle, customer, subscription, currency, created, discount_amount, org_amount,
+	amount_vat, amount_ex_vat, refunded_amount, authorized_amount, country, plan, state, states
+	FROM invoices WHERE ` + where
+	rows, err := s.db.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to query invoices by date range: %w", err)
+		return nil, fmt.Errorf("failed to query invoices: %w", err)
 	}
 	defer rows.Close()
 
 	var invoices []Invoice
 	for rows.Next() {
 		var invoice Invoice
+		var subscription, state sql.NullString
 		var statesJSON []byte
 		if err := rows.Scan(
-			&invoice.ID, &invoice.Handle, &invoice.Customer, &invoice.Currency, &invoice.Created,
-			&invoice.DiscountAmount, &invoice.OrgAmount, &invoice.AmountVAT, &invoice.AmountExVAT,
-			&invoice.RefundedAmount, &invoice.AuthorizedAmount, &invoice.Country, &invoice.Plan, &statesJSON,
+			&invoice.ID, &invoice.Handle, &invoice.Customer, &subscription, &invoice.Currency, &invoice.Created,
+			&invoice.DiscountAmount.Amount, &invoice.OrgAmount.Amount, &invoice.AmountVAT.Amount, &invoice.AmountExVAT.Amount,
+			&invoice.RefundedAmount.Amount, &invoice.AuthorizedAmount.Amount, &invoice.Country, &invoice.Plan, &state, &statesJSON,
 		); err != nil {
 			return nil, fmt.Errorf("failed to scan invoice row: %w", err)
 		}
+		invoice.Subscription, invoice.State = subscription.String, state.String
+		invoice.setCurrency()
 
 		if err := json.Unmarshal(statesJSON, &invoice.States); err != nil {
 			return nil, fmt.Errorf("failed to unmarshal invoice states: %w", err)
@@ -330,44 +327,61 @@ func GetInvoicesByDateRange(from, to time.Time) ([]Invoice, error) {
 		return nil, fmt.Errorf("error during rows iteration: %w", err)
 	}
 
+	if err := s.loadInvoiceDiscounts(ctx, invoices); err != nil {
+		return nil, err
+	}
 	return invoices, nil
 }
 
-func GetVirtualOfficeInvoicesByDateRange(from, to time.Time) ([]Invoice, error) {
-	query := `
-	SELECT i.id, i.handle, i.customer, c.email, i.currency, i.created, i.discount_amount, i.org_amount,
-	i.amount_vat, i.amount_ex_vat, i.refunded_amount, i.authorized_amount, i.country, i.plan, i.states
-	FROM invoices i
-	LEFT JOIN customers c ON i.customer = c.handle
-	WHERE i.created >= ? AND i.created < ? AND i.handle LIKE "inv%" ORDER BY i.created DESC
-	`
-	rows, err := db.Query(query, from, to)
+// nullString scans a nullable text column into a string, NULL becoming ""
+type nullString string
+
+func (n *nullString) Scan(v interface{}) error {
+	var ns sql.NullString
+	err := ns.Scan(v)
+	*n = nullString(ns.String)
+	return err
+}
+
+// GetCustomersByHandle returns the stored customers among handles, in no particular order
+func (s *SQLStore) GetCustomersByHandle(ctx context.Context, handles []string) ([]Customer, error) {
+	if len(handles) == 0 {
+		return nil, nil
+	}
+	args := make([]interface{}, len(handles))
+	for i, handle := range handles {
+		args[i] = handle
+	}
+	query := "SELECT " + strings.Join(customerColumns, ", ") + " FROM customers WHERE handle IN (" + placeholders(len(handles)) + ")"
+	rows, err := s.db.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to query invoices by date range: %w", err)
+		return nil, fmt.Errorf("failed to query customers: %w", err)
 	}
 	defer rows.Close()
 
-	var invoices []Invoice
+	var customers []Customer
 	for rows.Next() {
-		var invoice Invoice
-		var statesJSON []byte
+		var c Customer
 		if err := rows.Scan(
-			&invoice.ID, &invoice.Handle, &invoice.Customer, &invoice.CustomerEmail, &invoice.Currency, &invoice.Created,
-			&invoice.DiscountAmount, &invoice.OrgAmount, &invoice.AmountVAT, &invoice.AmountExVAT,
-			&invoice.RefundedAmount, &invoice.AuthorizedAmount, &invoice.Country, &invoice.Plan, &statesJSON,
+			(*nullString)(&c.Handle), &c.ActiveSubscriptions, (*nullString)(&c.Address), (*nullString)(&c.Address2), &c.CancelledAmount.Amount, &c.CancelledInvoices,
+			&c.CancelledSubscriptions, (*nullString)(&c.City), (*nullString)(&c.Company), (*nullString)(&c.Country), &c.Created, &c.DunningAmount.Amount, &c.DunningInvoices,
+			(*nullString)(&c.Email), &c.ExpiredSubscriptions, &c.FailedAmount.Amount, &c.FailedInvoices, (*nullString)(&c.FirstName), (*nullString)(&c.LastName),
+			&c.NonRenewingSubscriptions, &c.OnHoldSubscriptions, &c.PendingAdditionalCostAmount.Amount,
+			&c.PendingAdditionalCosts, &c.PendingAmount.Amount, &c.PendingCreditAmount.Amount, &c.PendingCredits,
+			&c.PendingInvoices, (*nullString)(&c.Phone), (*nullString)(&c.PostalCode), &c.RefundedAmount.Amount, &c.SettledAmount.Amount, &c.SettledInvoices,
+			&c.Subscriptions, &c.Test, &c.TransferredAdditionalCostAmount.Amount, &c.TransferredAdditionalCosts,
+			&c.TransferredCreditAmount.Amount, &c.TransferredCredits, &c.TrialActiveSubscriptions,
+			&c.TrialCancelledSubscriptions, (*nullString)(&c.Currency),
 		); err != nil {
-			return nil, fmt.Errorf("failed to scan invoice row: %w", err)
-		}
-
-		if err := json.Unmarshal(statesJSON, &invoice.States); err != nil {
-			return nil, fmt.Errorf("failed to unmarshal invoice states: %w", err)
+			return nil, fmt.Errorf("failed to scan customer row: %w", err)
 		}
-		invoices = append(invoices, invoice)
+		c.setCurrency()
+		customers = append(customers, c)
 	}
 
 	if err = rows.Err(); err != nil {
 		return nil, fmt.Errorf("error during rows iteration: %w", err)
 	}
 
-	return invoices, nil
+	return customers, nil
 }
@@ -0,0 +1,350 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// dialect is the SQL that differs between the databases SQLStore supports. Queries are
+// written with ? placeholders and MySQL-compatible SQL everywhere else they can be.
+type dialect interface {
+	// name is the backend name, which is also the migrations directory of the dialect
+	name() string
+	// rebind rewrites ? placeholders into the form the driver expects
+	rebind(query string) string
+	// arg converts a query argument before it is passed to the driver
+	arg(v interface{}) interface{}
+	// upsert is appended to an INSERT to update columns when a row with the same key exists
+	upsert(key, columns []string) string
+	// forUpdate is appended to a SELECT to lock the rows it reads until the transaction ends
+	forUpdate() string
+	// month formats a datetime column as YYYY-MM
+	month(column string) string
+	// jsonField extracts a top level field of a JSON column, NULL when it is missing
+	jsonField(column, field string) string
+	// migrationsTable creates the schema_migrations bookkeeping table
+	migrationsTable() string
+	// returningID is appended to an INSERT to return its generated id, for drivers that do
+	// not support sql.Result.LastInsertId; empty for the others
+	returningID() string
+	// lockMigrations blocks until the session c holds the lock that keeps processes from
+	// migrating at the same time; unlockMigrations releases it
+	lockMigrations(ctx context.Context, c *sql.Conn) error
+	unlockMigrations(ctx context.Context, c *sql.Conn) error
+}
+
+// migrationLockName names the migration lock of MySQL, and migrationLockKey is the advisory
+// lock key of PostgreSQL
+const (
+	migrationLockName = "psp_sync_migrations"
+	migrationLockKey  = 7313350047
+)
+
+// migrationLockTimeout is how long to wait for another process to finish migrating
+const migrationLockTimeout = 10 * time.Minute
+
+// insertID runs an INSERT into a table with a generated id column and returns the id
+func (s *SQLStore) insertID(ctx context.Context, query string, args ...interface{}) (int64, error) {
+	if returning := s.dialect.returningID(); returning != "" {
+		var id int64
+		err := s.db.QueryRowContext(ctx, query+returning, args...).Scan(&id)
+		return id, err
+	}
+	res, err := s.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// upsertQuery builds an INSERT of rows rows of columns into table that updates every
+// non-key column when a row with the same key exists
+func upsertQuery(d dialect, table string, key, columns []string, rows int) string {
+	var update []string
+	for _, c := range columns {
+		if !contains(key, c) {
+			update = append(update, c)
+		}
+	}
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES %s %s",
+		table, strings.Join(columns, ", "), valueRows(rows, len(columns)), d.upsert(key, update))
+}
+
+// placeholders returns n comma separated ? placeholders
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+// valueRows returns the VALUES list for rows rows of columns columns
+func valueRows(rows, columns int) string {
+	row := "(" + placeholders(columns) + ")"
+	return strings.TrimSuffix(strings.Repeat(row+", ", rows), ", ")
+}
+
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+// mysqlDialect is MySQL 8
+type mysqlDialect struct{}
+
+func (mysqlDialect) name() string                  { return "mysql" }
+func (mysqlDialect) rebind(query string) string    { return query }
+func (mysqlDialect) arg(v interface{}) interface{} { return v }
+func (mysqlDialect) forUpdate() string             { return " FOR UPDATE" }
+func (mysqlDialect) returningID() string           { return "" }
+
+func (mysqlDialect) upsert(key, columns []string) string {
+	set := make([]string, len(columns))
+	for i, c := range columns {
+		set[i] = fmt.Sprintf("%s = VALUES(%s)", c, c)
+	}
+	return "ON DUPLICATE KEY UPDATE " + strings.Join(set, ", ")
+}
+
+func (mysqlDialect) month(column string) string {
+	return fmt.Sprintf("DATE_FORMAT(%s, '%%Y-%%m')", column)
+}
+
+func (mysqlDialect) jsonField(column, field string) string {
+	return fmt.Sprintf("JSON_EXTRACT(%s, '$.%s')", column, field)
+}
+
+func (mysqlDialect) migrationsTable() string {
+	return `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name VARCHAR(255),
+		checksum CHAR(64),
+		applied_at DATETIME
+	);`
+}
+
+// GET_LOCK returns 1 once the lock is taken and 0 on timeout; the lock is released when
+// the session ends, should RELEASE_LOCK not be reached
+func (mysqlDialect) lockMigrations(ctx context.Context, c *sql.Conn) error {
+	var locked sql.NullInt64
+	if err := c.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", migrationLockName, int(migrationLockTimeout.Seconds())).Scan(&locked); err != nil {
+		return err
+	}
+	if locked.Int64 != 1 {
+		return fmt.Errorf("timed out after %s waiting for another process to finish migrating", migrationLockTimeout)
+	}
+	return nil
+}
+
+func (mysqlDialect) unlockMigrations(ctx context.Context, c *sql.Conn) error {
+	_, err := c.ExecContext(ctx, "DO RELEASE_LOCK(?)", migrationLockName)
+	return err
+}
+
+// sqliteDialect is SQLite 3.35 or newer. Times are stored as UTC text so they compare
+// correctly as strings.
+type sqliteDialect struct{}
+
+func (sqliteDialect) name() string               { return "sqlite" }
+func (sqliteDialect) rebind(query string) string { return query }
+func (sqliteDialect) forUpdate() string          { return "" } // Writers are serialized by the database lock
+func (sqliteDialect) returningID() string        { return "" }
+
+func (sqliteDialect) arg(v interface{}) interface{} {
+	switch t := v.(type) {
+	case time.Time:
+		return t.UTC()
+	case *time.Time:
+		if t == nil {
+			return nil
+		}
+		return t.UTC()
+	}
+	return v
+}
+
+func (sqliteDialect) upsert(key, columns []string) string {
+	return onConflictUpdate(key, columns)
+}
+
+func (sqliteDialect) month(column string) string {
+	return fmt.Sprintf("strftime('%%Y-%%m', %s)", column)
+}
+
+func (sqliteDialect) jsonField(column, field string) string {
+	return fmt.Sprintf("json_extract(%s, '$.%s')", column, field)
+}
+
+func (sqliteDialect) migrationsTable() string {
+	return `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name VARCHAR(255),
+		checksum CHAR(64),
+		applied_at DATETIME
+	);`
+}
+
+// SQLite has no session locks; its migrations take the database write lock statement by
+// statement, which is enough for the single process a database file is used by
+func (sqliteDialect) lockMigrations(ctx context.Context, c *sql.Conn) error   { return nil }
+func (sqliteDialect) unlockMigrations(ctx context.Context, c *sql.Conn) error { return nil }
+
+// onConflictUpdate is the standard SQL upsert clause shared by SQLite and PostgreSQL
+func onConflictUpdate(key, columns []string) string {
+	set := make([]string, len(columns))
+	for i, c := range columns {
+		set[i] = fmt.Sprintf("%s = excluded.%s", c, c)
+	}
+	return fmt.Sprintf("ON CONFLICT (%s) DO UPDATE SET %s", strings.Join(key, ", "), strings.Join(set, ", "))
+}
+
+// postgresDialect is PostgreSQL 12 or newer
+type postgresDialect struct{}
+
+func (postgresDialect) name() string                  { return "postgres" }
+func (postgresDialect) arg(v interface{}) interface{} { return v }
+func (postgresDialect) forUpdate() string             { return " FOR UPDATE" }
+func (postgresDialect) returningID() string           { return " RETURNING id" }
+
+// rebind numbers ? placeholders as $1, $2, ... leaving quoted strings alone
+func (postgresDialect) rebind(query string) string {
+	var b strings.Builder
+	n := 0
+	inString := false
+	for _, r := range query {
+		switch {
+		case r == '\'':
+			inString = !inString
+		case r == '?' && !inString:
+			n++
+			fmt.Fprintf(&b, "$%d", n)
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func (postgresDialect) upsert(key, columns []string) string {
+	return onConflictUpdate(key, columns)
+}
+
+func (postgresDialect) month(column string) string {
+	return fmt.Sprintf("to_char(%s AT TIME ZONE 'UTC', 'YYYY-MM')", column)
+}
+
+func (postgresDialect) jsonField(column, field string) string {
+	return fmt.Sprintf("(%s->>'%s')", column, field)
+}
+
+func (postgresDialect) migrationsTable() string {
+	return `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name VARCHAR(255),
+		checksum CHAR(64),
+		applied_at TIMESTAMPTZ
+	);`
+}
+
+func (postgresDialect) lockMigrations(ctx context.Context, c *sql.Conn) error {
+	ctx, cancel := context.WithTimeout(ctx, migrationLockTimeout)
+	defer cancel()
+	if _, err := c.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLockKey); err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("timed out after %s waiting for another process to finish migrating", migrationLockTimeout)
+		}
+		return err
+	}
+	return nil
+}
+
+func (postgresDialect) unlockMigrations(ctx context.Context, c *sql.Conn) error {
+	_, err := c.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", migrationLockKey)
+	return err
+}
+
+// conn is a connection pool that rebinds queries and converts arguments for its dialect
+type conn struct {
+	*sql.DB
+	dialect dialect
+}
+
+func (c *conn) args(args []interface{}) []interface{} {
+	return convertArgs(c.dialect, args)
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return c.DB.ExecContext(ctx, c.dialect.rebind(query), c.args(args)...)
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return c.DB.QueryContext(ctx, c.dialect.rebind(query), c.args(args)...)
+}
+
+func (c *conn) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return c.DB.QueryRowContext(ctx, c.dialect.rebind(query), c.args(args)...)
+}
+
+func (c *conn) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return c.ExecContext(context.Background(), query, args...)
+}
+
+func (c *conn) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return c.QueryContext(context.Background(), query, args...)
+}
+
+func (c *conn) BeginTx(ctx context.Context, opts *sql.TxOptions) (*txn, error) {
+	tx, err := c.DB.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &txn{Tx: tx, dialect: c.dialect}, nil
+}
+
+// session is a single connection that rebinds queries and converts arguments for its
+// dialect, for work that needs session state such as a lock
+type session struct {
+	*sql.Conn
+	dialect dialect
+}
+
+func (c *session) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return c.Conn.ExecContext(ctx, c.dialect.rebind(query), convertArgs(c.dialect, args)...)
+}
+
+func (c *session) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return c.Conn.QueryContext(ctx, c.dialect.rebind(query), convertArgs(c.dialect, args)...)
+}
+
+// txn is a transaction that rebinds queries and converts arguments for its dialect
+type txn struct {
+	*sql.Tx
+	dialect dialect
+}
+
+func (t *txn) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return t.Tx.ExecContext(ctx, t.dialect.rebind(query), convertArgs(t.dialect, args)...)
+}
+
+func (t *txn) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return t.Tx.QueryContext(ctx, t.dialect.rebind(query), convertArgs(t.dialect, args)...)
+}
+
+func (t *txn) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return t.Tx.QueryRowContext(ctx, t.dialect.rebind(query), convertArgs(t.dialect, args)...)
+}
+
+func convertArgs(d dialect, args []interface{}) []interface{} {
+	converted := make([]interface{}, len(args))
+	for i, a := range args {
+		converted[i] = d.arg(a)
+	}
+	return converted
+}
@@ -0,0 +1,31 @@
+package database
+
+import "testing"
+
+func TestPostgresRebind(t *testing.T) {
+	for _, tc := range []struct{ query, want string }{
+		{"SELECT 1", "SELECT 1"},
+		{"SELECT * FROM jobs WHERE id = ?", "SELECT * FROM jobs WHERE id = $1"},
+		{"INSERT INTO t (a, b) VALUES (?, ?), (?, ?)", "INSERT INTO t (a, b) VALUES ($1, $2), ($3, $4)"},
+		{"SELECT '?' AS q, a FROM t WHERE b = ?", "SELECT '?' AS q, a FROM t WHERE b = $1"},
+		{"SELECT 'it''s ?' FROM t WHERE a = ? AND b = '?'", "SELECT 'it''s ?' FROM t WHERE a = $1 AND b = '?'"},
+		{"WHERE i.handle LIKE ? ESCAPE '!' AND i.org_amount >= ?", "WHERE i.handle LIKE $1 ESCAPE '!' AND i.org_amount >= $2"},
+		{"WHERE i.handle LIKE 'inv%' AND c.email = ?", "WHERE i.handle LIKE 'inv%' AND c.email = $1"},
+		{"SELECT (states->>'settled') FROM invoices WHERE id IN (?, ?)", "SELECT (states->>'settled') FROM invoices WHERE id IN ($1, $2)"},
+		{"SELECT ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?", "SELECT $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11"},
+		{"SELECT 'æøå ?' WHERE name = ?", "SELECT 'æøå ?' WHERE name = $1"},
+	} {
+		if got := (postgresDialect{}).rebind(tc.query); got != tc.want {
+			t.Errorf("rebind(%q) = %q, want %q", tc.query, got, tc.want)
+		}
+	}
+}
+
+func TestPostgresUpsertQuery(t *testing.T) {
+	got := postgresDialect{}.rebind(upsertQuery(postgresDialect{}, "credits", []string{"handle", "country"}, []string{"handle", "country", "amount"}, 2))
+	want := "INSERT INTO credits (handle, country, amount) VALUES ($1, $2, $3), ($4, $5, $6) " +
+		"ON CONFLICT (handle, country) DO UPDATE SET amount = excluded.amount"
+	if got != want {
+		t.Errorf("upsert query = %q, want %q", got, want)
+	}
+}
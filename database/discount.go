@@ -0,0 +1,296 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"github.com/AndersKaae/legaldesk_psp_sync/money"
+	"time"
+)
+
+type Discount struct {
+	Handle          string
+	Name            string
+	Description     string
+	State           string
+	Amount          money.Money
+	Percentage      int
+	FixedCount      int
+	FixedPeriodUnit string
+	FixedPeriod     int
+	Created         time.Time
+	Deleted         *time.Time
+	Country         string
+}
+
+type Coupon struct {
+	Handle         string
+	Name           string
+	Code           string
+	Discount       string
+	State          string
+	Redemptions    int
+	MaxRedemptions int
+	ValidUntil     *time.Time
+	Expired        *time.Time
+	Created        time.Time
+	Country        string
+}
+
+type SubscriptionDiscount struct {
+	Handle       string
+	Subscription string
+	Discount     string
+	Coupon       string
+	State        string
+	Amount       money.Money
+	Percentage   int
+	Created      time.Time
+	Deleted      *time.Time
+	Country      string
+}
+
+// InvoiceDiscount links an invoice order line to the subscription discount that produced it
+type InvoiceDiscount struct {
+	OrderLineID          string      `json:"order_line_id"`
+	SubscriptionDiscount string      `json:"subscription_discount"`
+	Amount               money.Money `json:"amount"`
+}
+
+// CouponRedemptions is the number of subscription discounts created per coupon per month
+type CouponRedemptions struct {
+	Coupon      string `json:"coupon"`
+	Discount    string `json:"discount"`
+	Month       string `json:"month"`
+	Redemptions int    `json:"redemptions"`
+}
+
+// CouponDiscountedAmount is the total discounted invoice amount per coupon per month
+type CouponDiscountedAmount struct {
+	Coupon   string      `json:"coupon"`
+	Discount string      `json:"discount"`
+	Month    string      `json:"month"`
+	Amount   money.Money `json:"amount"`
+	Invoices int         `json:"invoices"`
+}
+
+// CouponTrialConversion counts subscriptions whose first discounted invoice was free, and how
+// many of them later settled a paid invoice
+type CouponTrialConversion struct {
+	Coupon    string `json:"coupon"`
+	Discount  string `json:"discount"`
+	Trials    int    `json:"trials"`
+	Converted int    `json:"converted"`
+}
+
+func (s *SQLStore) CreateOrUpdateDiscount(ctx context.Context, discount *Discount) error {
+	query := upsertQuery(s.dialect, "discounts", []string{"handle", "country"}, []string{
+		"handle", "name", "description", "state", "amount", "percentage", "fixed_count",
+		"fixed_period_unit", "fixed_period", "created", "deleted", "country",
+	}, 1)
+	_, err := s.db.ExecContext(ctx, query,
+		discount.Handle, discount.Name, discount.Description, discount.State, discount.Amount.Amount, discount.Percentage, discount.FixedCount,
+		discount.FixedPeriodUnit, discount.FixedPeriod, discount.Created, discount.Deleted, discount.Country,
+	)
+	return err
+}
+
+func (s *SQLStore) CreateOrUpdateCoupon(ctx context.Context, coupon *Coupon) error {
+	query := upsertQuery(s.dialect, "coupons", []string{"handle", "country"}, []string{
+		"handle", "name", "code", "discount", "state", "redemptions", "max_redemptions",
+		"valid_until", "expired", "created", "country",
+	}, 1)
+	_, err := s.db.ExecContext(ctx, query,
+		coupon.Handle, coupon.Name, coupon.Code, coupon.Discount, coupon.State, coupon.Redemptions, coupon.MaxRedemptions,
+		coupon.ValidUntil, coupon.Expired, coupon.Created, coupon.Country,
+	)
+	return err
+}
+
+func (s *SQLStore) CreateOrUpdateSubscriptionDiscount(ctx context.Context, subscriptionDiscount *SubscriptionDiscount) error {
+	query := upsertQuery(s.dialect, "subscription_discounts", []string{"handle", "country"}, []string{
+		"handle", "subscription", "discount", "coupon", "state", "amount", "percentage", "created", "deleted", "country",
+	}, 1)
+	_, err := s.db.ExecContext(ctx, query,
+		subscriptionDiscount.Handle, subscriptionDiscount.Subscription, subscriptionDiscount.Discount, subscriptionDiscount.Coupon,
+		subscriptionDiscount.State, subscriptionDiscount.Amount.Amount, subscriptionDiscount.Percentage, subscriptionDiscount.Created,
+		subscriptionDiscount.Deleted, subscriptionDiscount.Country,
+	)
+	return err
+}
+
+// replaceInvoiceDiscounts rewrites the discount links of invoices, since order lines can
+// change until an invoice is settled
+func replaceInvoiceDiscounts(ctx context.Context, tx *txn, invoices []Invoice) error {
+	ids := make([]interface{}, len(invoices))
+	var args []interface{}
+	for i, invoice := range invoices {
+		ids[i] = invoice.ID
+		for _, d := range invoice.Discounts {
+			args = append(args, invoice.ID, d.OrderLineID, d.SubscriptionDiscount, d.Amount.Amount)
+		}
+	}
+
+	query := "DELETE FROM invoice_discounts WHERE invoice_id IN (" + placeholders(len(ids)) + ")"
+	if _, err := tx.ExecContext(ctx, query, ids...); err != nil {
+		return fmt.Errorf("failed to delete invoice discounts: %w", err)
+	}
+	if len(args) == 0 {
+		return nil
+	}
+
+	query = "INSERT INTO invoice_discounts (invoice_id, order_line_id, subscription_discount, amount) VALUES " + valueRows(len(args)/4, 4)
+	if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+		return fmt.Errorf("failed to insert invoice discounts: %w", err)
+	}
+	return nil
+}
+
+// loadInvoiceDiscounts sets the discount links of invoices
+func (s *SQLStore) loadInvoiceDiscounts(ctx context.Context, invoices []Invoice) error {
+	if len(invoices) == 0 {
+		return nil
+	}
+	byID := make(map[string]*Invoice, len(invoices))
+	ids := make([]interface{}, len(invoices))
+	for i := range invoices {
+		byID[invoices[i].ID] = &invoices[i]
+		ids[i] = invoices[i].ID
+	}
+
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT invoice_id, order_line_id, subscription_discount, amount FROM invoice_discounts WHERE invoice_id IN ("+placeholders(len(ids))+") ORDER BY invoice_id, order_line_id",
+		ids...,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to query invoice discounts: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var invoiceID string
+		var d InvoiceDiscount
+		if err := rows.Scan(&invoiceID, &d.OrderLineID, &d.SubscriptionDiscount, &d.Amount.Amount); err != nil {
+			return fmt.Errorf("failed to scan invoice discount row: %w", err)
+		}
+		d.Amount.Currency = byID[invoiceID].Currency
+		byID[invoiceID].Discounts = append(byID[invoiceID].Discounts, d)
+	}
+
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("error during rows iteration: %w", err)
+	}
+	return nil
+}
+
+// GetCouponRedemptions returns the number of redemptions per coupon per month
+func (s *SQLStore) GetCouponRedemptions(ctx context.Context, from, to time.Time) ([]CouponRedemptions, error) {
+	query := `
+	SELECT COALESCE(coupon, ''), COALESCE(discount, ''), ` + s.dialect.month("created") + ` AS month, COUNT(*)
+	FROM subscription_discounts
+	WHERE created >= ? AND created < ?
+	GROUP BY coupon, discount, month
+	ORDER BY month, coupon, discount
+	`
+	rows, err := s.db.QueryContext(ctx, query, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query coupon redemptions: %w", err)
+	}
+	defer rows.Close()
+
+	var redemptions []CouponRedemptions
+	for rows.Next() {
+		var r CouponRedemptions
+		if err := rows.Scan(&r.Coupon, &r.Discount, &r.Month, &r.Redemptions); err != nil {
+			return nil, fmt.Errorf("failed to scan coupon redemption row: %w", err)
+		}
+		redemptions = append(redemptions, r)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during rows iteration: %w", err)
+	}
+
+	return redemptions, nil
+}
+
+// GetCouponDiscountedAmounts returns the discounted invoice amount per coupon per month
+func (s *SQLStore) GetCouponDiscountedAmounts(ctx context.Context, from, to time.Time) ([]CouponDiscountedAmount, error) {
+	query := `
+	SELECT COALESCE(sd.coupon, ''), COALESCE(sd.discount, ''), ` + s.dialect.month("i.created") + ` AS month, i.currency,
+	SUM(ABS(d.amount)), COUNT(DISTINCT i.id)
+	FROM invoice_discounts d
+	JOIN invoices i ON i.id = d.invoice_id
+	JOIN subscription_discounts sd ON sd.handle = d.subscription_discount AND sd.country = i.country
+	WHERE i.created >= ? AND i.created < ?
+	GROUP BY sd.coupon, sd.discount, month, i.currency
+	ORDER BY month, sd.coupon, sd.discount
+	`
+	rows, err := s.db.QueryContext(ctx, query, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query coupon discounted amounts: %w", err)
+	}
+	defer rows.Close()
+
+	var amounts []CouponDiscountedAmount
+	for rows.Next() {
+		var a CouponDiscountedAmount
+		if err := rows.Scan(&a.Coupon, &a.Discount, &a.Month, &a.Amount.Currency, &a.Amount.Amount, &a.Invoices); err != nil {
+			return nil, fmt.Errorf("failed to scan coupon discounted amount row: %w", err)
+		}
+		amounts = append(amounts, a)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during rows iteration: %w", err)
+	}
+
+	return amounts, nil
+}
+
+// GetCouponTrialConversions returns, per coupon, the subscriptions redeemed in the range whose
+// discount produced a free invoice, and how many of those settled a paid invoice created
+// after the free one
+func (s *SQLStore) GetCouponTrialConversions(ctx context.Context, from, to time.Time) ([]CouponTrialConversion, error) {
+	query := `
+	SELECT COALESCE(sd.coupon, ''), COALESCE(sd.discount, ''), COUNT(DISTINCT sd.subscription),
+	COUNT(DISTINCT CASE WHEN EXISTS (
+		SELECT 1 FROM invoices paid
+		JOIN invoice_discounts td ON td.subscription_discount = sd.handle
+		JOIN invoices trial ON trial.id = td.invoice_id AND trial.country = sd.country AND trial.org_amount = 0
+		WHERE paid.subscription = sd.subscription
+		AND paid.country = sd.country
+		AND paid.created > trial.created
+		AND paid.org_amount > 0
+		AND ` + s.dialect.jsonField("paid.states", "settled") + ` IS NOT NULL
+	) THEN sd.subscription END)
+	FROM subscription_discounts sd
+	WHERE sd.created >= ? AND sd.created < ?
+	AND EXISTS (
+		SELECT 1 FROM invoice_discounts d
+		JOIN invoices trial ON trial.id = d.invoice_id
+		WHERE d.subscription_discount = sd.handle AND trial.country = sd.country AND trial.org_amount = 0
+	)
+	GROUP BY sd.coupon, sd.discount
+	ORDER BY sd.coupon, sd.discount
+	`
+	rows, err := s.db.QueryContext(ctx, query, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query coupon trial conversions: %w", err)
+	}
+	defer rows.Close()
+
+	var conversions []CouponTrialConversion
+	for rows.Next() {
+		var c CouponTrialConversion
+		if err := rows.Scan(&c.Coupon, &c.Discount, &c.Trials, &c.Converted); err != nil {
+			return nil, fmt.Errorf("failed to scan coupon trial conversion row: %w", err)
+		}
+		conversions = append(conversions, c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during rows iteration: %w", err)
+	}
+
+	return conversions, nil
+}
@@ -0,0 +1,100 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// HighWaterMark is how far the incremental sync of one entity of one account has come.
+// Everything created before Mark was saved by a successful run.
+type HighWaterMark struct {
+	Account     string     `json:"account"`
+	Entity      string     `json:"entity"`
+	Mark        *time.Time `json:"high_water_mark"`
+	LastRun     *time.Time `json:"last_run"` // When the last run started
+	LastSuccess *time.Time `json:"last_success"`
+	LastError   string     `json:"last_error"` // Empty when the last run succeeded
+	Items       int64      `json:"items"`      // Saved by the last run
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+var highWaterMarkColumns = []string{
+	"account", "entity", "high_water_mark", "last_run", "last_success", "last_error", "items", "updated_at",
+}
+
+func scanHighWaterMark(row interface{ Scan(...interface{}) error }) (HighWaterMark, error) {
+	var hwm HighWaterMark
+	var mark, lastRun, lastSuccess sql.NullTime
+	var lastError sql.NullString
+	err := row.Scan(&hwm.Account, &hwm.Entity, &mark, &lastRun, &lastSuccess, &lastError, &hwm.Items, &hwm.UpdatedAt)
+	hwm.Mark = nullTimePtr(mark)
+	hwm.LastRun = nullTimePtr(lastRun)
+	hwm.LastSuccess = nullTimePtr(lastSuccess)
+	hwm.LastError = lastError.String
+	return hwm, err
+}
+
+func nullTimePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
+// GetHighWaterMark returns the high-water mark of an entity for an account, or nil if it
+// has never been synced incrementally
+func (s *SQLStore) GetHighWaterMark(ctx context.Context, account, entity string) (*HighWaterMark, error) {
+	hwm, err := scanHighWaterMark(s.db.QueryRowContext(ctx,
+		"SELECT account, entity, high_water_mark, last_run, last_success, last_error, items, updated_at FROM sync_high_water_marks WHERE account = ? AND entity = ?",
+		account, entity,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query high-water mark: %w", err)
+	}
+	return &hwm, nil
+}
+
+// SaveHighWaterMark stores a high-water mark, stamping its UpdatedAt
+func (s *SQLStore) SaveHighWaterMark(ctx context.Context, hwm *HighWaterMark) error {
+	hwm.UpdatedAt = time.Now().UTC()
+	query := upsertQuery(s.dialect, "sync_high_water_marks", []string{"account", "entity"}, highWaterMarkColumns, 1)
+	_, err := s.db.ExecContext(ctx, query,
+		hwm.Account, hwm.Entity, hwm.Mark, hwm.LastRun, hwm.LastSuccess, hwm.LastError, hwm.Items, hwm.UpdatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save high-water mark: %w", err)
+	}
+	return nil
+}
+
+// GetHighWaterMarks returns every high-water mark ordered by account and entity
+func (s *SQLStore) GetHighWaterMarks(ctx context.Context) ([]HighWaterMark, error) {
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT account, entity, high_water_mark, last_run, last_success, last_error, items, updated_at FROM sync_high_water_marks ORDER BY account, entity",
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query high-water marks: %w", err)
+	}
+	defer rows.Close()
+
+	var marks []HighWaterMark
+	for rows.Next() {
+		hwm, err := scanHighWaterMark(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan high-water mark row: %w", err)
+		}
+		marks = append(marks, hwm)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during rows iteration: %w", err)
+	}
+
+	return marks, nil
+}
@@ -0,0 +1,149 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Sources of an observed invoice state
+const (
+	SourceWebhook   = "webhook"
+	SourceBackfill  = "backfill"
+	SourceReconcile = "reconcile"
+	SourceManual    = "manual" // Synced on request, e.g. from the command line
+	SourceSync      = "sync"   // Refreshed by the scheduled incremental sync
+)
+
+// InvoiceStateTransition is one observed change of an invoice's state. FromState is nil for
+// the first observation of an invoice.
+type InvoiceStateTransition struct {
+	ID         int64      `json:"id"`
+	InvoiceID  string     `json:"invoice_id"`
+	FromState  *string    `json:"from_state"`
+	ToState    string     `json:"to_state"`
+	OccurredAt *time.Time `json:"occurred_at"` // When Frisbii says the state was entered
+	ObservedAt time.Time  `json:"observed_at"` // When we saw it
+	Source     string     `json:"source"`
+}
+
+// lockInvoiceStates returns the stored state of each invoice by id, locking their rows until
+// the transaction ends so concurrent upserts can't record the same transition twice.
+// Invoices that are not stored yet are missing from the map.
+func (s *SQLStore) lockInvoiceStates(ctx context.Context, tx *txn, invoiceIDs []string) (map[string]string, error) {
+	args := make([]interface{}, len(invoiceIDs))
+	for i, id := range invoiceIDs {
+		args[i] = id
+	}
+	rows, err := tx.QueryContext(ctx,
+		"SELECT id, state FROM invoices WHERE id IN ("+placeholders(len(args))+")"+s.dialect.forUpdate(),
+		args...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read invoice states: %w", err)
+	}
+	defer rows.Close()
+
+	states := make(map[string]string)
+	for rows.Next() {
+		var id string
+		var state sql.NullString
+		if err := rows.Scan(&id, &state); err != nil {
+			return nil, fmt.Errorf("failed to scan invoice state row: %w", err)
+		}
+		states[id] = state.String
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during rows iteration: %w", err)
+	}
+
+	return states, nil
+}
+
+// stateTransition returns the transition to record for an invoice whose stored state was
+// previousState, or nil if its state did not change
+func stateTransition(invoice *Invoice, previousState, source string) *InvoiceStateTransition {
+	if invoice.State == "" || invoice.State == previousState {
+		return nil
+	}
+
+	t := &InvoiceStateTransition{
+		InvoiceID:  invoice.ID,
+		ToState:    invoice.State,
+		OccurredAt: invoice.States[invoice.State],
+		ObservedAt: time.Now().UTC(),
+		Source:     source,
+	}
+	if previousState != "" {
+		t.FromState = &previousState
+	}
+	return t
+}
+
+func recordStateTransitions(ctx context.Context, tx *txn, invoices []Invoice, previousStates map[string]string, source string) error {
+	var args []interface{}
+	for i := range invoices {
+		t := stateTransition(&invoices[i], previousStates[invoices[i].ID], source)
+		if t == nil {
+			continue
+		}
+		args = append(args, t.InvoiceID, t.FromState, t.ToState, t.OccurredAt, t.ObservedAt, t.Source)
+	}
+	if len(args) == 0 {
+		return nil
+	}
+
+	query := "INSERT INTO invoice_state_transitions (invoice_id, from_state, to_state, occurred_at, observed_at, source) VALUES " + valueRows(len(args)/6, 6)
+	if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+		return fmt.Errorf("failed to record invoice state transition: %w", err)
+	}
+	return nil
+}
+
+// InvoiceExists reports whether an invoice with the given id is stored
+func (s *SQLStore) InvoiceExists(ctx context.Context, invoiceID string) (bool, error) {
+	var exists bool
+	err := s.db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM invoices WHERE id = ?)", invoiceID).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("failed to check invoice: %w", err)
+	}
+	return exists, nil
+}
+
+// GetInvoiceStateTransitions returns the state history of an invoice, oldest first
+func (s *SQLStore) GetInvoiceStateTransitions(ctx context.Context, invoiceID string) ([]InvoiceStateTransition, error) {
+	query := `
+	SELECT id, invoice_id, from_state, to_state, occurred_at, observed_at, source
+	FROM invoice_state_transitions WHERE invoice_id = ? ORDER BY observed_at, id
+	`
+	rows, err := s.db.QueryContext(ctx, query, invoiceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query invoice state transitions: %w", err)
+	}
+	defer rows.Close()
+
+	var transitions []InvoiceStateTransition
+	for rows.Next() {
+		var t InvoiceStateTransition
+		var from sql.NullString
+		var occurredAt sql.NullTime
+		if err := rows.Scan(&t.ID, &t.InvoiceID, &from, &t.ToState, &occurredAt, &t.ObservedAt, &t.Source); err != nil {
+			return nil, fmt.Errorf("failed to scan invoice state transition row: %w", err)
+		}
+		if from.Valid {
+			t.FromState = &from.String
+		}
+		if occurredAt.Valid {
+			t.OccurredAt = &occurredAt.Time
+		}
+		transitions = append(transitions, t)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during rows iteration: %w", err)
+	}
+
+	return transitions, nil
+}
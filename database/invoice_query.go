@@ -0,0 +1,133 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// InvoiceQuery selects invoices for the invoice API, newest first by (created, id). Empty
+// filters match every invoice; list filters match any of their values.
+type InvoiceQuery struct {
+	From, To      time.Time      // Created in [From, To)
+	VirtualOffice bool           // Only handles starting with "inv", with the customer email
+	After         *InvoiceCursor // Continue after this invoice; nil starts with the newest
+	Limit         int            // 0 means no limit
+
+	Accounts      []string // Countries
+	Plans         []string
+	States        []string // Current state
+	Currencies    []string
+	Customer      string // Customer handle
+	CustomerEmail string
+	HandlePrefix  string
+	MinAmount     *int64 // Original amount in minor units, inclusive
+	MaxAmount     *int64 // Original amount in minor units, inclusive
+	Test          *bool  // Test or live customer; invoices of customers not stored never match
+}
+
+// InvoiceCursor is the position of an invoice in the order of InvoiceQuery
+type InvoiceCursor struct {
+	Created time.Time
+	ID      string
+}
+
+// likeEscaper escapes the LIKE wildcards of a literal for a pattern with ESCAPE '!'
+var likeEscaper = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+
+// GetInvoicesPage returns the invoices matching q, newest first
+func (s *SQLStore) GetInvoicesPage(ctx context.Context, q InvoiceQuery) ([]Invoice, error) {
+	where := []string{"i.created >= ?", "i.created < ?"}
+	args := []interface{}{q.From, q.To}
+	email, join := "NULL", ""
+	if q.VirtualOffice || q.CustomerEmail != "" || q.Test != nil {
+		join = "LEFT JOIN customers c ON i.customer = c.handle"
+	}
+	if q.VirtualOffice {
+		where = append(where, "i.handle LIKE 'inv%'")
+		email = "c.email"
+	}
+	in := func(column string, values []string) {
+		if len(values) == 0 {
+			return
+		}
+		where = append(where, column+" IN ("+placeholders(len(values))+")")
+		for _, v := range values {
+			args = append(args, v)
+		}
+	}
+	in("i.country", q.Accounts)
+	in("i.plan", q.Plans)
+	in("i.state", q.States)
+	in("i.currency", q.Currencies)
+	if q.Customer != "" {
+		where = append(where, "i.customer = ?")
+		args = append(args, q.Customer)
+	}
+	if q.CustomerEmail != "" {
+		where = append(where, "c.email = ?")
+		args = append(args, q.CustomerEmail)
+	}
+	if q.HandlePrefix != "" {
+		where = append(where, "i.handle LIKE ? ESCAPE '!'")
+		args = append(args, likeEscaper.Replace(q.HandlePrefix)+"%")
+	}
+	if q.MinAmount != nil {
+		where = append(where, "i.org_amount >= ?")
+		args = append(args, *q.MinAmount)
+	}
+	if q.MaxAmount != nil {
+		where = append(where, "i.org_amount <= ?")
+		args = append(args, *q.MaxAmount)
+	}
+	if q.Test != nil {
+		where = append(where, "c.test = ?")
+		args = append(args, *q.Test)
+	}
+	if q.After != nil {
+		where = append(where, "(i.created < ? OR (i.created = ? AND i.id < ?))")
+		args = append(args, q.After.Created, q.After.Created, q.After.ID)
+	}
+	query := `
+	SELECT i.id, i.handle, i.customer, ` + email + `, i.currency, i.created, i.discount_amount, i.org_amount,
+	i.amount_vat, i.amount_ex_vat, i.refunded_amount, i.authorized_amount, i.country, i.plan, i.states
+	FROM invoices i ` + join + `
+	WHERE ` + strings.Join(where, " AND ") + ` ORDER BY i.created DESC, i.id DESC`
+	if q.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, q.Limit)
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query invoices page: %w", err)
+	}
+	defer rows.Close()
+
+	var invoices []Invoice
+	for rows.Next() {
+		var invoice Invoice
+		var statesJSON []byte
+		if err := rows.Scan(
+			&invoice.ID, &invoice.Handle, &invoice.Customer, &invoice.CustomerEmail, &invoice.Currency, &invoice.Created,
+			&invoice.DiscountAmount.Amount, &invoice.OrgAmount.Amount, &invoice.AmountVAT.Amount, &invoice.AmountExVAT.Amount,
+			&invoice.RefundedAmount.Amount, &invoice.AuthorizedAmount.Amount, &invoice.Country, &invoice.Plan, &statesJSON,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan invoice row: %w", err)
+		}
+		invoice.setCurrency()
+
+		if err := json.Unmarshal(statesJSON, &invoice.States); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal invoice states: %w", err)
+		}
+		invoices = append(invoices, invoice)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during rows iteration: %w", err)
+	}
+
+	return invoices, nil
+}
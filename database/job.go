@@ -0,0 +1,189 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Types of a job
+const (
+	JobBackfill  = "backfill"
+	JobReconcile = "reconcile"
+	JobCatchUp   = "catch_up" // Incremental sync since the high-water marks
+)
+
+// Statuses of a job
+const (
+	JobRunning     = "running"
+	JobSucceeded   = "succeeded"
+	JobFailed      = "failed"
+	JobCancelled   = "cancelled"
+	JobInterrupted = "interrupted" // The process running it stopped before it finished
+)
+
+// Job is a sync job started through the admin API. Params holds what it was started with
+// and Result its progress while it runs and its report once it has finished. The process
+// running a job stores a heartbeat while it runs and polls CancelRequested, so any process
+// can cancel it.
+type Job struct {
+	ID              int64           `json:"id"`
+	Type            string          `json:"type"`
+	Params          json.RawMessage `json:"params,omitempty"`
+	Status          string          `json:"status"`
+	Error           string          `json:"error,omitempty"`
+	Result          json.RawMessage `json:"result,omitempty"`
+	StartedAt       time.Time       `json:"started_at"`
+	FinishedAt      *time.Time      `json:"finished_at"`
+	HeartbeatAt     *time.Time      `json:"heartbeat_at,omitempty"`
+	CancelRequested bool            `json:"cancel_requested,omitempty"`
+}
+
+func jobArgs(job *Job) []interface{} {
+	var params, result, jobError interface{}
+	if len(job.Params) > 0 {
+		params = string(job.Params)
+	}
+	if len(job.Result) > 0 {
+		result = string(job.Result)
+	}
+	if job.Error != "" {
+		jobError = job.Error
+	}
+	return []interface{}{job.Type, params, job.Status, jobError, result, job.StartedAt, job.FinishedAt}
+}
+
+func scanJob(row interface{ Scan(...interface{}) error }, withResult bool) (Job, error) {
+	var job Job
+	var params []byte
+	var jobError sql.NullString
+	var finishedAt, heartbeatAt sql.NullTime
+	dest := []interface{}{&job.ID, &job.Type, &params, &job.Status, &jobError, &job.StartedAt, &finishedAt, &heartbeatAt, &job.CancelRequested}
+	var result []byte
+	if withResult {
+		dest = append(dest, &result)
+	}
+	err := row.Scan(dest...)
+	job.Error = jobError.String
+	job.FinishedAt = nullTimePtr(finishedAt)
+	job.HeartbeatAt = nullTimePtr(heartbeatAt)
+	if len(params) > 0 {
+		job.Params = json.RawMessage(params)
+	}
+	if len(result) > 0 {
+		job.Result = json.RawMessage(result)
+	}
+	return job, err
+}
+
+// staleJobError is the error of a job interrupted because its process stopped
+const staleJobError = "the process running the job stopped before it finished"
+
+const jobColumns = "id, type, params, status, error, started_at, finished_at, heartbeat_at, cancel_requested"
+
+// CreateJob inserts a job and sets its ID; its first heartbeat is when it started
+func (s *SQLStore) CreateJob(ctx context.Context, job *Job) error {
+	id, err := s.insertID(ctx,
+		"INSERT INTO jobs (type, params, status, error, result, started_at, finished_at, heartbeat_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		append(jobArgs(job), job.StartedAt)...,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to insert job: %w", err)
+	}
+	job.ID = id
+	job.HeartbeatAt = &job.StartedAt
+	return nil
+}
+
+// UpdateJob stores the status, error and result of a job
+func (s *SQLStore) UpdateJob(ctx context.Context, job *Job) error {
+	args := append(jobArgs(job), job.ID)
+	_, err := s.db.ExecContext(ctx, `
+	UPDATE jobs SET type = ?, params = ?, status = ?, error = ?, result = ?, started_at = ?, finished_at = ?
+	WHERE id = ?
+	`, args...)
+	if err != nil {
+		return fmt.Errorf("failed to update job: %w", err)
+	}
+	return nil
+}
+
+// GetJob returns a job with its result, or nil if there is no such job
+func (s *SQLStore) GetJob(ctx context.Context, id int64) (*Job, error) {
+	job, err := scanJob(s.db.QueryRowContext(ctx, "SELECT "+jobColumns+", result FROM jobs WHERE id = ?", id), true)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query job: %w", err)
+	}
+	return &job, nil
+}
+
+// GetJobs returns the latest limit jobs, newest first, without their results
+func (s *SQLStore) GetJobs(ctx context.Context, limit int) ([]Job, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+jobColumns+" FROM jobs ORDER BY started_at DESC, id DESC LIMIT ?", limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query jobs: %w", err)
+	}
+	defer rows.Close()
+
+	var jobs []Job
+	for rows.Next() {
+		job, err := scanJob(rows, false)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan job row: %w", err)
+		}
+		jobs = append(jobs, job)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during rows iteration: %w", err)
+	}
+
+	return jobs, nil
+}
+
+// HeartbeatJob records that a running job's process is still running it and reports
+// whether the job was asked to cancel
+func (s *SQLStore) HeartbeatJob(ctx context.Context, id int64) (bool, error) {
+	if _, err := s.db.ExecContext(ctx, "UPDATE jobs SET heartbeat_at = ? WHERE id = ? AND status = ?",
+		time.Now().UTC(), id, JobRunning); err != nil {
+		return false, fmt.Errorf("failed to update job heartbeat: %w", err)
+	}
+	var cancelRequested bool
+	err := s.db.QueryRowContext(ctx, "SELECT cancel_requested FROM jobs WHERE id = ?", id).Scan(&cancelRequested)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return false, fmt.Errorf("failed to query job cancellation: %w", err)
+	}
+	return cancelRequested, nil
+}
+
+// RequestJobCancel asks the process running a job to cancel it on its next heartbeat
+func (s *SQLStore) RequestJobCancel(ctx context.Context, id int64) error {
+	if _, err := s.db.ExecContext(ctx, "UPDATE jobs SET cancel_requested = ? WHERE id = ? AND status = ?",
+		true, id, JobRunning); err != nil {
+		return fmt.Errorf("failed to request job cancellation: %w", err)
+	}
+	return nil
+}
+
+// InterruptStaleJobs marks running jobs without a heartbeat since before as interrupted,
+// as their process stopped, and returns how many it marked
+func (s *SQLStore) InterruptStaleJobs(ctx context.Context, before time.Time) (int64, error) {
+	res, err := s.db.ExecContext(ctx, `
+	UPDATE jobs SET status = ?, error = ?, finished_at = ?
+	WHERE status = ? AND (heartbeat_at IS NULL OR heartbeat_at < ?)
+	`, JobInterrupted, staleJobError, time.Now().UTC(), JobRunning, before)
+	if err != nil {
+		return 0, fmt.Errorf("failed to interrupt stale jobs: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count interrupted jobs: %w", err)
+	}
+	return n, nil
+}
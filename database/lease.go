@@ -0,0 +1,98 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Lease is a named lock held by one process until it expires; holders renew it while they
+// work. Names are "<job type>:<account>", e.g. "backfill:DK".
+type Lease struct {
+	Name       string    `json:"name"`
+	Holder     string    `json:"holder"`
+	AcquiredAt time.Time `json:"acquired_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// AcquireLease takes the lease name for holder for ttl, or extends it if holder already
+// has it. It reports whether holder has the lease and, if not, who does.
+func (s *SQLStore) AcquireLease(ctx context.Context, name, holder string, ttl time.Duration) (bool, string, error) {
+	now := time.Now().UTC()
+	expires := now.Add(ttl)
+
+	// acquired_at is assigned before holder, as MySQL evaluates assignments left to right
+	res, err := s.db.ExecContext(ctx, `
+	UPDATE leases SET acquired_at = CASE WHEN holder = ? THEN acquired_at ELSE ? END, holder = ?, expires_at = ?
+	WHERE name = ? AND (holder = ? OR expires_at < ?)
+	`, holder, now, holder, expires, name, holder, now)
+	if err != nil {
+		return false, "", fmt.Errorf("failed to update lease: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n > 0 {
+		return true, holder, nil
+	}
+
+	_, err = s.db.ExecContext(ctx,
+		"INSERT INTO leases (name, holder, acquired_at, expires_at) VALUES (?, ?, ?, ?)",
+		name, holder, now, expires,
+	)
+	if err == nil {
+		return true, holder, nil
+	}
+	// The insert fails when the lease exists: held by another process, or by holder when
+	// MySQL reports a renewal within the same second as changing no rows
+	current, getErr := s.getLease(ctx, name)
+	if getErr != nil || current == nil {
+		return false, "", fmt.Errorf("failed to insert lease: %w", err)
+	}
+	return current.Holder == holder, current.Holder, nil
+}
+
+// ReleaseLease gives up the lease name if holder has it
+func (s *SQLStore) ReleaseLease(ctx context.Context, name, holder string) error {
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM leases WHERE name = ? AND holder = ?", name, holder); err != nil {
+		return fmt.Errorf("failed to release lease: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLStore) getLease(ctx context.Context, name string) (*Lease, error) {
+	var lease Lease
+	err := s.db.QueryRowContext(ctx,
+		"SELECT name, holder, acquired_at, expires_at FROM leases WHERE name = ?", name,
+	).Scan(&lease.Name, &lease.Holder, &lease.AcquiredAt, &lease.ExpiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query lease: %w", err)
+	}
+	return &lease, nil
+}
+
+// GetLeases returns all leases by name, including expired ones not yet taken over
+func (s *SQLStore) GetLeases(ctx context.Context) ([]Lease, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT name, holder, acquired_at, expires_at FROM leases ORDER BY name")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query leases: %w", err)
+	}
+	defer rows.Close()
+
+	var leases []Lease
+	for rows.Next() {
+		var lease Lease
+		if err := rows.Scan(&lease.Name, &lease.Holder, &lease.AcquiredAt, &lease.ExpiresAt); err != nil {
+			return nil, fmt.Errorf("failed to scan lease row: %w", err)
+		}
+		leases = append(leases, lease)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during rows iteration: %w", err)
+	}
+
+	return leases, nil
+}
@@ -0,0 +1,758 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"github.com/AndersKaae/legaldesk_psp_sync/money"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryStore is an in-memory Store for tests and local development. It keeps the same
+// validation and history rules as SQLStore.
+type MemoryStore struct {
+	mu                    sync.RWMutex
+	customers             map[string]Customer
+	customerVersions      map[string][]CustomerVersion
+	invoices              map[string]Invoice
+	transitions           []InvoiceStateTransition
+	discounts             map[[2]string]Discount             // By handle and country
+	coupons               map[[2]string]Coupon               // By handle and country
+	subscriptionDiscounts map[[2]string]SubscriptionDiscount // By handle and country
+	additionalCosts       map[[2]string]AdditionalCost       // By handle and country
+	credits               map[[2]string]Credit               // By handle and country
+	checkpoints           map[[2]string]SyncCheckpoint       // By account and entity
+	windows               map[[2]string][]SyncWindow         // By account and entity, ordered by start
+	highWaterMarks        map[[2]string]HighWaterMark        // By account and entity
+	reconcileRuns         []ReconcileRun
+	jobs                  []Job
+	leases                map[string]Lease
+	nextID                int64
+}
+
+// NewMemoryStore returns an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		customers:             make(map[string]Customer),
+		customerVersions:      make(map[string][]CustomerVersion),
+		invoices:              make(map[string]Invoice),
+		discounts:             make(map[[2]string]Discount),
+		coupons:               make(map[[2]string]Coupon),
+		subscriptionDiscounts: make(map[[2]string]SubscriptionDiscount),
+		additionalCosts:       make(map[[2]string]AdditionalCost),
+		credits:               make(map[[2]string]Credit),
+		checkpoints:           make(map[[2]string]SyncCheckpoint),
+		windows:               make(map[[2]string][]SyncWindow),
+		leases:                make(map[string]Lease),
+		highWaterMarks:        make(map[[2]string]HighWaterMark),
+	}
+}
+
+// Close is a no-op
+func (m *MemoryStore) Close() error {
+	return nil
+}
+
+func (m *MemoryStore) id() int64 {
+	m.nextID++
+	return m.nextID
+}
+
+func (m *MemoryStore) CreateOrUpdateCustomer(ctx context.Context, customer *Customer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored := *customer
+	stored.setCurrency()
+	m.customers[customer.Handle] = stored
+
+	next := customerVersionOf(customer)
+	now := time.Now().UTC()
+	versions := m.customerVersions[customer.Handle]
+	if len(versions) == 0 {
+		next.ValidFrom = now
+	} else {
+		current := &versions[len(versions)-1]
+		if len(DiffCustomerVersions(*current, next)) == 0 {
+			return nil
+		}
+		current.ValidTo = &now
+		next.ValidFrom = now
+	}
+	next.ID = m.id()
+	m.customerVersions[customer.Handle] = append(versions, next)
+	return nil
+}
+
+func (m *MemoryStore) CreateOrUpdateInvoice(ctx context.Context, invoice *Invoice, source string) error {
+	if err := validateInvoice(invoice); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	previousState := m.invoices[invoice.ID].State
+
+	stored := *invoice
+	stored.CustomerEmail.Valid = false
+	stored.Discounts = append([]InvoiceDiscount(nil), invoice.Discounts...)
+	stored.setCurrency()
+	m.invoices[invoice.ID] = stored
+
+	if t := stateTransition(invoice, previousState, source); t != nil {
+		t.ID = m.id()
+		m.transitions = append(m.transitions, *t)
+	}
+	return nil
+}
+
+// CreateOrUpdateCustomers upserts customers one by one; failures are returned per customer
+func (m *MemoryStore) CreateOrUpdateCustomers(ctx context.Context, customers []Customer, batchSize int) ([]RowError, error) {
+	var failed []RowError
+	for i := range customers {
+		if err := m.CreateOrUpdateCustomer(ctx, &customers[i]); err != nil {
+			failed = append(failed, RowError{Key: customers[i].Handle, Err: err})
+		}
+	}
+	return failed, nil
+}
+
+// CreateOrUpdateInvoices upserts invoices one by one; failures are returned per invoice
+func (m *MemoryStore) CreateOrUpdateInvoices(ctx context.Context, invoices []Invoice, source string, batchSize int) ([]RowError, error) {
+	var failed []RowError
+	for i := range invoices {
+		if err := m.CreateOrUpdateInvoice(ctx, &invoices[i], source); err != nil {
+			failed = append(failed, RowError{Key: invoices[i].ID, Err: err})
+		}
+	}
+	return failed, nil
+}
+
+func (m *MemoryStore) CreateOrUpdateDiscount(ctx context.Context, discount *Discount) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.discounts[[2]string{discount.Handle, discount.Country}] = *discount
+	return nil
+}
+
+func (m *MemoryStore) CreateOrUpdateCoupon(ctx context.Context, coupon *Coupon) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.coupons[[2]string{coupon.Handle, coupon.Country}] = *coupon
+	return nil
+}
+
+func (m *MemoryStore) CreateOrUpdateSubscriptionDiscount(ctx context.Context, subscriptionDiscount *SubscriptionDiscount) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.subscriptionDiscounts[[2]string{subscriptionDiscount.Handle, subscriptionDiscount.Country}] = *subscriptionDiscount
+	return nil
+}
+
+func (m *MemoryStore) CreateOrUpdateAdditionalCost(ctx context.Context, additionalCost *AdditionalCost) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.additionalCosts[[2]string{additionalCost.Handle, additionalCost.Country}] = *additionalCost
+	return nil
+}
+
+func (m *MemoryStore) CreateOrUpdateCredit(ctx context.Context, credit *Credit) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.credits[[2]string{credit.Handle, credit.Country}] = *credit
+	return nil
+}
+
+// invoicesWhere returns copies of the invoices matching keep, newest first
+func (m *MemoryStore) invoicesWhere(keep func(Invoice) bool) []Invoice {
+	var invoices []Invoice
+	for _, invoice := range m.invoices {
+		if keep(invoice) {
+			invoice.Discounts = append([]InvoiceDiscount(nil), invoice.Discounts...)
+			invoices = append(invoices, invoice)
+		}
+	}
+	sort.Slice(invoices, func(i, j int) bool {
+		if invoices[i].Created.Equal(invoices[j].Created) {
+			return invoices[i].ID > invoices[j].ID
+		}
+		return invoices[i].Created.After(invoices[j].Created)
+	})
+	return invoices
+}
+
+func inRange(t, from, to time.Time) bool {
+	return !t.Before(from) && t.Before(to)
+}
+
+func (m *MemoryStore) GetInvoicesPage(ctx context.Context, q InvoiceQuery) ([]Invoice, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	invoices := m.invoicesWhere(func(i Invoice) bool {
+		if !inRange(i.Created, q.From, q.To) {
+			return false
+		}
+		if q.VirtualOffice && !strings.HasPrefix(strings.ToLower(i.Handle), "inv") {
+			return false
+		}
+		if q.After != nil && !i.Created.Before(q.After.Created) && !(i.Created.Equal(q.After.Created) && i.ID < q.After.ID) {
+			return false
+		}
+		return m.invoiceMatches(i, q)
+	})
+	if q.Limit > 0 && len(invoices) > q.Limit {
+		invoices = invoices[:q.Limit]
+	}
+	if q.VirtualOffice {
+		for i := range invoices {
+			if customer, ok := m.customers[invoices[i].Customer]; ok {
+				invoices[i].CustomerEmail.String = customer.Email
+				invoices[i].CustomerEmail.Valid = true
+			}
+		}
+	}
+	return invoices, nil
+}
+
+// invoiceMatches applies the filters of q other than the date range, handle and cursor
+func (m *MemoryStore) invoiceMatches(i Invoice, q InvoiceQuery) bool {
+	oneOf := func(values []string, v string) bool {
+		if len(values) == 0 {
+			return true
+		}
+		for _, value := range values {
+			if value == v {
+				return true
+			}
+		}
+		return false
+	}
+	if !oneOf(q.Accounts, i.Country) || !oneOf(q.Plans, i.Plan) || !oneOf(q.States, i.State) || !oneOf(q.Currencies, i.Currency) {
+		return false
+	}
+	if q.Customer != "" && i.Customer != q.Customer {
+		return false
+	}
+	if q.HandlePrefix != "" && !strings.HasPrefix(i.Handle, q.HandlePrefix) {
+		return false
+	}
+	if q.MinAmount != nil && i.OrgAmount.Amount < *q.MinAmount || q.MaxAmount != nil && i.OrgAmount.Amount > *q.MaxAmount {
+		return false
+	}
+	if q.CustomerEmail != "" || q.Test != nil {
+		customer, ok := m.customers[i.Customer]
+		if !ok || q.CustomerEmail != "" && customer.Email != q.CustomerEmail || q.Test != nil && customer.Test != *q.Test {
+			return false
+		}
+	}
+	return true
+}
+
+func (m *MemoryStore) GetAccountInvoices(ctx context.Context, country string, from, to time.Time) ([]Invoice, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	invoices := m.invoicesWhere(func(i Invoice) bool { return i.Country == country && inRange(i.Created, from, to) })
+	sort.Slice(invoices, func(i, j int) bool {
+		if !invoices[i].Created.Equal(invoices[j].Created) {
+			return invoices[i].Created.Before(invoices[j].Created)
+		}
+		return invoices[i].ID < invoices[j].ID
+	})
+	return invoices, nil
+}
+
+func (m *MemoryStore) GetInvoicesByID(ctx context.Context, ids []string) ([]Invoice, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var invoices []Invoice
+	for _, id := range ids {
+		if invoice, ok := m.invoices[id]; ok {
+			invoice.Discounts = append([]InvoiceDiscount(nil), invoice.Discounts...)
+			invoices = append(invoices, invoice)
+		}
+	}
+	return invoices, nil
+}
+
+func (m *MemoryStore) GetCustomersByHandle(ctx context.Context, handles []string) ([]Customer, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var customers []Customer
+	for _, handle := range handles {
+		if customer, ok := m.customers[handle]; ok {
+			customers = append(customers, customer)
+		}
+	}
+	return customers, nil
+}
+
+func (m *MemoryStore) InvoiceExists(ctx context.Context, invoiceID string) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	_, ok := m.invoices[invoiceID]
+	return ok, nil
+}
+
+func (m *MemoryStore) GetInvoiceStateTransitions(ctx context.Context, invoiceID string) ([]InvoiceStateTransition, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var transitions []InvoiceStateTransition
+	for _, t := range m.transitions {
+		if t.InvoiceID == invoiceID {
+			transitions = append(transitions, t)
+		}
+	}
+	return transitions, nil
+}
+
+func (m *MemoryStore) GetCustomerAsOf(ctx context.Context, handle string, t time.Time) (*CustomerVersion, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	versions := m.customerVersions[handle]
+	for i := len(versions) - 1; i >= 0; i-- {
+		v := versions[i]
+		if !v.ValidFrom.After(t) && (v.ValidTo == nil || v.ValidTo.After(t)) {
+			return &v, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *MemoryStore) GetCustomerVersions(ctx context.Context, handle string) ([]CustomerVersion, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]CustomerVersion(nil), m.customerVersions[handle]...), nil
+}
+
+func (m *MemoryStore) GetCustomerDiffs(ctx context.Context, handle string) ([]CustomerVersionDiff, error) {
+	versions, err := m.GetCustomerVersions(ctx, handle)
+	if err != nil {
+		return nil, err
+	}
+	diffs := []CustomerVersionDiff{}
+	for i := 1; i < len(versions); i++ {
+		diffs = append(diffs, CustomerVersionDiff{
+			VersionID: versions[i].ID,
+			ChangedAt: versions[i].ValidFrom,
+			Changes:   DiffCustomerVersions(versions[i-1], versions[i]),
+		})
+	}
+	return diffs, nil
+}
+
+func (m *MemoryStore) GetCouponRedemptions(ctx context.Context, from, to time.Time) ([]CouponRedemptions, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	counts := make(map[CouponRedemptions]int)
+	for _, sd := range m.subscriptionDiscounts {
+		if !inRange(sd.Created, from, to) {
+			continue
+		}
+		counts[CouponRedemptions{Coupon: sd.Coupon, Discount: sd.Discount, Month: sd.Created.Format("2006-01")}]++
+	}
+
+	var redemptions []CouponRedemptions
+	for key, n := range counts {
+		key.Redemptions = n
+		redemptions = append(redemptions, key)
+	}
+	sort.Slice(redemptions, func(i, j int) bool {
+		a, b := redemptions[i], redemptions[j]
+		if a.Month != b.Month {
+			return a.Month < b.Month
+		}
+		if a.Coupon != b.Coupon {
+			return a.Coupon < b.Coupon
+		}
+		return a.Discount < b.Discount
+	})
+	return redemptions, nil
+}
+
+func (m *MemoryStore) GetCouponDiscountedAmounts(ctx context.Context, from, to time.Time) ([]CouponDiscountedAmount, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	type key struct{ coupon, discount, month, currency string }
+	amounts := make(map[key]*CouponDiscountedAmount)
+	invoiceSeen := make(map[key]map[string]bool)
+	for _, invoice := range m.invoices {
+		if !inRange(invoice.Created, from, to) {
+			continue
+		}
+		for _, d := range invoice.Discounts {
+			sd, ok := m.subscriptionDiscounts[[2]string{d.SubscriptionDiscount, invoice.Country}]
+			if !ok {
+				continue
+			}
+			k := key{sd.Coupon, sd.Discount, invoice.Created.Format("2006-01"), invoice.Currency}
+			a, ok := amounts[k]
+			if !ok {
+				a = &CouponDiscountedAmount{Coupon: k.coupon, Discount: k.discount, Month: k.month, Amount: money.New(0, k.currency)}
+				amounts[k] = a
+				invoiceSeen[k] = make(map[string]bool)
+			}
+			amount := d.Amount.Amount
+			if amount < 0 {
+				amount = -amount
+			}
+			a.Amount.Amount += amount
+			if !invoiceSeen[k][invoice.ID] {
+				invoiceSeen[k][invoice.ID] = true
+				a.Invoices++
+			}
+		}
+	}
+
+	var result []CouponDiscountedAmount
+	for _, a := range amounts {
+		result = append(result, *a)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if a.Month != b.Month {
+			return a.Month < b.Month
+		}
+		if a.Coupon != b.Coupon {
+			return a.Coupon < b.Coupon
+		}
+		if a.Discount != b.Discount {
+			return a.Discount < b.Discount
+		}
+		return a.Amount.Currency < b.Amount.Currency
+	})
+	return result, nil
+}
+
+func (m *MemoryStore) GetCouponTrialConversions(ctx context.Context, from, to time.Time) ([]CouponTrialConversion, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	// By handle or subscription and country: the first free invoice of each subscription
+	// discount, and the last settled paid invoice of each subscription
+	firstFree := make(map[[2]string]time.Time)
+	lastPaid := make(map[[2]string]time.Time)
+	for _, invoice := range m.invoices {
+		if invoice.OrgAmount.Amount == 0 {
+			for _, d := range invoice.Discounts {
+				k := [2]string{d.SubscriptionDiscount, invoice.Country}
+				if first, ok := firstFree[k]; !ok || invoice.Created.Before(first) {
+					firstFree[k] = invoice.Created
+				}
+			}
+		}
+		if invoice.OrgAmount.Amount > 0 && invoice.States["settled"] != nil {
+			k := [2]string{invoice.Subscription, invoice.Country}
+			if invoice.Created.After(lastPaid[k]) {
+				lastPaid[k] = invoice.Created
+			}
+		}
+	}
+
+	type key struct{ coupon, discount string }
+	trials := make(map[key]map[string]bool) // Subscriptions, and whether they converted
+	for _, sd := range m.subscriptionDiscounts {
+		free, ok := firstFree[[2]string{sd.Handle, sd.Country}]
+		if !inRange(sd.Created, from, to) || !ok {
+			continue
+		}
+		k := key{sd.Coupon, sd.Discount}
+		if trials[k] == nil {
+			trials[k] = make(map[string]bool)
+		}
+		trials[k][sd.Subscription] = trials[k][sd.Subscription] || lastPaid[[2]string{sd.Subscription, sd.Country}].After(free)
+	}
+
+	var conversions []CouponTrialConversion
+	for k, subscriptions := range trials {
+		c := CouponTrialConversion{Coupon: k.coupon, Discount: k.discount, Trials: len(subscriptions)}
+		for _, converted := range subscriptions {
+			if converted {
+				c.Converted++
+			}
+		}
+		conversions = append(conversions, c)
+	}
+	sort.Slice(conversions, func(i, j int) bool {
+		if conversions[i].Coupon != conversions[j].Coupon {
+			return conversions[i].Coupon < conversions[j].Coupon
+		}
+		return conversions[i].Discount < conversions[j].Discount
+	})
+	return conversions, nil
+}
+
+func (m *MemoryStore) GetSyncCheckpoint(ctx context.Context, account, entity string) (*SyncCheckpoint, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	cp, ok := m.checkpoints[[2]string{account, entity}]
+	if !ok {
+		return nil, nil
+	}
+	return &cp, nil
+}
+
+func (m *MemoryStore) SaveSyncCheckpoint(ctx context.Context, cp *SyncCheckpoint) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cp.UpdatedAt = time.Now().UTC()
+	m.checkpoints[[2]string{cp.Account, cp.Entity}] = *cp
+	return nil
+}
+
+func (m *MemoryStore) ResetSyncCheckpoint(ctx context.Context, account, entity string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for k := range m.checkpoints {
+		if k[0] == account && (entity == "" || k[1] == entity) {
+			delete(m.checkpoints, k)
+		}
+	}
+	for k := range m.windows {
+		if k[0] == account && (entity == "" || k[1] == entity) {
+			delete(m.windows, k)
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) GetSyncCheckpoints(ctx context.Context) ([]SyncCheckpoint, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var checkpoints []SyncCheckpoint
+	for _, cp := range m.checkpoints {
+		checkpoints = append(checkpoints, cp)
+	}
+	sort.Slice(checkpoints, func(i, j int) bool {
+		if checkpoints[i].Account != checkpoints[j].Account {
+			return checkpoints[i].Account < checkpoints[j].Account
+		}
+		return checkpoints[i].Entity < checkpoints[j].Entity
+	})
+	return checkpoints, nil
+}
+
+func (m *MemoryStore) GetSyncWindows(ctx context.Context, account, entity string) ([]SyncWindow, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]SyncWindow(nil), m.windows[[2]string{account, entity}]...), nil
+}
+
+func (m *MemoryStore) SaveSyncWindow(ctx context.Context, w *SyncWindow) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	w.UpdatedAt = time.Now().UTC()
+	key := [2]string{w.Account, w.Entity}
+	windows := m.windows[key]
+	for i := range windows {
+		if windows[i].From.Equal(w.From) {
+			windows[i] = *w
+			return nil
+		}
+	}
+	windows = append(windows, *w)
+	sort.Slice(windows, func(i, j int) bool { return windows[i].From.Before(windows[j].From) })
+	m.windows[key] = windows
+	return nil
+}
+
+func (m *MemoryStore) GetHighWaterMark(ctx context.Context, account, entity string) (*HighWaterMark, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	hwm, ok := m.highWaterMarks[[2]string{account, entity}]
+	if !ok {
+		return nil, nil
+	}
+	return &hwm, nil
+}
+
+func (m *MemoryStore) SaveHighWaterMark(ctx context.Context, hwm *HighWaterMark) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	hwm.UpdatedAt = time.Now().UTC()
+	m.highWaterMarks[[2]string{hwm.Account, hwm.Entity}] = *hwm
+	return nil
+}
+
+func (m *MemoryStore) GetHighWaterMarks(ctx context.Context) ([]HighWaterMark, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var marks []HighWaterMark
+	for _, hwm := range m.highWaterMarks {
+		marks = append(marks, hwm)
+	}
+	sort.Slice(marks, func(i, j int) bool {
+		if marks[i].Account != marks[j].Account {
+			return marks[i].Account < marks[j].Account
+		}
+		return marks[i].Entity < marks[j].Entity
+	})
+	return marks, nil
+}
+
+func (m *MemoryStore) CreateReconcileRun(ctx context.Context, run *ReconcileRun) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	run.ID = m.id()
+	m.reconcileRuns = append(m.reconcileRuns, *run)
+	return nil
+}
+
+func (m *MemoryStore) UpdateReconcileRun(ctx context.Context, run *ReconcileRun) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.reconcileRuns {
+		if m.reconcileRuns[i].ID == run.ID {
+			m.reconcileRuns[i] = *run
+			return nil
+		}
+	}
+	return fmt.Errorf("reconcile run %d not found", run.ID)
+}
+
+func (m *MemoryStore) GetReconcileRun(ctx context.Context, id int64) (*ReconcileRun, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, run := range m.reconcileRuns {
+		if run.ID == id {
+			return &run, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *MemoryStore) GetReconcileRuns(ctx context.Context, limit int) ([]ReconcileRun, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var runs []ReconcileRun
+	for i := len(m.reconcileRuns) - 1; i >= 0 && len(runs) < limit; i-- {
+		run := m.reconcileRuns[i]
+		run.Report = nil
+		runs = append(runs, run)
+	}
+	return runs, nil
+}
+
+func (m *MemoryStore) CreateJob(ctx context.Context, job *Job) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job.ID = m.id()
+	job.HeartbeatAt = &job.StartedAt
+	m.jobs = append(m.jobs, *job)
+	return nil
+}
+
+func (m *MemoryStore) UpdateJob(ctx context.Context, job *Job) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.jobs {
+		if m.jobs[i].ID == job.ID {
+			// As in SQLStore, the heartbeat and cancellation are not the caller's to change
+			updated := *job
+			updated.HeartbeatAt, updated.CancelRequested = m.jobs[i].HeartbeatAt, m.jobs[i].CancelRequested
+			m.jobs[i] = updated
+			return nil
+		}
+	}
+	return fmt.Errorf("job %d not found", job.ID)
+}
+
+func (m *MemoryStore) HeartbeatJob(ctx context.Context, id int64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.jobs {
+		if m.jobs[i].ID == id {
+			if m.jobs[i].Status == JobRunning {
+				now := time.Now().UTC()
+				m.jobs[i].HeartbeatAt = &now
+			}
+			return m.jobs[i].CancelRequested, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *MemoryStore) RequestJobCancel(ctx context.Context, id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.jobs {
+		if m.jobs[i].ID == id && m.jobs[i].Status == JobRunning {
+			m.jobs[i].CancelRequested = true
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) InterruptStaleJobs(ctx context.Context, before time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now().UTC()
+	var n int64
+	for i := range m.jobs {
+		job := &m.jobs[i]
+		if job.Status == JobRunning && (job.HeartbeatAt == nil || job.HeartbeatAt.Before(before)) {
+			job.Status, job.Error, job.FinishedAt = JobInterrupted, staleJobError, &now
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *MemoryStore) GetJob(ctx context.Context, id int64) (*Job, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, job := range m.jobs {
+		if job.ID == id {
+			return &job, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *MemoryStore) GetJobs(ctx context.Context, limit int) ([]Job, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var jobs []Job
+	for i := len(m.jobs) - 1; i >= 0 && len(jobs) < limit; i-- {
+		job := m.jobs[i]
+		job.Result = nil
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+func (m *MemoryStore) AcquireLease(ctx context.Context, name, holder string, ttl time.Duration) (bool, string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now().UTC()
+	lease, ok := m.leases[name]
+	if ok && lease.Holder != holder && !lease.ExpiresAt.Before(now) {
+		return false, lease.Holder, nil
+	}
+	if !ok || lease.Holder != holder {
+		lease = Lease{Name: name, Holder: holder, AcquiredAt: now}
+	}
+	lease.ExpiresAt = now.Add(ttl)
+	m.leases[name] = lease
+	return true, holder, nil
+}
+
+func (m *MemoryStore) ReleaseLease(ctx context.Context, name, holder string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.leases[name].Holder == holder {
+		delete(m.leases, name)
+	}
+	return nil
+}
+
+func (m *MemoryStore) GetLeases(ctx context.Context) ([]Lease, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var leases []Lease
+	for _, lease := range m.leases {
+		leases = append(leases, lease)
+	}
+	sort.Slice(leases, func(i, j int) bool { return leases[i].Name < leases[j].Name })
+	return leases, nil
+}
@@ -0,0 +1,324 @@
+package database
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations/*/*.sql
+var migrationFS embed.FS
+
+var migrationFileRe = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Migration is a versioned schema change with up and down SQL
+type Migration struct {
+	Version  int
+	Name     string
+	Up       string
+	Down     string
+	Checksum string // sha256 of the up SQL
+}
+
+// MigrationStatus describes a known or applied migration
+type MigrationStatus struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	Applied   bool       `json:"applied"`
+	AppliedAt *time.Time `json:"applied_at"`
+	Checksum  string     `json:"checksum"`
+	Modified  bool       `json:"modified"` // applied checksum differs from the embedded file
+	Unknown   bool       `json:"unknown"`  // applied but not embedded in this binary
+}
+
+type appliedMigration struct {
+	Version   int
+	Name      string
+	Checksum  string
+	AppliedAt time.Time
+}
+
+// migrationDB is what migrations are read and run with: the connection pool, or the session
+// holding the migration lock
+type migrationDB interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// withMigrationLock runs fn on one connection holding the migration lock, so processes
+// starting at the same time apply each migration once. Statements that rely on session
+// state, such as MySQL user variables, work as fn runs them all on that connection.
+func (s *SQLStore) withMigrationLock(fn func(db migrationDB) error) error {
+	ctx := context.Background()
+	c, err := s.db.DB.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get a connection for migrating: %w", err)
+	}
+	defer c.Close()
+
+	if err := s.dialect.lockMigrations(ctx, c); err != nil {
+		return fmt.Errorf("failed to take migration lock: %w", err)
+	}
+	err = fn(&session{Conn: c, dialect: s.dialect})
+	if unlockErr := s.dialect.unlockMigrations(ctx, c); unlockErr != nil && err == nil {
+		err = fmt.Errorf("failed to release migration lock: %w", unlockErr)
+	}
+	return err
+}
+
+func (s *SQLStore) createMigrationsTable() error {
+	_, err := s.db.Exec(s.dialect.migrationsTable())
+	return err
+}
+
+// loadMigrations reads the embedded migration files of a dialect ordered by version. Every
+// dialect has its own directory with the same versions, so they stay in step.
+func loadMigrations(d dialect) ([]Migration, error) {
+	dir := path.Join("migrations", d.name())
+	entries, err := fs.ReadDir(migrationFS, dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %w", err)
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		m := migrationFileRe.FindStringSubmatch(entry.Name())
+		if m == nil {
+			return nil, fmt.Errorf("unexpected migration file name: %s", entry.Name())
+		}
+		version, _ := strconv.Atoi(m[1])
+		content, err := migrationFS.ReadFile(path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %w", entry.Name(), err)
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = migration
+		} else if migration.Name != m[2] {
+			return nil, fmt.Errorf("migration %d has conflicting names %s and %s", version, migration.Name, m[2])
+		}
+		if m[3] == "up" {
+			migration.Up = string(content)
+			sum := sha256.Sum256(content)
+			migration.Checksum = hex.EncodeToString(sum[:])
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s needs both an up and a down file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+func getAppliedMigrations(db migrationDB) (map[int]appliedMigration, error) {
+	rows, err := db.QueryContext(context.Background(), "SELECT version, name, checksum, applied_at FROM schema_migrations ORDER BY version")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query applied migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int]appliedMigration)
+	for rows.Next() {
+		var a appliedMigration
+		if err := rows.Scan(&a.Version, &a.Name, &a.Checksum, &a.AppliedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan migration row: %w", err)
+		}
+		applied[a.Version] = a
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during rows iteration: %w", err)
+	}
+
+	return applied, nil
+}
+
+// CheckSchema returns an error if the database has migrations this binary does not know,
+// i.e. it was migrated by a newer version, or if an applied migration has been edited
+func (s *SQLStore) CheckSchema() error {
+	return checkSchema(s.dialect, s.db)
+}
+
+func checkSchema(d dialect, db migrationDB) error {
+	statuses, err := migrationStatus(d, db)
+	if err != nil {
+		return err
+	}
+	for _, st := range statuses {
+		if st.Unknown {
+			return fmt.Errorf("database schema is at unknown migration %d_%s; refusing to run an older binary against it", st.Version, st.Name)
+		}
+		if st.Modified {
+			return fmt.Errorf("migration %d_%s was modified after it was applied (checksum mismatch)", st.Version, st.Name)
+		}
+	}
+	return nil
+}
+
+// GetMigrationStatus lists embedded and applied migrations ordered by version
+func (s *SQLStore) GetMigrationStatus() ([]MigrationStatus, error) {
+	return migrationStatus(s.dialect, s.db)
+}
+
+func migrationStatus(d dialect, db migrationDB) ([]MigrationStatus, error) {
+	migrations, err := loadMigrations(d)
+	if err != nil {
+		return nil, err
+	}
+	applied, err := getAppliedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []MigrationStatus
+	for _, m := range migrations {
+		st := MigrationStatus{Version: m.Version, Name: m.Name, Checksum: m.Checksum}
+		if a, ok := applied[m.Version]; ok {
+			appliedAt := a.AppliedAt
+			st.Applied = true
+			st.AppliedAt = &appliedAt
+			st.Modified = a.Checksum != m.Checksum
+			delete(applied, m.Version)
+		}
+		statuses = append(statuses, st)
+	}
+	for _, a := range applied {
+		appliedAt := a.AppliedAt
+		statuses = append(statuses, MigrationStatus{
+			Version:   a.Version,
+			Name:      a.Name,
+			Applied:   true,
+			AppliedAt: &appliedAt,
+			Checksum:  a.Checksum,
+			Unknown:   true,
+		})
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+	return statuses, nil
+}
+
+// MigrateUp applies all pending migrations in order and returns the ones applied. It holds
+// the migration lock throughout, so another process migrating at the same time waits and
+// then finds nothing left to apply.
+func (s *SQLStore) MigrateUp() ([]Migration, error) {
+	var done []Migration
+	err := s.withMigrationLock(func(db migrationDB) error {
+		if err := checkSchema(s.dialect, db); err != nil {
+			return err
+		}
+		migrations, err := loadMigrations(s.dialect)
+		if err != nil {
+			return err
+		}
+		applied, err := getAppliedMigrations(db)
+		if err != nil {
+			return err
+		}
+
+		for _, m := range migrations {
+			if _, ok := applied[m.Version]; ok {
+				continue
+			}
+			if err := execMigrationSQL(db, m.Up); err != nil {
+				return fmt.Errorf("migration %d_%s up: %w", m.Version, m.Name, err)
+			}
+			_, err := db.ExecContext(context.Background(),
+				"INSERT INTO schema_migrations (version, name, checksum, applied_at) VALUES (?, ?, ?, ?)",
+				m.Version, m.Name, m.Checksum, time.Now().UTC(),
+			)
+			if err != nil {
+				return fmt.Errorf("failed to record migration %d_%s: %w", m.Version, m.Name, err)
+			}
+			done = append(done, m)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// MigrateDown reverts the latest applied migrations, up to steps of them, holding the
+// migration lock
+func (s *SQLStore) MigrateDown(steps int) ([]Migration, error) {
+	var done []Migration
+	err := s.withMigrationLock(func(db migrationDB) error {
+		if err := checkSchema(s.dialect, db); err != nil {
+			return err
+		}
+		migrations, err := loadMigrations(s.dialect)
+		if err != nil {
+			return err
+		}
+		applied, err := getAppliedMigrations(db)
+		if err != nil {
+			return err
+		}
+
+		for i := len(migrations) - 1; i >= 0 && len(done) < steps; i-- {
+			m := migrations[i]
+			if _, ok := applied[m.Version]; !ok {
+				continue
+			}
+			if err := execMigrationSQL(db, m.Down); err != nil {
+				return fmt.Errorf("migration %d_%s down: %w", m.Version, m.Name, err)
+			}
+			if _, err := db.ExecContext(context.Background(), "DELETE FROM schema_migrations WHERE version = ?", m.Version); err != nil {
+				return fmt.Errorf("failed to unrecord migration %d_%s: %w", m.Version, m.Name, err)
+			}
+			done = append(done, m)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// execMigrationSQL runs each statement of a migration file. MySQL commits DDL implicitly, so
+// statements are run one at a time rather than in a transaction on every backend.
+func execMigrationSQL(db migrationDB, script string) error {
+	for _, stmt := range splitStatements(script) {
+		if _, err := db.ExecContext(context.Background(), stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitStatements splits a script on semicolons ending a line, dropping comment-only lines
+func splitStatements(script string) []string {
+	var statements []string
+	var current strings.Builder
+	for _, line := range strings.Split(script, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		current.WriteString(line)
+		current.WriteString("\n")
+		if strings.HasSuffix(trimmed, ";") {
+			statements = append(statements, strings.TrimSpace(current.String()))
+			current.Reset()
+		}
+	}
+	if rest := strings.TrimSpace(current.String()); rest != "" {
+		statements = append(statements, rest)
+	}
+	return statements
+}
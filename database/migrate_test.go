@@ -0,0 +1,130 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMigrateSQLiteUpDownUp(t *testing.T) {
+	s, err := OpenSQLite(filepath.Join(t.TempDir(), "psp.db"))
+	if err != nil {
+		t.Fatalf("OpenSQLite: %v", err)
+	}
+	defer s.Close()
+
+	migrations, err := loadMigrations(s.dialect)
+	if err != nil {
+		t.Fatalf("loadMigrations: %v", err)
+	}
+	applied, err := s.MigrateUp()
+	if err != nil {
+		t.Fatalf("MigrateUp: %v", err)
+	}
+	if len(applied) != len(migrations) {
+		t.Fatalf("applied %d migrations, want %d", len(applied), len(migrations))
+	}
+	if applied, err := s.MigrateUp(); err != nil || len(applied) != 0 {
+		t.Fatalf("second MigrateUp applied %d migrations, err %v; want none", len(applied), err)
+	}
+
+	reverted, err := s.MigrateDown(len(migrations))
+	if err != nil {
+		t.Fatalf("MigrateDown: %v", err)
+	}
+	if len(reverted) != len(migrations) {
+		t.Fatalf("reverted %d migrations, want %d", len(reverted), len(migrations))
+	}
+	if applied, err := s.MigrateUp(); err != nil || len(applied) != len(migrations) {
+		t.Fatalf("MigrateUp after reverting applied %d migrations, err %v; want %d", len(applied), err, len(migrations))
+	}
+	if err := s.CheckSchema(); err != nil {
+		t.Errorf("CheckSchema: %v", err)
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	script := `-- A comment; not a statement
+SET @ddl = IF(
+	(SELECT COUNT(*) FROM t) = 0,
+	'ALTER TABLE t ADD COLUMN c INT',
+	'DO 0'
+);
+PREPARE stmt FROM @ddl;
+
+EXECUTE stmt;`
+	got := splitStatements(script)
+	if len(got) != 3 || got[1] != "PREPARE stmt FROM @ddl;" || got[2] != "EXECUTE stmt;" {
+		t.Errorf("splitStatements = %q", got)
+	}
+}
+
+func TestMigrateBackfillsInvoiceState(t *testing.T) {
+	s, err := OpenSQLite(filepath.Join(t.TempDir(), "psp.db"))
+	if err != nil {
+		t.Fatalf("OpenSQLite: %v", err)
+	}
+	defer s.Close()
+	if _, err := s.MigrateUp(); err != nil {
+		t.Fatalf("MigrateUp: %v", err)
+	}
+	migrations, err := loadMigrations(s.dialect)
+	if err != nil {
+		t.Fatalf("loadMigrations: %v", err)
+	}
+	var steps int
+	for i := len(migrations) - 1; migrations[i].Name != "backfill_invoice_state"; i-- {
+		steps++
+	}
+	if _, err := s.MigrateDown(steps + 1); err != nil {
+		t.Fatalf("MigrateDown: %v", err)
+	}
+
+	invoices := map[string]struct{ states, state string }{
+		"settled":      {`{"created":"2025-03-01T10:00:00Z","settled":"2025-03-01T10:05:30.25Z"}`, "settled"},
+		"fraction":     {`{"created":"2025-03-01T10:00:00.999Z","authorized":"2025-03-01T10:00:01Z"}`, "authorized"},
+		"cancelled":    {`{"created":"2025-03-01T10:00:00Z","authorized":"2025-03-02T10:00:00Z","cancelled":"2025-03-03T10:00:00Z"}`, "cancelled"},
+		"tie":          {`{"created":"2025-03-01T10:00:00Z","settled":"2025-03-01T10:00:00Z"}`, "settled"},
+		"pending":      {`{"created":"2025-03-01T10:00:00Z"}`, ""},
+		"no timestamp": {`{}`, ""},
+	}
+	for id, inv := range invoices {
+		if _, err := s.db.Exec("INSERT INTO invoices (id, handle, states) VALUES (?, ?, ?)", id, id, inv.states); err != nil {
+			t.Fatalf("insert %s: %v", id, err)
+		}
+	}
+	if _, err := s.db.Exec("INSERT INTO invoices (id, handle, state, states) VALUES ('kept', 'kept', 'dunning', ?)",
+		`{"created":"2025-03-01T10:00:00Z"}`); err != nil {
+		t.Fatalf("insert kept: %v", err)
+	}
+	invoices["kept"] = struct{ states, state string }{"", "dunning"}
+
+	if _, err := s.MigrateUp(); err != nil {
+		t.Fatalf("MigrateUp: %v", err)
+	}
+	for id, inv := range invoices {
+		var state sql.NullString
+		if err := s.db.QueryRowContext(context.Background(), "SELECT state FROM invoices WHERE id = ?", id).Scan(&state); err != nil {
+			t.Fatalf("read %s: %v", id, err)
+		}
+		if state.String != inv.state {
+			t.Errorf("state of %s = %q, want %q", id, state.String, inv.state)
+		}
+	}
+
+	// The next sync sets the state it could not infer without recording a transition from it
+	pending := Invoice{ID: "pending", Handle: "pending", Currency: "DKK", State: "pending",
+		Created: time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC)}
+	if err := s.CreateOrUpdateInvoice(context.Background(), &pending, SourceSync); err != nil {
+		t.Fatalf("CreateOrUpdateInvoice: %v", err)
+	}
+	transitions, err := s.GetInvoiceStateTransitions(context.Background(), "pending")
+	if err != nil {
+		t.Fatalf("GetInvoiceStateTransitions: %v", err)
+	}
+	if len(transitions) != 1 || transitions[0].FromState != nil || transitions[0].ToState != "pending" {
+		t.Errorf("transitions of pending = %+v, want only the first observation", transitions)
+	}
+}
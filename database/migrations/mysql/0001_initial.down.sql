@@ -0,0 +1,8 @@
+DROP TABLE IF EXISTS credits;
+DROP TABLE IF EXISTS additional_costs;
+DROP TABLE IF EXISTS invoice_discounts;
+DROP TABLE IF EXISTS subscription_discounts;
+DROP TABLE IF EXISTS coupons;
+DROP TABLE IF EXISTS discounts;
+DROP TABLE IF EXISTS invoices;
+DROP TABLE IF EXISTS customers;
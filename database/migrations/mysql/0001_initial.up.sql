@@ -0,0 +1,154 @@
+-- Schema as created by createTables before versioned migrations were introduced.
+-- IF NOT EXISTS lets databases created that way adopt this migration unchanged.
+
+CREATE TABLE IF NOT EXISTS customers (
+	handle VARCHAR(255) PRIMARY KEY,
+	active_subscriptions INTEGER,
+	address TEXT,
+	address2 TEXT,
+	cancelled_amount INTEGER,
+	cancelled_invoices INTEGER,
+	cancelled_subscriptions INTEGER,
+	city VARCHAR(255),
+	company VARCHAR(255),
+	country VARCHAR(255),
+	created DATETIME,
+	dunning_amount INTEGER,
+	dunning_invoices INTEGER,
+	email VARCHAR(255),
+	expired_subscriptions INTEGER,
+	failed_amount INTEGER,
+	failed_invoices INTEGER,
+	first_name VARCHAR(255),
+	last_name VARCHAR(255),
+	non_renewing_subscriptions INTEGER,
+	on_hold_subscriptions INTEGER,
+	pending_additional_cost_amount INTEGER,
+	pending_additional_costs INTEGER,
+	pending_amount INTEGER,
+	pending_credit_amount INTEGER,
+	pending_credits INTEGER,
+	pending_invoices INTEGER,
+	phone VARCHAR(255),
+	postal_code VARCHAR(255),
+	refunded_amount INTEGER,
+	settled_amount INTEGER,
+	settled_invoices INTEGER,
+	subscriptions INTEGER,
+	test BOOLEAN,
+	transferred_additional_cost_amount INTEGER,
+	transferred_additional_costs INTEGER,
+	transferred_credit_amount INTEGER,
+	transferred_credits INTEGER,
+	trial_active_subscriptions INTEGER,
+	trial_cancelled_subscriptions INTEGER
+);
+
+CREATE TABLE IF NOT EXISTS invoices (
+	id VARCHAR(255) PRIMARY KEY,
+	handle VARCHAR(255),
+	customer VARCHAR(255),
+	currency VARCHAR(255),
+	created DATETIME,
+	discount_amount INTEGER,
+	org_amount INTEGER,
+	amount_vat INTEGER,
+	amount_ex_vat INTEGER,
+	refunded_amount INTEGER,
+	authorized_amount INTEGER,
+	country VARCHAR(255),
+	plan VARCHAR(255),
+	states JSON,
+	subscription VARCHAR(255)
+);
+
+CREATE TABLE IF NOT EXISTS discounts (
+	handle VARCHAR(255) PRIMARY KEY,
+	name VARCHAR(255),
+	description TEXT,
+	state VARCHAR(255),
+	amount INTEGER,
+	percentage INTEGER,
+	fixed_count INTEGER,
+	fixed_period_unit VARCHAR(255),
+	fixed_period INTEGER,
+	created DATETIME,
+	deleted DATETIME NULL,
+	country VARCHAR(255)
+);
+
+CREATE TABLE IF NOT EXISTS coupons (
+	handle VARCHAR(255) PRIMARY KEY,
+	name VARCHAR(255),
+	code VARCHAR(255),
+	discount VARCHAR(255),
+	state VARCHAR(255),
+	redemptions INTEGER,
+	max_redemptions INTEGER,
+	valid_until DATETIME NULL,
+	expired DATETIME NULL,
+	created DATETIME,
+	country VARCHAR(255),
+	INDEX idx_coupons_discount (discount)
+);
+
+CREATE TABLE IF NOT EXISTS subscription_discounts (
+	handle VARCHAR(255) PRIMARY KEY,
+	subscription VARCHAR(255),
+	discount VARCHAR(255),
+	coupon VARCHAR(255),
+	state VARCHAR(255),
+	amount INTEGER,
+	percentage INTEGER,
+	created DATETIME,
+	deleted DATETIME NULL,
+	country VARCHAR(255),
+	INDEX idx_subscription_discounts_subscription (subscription),
+	INDEX idx_subscription_discounts_coupon (coupon)
+);
+
+CREATE TABLE IF NOT EXISTS invoice_discounts (
+	invoice_id VARCHAR(255),
+	order_line_id VARCHAR(255),
+	subscription_discount VARCHAR(255),
+	amount INTEGER,
+	PRIMARY KEY (invoice_id, order_line_id),
+	INDEX idx_invoice_discounts_subscription_discount (subscription_discount)
+);
+
+CREATE TABLE IF NOT EXISTS additional_costs (
+	handle VARCHAR(255) PRIMARY KEY,
+	customer VARCHAR(255),
+	subscription VARCHAR(255),
+	state VARCHAR(255),
+	invoice VARCHAR(255),
+	amount INTEGER,
+	vat DOUBLE,
+	quantity INTEGER,
+	ordertext TEXT,
+	amount_incl_vat BOOLEAN,
+	created DATETIME,
+	transferred DATETIME NULL,
+	cancelled DATETIME NULL,
+	country VARCHAR(255),
+	INDEX idx_additional_costs_customer (customer),
+	INDEX idx_additional_costs_subscription (subscription),
+	INDEX idx_additional_costs_invoice (invoice)
+);
+
+CREATE TABLE IF NOT EXISTS credits (
+	handle VARCHAR(255) PRIMARY KEY,
+	customer VARCHAR(255),
+	subscription VARCHAR(255),
+	state VARCHAR(255),
+	invoice VARCHAR(255),
+	amount INTEGER,
+	text TEXT,
+	created DATETIME,
+	transferred DATETIME NULL,
+	cancelled DATETIME NULL,
+	country VARCHAR(255),
+	INDEX idx_credits_customer (customer),
+	INDEX idx_credits_subscription (subscription),
+	INDEX idx_credits_invoice (invoice)
+);
@@ -0,0 +1,34 @@
+ALTER TABLE customers
+	MODIFY COLUMN cancelled_amount INTEGER,
+	MODIFY COLUMN dunning_amount INTEGER,
+	MODIFY COLUMN failed_amount INTEGER,
+	MODIFY COLUMN pending_additional_cost_amount INTEGER,
+	MODIFY COLUMN pending_amount INTEGER,
+	MODIFY COLUMN pending_credit_amount INTEGER,
+	MODIFY COLUMN refunded_amount INTEGER,
+	MODIFY COLUMN settled_amount INTEGER,
+	MODIFY COLUMN transferred_additional_cost_amount INTEGER,
+	MODIFY COLUMN transferred_credit_amount INTEGER;
+
+ALTER TABLE invoices
+	MODIFY COLUMN discount_amount INTEGER,
+	MODIFY COLUMN org_amount INTEGER,
+	MODIFY COLUMN amount_vat INTEGER,
+	MODIFY COLUMN amount_ex_vat INTEGER,
+	MODIFY COLUMN refunded_amount INTEGER,
+	MODIFY COLUMN authorized_amount INTEGER;
+
+ALTER TABLE discounts
+	MODIFY COLUMN amount INTEGER;
+
+ALTER TABLE subscription_discounts
+	MODIFY COLUMN amount INTEGER;
+
+ALTER TABLE invoice_discounts
+	MODIFY COLUMN amount INTEGER;
+
+ALTER TABLE additional_costs
+	MODIFY COLUMN amount INTEGER;
+
+ALTER TABLE credits
+	MODIFY COLUMN amount INTEGER;
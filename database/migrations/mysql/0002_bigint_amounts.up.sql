@@ -0,0 +1,36 @@
+-- Amounts are int64 minor units in Go; INTEGER is 32-bit and overflows on large aggregates.
+
+ALTER TABLE customers
+	MODIFY COLUMN cancelled_amount BIGINT,
+	MODIFY COLUMN dunning_amount BIGINT,
+	MODIFY COLUMN failed_amount BIGINT,
+	MODIFY COLUMN pending_additional_cost_amount BIGINT,
+	MODIFY COLUMN pending_amount BIGINT,
+	MODIFY COLUMN pending_credit_amount BIGINT,
+	MODIFY COLUMN refunded_amount BIGINT,
+	MODIFY COLUMN settled_amount BIGINT,
+	MODIFY COLUMN transferred_additional_cost_amount BIGINT,
+	MODIFY COLUMN transferred_credit_amount BIGINT;
+
+ALTER TABLE invoices
+	MODIFY COLUMN discount_amount BIGINT,
+	MODIFY COLUMN org_amount BIGINT,
+	MODIFY COLUMN amount_vat BIGINT,
+	MODIFY COLUMN amount_ex_vat BIGINT,
+	MODIFY COLUMN refunded_amount BIGINT,
+	MODIFY COLUMN authorized_amount BIGINT;
+
+ALTER TABLE discounts
+	MODIFY COLUMN amount BIGINT;
+
+ALTER TABLE subscription_discounts
+	MODIFY COLUMN amount BIGINT;
+
+ALTER TABLE invoice_discounts
+	MODIFY COLUMN amount BIGINT;
+
+ALTER TABLE additional_costs
+	MODIFY COLUMN amount BIGINT;
+
+ALTER TABLE credits
+	MODIFY COLUMN amount BIGINT;
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS invoice_state_transitions;
+
+ALTER TABLE invoices DROP COLUMN state;
@@ -0,0 +1,12 @@
+ALTER TABLE invoices ADD COLUMN state VARCHAR(255);
+
+CREATE TABLE invoice_state_transitions (
+	id BIGINT AUTO_INCREMENT PRIMARY KEY,
+	invoice_id VARCHAR(255) NOT NULL,
+	from_state VARCHAR(255) NULL,
+	to_state VARCHAR(255) NOT NULL,
+	occurred_at DATETIME NULL,
+	observed_at DATETIME NOT NULL,
+	source VARCHAR(32) NOT NULL,
+	INDEX idx_invoice_state_transitions_invoice (invoice_id, observed_at)
+);
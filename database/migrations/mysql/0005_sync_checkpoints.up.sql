@@ -0,0 +1,11 @@
+CREATE TABLE sync_checkpoints (
+	account VARCHAR(8) NOT NULL,
+	entity VARCHAR(64) NOT NULL,
+	next_page_token VARCHAR(1024),
+	last_created DATETIME NULL,
+	pages INTEGER NOT NULL DEFAULT 0,
+	items BIGINT NOT NULL DEFAULT 0,
+	completed BOOLEAN NOT NULL DEFAULT FALSE,
+	updated_at DATETIME NOT NULL,
+	PRIMARY KEY (account, entity)
+);
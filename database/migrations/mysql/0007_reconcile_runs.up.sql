@@ -0,0 +1,17 @@
+CREATE TABLE reconcile_runs (
+	id BIGINT AUTO_INCREMENT PRIMARY KEY,
+	account VARCHAR(8) NOT NULL,
+	range_from DATETIME NOT NULL,
+	range_to DATETIME NOT NULL,
+	repair BOOLEAN NOT NULL DEFAULT FALSE,
+	status VARCHAR(16) NOT NULL,
+	missing INTEGER NOT NULL DEFAULT 0,
+	extra INTEGER NOT NULL DEFAULT 0,
+	mismatched INTEGER NOT NULL DEFAULT 0,
+	repaired INTEGER NOT NULL DEFAULT 0,
+	error VARCHAR(1024),
+	report JSON,
+	started_at DATETIME NOT NULL,
+	finished_at DATETIME NULL,
+	INDEX idx_reconcile_runs_started (started_at)
+);
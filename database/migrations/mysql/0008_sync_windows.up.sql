@@ -0,0 +1,15 @@
+CREATE TABLE sync_windows (
+	account VARCHAR(8) NOT NULL,
+	entity VARCHAR(64) NOT NULL,
+	window_from DATETIME NOT NULL,
+	window_to DATETIME NULL,
+	next_page_token VARCHAR(1024),
+	pages INTEGER NOT NULL DEFAULT 0,
+	items BIGINT NOT NULL DEFAULT 0,
+	failed BIGINT NOT NULL DEFAULT 0,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	completed BOOLEAN NOT NULL DEFAULT FALSE,
+	last_error VARCHAR(1024),
+	updated_at DATETIME NOT NULL,
+	PRIMARY KEY (account, entity, window_from)
+);
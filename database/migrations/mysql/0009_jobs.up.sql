@@ -0,0 +1,11 @@
+CREATE TABLE jobs (
+	id BIGINT AUTO_INCREMENT PRIMARY KEY,
+	type VARCHAR(32) NOT NULL,
+	params JSON,
+	status VARCHAR(16) NOT NULL,
+	error VARCHAR(1024),
+	result JSON,
+	started_at DATETIME NOT NULL,
+	finished_at DATETIME NULL,
+	INDEX idx_jobs_started (started_at)
+);
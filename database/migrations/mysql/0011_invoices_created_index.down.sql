@@ -0,0 +1 @@
+DROP INDEX idx_invoices_created ON invoices;
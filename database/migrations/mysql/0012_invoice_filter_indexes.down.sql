@@ -0,0 +1,6 @@
+DROP INDEX idx_invoices_country_created ON invoices;
+DROP INDEX idx_invoices_plan_created ON invoices;
+DROP INDEX idx_invoices_state_created ON invoices;
+DROP INDEX idx_invoices_customer ON invoices;
+DROP INDEX idx_invoices_handle ON invoices;
+DROP INDEX idx_customers_email ON customers;
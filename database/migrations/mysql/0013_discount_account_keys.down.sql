@@ -0,0 +1,4 @@
+-- Fails if a handle is stored for more than one account.
+ALTER TABLE discounts DROP PRIMARY KEY, ADD PRIMARY KEY (handle), MODIFY COLUMN country VARCHAR(255) NULL;
+ALTER TABLE coupons DROP PRIMARY KEY, ADD PRIMARY KEY (handle), MODIFY COLUMN country VARCHAR(255) NULL;
+ALTER TABLE subscription_discounts DROP PRIMARY KEY, ADD PRIMARY KEY (handle), MODIFY COLUMN country VARCHAR(255) NULL;
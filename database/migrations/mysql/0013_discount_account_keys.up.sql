@@ -0,0 +1,8 @@
+-- Discount, coupon and subscription discount handles are only unique within a Frisbii account.
+UPDATE discounts SET country = '' WHERE country IS NULL;
+UPDATE coupons SET country = '' WHERE country IS NULL;
+UPDATE subscription_discounts SET country = '' WHERE country IS NULL;
+
+ALTER TABLE discounts MODIFY COLUMN country VARCHAR(255) NOT NULL, DROP PRIMARY KEY, ADD PRIMARY KEY (handle, country);
+ALTER TABLE coupons MODIFY COLUMN country VARCHAR(255) NOT NULL, DROP PRIMARY KEY, ADD PRIMARY KEY (handle, country);
+ALTER TABLE subscription_discounts MODIFY COLUMN country VARCHAR(255) NOT NULL, DROP PRIMARY KEY, ADD PRIMARY KEY (handle, country);
@@ -0,0 +1,13 @@
+-- Databases created before versioned migrations adopted 0001_initial through CREATE TABLE
+-- IF NOT EXISTS, which kept their invoices table without the subscription column that
+-- createTables added later. Add it where it is missing; MySQL has no ADD COLUMN IF NOT EXISTS.
+
+SET @add_invoice_subscription = IF(
+	(SELECT COUNT(*) FROM information_schema.COLUMNS
+	WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = 'invoices' AND COLUMN_NAME = 'subscription') = 0,
+	'ALTER TABLE invoices ADD COLUMN subscription VARCHAR(255)',
+	'DO 0'
+);
+PREPARE add_invoice_subscription FROM @add_invoice_subscription;
+EXECUTE add_invoice_subscription;
+DEALLOCATE PREPARE add_invoice_subscription;
@@ -0,0 +1,20 @@
+-- 0003 added invoices.state empty, and only invoices synced since have it. Set it for the
+-- others from their latest state timestamp, comparing the first 19 characters
+-- (YYYY-MM-DDTHH:MM:SS) as Frisbii reports times in UTC. On a tie the later state in the
+-- lifecycle wins. An invoice with only a created timestamp may be created, pending or
+-- dunning, so its state stays NULL until the next sync sets it.
+
+UPDATE invoices
+SET state = CASE GREATEST(
+		COALESCE(LEFT(NULLIF(JSON_UNQUOTE(JSON_EXTRACT(states, '$.created')), 'null'), 19), ''),
+		COALESCE(LEFT(NULLIF(JSON_UNQUOTE(JSON_EXTRACT(states, '$.authorized')), 'null'), 19), ''),
+		COALESCE(LEFT(NULLIF(JSON_UNQUOTE(JSON_EXTRACT(states, '$.settled')), 'null'), 19), ''),
+		COALESCE(LEFT(NULLIF(JSON_UNQUOTE(JSON_EXTRACT(states, '$.failed')), 'null'), 19), ''),
+		COALESCE(LEFT(NULLIF(JSON_UNQUOTE(JSON_EXTRACT(states, '$.cancelled')), 'null'), 19), '')
+	)
+		WHEN LEFT(NULLIF(JSON_UNQUOTE(JSON_EXTRACT(states, '$.cancelled')), 'null'), 19) THEN 'cancelled'
+		WHEN LEFT(NULLIF(JSON_UNQUOTE(JSON_EXTRACT(states, '$.failed')), 'null'), 19) THEN 'failed'
+		WHEN LEFT(NULLIF(JSON_UNQUOTE(JSON_EXTRACT(states, '$.settled')), 'null'), 19) THEN 'settled'
+		WHEN LEFT(NULLIF(JSON_UNQUOTE(JSON_EXTRACT(states, '$.authorized')), 'null'), 19) THEN 'authorized'
+	END
+WHERE state IS NULL AND states IS NOT NULL;
@@ -0,0 +1,3 @@
+-- Processes store a heartbeat for the jobs they run and poll for cancellation, so jobs of
+-- a process that stopped can be marked interrupted and any process can cancel a job.
+ALTER TABLE jobs ADD COLUMN heartbeat_at DATETIME NULL, ADD COLUMN cancel_requested BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,3 @@
+-- Fails if a handle is stored for more than one account.
+ALTER TABLE additional_costs DROP PRIMARY KEY, ADD PRIMARY KEY (handle), MODIFY COLUMN country VARCHAR(255) NULL;
+ALTER TABLE credits DROP PRIMARY KEY, ADD PRIMARY KEY (handle), MODIFY COLUMN country VARCHAR(255) NULL;
@@ -0,0 +1,6 @@
+-- Additional cost and credit handles are only unique within a Frisbii account.
+UPDATE additional_costs SET country = '' WHERE country IS NULL;
+UPDATE credits SET country = '' WHERE country IS NULL;
+
+ALTER TABLE additional_costs MODIFY COLUMN country VARCHAR(255) NOT NULL, DROP PRIMARY KEY, ADD PRIMARY KEY (handle, country);
+ALTER TABLE credits MODIFY COLUMN country VARCHAR(255) NOT NULL, DROP PRIMARY KEY, ADD PRIMARY KEY (handle, country);
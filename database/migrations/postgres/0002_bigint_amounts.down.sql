@@ -0,0 +1,34 @@
+ALTER TABLE customers
+	ALTER COLUMN cancelled_amount TYPE INTEGER,
+	ALTER COLUMN dunning_amount TYPE INTEGER,
+	ALTER COLUMN failed_amount TYPE INTEGER,
+	ALTER COLUMN pending_additional_cost_amount TYPE INTEGER,
+	ALTER COLUMN pending_amount TYPE INTEGER,
+	ALTER COLUMN pending_credit_amount TYPE INTEGER,
+	ALTER COLUMN refunded_amount TYPE INTEGER,
+	ALTER COLUMN settled_amount TYPE INTEGER,
+	ALTER COLUMN transferred_additional_cost_amount TYPE INTEGER,
+	ALTER COLUMN transferred_credit_amount TYPE INTEGER;
+
+ALTER TABLE invoices
+	ALTER COLUMN discount_amount TYPE INTEGER,
+	ALTER COLUMN org_amount TYPE INTEGER,
+	ALTER COLUMN amount_vat TYPE INTEGER,
+	ALTER COLUMN amount_ex_vat TYPE INTEGER,
+	ALTER COLUMN refunded_amount TYPE INTEGER,
+	ALTER COLUMN authorized_amount TYPE INTEGER;
+
+ALTER TABLE discounts
+	ALTER COLUMN amount TYPE INTEGER;
+
+ALTER TABLE subscription_discounts
+	ALTER COLUMN amount TYPE INTEGER;
+
+ALTER TABLE invoice_discounts
+	ALTER COLUMN amount TYPE INTEGER;
+
+ALTER TABLE additional_costs
+	ALTER COLUMN amount TYPE INTEGER;
+
+ALTER TABLE credits
+	ALTER COLUMN amount TYPE INTEGER;
@@ -0,0 +1,36 @@
+-- Amounts are int64 minor units in Go; INTEGER is 32-bit and overflows on large aggregates.
+
+ALTER TABLE customers
+	ALTER COLUMN cancelled_amount TYPE BIGINT,
+	ALTER COLUMN dunning_amount TYPE BIGINT,
+	ALTER COLUMN failed_amount TYPE BIGINT,
+	ALTER COLUMN pending_additional_cost_amount TYPE BIGINT,
+	ALTER COLUMN pending_amount TYPE BIGINT,
+	ALTER COLUMN pending_credit_amount TYPE BIGINT,
+	ALTER COLUMN refunded_amount TYPE BIGINT,
+	ALTER COLUMN settled_amount TYPE BIGINT,
+	ALTER COLUMN transferred_additional_cost_amount TYPE BIGINT,
+	ALTER COLUMN transferred_credit_amount TYPE BIGINT;
+
+ALTER TABLE invoices
+	ALTER COLUMN discount_amount TYPE BIGINT,
+	ALTER COLUMN org_amount TYPE BIGINT,
+	ALTER COLUMN amount_vat TYPE BIGINT,
+	ALTER COLUMN amount_ex_vat TYPE BIGINT,
+	ALTER COLUMN refunded_amount TYPE BIGINT,
+	ALTER COLUMN authorized_amount TYPE BIGINT;
+
+ALTER TABLE discounts
+	ALTER COLUMN amount TYPE BIGINT;
+
+ALTER TABLE subscription_discounts
+	ALTER COLUMN amount TYPE BIGINT;
+
+ALTER TABLE invoice_discounts
+	ALTER COLUMN amount TYPE BIGINT;
+
+ALTER TABLE additional_costs
+	ALTER COLUMN amount TYPE BIGINT;
+
+ALTER TABLE credits
+	ALTER COLUMN amount TYPE BIGINT;
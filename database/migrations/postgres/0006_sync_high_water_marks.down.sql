@@ -0,0 +1 @@
+DROP TABLE IF EXISTS sync_high_water_marks;
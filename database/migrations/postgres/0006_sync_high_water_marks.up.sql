@@ -0,0 +1,11 @@
+CREATE TABLE sync_high_water_marks (
+	account VARCHAR(8) NOT NULL,
+	entity VARCHAR(64) NOT NULL,
+	high_water_mark TIMESTAMPTZ NULL,
+	last_run TIMESTAMPTZ NULL,
+	last_success TIMESTAMPTZ NULL,
+	last_error VARCHAR(1024),
+	items BIGINT NOT NULL DEFAULT 0,
+	updated_at TIMESTAMPTZ NOT NULL,
+	PRIMARY KEY (account, entity)
+);
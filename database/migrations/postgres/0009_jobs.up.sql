@@ -0,0 +1,11 @@
+CREATE TABLE jobs (
+	id BIGSERIAL PRIMARY KEY,
+	type VARCHAR(32) NOT NULL,
+	params JSONB,
+	status VARCHAR(16) NOT NULL,
+	error VARCHAR(1024),
+	result JSONB,
+	started_at TIMESTAMPTZ NOT NULL,
+	finished_at TIMESTAMPTZ NULL
+);
+CREATE INDEX idx_jobs_started ON jobs (started_at);
@@ -0,0 +1,6 @@
+CREATE TABLE leases (
+	name VARCHAR(64) NOT NULL PRIMARY KEY,
+	holder VARCHAR(255) NOT NULL,
+	acquired_at TIMESTAMPTZ NOT NULL,
+	expires_at TIMESTAMPTZ NOT NULL
+);
@@ -0,0 +1,4 @@
+-- Fails if a handle is stored for more than one account.
+ALTER TABLE discounts DROP CONSTRAINT discounts_pkey, ADD PRIMARY KEY (handle), ALTER COLUMN country DROP NOT NULL;
+ALTER TABLE coupons DROP CONSTRAINT coupons_pkey, ADD PRIMARY KEY (handle), ALTER COLUMN country DROP NOT NULL;
+ALTER TABLE subscription_discounts DROP CONSTRAINT subscription_discounts_pkey, ADD PRIMARY KEY (handle), ALTER COLUMN country DROP NOT NULL;
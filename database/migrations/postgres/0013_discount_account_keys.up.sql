@@ -0,0 +1,8 @@
+-- Discount, coupon and subscription discount handles are only unique within a Frisbii account.
+UPDATE discounts SET country = '' WHERE country IS NULL;
+UPDATE coupons SET country = '' WHERE country IS NULL;
+UPDATE subscription_discounts SET country = '' WHERE country IS NULL;
+
+ALTER TABLE discounts ALTER COLUMN country SET NOT NULL, DROP CONSTRAINT discounts_pkey, ADD PRIMARY KEY (handle, country);
+ALTER TABLE coupons ALTER COLUMN country SET NOT NULL, DROP CONSTRAINT coupons_pkey, ADD PRIMARY KEY (handle, country);
+ALTER TABLE subscription_discounts ALTER COLUMN country SET NOT NULL, DROP CONSTRAINT subscription_discounts_pkey, ADD PRIMARY KEY (handle, country);
@@ -0,0 +1,3 @@
+-- Kept in step with MySQL, where databases that adopted 0001_initial may lack the column.
+
+ALTER TABLE invoices ADD COLUMN IF NOT EXISTS subscription VARCHAR(255);
@@ -0,0 +1 @@
+-- The states set by the up migration are kept; 0003 down drops the column.
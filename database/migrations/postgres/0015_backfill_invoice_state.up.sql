@@ -0,0 +1,24 @@
+-- 0003 added invoices.state empty, and only invoices synced since have it. Set it for the
+-- others from their latest state timestamp, comparing the first 19 characters
+-- (YYYY-MM-DDTHH:MM:SS) as Frisbii reports times in UTC. On a tie the later state in the
+-- lifecycle wins. An invoice with only a created timestamp may be created, pending or
+-- dunning, so its state stays NULL until the next sync sets it.
+
+UPDATE invoices
+SET state = CASE GREATEST(s.created_at, s.authorized_at, s.settled_at, s.failed_at, s.cancelled_at)
+		WHEN s.cancelled_at THEN 'cancelled'
+		WHEN s.failed_at THEN 'failed'
+		WHEN s.settled_at THEN 'settled'
+		WHEN s.authorized_at THEN 'authorized'
+	END
+FROM (
+	SELECT id,
+		left(states->>'created', 19) AS created_at,
+		left(states->>'authorized', 19) AS authorized_at,
+		left(states->>'settled', 19) AS settled_at,
+		left(states->>'failed', 19) AS failed_at,
+		left(states->>'cancelled', 19) AS cancelled_at
+	FROM invoices
+	WHERE state IS NULL AND states IS NOT NULL
+) s
+WHERE invoices.id = s.id;
@@ -0,0 +1,7 @@
+-- Customer amounts are in the currency of their Frisbii account, which the customer
+-- object does not name. Synced customers record it; existing ones take the currency of
+-- their latest invoice.
+ALTER TABLE customers ADD COLUMN currency VARCHAR(3);
+UPDATE customers SET currency = (
+	SELECT i.currency FROM invoices i WHERE i.customer = customers.handle ORDER BY i.created DESC LIMIT 1
+) WHERE currency IS NULL;
@@ -0,0 +1,3 @@
+-- Fails if a handle is stored for more than one account.
+ALTER TABLE additional_costs DROP CONSTRAINT additional_costs_pkey, ADD PRIMARY KEY (handle), ALTER COLUMN country DROP NOT NULL;
+ALTER TABLE credits DROP CONSTRAINT credits_pkey, ADD PRIMARY KEY (handle), ALTER COLUMN country DROP NOT NULL;
@@ -0,0 +1,6 @@
+-- Additional cost and credit handles are only unique within a Frisbii account.
+UPDATE additional_costs SET country = '' WHERE country IS NULL;
+UPDATE credits SET country = '' WHERE country IS NULL;
+
+ALTER TABLE additional_costs ALTER COLUMN country SET NOT NULL, DROP CONSTRAINT additional_costs_pkey, ADD PRIMARY KEY (handle, country);
+ALTER TABLE credits ALTER COLUMN country SET NOT NULL, DROP CONSTRAINT credits_pkey, ADD PRIMARY KEY (handle, country);
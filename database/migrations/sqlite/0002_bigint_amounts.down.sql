@@ -0,0 +1 @@
+-- SQLite INTEGER columns are already 64-bit; kept so versions match the other backends.
@@ -0,0 +1,17 @@
+CREATE TABLE customer_versions (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	handle VARCHAR(255) NOT NULL,
+	email VARCHAR(255),
+	first_name VARCHAR(255),
+	last_name VARCHAR(255),
+	company VARCHAR(255),
+	address TEXT,
+	address2 TEXT,
+	postal_code VARCHAR(255),
+	city VARCHAR(255),
+	country VARCHAR(255),
+	phone VARCHAR(255),
+	valid_from DATETIME NOT NULL,
+	valid_to DATETIME NULL
+);
+CREATE INDEX idx_customer_versions_handle ON customer_versions (handle, valid_from);
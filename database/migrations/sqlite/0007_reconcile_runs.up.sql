@@ -0,0 +1,17 @@
+CREATE TABLE reconcile_runs (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	account VARCHAR(8) NOT NULL,
+	range_from DATETIME NOT NULL,
+	range_to DATETIME NOT NULL,
+	repair BOOLEAN NOT NULL DEFAULT FALSE,
+	status VARCHAR(16) NOT NULL,
+	missing INTEGER NOT NULL DEFAULT 0,
+	extra INTEGER NOT NULL DEFAULT 0,
+	mismatched INTEGER NOT NULL DEFAULT 0,
+	repaired INTEGER NOT NULL DEFAULT 0,
+	error VARCHAR(1024),
+	report TEXT,
+	started_at DATETIME NOT NULL,
+	finished_at DATETIME NULL
+);
+CREATE INDEX idx_reconcile_runs_started ON reconcile_runs (started_at);
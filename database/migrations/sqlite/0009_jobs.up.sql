@@ -0,0 +1,11 @@
+CREATE TABLE jobs (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	type VARCHAR(32) NOT NULL,
+	params TEXT,
+	status VARCHAR(16) NOT NULL,
+	error VARCHAR(1024),
+	result TEXT,
+	started_at DATETIME NOT NULL,
+	finished_at DATETIME NULL
+);
+CREATE INDEX idx_jobs_started ON jobs (started_at);
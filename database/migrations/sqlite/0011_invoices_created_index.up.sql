@@ -0,0 +1 @@
+CREATE INDEX idx_invoices_created ON invoices (created, id);
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_invoices_country_created;
+DROP INDEX IF EXISTS idx_invoices_plan_created;
+DROP INDEX IF EXISTS idx_invoices_state_created;
+DROP INDEX IF EXISTS idx_invoices_customer;
+DROP INDEX IF EXISTS idx_invoices_handle;
+DROP INDEX IF EXISTS idx_customers_email;
@@ -0,0 +1,6 @@
+CREATE INDEX idx_invoices_country_created ON invoices (country, created);
+CREATE INDEX idx_invoices_plan_created ON invoices (plan, created);
+CREATE INDEX idx_invoices_state_created ON invoices (state, created);
+CREATE INDEX idx_invoices_customer ON invoices (customer);
+CREATE INDEX idx_invoices_handle ON invoices (handle);
+CREATE INDEX idx_customers_email ON customers (email);
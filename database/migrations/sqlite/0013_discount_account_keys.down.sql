@@ -0,0 +1,60 @@
+-- Fails if a handle is stored for more than one account.
+CREATE TABLE discounts_new (
+	handle VARCHAR(255) NOT NULL,
+	name VARCHAR(255),
+	description TEXT,
+	state VARCHAR(255),
+	amount INTEGER,
+	percentage INTEGER,
+	fixed_count INTEGER,
+	fixed_period_unit VARCHAR(255),
+	fixed_period INTEGER,
+	created DATETIME,
+	deleted DATETIME NULL,
+	country VARCHAR(255),
+	PRIMARY KEY (handle)
+);
+INSERT INTO discounts_new SELECT handle, name, description, state, amount, percentage, fixed_count,
+	fixed_period_unit, fixed_period, created, deleted, country FROM discounts;
+DROP TABLE discounts;
+ALTER TABLE discounts_new RENAME TO discounts;
+
+CREATE TABLE coupons_new (
+	handle VARCHAR(255) NOT NULL,
+	name VARCHAR(255),
+	code VARCHAR(255),
+	discount VARCHAR(255),
+	state VARCHAR(255),
+	redemptions INTEGER,
+	max_redemptions INTEGER,
+	valid_until DATETIME NULL,
+	expired DATETIME NULL,
+	created DATETIME,
+	country VARCHAR(255),
+	PRIMARY KEY (handle)
+);
+INSERT INTO coupons_new SELECT handle, name, code, discount, state, redemptions, max_redemptions,
+	valid_until, expired, created, country FROM coupons;
+DROP TABLE coupons;
+ALTER TABLE coupons_new RENAME TO coupons;
+CREATE INDEX idx_coupons_discount ON coupons (discount);
+
+CREATE TABLE subscription_discounts_new (
+	handle VARCHAR(255) NOT NULL,
+	subscription VARCHAR(255),
+	discount VARCHAR(255),
+	coupon VARCHAR(255),
+	state VARCHAR(255),
+	amount INTEGER,
+	percentage INTEGER,
+	created DATETIME,
+	deleted DATETIME NULL,
+	country VARCHAR(255),
+	PRIMARY KEY (handle)
+);
+INSERT INTO subscription_discounts_new SELECT handle, subscription, discount, coupon, state, amount, percentage,
+	created, deleted, country FROM subscription_discounts;
+DROP TABLE subscription_discounts;
+ALTER TABLE subscription_discounts_new RENAME TO subscription_discounts;
+CREATE INDEX idx_subscription_discounts_subscription ON subscription_discounts (subscription);
+CREATE INDEX idx_subscription_discounts_coupon ON subscription_discounts (coupon);
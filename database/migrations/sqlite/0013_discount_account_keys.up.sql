@@ -0,0 +1,61 @@
+-- Discount, coupon and subscription discount handles are only unique within a Frisbii account.
+-- SQLite cannot change a primary key, so the tables are rebuilt.
+CREATE TABLE discounts_new (
+	handle VARCHAR(255) NOT NULL,
+	name VARCHAR(255),
+	description TEXT,
+	state VARCHAR(255),
+	amount INTEGER,
+	percentage INTEGER,
+	fixed_count INTEGER,
+	fixed_period_unit VARCHAR(255),
+	fixed_period INTEGER,
+	created DATETIME,
+	deleted DATETIME NULL,
+	country VARCHAR(255) NOT NULL DEFAULT '',
+	PRIMARY KEY (handle, country)
+);
+INSERT INTO discounts_new SELECT handle, name, description, state, amount, percentage, fixed_count,
+	fixed_period_unit, fixed_period, created, deleted, COALESCE(country, '') FROM discounts;
+DROP TABLE discounts;
+ALTER TABLE discounts_new RENAME TO discounts;
+
+CREATE TABLE coupons_new (
+	handle VARCHAR(255) NOT NULL,
+	name VARCHAR(255),
+	code VARCHAR(255),
+	discount VARCHAR(255),
+	state VARCHAR(255),
+	redemptions INTEGER,
+	max_redemptions INTEGER,
+	valid_until DATETIME NULL,
+	expired DATETIME NULL,
+	created DATETIME,
+	country VARCHAR(255) NOT NULL DEFAULT '',
+	PRIMARY KEY (handle, country)
+);
+INSERT INTO coupons_new SELECT handle, name, code, discount, state, redemptions, max_redemptions,
+	valid_until, expired, created, COALESCE(country, '') FROM coupons;
+DROP TABLE coupons;
+ALTER TABLE coupons_new RENAME TO coupons;
+CREATE INDEX idx_coupons_discount ON coupons (discount);
+
+CREATE TABLE subscription_discounts_new (
+	handle VARCHAR(255) NOT NULL,
+	subscription VARCHAR(255),
+	discount VARCHAR(255),
+	coupon VARCHAR(255),
+	state VARCHAR(255),
+	amount INTEGER,
+	percentage INTEGER,
+	created DATETIME,
+	deleted DATETIME NULL,
+	country VARCHAR(255) NOT NULL DEFAULT '',
+	PRIMARY KEY (handle, country)
+);
+INSERT INTO subscription_discounts_new SELECT handle, subscription, discount, coupon, state, amount, percentage,
+	created, deleted, COALESCE(country, '') FROM subscription_discounts;
+DROP TABLE subscription_discounts;
+ALTER TABLE subscription_discounts_new RENAME TO subscription_discounts;
+CREATE INDEX idx_subscription_discounts_subscription ON subscription_discounts (subscription);
+CREATE INDEX idx_subscription_discounts_coupon ON subscription_discounts (coupon);
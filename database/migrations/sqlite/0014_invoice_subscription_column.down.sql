@@ -0,0 +1 @@
+-- The column is part of 0001_initial on every database created since, so it is kept.
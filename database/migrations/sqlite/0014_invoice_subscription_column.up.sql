@@ -0,0 +1,2 @@
+-- Kept in step with MySQL, where databases that adopted 0001_initial may lack the column.
+-- SQLite databases were always created by 0001_initial, which has it.
@@ -0,0 +1,2 @@
+ALTER TABLE jobs DROP COLUMN heartbeat_at;
+ALTER TABLE jobs DROP COLUMN cancel_requested;
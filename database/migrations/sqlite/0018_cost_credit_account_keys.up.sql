@@ -0,0 +1,48 @@
+-- Additional cost and credit handles are only unique within a Frisbii account.
+-- SQLite cannot change a primary key, so the tables are rebuilt.
+CREATE TABLE additional_costs_new (
+	handle VARCHAR(255) NOT NULL,
+	customer VARCHAR(255),
+	subscription VARCHAR(255),
+	state VARCHAR(255),
+	invoice VARCHAR(255),
+	amount INTEGER,
+	vat DOUBLE,
+	quantity INTEGER,
+	ordertext TEXT,
+	amount_incl_vat BOOLEAN,
+	created DATETIME,
+	transferred DATETIME NULL,
+	cancelled DATETIME NULL,
+	country VARCHAR(255) NOT NULL DEFAULT '',
+	PRIMARY KEY (handle, country)
+);
+INSERT INTO additional_costs_new SELECT handle, customer, subscription, state, invoice, amount, vat, quantity,
+	ordertext, amount_incl_vat, created, transferred, cancelled, COALESCE(country, '') FROM additional_costs;
+DROP TABLE additional_costs;
+ALTER TABLE additional_costs_new RENAME TO additional_costs;
+CREATE INDEX idx_additional_costs_customer ON additional_costs (customer);
+CREATE INDEX idx_additional_costs_subscription ON additional_costs (subscription);
+CREATE INDEX idx_additional_costs_invoice ON additional_costs (invoice);
+
+CREATE TABLE credits_new (
+	handle VARCHAR(255) NOT NULL,
+	customer VARCHAR(255),
+	subscription VARCHAR(255),
+	state VARCHAR(255),
+	invoice VARCHAR(255),
+	amount INTEGER,
+	text TEXT,
+	created DATETIME,
+	transferred DATETIME NULL,
+	cancelled DATETIME NULL,
+	country VARCHAR(255) NOT NULL DEFAULT '',
+	PRIMARY KEY (handle, country)
+);
+INSERT INTO credits_new SELECT handle, customer, subscription, state, invoice, amount, text,
+	created, transferred, cancelled, COALESCE(country, '') FROM credits;
+DROP TABLE credits;
+ALTER TABLE credits_new RENAME TO credits;
+CREATE INDEX idx_credits_customer ON credits (customer);
+CREATE INDEX idx_credits_subscription ON credits (subscription);
+CREATE INDEX idx_credits_invoice ON credits (invoice);
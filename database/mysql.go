@@ -0,0 +1,50 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// OpenMySQL connects to a MySQL database, creating it if needed, without touching the
+// schema beyond the migrations bookkeeping table
+func OpenMySQL(dataSourceName string) (*SQLStore, error) {
+	if err := createDatabaseIfNotExists(dataSourceName); err != nil {
+		return nil, err
+	}
+	dsn, err := mysql.ParseDSN(dataSourceName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse DSN: %w", err)
+	}
+	dsn.ParseTime = true // DATETIME columns are scanned into time.Time
+
+	db, err := sql.Open("mysql", dsn.FormatDSN())
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	return newSQLStore(db, mysqlDialect{})
+}
+
+func createDatabaseIfNotExists(dsn string) error {
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return fmt.Errorf("failed to parse DSN: %w", err)
+	}
+
+	dbName := cfg.DBName
+	cfg.DBName = ""
+
+	db, err := sql.Open("mysql", cfg.FormatDSN())
+	if err != nil {
+		return fmt.Errorf("failed to open database for creation check: %w", err)
+	}
+	defer db.Close()
+
+	_, err = db.Exec(fmt.Sprintf("CREATE DATABASE IF NOT EXISTS %s", dbName))
+	if err != nil {
+		return fmt.Errorf("failed to create database: %w", err)
+	}
+
+	return nil
+}
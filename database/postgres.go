@@ -0,0 +1,18 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// OpenPostgres connects to an existing PostgreSQL database given a postgres:// URL or a
+// key=value connection string
+func OpenPostgres(dataSourceName string) (*SQLStore, error) {
+	db, err := sql.Open("pgx", dataSourceName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	return newSQLStore(db, postgresDialect{})
+}
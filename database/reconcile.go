@@ -0,0 +1,143 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Statuses of a reconcile run
+const (
+	ReconcileRunning     = "running"
+	ReconcileMatched     = "matched"     // The database matches Frisbii
+	ReconcileDifferences = "differences" // Differences were found and not all were repaired
+	ReconcileRepaired    = "repaired"    // Differences were found and every one was repaired
+	ReconcileFailed      = "failed"
+)
+
+// ReconcileRun is one comparison of the invoices of an account created in [From, To)
+// between Frisbii and the database. Report holds the per day totals and differences.
+type ReconcileRun struct {
+	ID         int64           `json:"id"`
+	Account    string          `json:"account"`
+	From       time.Time       `json:"from"`
+	To         time.Time       `json:"to"`
+	Repair     bool            `json:"repair"`
+	Status     string          `json:"status"`
+	Missing    int             `json:"missing"`    // In Frisbii but not in the database
+	Extra      int             `json:"extra"`      // In the database but not in Frisbii
+	Mismatched int             `json:"mismatched"` // In both with different values
+	Repaired   int             `json:"repaired"`
+	Error      string          `json:"error,omitempty"`
+	Report     json.RawMessage `json:"report,omitempty"`
+	StartedAt  time.Time       `json:"started_at"`
+	FinishedAt *time.Time      `json:"finished_at"`
+}
+
+func reconcileRunArgs(run *ReconcileRun) []interface{} {
+	var report interface{}
+	if len(run.Report) > 0 {
+		report = string(run.Report)
+	}
+	var runError interface{}
+	if run.Error != "" {
+		runError = run.Error
+	}
+	return []interface{}{
+		run.Account, run.From, run.To, run.Repair, run.Status, run.Missing, run.Extra, run.Mismatched,
+		run.Repaired, runError, report, run.StartedAt, run.FinishedAt,
+	}
+}
+
+func scanReconcileRun(row interface{ Scan(...interface{}) error }, withReport bool) (ReconcileRun, error) {
+	var run ReconcileRun
+	var runError sql.NullString
+	var finishedAt sql.NullTime
+	dest := []interface{}{
+		&run.ID, &run.Account, &run.From, &run.To, &run.Repair, &run.Status, &run.Missing, &run.Extra,
+		&run.Mismatched, &run.Repaired, &runError, &run.StartedAt, &finishedAt,
+	}
+	var report []byte
+	if withReport {
+		dest = append(dest, &report)
+	}
+	err := row.Scan(dest...)
+	run.Error = runError.String
+	run.FinishedAt = nullTimePtr(finishedAt)
+	if len(report) > 0 {
+		run.Report = json.RawMessage(report)
+	}
+	return run, err
+}
+
+const reconcileRunColumns = "id, account, range_from, range_to, repair, status, missing, extra, mismatched, repaired, error, started_at, finished_at"
+
+// CreateReconcileRun inserts a run and sets its ID
+func (s *SQLStore) CreateReconcileRun(ctx context.Context, run *ReconcileRun) error {
+	id, err := s.insertID(ctx, `
+	INSERT INTO reconcile_runs (account, range_from, range_to, repair, status, missing, extra, mismatched,
+	repaired, error, report, started_at, finished_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, reconcileRunArgs(run)...)
+	if err != nil {
+		return fmt.Errorf("failed to insert reconcile run: %w", err)
+	}
+	run.ID = id
+	return nil
+}
+
+// UpdateReconcileRun stores the status, counts and report of a run
+func (s *SQLStore) UpdateReconcileRun(ctx context.Context, run *ReconcileRun) error {
+	args := append(reconcileRunArgs(run), run.ID)
+	_, err := s.db.ExecContext(ctx, `
+	UPDATE reconcile_runs SET account = ?, range_from = ?, range_to = ?, repair = ?, status = ?, missing = ?,
+	extra = ?, mismatched = ?, repaired = ?, error = ?, report = ?, started_at = ?, finished_at = ?
+	WHERE id = ?
+	`, args...)
+	if err != nil {
+		return fmt.Errorf("failed to update reconcile run: %w", err)
+	}
+	return nil
+}
+
+// GetReconcileRun returns a run with its report, or nil if there is no such run
+func (s *SQLStore) GetReconcileRun(ctx context.Context, id int64) (*ReconcileRun, error) {
+	run, err := scanReconcileRun(s.db.QueryRowContext(ctx,
+		"SELECT "+reconcileRunColumns+", report FROM reconcile_runs WHERE id = ?", id,
+	), true)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query reconcile run: %w", err)
+	}
+	return &run, nil
+}
+
+// GetReconcileRuns returns the latest limit runs, newest first, without their reports
+func (s *SQLStore) GetReconcileRuns(ctx context.Context, limit int) ([]ReconcileRun, error) {
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT "+reconcileRunColumns+" FROM reconcile_runs ORDER BY started_at DESC, id DESC LIMIT ?", limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query reconcile runs: %w", err)
+	}
+	defer rows.Close()
+
+	var runs []ReconcileRun
+	for rows.Next() {
+		run, err := scanReconcileRun(rows, false)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan reconcile run row: %w", err)
+		}
+		runs = append(runs, run)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during rows iteration: %w", err)
+	}
+
+	return runs, nil
+}
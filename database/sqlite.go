@@ -0,0 +1,60 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"strings"
+
+	_ "modernc.org/sqlite"
+)
+
+// sqlitePragmas are the pragmas every connection runs unless the DSN sets them itself
+var sqlitePragmas = []string{"busy_timeout(5000)", "foreign_keys(1)"}
+
+// OpenSQLite opens or creates an SQLite database file; path ":memory:" gives a private
+// in-memory database that lives as long as the store. The path may carry driver parameters
+// of its own, e.g. data/psp.db?_pragma=journal_mode(WAL).
+func OpenSQLite(path string) (*SQLStore, error) {
+	dsn, err := sqliteDSN(path)
+	if err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	// SQLite allows one writer at a time, and each connection to :memory: is its own database
+	db.SetMaxOpenConns(1)
+	return newSQLStore(db, sqliteDialect{})
+}
+
+// sqliteDSN adds the driver parameters the store relies on to the parameters of path
+func sqliteDSN(path string) (string, error) {
+	path, rawQuery, _ := strings.Cut(path, "?")
+	params, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return "", fmt.Errorf("invalid SQLite DSN parameters %q: %w", rawQuery, err)
+	}
+	for _, pragma := range sqlitePragmas {
+		name, _, _ := strings.Cut(pragma, "(")
+		if !hasPragma(params["_pragma"], name) {
+			params.Add("_pragma", pragma)
+		}
+	}
+	// Times are compared as text, and immediate transactions take the write lock up front,
+	// standing in for SELECT ... FOR UPDATE
+	params.Set("_time_format", "sqlite")
+	params.Set("_txlock", "immediate")
+	return path + "?" + params.Encode(), nil
+}
+
+// hasPragma reports whether pragmas, as in _pragma=name(value) parameters, set name
+func hasPragma(pragmas []string, name string) bool {
+	for _, p := range pragmas {
+		if set, _, _ := strings.Cut(p, "("); strings.EqualFold(strings.TrimSpace(set), name) {
+			return true
+		}
+	}
+	return false
+}
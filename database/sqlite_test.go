@@ -0,0 +1,88 @@
+package database
+
+import (
+	"context"
+	"github.com/AndersKaae/legaldesk_psp_sync/money"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSQLiteDSN(t *testing.T) {
+	for _, tc := range []struct {
+		path     string
+		wantPath string
+		pragmas  []string
+	}{
+		{"data/psp.db", "data/psp.db", []string{"busy_timeout(5000)", "foreign_keys(1)"}},
+		{":memory:", ":memory:", []string{"busy_timeout(5000)", "foreign_keys(1)"}},
+		{"data/psp.db?_pragma=journal_mode(WAL)", "data/psp.db", []string{"journal_mode(WAL)", "busy_timeout(5000)", "foreign_keys(1)"}},
+		{"file:psp.db?mode=rwc&_pragma=busy_timeout(100)&_txlock=deferred", "file:psp.db", []string{"busy_timeout(100)", "foreign_keys(1)"}},
+	} {
+		dsn, err := sqliteDSN(tc.path)
+		if err != nil {
+			t.Fatalf("sqliteDSN(%q): %v", tc.path, err)
+		}
+		path, rawQuery, _ := strings.Cut(dsn, "?")
+		params, err := url.ParseQuery(rawQuery)
+		if err != nil {
+			t.Fatalf("sqliteDSN(%q) = %q, not a valid query: %v", tc.path, dsn, err)
+		}
+		if path != tc.wantPath {
+			t.Errorf("sqliteDSN(%q) path = %q, want %q", tc.path, path, tc.wantPath)
+		}
+		if !slices.Equal(params["_pragma"], tc.pragmas) {
+			t.Errorf("sqliteDSN(%q) pragmas = %q, want %q", tc.path, params["_pragma"], tc.pragmas)
+		}
+		if params.Get("_txlock") != "immediate" || params.Get("_time_format") != "sqlite" {
+			t.Errorf("sqliteDSN(%q) = %q, want _txlock=immediate and _time_format=sqlite", tc.path, dsn)
+		}
+	}
+
+	if _, err := sqliteDSN("psp.db?_pragma=%zz"); err == nil {
+		t.Error("sqliteDSN accepted an invalid query")
+	}
+}
+
+func TestOpenSQLiteWithParameters(t *testing.T) {
+	s, err := OpenSQLite(filepath.Join(t.TempDir(), "psp.db") + "?_pragma=journal_mode(WAL)")
+	if err != nil {
+		t.Fatalf("OpenSQLite: %v", err)
+	}
+	defer s.Close()
+
+	var mode string
+	if err := s.db.QueryRow("PRAGMA journal_mode").Scan(&mode); err != nil {
+		t.Fatalf("read journal_mode: %v", err)
+	}
+	if mode != "wal" {
+		t.Errorf("journal_mode = %q, want wal", mode)
+	}
+}
+
+func TestSQLiteCostsAndCreditsKeyedByAccount(t *testing.T) {
+	s := openMigratedSQLite(t, ":memory:").(*SQLStore)
+	defer s.Close()
+	ctx := context.Background()
+
+	// Handles are only unique within an account, so the same handle in DK and SE are two rows
+	for _, country := range []string{"DK", "SE", "DK"} {
+		if err := s.CreateOrUpdateAdditionalCost(ctx, &AdditionalCost{Handle: "ac-1", Amount: money.New(100, ""), Country: country}); err != nil {
+			t.Fatalf("CreateOrUpdateAdditionalCost(%s): %v", country, err)
+		}
+		if err := s.CreateOrUpdateCredit(ctx, &Credit{Handle: "cr-1", Amount: money.New(100, ""), Country: country}); err != nil {
+			t.Fatalf("CreateOrUpdateCredit(%s): %v", country, err)
+		}
+	}
+	for _, table := range []string{"additional_costs", "credits"} {
+		var countries string
+		if err := s.db.QueryRow("SELECT group_concat(country, ',') FROM (SELECT country FROM " + table + " ORDER BY country)").Scan(&countries); err != nil {
+			t.Fatalf("read %s: %v", table, err)
+		}
+		if countries != "DK,SE" {
+			t.Errorf("%s countries = %q, want DK,SE", table, countries)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/AndersKaae/legaldesk_psp_sync/config"
+)
+
+// Store is every read and write the app makes against its storage
+type Store interface {
+	CreateOrUpdateCustomer(ctx context.Context, customer *Customer) error
+	CreateOrUpdateInvoice(ctx context.Context, invoice *Invoice, source string) error
+	CreateOrUpdateDiscount(ctx context.Context, discount *Discount) error
+	CreateOrUpdateCoupon(ctx context.Context, coupon *Coupon) error
+	CreateOrUpdateSubscriptionDiscount(ctx context.Context, subscriptionDiscount *SubscriptionDiscount) error
+	CreateOrUpdateAdditionalCost(ctx context.Context, additionalCost *AdditionalCost) error
+	CreateOrUpdateCredit(ctx context.Context, credit *Credit) error
+
+	// Batch upserts save what they can and return the rows that failed
+	CreateOrUpdateCustomers(ctx context.Context, customers []Customer, batchSize int) ([]RowError, error)
+	CreateOrUpdateInvoices(ctx context.Context, invoices []Invoice, source string, batchSize int) ([]RowError, error)
+
+	GetInvoicesPage(ctx context.Context, q InvoiceQuery) ([]Invoice, error)
+	GetAccountInvoices(ctx context.Context, country string, from, to time.Time) ([]Invoice, error)
+	GetInvoicesByID(ctx context.Context, ids []string) ([]Invoice, error)
+	GetCustomersByHandle(ctx context.Context, handles []string) ([]Customer, error)
+	InvoiceExists(ctx context.Context, invoiceID string) (bool, error)
+	GetInvoiceStateTransitions(ctx context.Context, invoiceID string) ([]InvoiceStateTransition, error)
+
+	GetCustomerAsOf(ctx context.Context, handle string, t time.Time) (*CustomerVersion, error)
+	GetCustomerVersions(ctx context.Context, handle string) ([]CustomerVersion, error)
+	GetCustomerDiffs(ctx context.Context, handle string) ([]CustomerVersionDiff, error)
+
+	GetCouponRedemptions(ctx context.Context, from, to time.Time) ([]CouponRedemptions, error)
+	GetCouponDiscountedAmounts(ctx context.Context, from, to time.Time) ([]CouponDiscountedAmount, error)
+	GetCouponTrialConversions(ctx context.Context, from, to time.Time) ([]CouponTrialConversion, error)
+
+	GetSyncCheckpoint(ctx context.Context, account, entity string) (*SyncCheckpoint, error)
+	SaveSyncCheckpoint(ctx context.Context, cp *SyncCheckpoint) error
+	ResetSyncCheckpoint(ctx context.Context, account, entity string) error
+	GetSyncCheckpoints(ctx context.Context) ([]SyncCheckpoint, error)
+	GetSyncWindows(ctx context.Context, account, entity string) ([]SyncWindow, error)
+	SaveSyncWindow(ctx context.Context, w *SyncWindow) error
+
+	GetHighWaterMark(ctx context.Context, account, entity string) (*HighWaterMark, error)
+	SaveHighWaterMark(ctx context.Context, hwm *HighWaterMark) error
+	GetHighWaterMarks(ctx context.Context) ([]HighWaterMark, error)
+
+	CreateReconcileRun(ctx context.Context, run *ReconcileRun) error
+	UpdateReconcileRun(ctx context.Context, run *ReconcileRun) error
+	GetReconcileRun(ctx context.Context, id int64) (*ReconcileRun, error)
+	GetReconcileRuns(ctx context.Context, limit int) ([]ReconcileRun, error)
+
+	CreateJob(ctx context.Context, job *Job) error
+	UpdateJob(ctx context.Context, job *Job) error
+	GetJob(ctx context.Context, id int64) (*Job, error)
+	GetJobs(ctx context.Context, limit int) ([]Job, error)
+	HeartbeatJob(ctx context.Context, id int64) (bool, error)
+	RequestJobCancel(ctx context.Context, id int64) error
+	InterruptStaleJobs(ctx context.Context, before time.Time) (int64, error)
+
+	AcquireLease(ctx context.Context, name, holder string, ttl time.Duration) (bool, string, error)
+	ReleaseLease(ctx context.Context, name, holder string) error
+	GetLeases(ctx context.Context) ([]Lease, error)
+
+	Close() error
+}
+
+// Migrator is implemented by stores with a versioned schema
+type Migrator interface {
+	CheckSchema() error
+	GetMigrationStatus() ([]MigrationStatus, error)
+	MigrateUp() ([]Migration, error)
+	MigrateDown(steps int) ([]Migration, error)
+}
+
+var (
+	_ Store    = (*SQLStore)(nil)
+	_ Migrator = (*SQLStore)(nil)
+	_ Store    = (*MemoryStore)(nil)
+)
+
+// Connect opens the configured SQL database without migrating it
+func Connect(cfg config.Config) (*SQLStore, error) {
+	switch cfg.DatabaseBackend {
+	case config.BackendMySQL, "":
+		return OpenMySQL(cfg.DatabaseDSN)
+	case config.BackendSQLite:
+		return OpenSQLite(cfg.DatabaseDSN)
+	case config.BackendPostgres:
+		return OpenPostgres(cfg.DatabaseDSN)
+	default:
+		return nil, fmt.Errorf("database backend %q is not an SQL database", cfg.DatabaseBackend)
+	}
+}
+
+// Open connects to the configured store and applies any pending migrations. It refuses to
+// continue if the schema is newer than this binary knows about.
+func Open(cfg config.Config) (Store, error) {
+	if cfg.DatabaseBackend == config.BackendMemory {
+		return NewMemoryStore(), nil
+	}
+	store, err := Connect(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := store.MigrateUp(); err != nil {
+		store.Close()
+		return nil, fmt.Errorf("failed to migrate database: %w", err)
+	}
+	return store, nil
+}
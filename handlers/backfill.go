@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/AndersKaae/legaldesk_psp_sync/database"
+	"github.com/AndersKaae/legaldesk_psp_sync/syncer"
+)
+
+// Backfill status of an account and entity
+const (
+	BackfillNotStarted = "not_started"
+	BackfillInProgress = "in_progress"
+	BackfillCompleted  = "completed"
+)
+
+// BackfillEntityProgress is the checkpoint of one account and entity with its status
+type BackfillEntityProgress struct {
+	database.SyncCheckpoint
+	Status string `json:"status"`
+}
+
+// BackfillProgressResponse lists every account and entity, and how many are completed
+type BackfillProgressResponse struct {
+	Completed int                      `json:"completed"`
+	Total     int                      `json:"total"`
+	Entities  []BackfillEntityProgress `json:"entities"`
+}
+
+// BackfillProgress reports how far the backfill of every account and entity has come
+func BackfillProgress(store database.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		checkpoints, err := store.GetSyncCheckpoints(r.Context())
+		if err != nil {
+			log.Printf("Failed to fetch sync checkpoints: %v", err)
+			http.Error(w, "Failed to fetch backfill progress", http.StatusInternalServerError)
+			return
+		}
+		byKey := make(map[[2]string]database.SyncCheckpoint)
+		for _, cp := range checkpoints {
+			byKey[[2]string{cp.Account, cp.Entity}] = cp
+		}
+
+		var response BackfillProgressResponse
+		for _, country := range syncer.Countries {
+			for _, entity := range syncer.Entities {
+				progress := BackfillEntityProgress{Status: BackfillNotStarted}
+				progress.Account, progress.Entity = country, entity
+				if cp, ok := byKey[[2]string{country, entity}]; ok {
+					progress.SyncCheckpoint = cp
+					progress.Status = BackfillInProgress
+					if cp.Completed {
+						progress.Status = BackfillCompleted
+						response.Completed++
+					}
+				}
+				response.Entities = append(response.Entities, progress)
+			}
+		}
+		response.Total = len(response.Entities)
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			log.Printf("Failed to encode backfill progress to JSON: %v", err)
+			http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/AndersKaae/legaldesk_psp_sync/database"
+	"log"
+	"net/http"
+	"time"
+)
+
+// CustomerAsOf returns the tracked fields of the customer in the {handle} path value as they
+// were at the 'as_of' query parameter (RFC 3339 or YYYY-MM-DD), defaulting to now
+func CustomerAsOf(store database.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		asOf := time.Now().UTC()
+		if v := r.URL.Query().Get("as_of"); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				t, err = time.Parse("2006-01-02", v)
+			}
+			if err != nil {
+				http.Error(w, "'as_of' is invalid, must be RFC 3339 or YYYY-MM-DD", http.StatusBadRequest)
+				return
+			}
+			asOf = t
+		}
+
+		handle := r.PathValue("handle")
+		version, err := store.GetCustomerAsOf(r.Context(), handle, asOf)
+		if err != nil {
+			log.Printf("Failed to fetch customer %s as of %s: %v", handle, asOf, err)
+			http.Error(w, "Failed to fetch customer", http.StatusInternalServerError)
+			return
+		}
+		if version == nil {
+			versions, err := store.GetCustomerVersions(r.Context(), handle)
+			if err != nil {
+				log.Printf("Failed to fetch customer versions of %s: %v", handle, err)
+				http.Error(w, "Failed to fetch customer", http.StatusInternalServerError)
+				return
+			}
+			if len(versions) == 0 {
+				http.Error(w, "Customer not found", http.StatusNotFound)
+				return
+			}
+			// Only the fields seen since the first sync are known
+			http.Error(w, fmt.Sprintf("Customer fields as of %s are unknown; it was first synced %s",
+				asOf.Format(time.RFC3339), versions[0].ValidFrom.UTC().Format(time.RFC3339)), http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(version); err != nil {
+			log.Printf("Failed to encode customer to JSON: %v", err)
+			http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		}
+	}
+}
+
+// CustomerDiff returns the field changes between consecutive versions of the customer in
+// the {handle} path value
+func CustomerDiff(store database.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		handle := r.PathValue("handle")
+		diffs, err := store.GetCustomerDiffs(r.Context(), handle)
+		if err != nil {
+			log.Printf("Failed to fetch customer diff for %s: %v", handle, err)
+			http.Error(w, "Failed to fetch customer diff", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(diffs); err != nil {
+			log.Printf("Failed to encode customer diff to JSON: %v", err)
+			http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/AndersKaae/legaldesk_psp_sync/database"
+	"github.com/AndersKaae/legaldesk_psp_sync/syncer"
+)
+
+// invoiceStates are the states a Frisbii invoice can be in
+var invoiceStates = []string{"created", "pending", "dunning", "settled", "authorized", "cancelled", "failed"}
+
+// invoiceParams are the query parameters the invoice endpoints accept, with what they do
+var invoiceParams = map[string]string{
+	"from":              "first day, YYYY-MM-DD",
+	"to":                "last day, YYYY-MM-DD",
+	"amounts":           "minor or decimal",
+	"limit":             "page size",
+	"cursor":            "next_cursor of the previous page",
+	"account":           "accounts, e.g. DK,SE",
+	"country":           "same as account",
+	"plan":              "plan handles",
+	"state":             "current states",
+	"currency":          "currency codes",
+	"customer":          "customer handle",
+	"customer_email":    "customer email",
+	"handle_prefix":     "start of the invoice handle",
+	"min_amount":        "smallest original amount in minor units",
+	"max_amount":        "largest original amount in minor units",
+	"test":              "true for test customers, false for live",
+	"format":            "json or csv",
+	"delimiter":         "CSV delimiter: comma, semicolon, pipe or tab",
+	"decimal_separator": "CSV decimal separator: . or ,",
+}
+
+// listParam returns the values of a repeatable parameter, each of which may also be a
+// comma separated list
+func listParam(params url.Values, name string) []string {
+	var values []string
+	for _, v := range params[name] {
+		for _, value := range strings.Split(v, ",") {
+			if value = strings.TrimSpace(value); value != "" {
+				values = append(values, value)
+			}
+		}
+	}
+	return values
+}
+
+// parseInvoiceFilters sets the filters of q from the query parameters; the error says what
+// is wrong with them, for a 400 response
+func parseInvoiceFilters(params url.Values, q *database.InvoiceQuery) error {
+	for name := range params {
+		if _, ok := invoiceParams[name]; !ok {
+			names := make([]string, 0, len(invoiceParams))
+			for known := range invoiceParams {
+				names = append(names, known)
+			}
+			sort.Strings(names)
+			return fmt.Errorf("unknown query parameter '%s', expected one of %s", name, strings.Join(names, ", "))
+		}
+	}
+
+	for _, name := range []string{"account", "country"} {
+		for _, account := range listParam(params, name) {
+			account = strings.ToUpper(account)
+			if !slices.Contains(syncer.Countries, account) {
+				return fmt.Errorf("'%s' must be one of %s, got %s", name, strings.Join(syncer.Countries, ", "), account)
+			}
+			q.Accounts = append(q.Accounts, account)
+		}
+	}
+	for _, state := range listParam(params, "state") {
+		state = strings.ToLower(state)
+		if !slices.Contains(invoiceStates, state) {
+			return fmt.Errorf("'state' must be one of %s, got %s", strings.Join(invoiceStates, ", "), state)
+		}
+		q.States = append(q.States, state)
+	}
+	for _, currency := range listParam(params, "currency") {
+		q.Currencies = append(q.Currencies, strings.ToUpper(currency))
+	}
+	q.Plans = listParam(params, "plan")
+	q.Customer = params.Get("customer")
+	q.CustomerEmail = params.Get("customer_email")
+	q.HandlePrefix = params.Get("handle_prefix")
+
+	for name, amount := range map[string]**int64{"min_amount": &q.MinAmount, "max_amount": &q.MaxAmount} {
+		if v := params.Get(name); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return fmt.Errorf("'%s' must be an integer amount in minor units, got %s", name, v)
+			}
+			*amount = &n
+		}
+	}
+	if q.MinAmount != nil && q.MaxAmount != nil && *q.MinAmount > *q.MaxAmount {
+		return fmt.Errorf("'min_amount' cannot be more than 'max_amount'")
+	}
+
+	if v := params.Get("test"); v != "" {
+		test, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("'test' must be true or false, got %s", v)
+		}
+		q.Test = &test
+	}
+	return nil
+}
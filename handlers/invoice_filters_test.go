@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/AndersKaae/legaldesk_psp_sync/database"
+)
+
+func TestParseInvoiceFilters(t *testing.T) {
+	amount := func(n int64) *int64 { return &n }
+	yes := true
+	for _, tc := range []struct {
+		query string
+		want  database.InvoiceQuery
+	}{
+		{"", database.InvoiceQuery{}},
+		{"account=dk,SE&country=NO", database.InvoiceQuery{Accounts: []string{"DK", "SE", "NO"}}},
+		{"plan=plan-basic&plan=plan-pro,+plan-gold", database.InvoiceQuery{Plans: []string{"plan-basic", "plan-pro", "plan-gold"}}},
+		{"state=Settled,dunning", database.InvoiceQuery{States: []string{"settled", "dunning"}}},
+		{"currency=dkk", database.InvoiceQuery{Currencies: []string{"DKK"}}},
+		{"customer=cust-1&customer_email=a%40example.com", database.InvoiceQuery{Customer: "cust-1", CustomerEmail: "a@example.com"}},
+		{"handle_prefix=inv-50%25_", database.InvoiceQuery{HandlePrefix: "inv-50%_"}},
+		{"min_amount=-100&max_amount=100", database.InvoiceQuery{MinAmount: amount(-100), MaxAmount: amount(100)}},
+		{"min_amount=100&max_amount=100", database.InvoiceQuery{MinAmount: amount(100), MaxAmount: amount(100)}},
+		{"test=true", database.InvoiceQuery{Test: &yes}},
+	} {
+		params, err := url.ParseQuery(tc.query)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.query, err)
+		}
+		var got database.InvoiceQuery
+		if err := parseInvoiceFilters(params, &got); err != nil {
+			t.Errorf("%s: parseInvoiceFilters: %v", tc.query, err)
+		} else if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: query = %+v, want %+v", tc.query, got, tc.want)
+		}
+	}
+}
+
+func TestParseInvoiceFiltersInvalid(t *testing.T) {
+	for query, want := range map[string]string{
+		"acount=DK":                       "unknown query parameter 'acount'",
+		"account=US":                      "'account' must be one of",
+		"country=DK,XX":                   "'country' must be one of",
+		"state=open":                      "'state' must be one of",
+		"min_amount=12.50":                "'min_amount' must be an integer",
+		"max_amount=many":                 "'max_amount' must be an integer",
+		"min_amount=200&max_amount=100":   "'min_amount' cannot be more than 'max_amount'",
+		"test=maybe":                      "'test' must be true or false",
+		"from=2025-03-01&handle-prefix=x": "unknown query parameter 'handle-prefix'",
+	} {
+		params, err := url.ParseQuery(query)
+		if err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		err = parseInvoiceFilters(params, &database.InvoiceQuery{})
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: error = %v, want %q", query, err, want)
+		}
+	}
+}
+
+func TestInvoicesRejectsInvalidFilters(t *testing.T) {
+	store := database.NewMemoryStore()
+	for _, query := range []string{"acount=DK", "state=open", "min_amount=x", "handle_prefix=a;b"} {
+		rec := httptest.NewRecorder()
+		Invoices(store, "")(rec, httptest.NewRequest(http.MethodGet, "/invoices?from=2025-03-01&to=2025-03-01&"+query, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", query, rec.Code)
+		}
+	}
+}
+
+func TestInvoicesFiltersHandlePrefix(t *testing.T) {
+	sqlite, err := database.OpenSQLite(":memory:")
+	if err != nil {
+		t.Fatalf("OpenSQLite: %v", err)
+	}
+	defer sqlite.Close()
+	if _, err := sqlite.MigrateUp(); err != nil {
+		t.Fatalf("MigrateUp: %v", err)
+	}
+
+	for name, store := range map[string]database.Store{"memory": database.NewMemoryStore(), "sqlite": sqlite} {
+		percent := testInvoice("percent", 1)
+		percent.Handle = "inv-50%-off"
+		other := testInvoice("other", 2)
+		other.Handle = "inv-500"
+		storeInvoices(t, store, percent, other)
+
+		var invoices []listedInvoice
+		getInvoices(t, store, "/invoices?from=2025-03-01&to=2025-03-01&handle_prefix=inv-50%25", &invoices)
+		if len(invoices) != 1 || invoices[0].ID != "percent" {
+			t.Errorf("%s: invoices = %+v, want only percent", name, invoices)
+		}
+	}
+}
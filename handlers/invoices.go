@@ -1,115 +1,318 @@
 package handlers
 
 import (
+	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/AndersKaae/legaldesk_psp_sync/database"
+	"github.com/AndersKaae/legaldesk_psp_sync/money"
 	"log"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 )
 
+// JSONAmount renders an amount as an integer in minor units, or as a decimal string in
+// major units when Decimal is set
+type JSONAmount struct {
+	Money   money.Money
+	Decimal bool
+}
+
+func (a JSONAmount) MarshalJSON() ([]byte, error) {
+	if a.Decimal {
+		return json.Marshal(a.Money.Decimal())
+	}
+	return json.Marshal(a.Money.Amount)
+}
+
+// parseAmounts reads the 'amounts' query parameter: amounts are minor units by default, and
+// amounts=decimal renders them as major-unit strings. It writes a 400 response if invalid.
+func parseAmounts(w http.ResponseWriter, r *http.Request) (bool, bool) {
+	switch r.URL.Query().Get("amounts") {
+	case "", "minor":
+		return false, true
+	case "decimal":
+		return true, true
+	default:
+		http.Error(w, "'amounts' must be 'minor' or 'decimal'", http.StatusBadRequest)
+		return false, false
+	}
+}
+
 // JSONInvoice is the representation of an invoice for JSON responses.
 type JSONInvoice struct {
-	ID               string               `json:"id"`
-	Handle           string               `json:"handle"`
-	Customer         string               `json:"customer"`
-	CustomerEmail    *string              `json:"customer_email"` // Use a pointer to handle nulls
-	Currency         string               `json:"currency"`
-	Created          time.Time            `json:"created"`
-	DiscountAmount   int64                `json:"discount_amount"`
-	OrgAmount        int64                `json:"org_amount"`
-	AmountVAT        int64                `json:"amount_vat"`
-	AmountExVAT      int64                `json:"amount_ex_vat"`
-	RefundedAmount   int64                `json:"refunded_amount"`
-	AuthorizedAmount int64                `json:"authorized_amount"`
-	Country          string               `json:"country"`
-	Plan             string               `json:"plan"`
+	ID               string                 `json:"id"`
+	Handle           string                 `json:"handle"`
+	Customer         string                 `json:"customer"`
+	CustomerEmail    *string                `json:"customer_email"` // Use a pointer to handle nulls
+	Currency         string                 `json:"currency"`
+	Created          time.Time              `json:"created"`
+	DiscountAmount   JSONAmount             `json:"discount_amount"`
+	OrgAmount        JSONAmount             `json:"org_amount"`
+	AmountVAT        JSONAmount             `json:"amount_vat"`
+	AmountExVAT      JSONAmount             `json:"amount_ex_vat"`
+	RefundedAmount   JSONAmount             `json:"refunded_amount"`
+	AuthorizedAmount JSONAmount             `json:"authorized_amount"`
+	Country          string                 `json:"country"`
+	Plan             string                 `json:"plan"`
 	States           database.InvoiceStates `json:"states"`
 }
 
-func Invoices(filter string) http.HandlerFunc {
+// parseDateRange reads the 'from' and 'to' query parameters as YYYY-MM-DD dates and
+// writes a 400 response if they are missing or invalid
+func parseDateRange(w http.ResponseWriter, r *http.Request) (time.Time, time.Time, bool) {
+	fromStr := r.URL.Query().Get("from")
+	toStr := r.URL.Query().Get("to")
+
+	if fromStr == "" || toStr == "" {
+		http.Error(w, "Missing 'from' or 'to' query parameter", http.StatusBadRequest)
+		return time.Time{}, time.Time{}, false
+	}
+
+	// Parse and validate dates
+	const layout = "2006-01-02" // Go's reference date for YYYY-MM-DD
+	from, err := time.Parse(layout, fromStr)
+	if err != nil {
+		http.Error(w, "'from' date is invalid, must be YYYY-MM-DD", http.StatusBadRequest)
+		return time.Time{}, time.Time{}, false
+	}
+
+	to, err := time.Parse(layout, toStr)
+	if err != nil {
+		http.Error(w, "'to' date is invalid, must be YYYY-MM-DD", http.StatusBadRequest)
+		return time.Time{}, time.Time{}, false
+	}
+
+	// Optional: check if from is before to
+	if from.After(to) {
+		http.Error(w, "'from' date cannot be after 'to' date", http.StatusBadRequest)
+		return time.Time{}, time.Time{}, false
+	}
+	return from, to, true
+}
+
+// Page sizes of the invoice endpoints when they are paginated
+const (
+	defaultInvoiceLimit = 100
+	maxInvoiceLimit     = 1000
+)
+
+// InvoicePage is a page of invoices; NextCursor and Next, the URL of the next page, are
+// empty on the last page
+type InvoicePage struct {
+	Invoices   []JSONInvoice `json:"invoices"`
+	NextCursor string        `json:"next_cursor,omitempty"`
+	Next       string        `json:"next,omitempty"`
+}
+
+// encodeInvoiceCursor returns the opaque cursor of the page after invoice c
+func encodeInvoiceCursor(c database.InvoiceCursor) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(c.Created.UTC().Format(time.RFC3339Nano) + " " + c.ID))
+}
+
+func decodeInvoiceCursor(cursor string) (database.InvoiceCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return database.InvoiceCursor{}, err
+	}
+	created, id, ok := strings.Cut(string(raw), " ")
+	if !ok || id == "" {
+		return database.InvoiceCursor{}, errors.New("missing invoice id")
+	}
+	t, err := time.Parse(time.RFC3339Nano, created)
+	if err != nil {
+		return database.InvoiceCursor{}, err
+	}
+	return database.InvoiceCursor{Created: t, ID: id}, nil
+}
+
+// toJSONInvoice maps a database invoice to its JSON representation
+func toJSONInvoice(dbInvoice database.Invoice, decimal bool) JSONInvoice {
+	var customerEmail *string
+	if dbInvoice.CustomerEmail.Valid {
+		customerEmail = &dbInvoice.CustomerEmail.String
+	}
+
+	return JSONInvoice{
+		ID:               dbInvoice.ID,
+		Handle:           dbInvoice.Handle,
+		Customer:         dbInvoice.Customer,
+		CustomerEmail:    customerEmail,
+		Currency:         dbInvoice.Currency,
+		Created:          dbInvoice.Created,
+		DiscountAmount:   JSONAmount{dbInvoice.DiscountAmount, decimal},
+		OrgAmount:        JSONAmount{dbInvoice.OrgAmount, decimal},
+		AmountVAT:        JSONAmount{dbInvoice.AmountVAT, decimal},
+		AmountExVAT:      JSONAmount{dbInvoice.AmountExVAT, decimal},
+		RefundedAmount:   JSONAmount{dbInvoice.RefundedAmount, decimal},
+		AuthorizedAmount: JSONAmount{dbInvoice.AuthorizedAmount, decimal},
+		Country:          dbInvoice.Country,
+		Plan:             dbInvoice.Plan,
+		States:           dbInvoice.States,
+	}
+}
+
+// Invoices lists the invoices created in the 'from' to 'to' date range, newest first. Without
+// 'limit' and 'cursor' every invoice is returned as one array; with either, an InvoicePage
+// of up to 'limit' invoices (default 100, at most 1000) is returned, continuing after 'cursor'.
+// The other parameters filter the invoices, see invoiceParams. With 'format=csv' or an Accept
+// header naming text/csv the invoices are streamed as CSV instead, see writeInvoicesCSV.
+func Invoices(store database.Store, filter string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
 
-		fromStr := r.URL.Query().Get("from")
-		toStr := r.URL.Query().Get("to")
+		// Query parameters that do not parse, such as an unescaped ';', would otherwise be dropped silently
+		if _, err := url.ParseQuery(r.URL.RawQuery); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid query: %v; escape ';' as %%3B", err), http.StatusBadRequest)
+			return
+		}
 
-		if fromStr == "" || toStr == "" {
-			http.Error(w, "Missing 'from' or 'to' query parameter", http.StatusBadRequest)
+		from, to, ok := parseDateRange(w, r)
+		if !ok {
 			return
 		}
 
-		// Parse and validate dates
-		const layout = "2006-01-02" // Go's reference date for YYYY-MM-DD
-		from, err := time.Parse(layout, fromStr)
-		if err != nil {
-			http.Error(w, "'from' date is invalid, must be YYYY-MM-DD", http.StatusBadRequest)
+		decimal, ok := parseAmounts(w, r)
+		if !ok {
 			return
 		}
 
-		to, err := time.Parse(layout, toStr)
+		query := database.InvoiceQuery{From: from, To: to.Add(24 * time.Hour), VirtualOffice: filter == "virtualOffice"}
+		params := r.URL.Query()
+		if err := parseInvoiceFilters(params, &query); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		asCSV, err := wantsCSV(r)
 		if err != nil {
-			http.Error(w, "'to' date is invalid, must be YYYY-MM-DD", http.StatusBadRequest)
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
+		format, err := parseCSVFormat(params)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		paginate := params.Has("limit") || params.Has("cursor")
+		limit := defaultInvoiceLimit
+		if paginate {
+			if v := params.Get("limit"); v != "" {
+				n, err := strconv.Atoi(v)
+				if err != nil || n < 1 || n > maxInvoiceLimit {
+					http.Error(w, fmt.Sprintf("'limit' must be an integer from 1 to %d", maxInvoiceLimit), http.StatusBadRequest)
+					return
+				}
+				limit = n
+			}
+			if v := params.Get("cursor"); v != "" {
+				cursor, err := decodeInvoiceCursor(v)
+				if err != nil {
+					http.Error(w, "'cursor' is invalid, pass the next_cursor of the previous page", http.StatusBadRequest)
+					return
+				}
+				query.After = &cursor
+			}
+			// One more than the page to tell whether there is a next page
+			query.Limit = limit + 1
+		} else if asCSV {
+			// Stream every invoice a batch at a time instead of loading them all
+			query.Limit = csvBatchSize
+		}
 
-		// Optional: check if from is before to
-		if from.After(to) {
-			http.Error(w, "'from' date cannot be after 'to' date", http.StatusBadRequest)
+		dbInvoices, err := store.GetInvoicesPage(r.Context(), query)
+		if err != nil {
+			log.Printf("Failed to fetch invoices: %v", err)
+			http.Error(w, "Failed to fetch invoices", http.StatusInternalServerError)
 			return
 		}
 
-		var dbInvoices []database.Invoice
+		var page InvoicePage
+		if paginate && len(dbInvoices) > limit {
+			dbInvoices = dbInvoices[:limit]
+			last := dbInvoices[limit-1]
+			page.NextCursor = encodeInvoiceCursor(database.InvoiceCursor{Created: last.Created, ID: last.ID})
+			next := r.URL.Query()
+			next.Set("cursor", page.NextCursor)
+			next.Set("limit", strconv.Itoa(limit))
+			page.Next = r.URL.Path + "?" + next.Encode()
+		}
 
-		if filter == "all" {
-			dbInvoices, err = database.GetInvoicesByDateRange(from, to.Add(24*time.Hour))
-			if err != nil {
-				log.Printf("Failed to fetch invoices: %v", err)
-				http.Error(w, "Failed to fetch invoices", http.StatusInternalServerError)
-				return
-			}
-		} else if filter == "virtualOffice" {
-			dbInvoices, err = database.GetVirtualOfficeInvoicesByDateRange(from, to.Add(24*time.Hour))
-			if err != nil {
-				log.Printf("Failed to fetch invoices: %v", err)
-				http.Error(w, "Failed to fetch invoices", http.StatusInternalServerError)
-				return
+		if asCSV {
+			var more func(ctx context.Context, after database.Invoice) ([]database.Invoice, error)
+			if paginate {
+				if page.Next != "" {
+					w.Header().Set("Link", "<"+page.Next+`>; rel="next"`)
+				}
+			} else if len(dbInvoices) == csvBatchSize {
+				more = func(ctx context.Context, after database.Invoice) ([]database.Invoice, error) {
+					query.After = &database.InvoiceCursor{Created: after.Created, ID: after.ID}
+					return store.GetInvoicesPage(ctx, query)
+				}
 			}
+			writeInvoicesCSV(r.Context(), w, format, dbInvoices, more)
+			return
 		}
 
 		// Map database invoices to JSON invoices
 		jsonInvoices := make([]JSONInvoice, len(dbInvoices))
 		for i, dbInvoice := range dbInvoices {
-			var customerEmail *string
-			if dbInvoice.CustomerEmail.Valid {
-				customerEmail = &dbInvoice.CustomerEmail.String
-			}
+			jsonInvoices[i] = toJSONInvoice(dbInvoice, decimal)
+		}
 
-			jsonInvoices[i] = JSONInvoice{
-				ID:               dbInvoice.ID,
-				Handle:           dbInvoice.Handle,
-				Customer:         dbInvoice.Customer,
-				CustomerEmail:    customerEmail,
-				Currency:         dbInvoice.Currency,
-				Created:          dbInvoice.Created,
-				DiscountAmount:   dbInvoice.DiscountAmount,
-				OrgAmount:        dbInvoice.OrgAmount,
-				AmountVAT:        dbInvoice.AmountVAT,
-				AmountExVAT:      dbInvoice.AmountExVAT,
-				RefundedAmount:   dbInvoice.RefundedAmount,
-				AuthorizedAmount: dbInvoice.AuthorizedAmount,
-				Country:          dbInvoice.Country,
-				Plan:             dbInvoice.Plan,
-				States:           dbInvoice.States,
+		var resp interface{} = jsonInvoices
+		if paginate {
+			page.Invoices = jsonInvoices
+			resp = page
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			log.Printf("Failed to encode invoices to JSON: %v", err)
+			http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		}
+	}
+}
+
+// InvoiceHistory returns the observed state transitions of the invoice in the {id} path value
+func InvoiceHistory(store database.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		invoiceID := r.PathValue("id")
+		transitions, err := store.GetInvoiceStateTransitions(r.Context(), invoiceID)
+		if err != nil {
+			log.Printf("Failed to fetch invoice history for %s: %v", invoiceID, err)
+			http.Error(w, "Failed to fetch invoice history", http.StatusInternalServerError)
+			return
+		}
+
+		if len(transitions) == 0 {
+			exists, err := store.InvoiceExists(r.Context(), invoiceID)
+			if err != nil {
+				log.Printf("Failed to check invoice %s: %v", invoiceID, err)
+				http.Error(w, "Failed to fetch invoice history", http.StatusInternalServerError)
+				return
+			}
+			if !exists {
+				http.Error(w, "Invoice not found", http.StatusNotFound)
+				return
 			}
+			transitions = []database.InvoiceStateTransition{}
 		}
 
 		w.Header().Set("Content-Type", "application/json")
-		if err := json.NewEncoder(w).Encode(jsonInvoices); err != nil {
-			log.Printf("Failed to encode invoices to JSON: %v", err)
+		if err := json.NewEncoder(w).Encode(transitions); err != nil {
+			log.Printf("Failed to encode invoice history to JSON: %v", err)
 			http.Error(w, "Failed to encode response", http.StatusInternalServerError)
 		}
 	}
@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/AndersKaae/legaldesk_psp_sync/database"
+	"github.com/AndersKaae/legaldesk_psp_sync/money"
+)
+
+// csvBatchSize is how many invoices an unpaginated CSV export fetches at a time
+var csvBatchSize = 1000
+
+// csvStates are the invoice states flattened into a column each, holding when the invoice
+// entered the state
+var csvStates = []string{"created", "authorized", "settled", "failed", "cancelled"}
+
+// invoiceCSVHeader is the stable column order of invoice CSV exports
+var invoiceCSVHeader = append([]string{
+	"id", "handle", "customer", "customer_email", "country", "plan", "currency",
+	"discount_amount", "org_amount", "amount_vat", "amount_ex_vat", "refunded_amount", "authorized_amount",
+}, csvStates...)
+
+// csvFormat is how an invoice CSV export is written
+type csvFormat struct {
+	delimiter        rune
+	decimalSeparator string
+}
+
+// wantsCSV reports whether the invoices are to be returned as CSV: 'format' if set,
+// otherwise an Accept header naming text/csv
+func wantsCSV(r *http.Request) (bool, error) {
+	switch r.URL.Query().Get("format") {
+	case "csv":
+		return true, nil
+	case "json":
+		return false, nil
+	case "":
+	default:
+		return false, fmt.Errorf("'format' must be 'json' or 'csv'")
+	}
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		if mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accept)); err == nil && mediaType == "text/csv" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// csvDelimiters are the accepted 'delimiter' values. A literal ';' must be sent as %3B, as
+// net/url does not accept it unescaped in a query.
+var csvDelimiters = map[string]rune{
+	",": ',', "comma": ',',
+	";": ';', "semicolon": ';',
+	"|": '|', "pipe": '|',
+	"\t": '\t', "tab": '\t',
+}
+
+// parseCSVFormat reads the 'delimiter' (default ",") and 'decimal_separator' (default ".")
+// query parameters; Danish Excel expects delimiter=semicolon and decimal_separator=,
+func parseCSVFormat(params url.Values) (csvFormat, error) {
+	format := csvFormat{delimiter: ',', decimalSeparator: "."}
+	if v := params.Get("delimiter"); v != "" {
+		delimiter, ok := csvDelimiters[v]
+		if !ok {
+			return format, fmt.Errorf("'delimiter' must be one of comma, semicolon, pipe or tab")
+		}
+		format.delimiter = delimiter
+	}
+	switch v := params.Get("decimal_separator"); v {
+	case "":
+	case ".", ",":
+		format.decimalSeparator = v
+	default:
+		return format, fmt.Errorf("'decimal_separator' must be . or ,")
+	}
+	return format, nil
+}
+
+// csvText returns a text cell that spreadsheets show as text: a cell starting with =, +, -,
+// @, tab or carriage return would otherwise be evaluated as a formula
+func csvText(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// invoiceCSVRecord returns the columns of an invoice in invoiceCSVHeader order; amounts are
+// in major units and times in UTC
+func invoiceCSVRecord(invoice database.Invoice, decimalSeparator string) []string {
+	record := []string{
+		csvText(invoice.ID), csvText(invoice.Handle), csvText(invoice.Customer), csvText(invoice.CustomerEmail.String),
+		csvText(invoice.Country), csvText(invoice.Plan), csvText(invoice.Currency),
+	}
+	for _, m := range []money.Money{
+		invoice.DiscountAmount, invoice.OrgAmount, invoice.AmountVAT, invoice.AmountExVAT, invoice.RefundedAmount, invoice.AuthorizedAmount,
+	} {
+		record = append(record, m.Format(decimalSeparator))
+	}
+	for _, state := range csvStates {
+		at := ""
+		if t := invoice.States[state]; t != nil {
+			at = t.UTC().Format(time.DateTime)
+		}
+		record = append(record, at)
+	}
+	return record
+}
+
+// writeInvoicesCSV streams invoices as CSV, starting with the first fetched batch. If more is
+// not nil, it is called for the batch after the last invoice written until it returns an
+// empty batch; an error then ends the export early, as the response has already started.
+func writeInvoicesCSV(ctx context.Context, w http.ResponseWriter, format csvFormat, batch []database.Invoice,
+	more func(ctx context.Context, after database.Invoice) ([]database.Invoice, error)) {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="invoices.csv"`)
+	writer := csv.NewWriter(w)
+	writer.Comma = format.delimiter
+	flusher, _ := w.(http.Flusher)
+
+	writer.Write(invoiceCSVHeader)
+	for len(batch) > 0 {
+		for _, invoice := range batch {
+			writer.Write(invoiceCSVRecord(invoice, format.decimalSeparator))
+		}
+		writer.Flush()
+		if err := writer.Error(); err != nil {
+			log.Printf("Failed to write invoices CSV: %v", err)
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		if more == nil {
+			return
+		}
+		var err error
+		if batch, err = more(ctx, batch[len(batch)-1]); err != nil {
+			log.Printf("Failed to fetch invoices for CSV, stopping export: %v", err)
+			return
+		}
+	}
+	writer.Flush()
+}
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AndersKaae/legaldesk_psp_sync/database"
+	"github.com/AndersKaae/legaldesk_psp_sync/money"
+)
+
+// getInvoicesCSV serves target with the Invoices handler and parses the CSV response
+func getInvoicesCSV(t *testing.T, store database.Store, target string, delimiter rune) [][]string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	Invoices(store, "")(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET %s: status = %d: %s", target, rec.Code, rec.Body)
+	}
+	if got := rec.Header().Get("Content-Type"); got != "text/csv; charset=utf-8" {
+		t.Errorf("GET %s: Content-Type = %q", target, got)
+	}
+	reader := csv.NewReader(rec.Body)
+	reader.Comma = delimiter
+	records, err := reader.ReadAll()
+	if err != nil {
+		t.Fatalf("GET %s: read CSV: %v", target, err)
+	}
+	return records
+}
+
+func TestInvoicesCSV(t *testing.T) {
+	store := database.NewMemoryStore()
+	invoice := testInvoice("a", 1)
+	invoice.OrgAmount = money.New(123456, "DKK")
+	invoice.AmountVAT = money.New(-5, "DKK")
+	settled := invoice.Created.Add(90 * time.Second)
+	invoice.States["settled"] = &settled
+	storeInvoices(t, store, invoice)
+
+	records := getInvoicesCSV(t, store, "/invoices?from=2025-03-01&to=2025-03-01&format=csv&delimiter=%3B&decimal_separator=,", ';')
+	want := []string{
+		"id", "handle", "customer", "customer_email", "country", "plan", "currency",
+		"discount_amount", "org_amount", "amount_vat", "amount_ex_vat", "refunded_amount", "authorized_amount",
+		"created", "authorized", "settled", "failed", "cancelled",
+	}
+	if len(records) != 2 || !slices.Equal(records[0], want) {
+		t.Fatalf("records = %q, want header %q and one invoice", records, want)
+	}
+	want = []string{
+		"a", "inv-a", "cust-1", "", "DK", "plan-basic", "DKK",
+		"0,00", "1234,56", "-0,05", "0,00", "0,00", "0,00",
+		"2025-03-01 10:01:00", "", "2025-03-01 10:02:30", "", "",
+	}
+	if !slices.Equal(records[1], want) {
+		t.Errorf("invoice = %q, want %q", records[1], want)
+	}
+}
+
+func TestInvoicesCSVAccept(t *testing.T) {
+	store := database.NewMemoryStore()
+	storeInvoices(t, store, testInvoice("a", 1))
+
+	rec := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/invoices?from=2025-03-01&to=2025-03-01", nil)
+	r.Header.Set("Accept", "application/json;q=0.5, text/csv")
+	Invoices(store, "")(rec, r)
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Body.String(), "id,handle,") {
+		t.Errorf("status = %d, body = %q; want comma separated CSV", rec.Code, rec.Body)
+	}
+}
+
+func TestInvoicesCSVStreamsBatches(t *testing.T) {
+	defer func(size int) { csvBatchSize = size }(csvBatchSize)
+	csvBatchSize = 2
+
+	store := database.NewMemoryStore()
+	// Two invoices share a created time across the boundary of the first batch
+	storeInvoices(t, store, testInvoice("a", 1), testInvoice("b", 2), testInvoice("c", 3), testInvoice("d", 3),
+		testInvoice("e", 4))
+
+	records := getInvoicesCSV(t, store, "/invoices?from=2025-03-01&to=2025-03-01&format=csv", ',')
+	var ids []string
+	for _, record := range records[1:] {
+		ids = append(ids, record[0])
+	}
+	if want := []string{"e", "d", "c", "b", "a"}; !slices.Equal(ids, want) {
+		t.Errorf("invoices = %v, want %v", ids, want)
+	}
+}
+
+func TestInvoicesCSVEscapesFormulas(t *testing.T) {
+	store := database.NewMemoryStore()
+	invoice := testInvoice("a", 1)
+	invoice.Handle, invoice.Customer, invoice.Plan = "=HYPERLINK(\"http://example.com\")", "+cust", "-plan"
+	storeInvoices(t, store, invoice)
+
+	records := getInvoicesCSV(t, store, "/invoices?from=2025-03-01&to=2025-03-01&format=csv", ',')
+	if len(records) != 2 {
+		t.Fatalf("records = %q, want one invoice", records)
+	}
+	if got := records[1][1:6]; !slices.Equal(got, []string{`'=HYPERLINK("http://example.com")`, "'+cust", "", "DK", "'-plan"}) {
+		t.Errorf("handle to plan = %q, want formulas prefixed with '", got)
+	}
+}
+
+func TestCSVText(t *testing.T) {
+	for in, want := range map[string]string{
+		"":         "",
+		"inv-1":    "inv-1",
+		"=1+1":     "'=1+1",
+		"+45":      "'+45",
+		"-2":       "'-2",
+		"@SUM(A1)": "'@SUM(A1)",
+		"\t=1":     "'\t=1",
+		"a=b":      "a=b",
+	} {
+		if got := csvText(in); got != want {
+			t.Errorf("csvText(%q) = %q, want %q", in, got, want)
+		}
+	}
+	record := invoiceCSVRecord(database.Invoice{CustomerEmail: sql.NullString{String: "=cmd", Valid: true}}, ".")
+	if record[3] != "'=cmd" {
+		t.Errorf("customer_email = %q, want '=cmd", record[3])
+	}
+}
@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/AndersKaae/legaldesk_psp_sync/database"
+	"github.com/AndersKaae/legaldesk_psp_sync/money"
+)
+
+var invoicesStart = time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC)
+
+// testInvoice returns a settled DK invoice created minutes after invoicesStart
+func testInvoice(id string, minutes int) database.Invoice {
+	created := invoicesStart.Add(time.Duration(minutes) * time.Minute)
+	return database.Invoice{
+		ID: id, Handle: "inv-" + id, Customer: "cust-1", Currency: "DKK", Created: created,
+		OrgAmount: money.New(1000, "DKK"), Country: "DK", Plan: "plan-basic", State: "settled",
+		States: database.InvoiceStates{"created": &created},
+	}
+}
+
+func storeInvoices(t *testing.T, store database.Store, invoices ...database.Invoice) {
+	t.Helper()
+	for i := range invoices {
+		if err := store.CreateOrUpdateInvoice(context.Background(), &invoices[i], "test"); err != nil {
+			t.Fatalf("CreateOrUpdateInvoice(%s): %v", invoices[i].ID, err)
+		}
+	}
+}
+
+// listedInvoice and listedPage decode invoice responses; JSONAmount cannot be unmarshalled
+type listedInvoice struct {
+	ID            string  `json:"id"`
+	Handle        string  `json:"handle"`
+	CustomerEmail *string `json:"customer_email"`
+}
+
+type listedPage struct {
+	Invoices   []listedInvoice `json:"invoices"`
+	NextCursor string          `json:"next_cursor"`
+	Next       string          `json:"next"`
+}
+
+// getInvoices serves target with the Invoices handler and decodes the response into v
+func getInvoices(t *testing.T, store database.Store, target string, v interface{}) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	Invoices(store, "")(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET %s: status = %d: %s", target, rec.Code, rec.Body)
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatalf("GET %s: unmarshal: %v", target, err)
+	}
+}
+
+func TestInvoicesPagination(t *testing.T) {
+	store := database.NewMemoryStore()
+	// Three invoices share a created time, so pages must break ties by id
+	other := testInvoice("f", 3)
+	other.Plan = "plan-pro"
+	storeInvoices(t, store, testInvoice("a", 1), testInvoice("b", 2), testInvoice("c", 2), testInvoice("d", 2),
+		testInvoice("e", 3), other)
+
+	var ids []string
+	var pages int
+	next := "/invoices?from=2025-03-01&to=2025-03-01&plan=plan-basic&limit=2"
+	for next != "" {
+		var page listedPage
+		getInvoices(t, store, next, &page)
+		if pages++; pages > 5 {
+			t.Fatalf("still paging after %d pages: %v", pages, ids)
+		}
+		if len(page.Invoices) > 2 {
+			t.Fatalf("page %d has %d invoices, want at most 2", pages, len(page.Invoices))
+		}
+		for _, invoice := range page.Invoices {
+			ids = append(ids, invoice.ID)
+		}
+		if page.Next != "" && page.NextCursor == "" {
+			t.Errorf("page %d has next %q without next_cursor", pages, page.Next)
+		}
+		next = page.Next
+	}
+	if want := []string{"e", "d", "c", "b", "a"}; !slices.Equal(ids, want) {
+		t.Errorf("invoices over %d pages = %v, want %v", pages, ids, want)
+	}
+	if pages != 3 {
+		t.Errorf("pages = %d, want 3", pages)
+	}
+}
+
+func TestInvoicesUnpaginated(t *testing.T) {
+	store := database.NewMemoryStore()
+	storeInvoices(t, store, testInvoice("a", 1), testInvoice("b", 2))
+
+	var invoices []listedInvoice
+	getInvoices(t, store, "/invoices?from=2025-03-01&to=2025-03-01", &invoices)
+	if len(invoices) != 2 || invoices[0].ID != "b" || invoices[1].ID != "a" {
+		t.Errorf("invoices = %+v, want b and a as one array", invoices)
+	}
+
+	var empty []listedInvoice
+	getInvoices(t, store, "/invoices?from=2025-04-01&to=2025-04-30", &empty)
+	if empty == nil || len(empty) != 0 {
+		t.Errorf("invoices of an empty range = %#v, want an empty array", empty)
+	}
+}
+
+func TestInvoicesInvalidPage(t *testing.T) {
+	store := database.NewMemoryStore()
+	for _, query := range []string{
+		"limit=0",
+		"limit=1001",
+		"limit=ten",
+		"cursor=not-a-cursor",
+		"cursor=" + encodeInvoiceCursor(database.InvoiceCursor{Created: invoicesStart})[:10],
+	} {
+		rec := httptest.NewRecorder()
+		Invoices(store, "")(rec, httptest.NewRequest(http.MethodGet, "/invoices?from=2025-03-01&to=2025-03-01&"+query, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", query, rec.Code)
+		}
+	}
+}
+
+func TestInvoiceCursorRoundTrip(t *testing.T) {
+	want := database.InvoiceCursor{Created: invoicesStart.Add(123456789 * time.Nanosecond), ID: "inv 1"}
+	got, err := decodeInvoiceCursor(encodeInvoiceCursor(want))
+	if err != nil || !got.Created.Equal(want.Created) || got.ID != want.ID {
+		t.Errorf("decodeInvoiceCursor(encodeInvoiceCursor(%+v)) = %+v, %v", want, got, err)
+	}
+}
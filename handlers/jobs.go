@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/AndersKaae/legaldesk_psp_sync/database"
+	"github.com/AndersKaae/legaldesk_psp_sync/syncer"
+)
+
+// JobRequest starts a job: its type, one of backfill, reconcile and catch_up, with its
+// parameters alongside
+type JobRequest struct {
+	Type string `json:"type"`
+	syncer.JobParams
+}
+
+// Jobs starts a job on POST and returns it with 202 Accepted, or lists the latest jobs
+// without their results on GET, up to the 'limit' query parameter (default 50)
+func Jobs(jobs *syncer.Jobs) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			var req JobRequest
+			decoder := json.NewDecoder(r.Body)
+			decoder.DisallowUnknownFields()
+			if err := decoder.Decode(&req); err != nil {
+				http.Error(w, fmt.Sprintf("Invalid JSON: %v", err), http.StatusBadRequest)
+				return
+			}
+			defer r.Body.Close()
+
+			job, err := jobs.Start(r.Context(), req.Type, req.JobParams)
+			if errors.Is(err, syncer.ErrInvalidJob) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err != nil {
+				log.Printf("Failed to start %s job: %v", req.Type, err)
+				http.Error(w, "Failed to start job", http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Location", fmt.Sprintf("/admin/jobs/%d", job.ID))
+			writeJob(w, http.StatusAccepted, job)
+
+		case http.MethodGet:
+			limit := 50
+			if v := r.URL.Query().Get("limit"); v != "" {
+				n, err := strconv.Atoi(v)
+				if err != nil || n < 1 {
+					http.Error(w, "'limit' must be a positive integer", http.StatusBadRequest)
+					return
+				}
+				limit = n
+			}
+			list, err := jobs.List(r.Context(), limit)
+			if err != nil {
+				log.Printf("Failed to fetch jobs: %v", err)
+				http.Error(w, "Failed to fetch jobs", http.StatusInternalServerError)
+				return
+			}
+			writeJob(w, http.StatusOK, list)
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// Job returns the job in the {id} path value on GET, with its progress while it runs and
+// its report once it has finished, or cancels it on DELETE, whichever process runs it
+func Job(jobs *syncer.Jobs) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodDelete {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid job id", http.StatusBadRequest)
+			return
+		}
+		job, err := jobs.Get(r.Context(), id)
+		if err != nil {
+			log.Printf("Failed to fetch job %d: %v", id, err)
+			http.Error(w, "Failed to fetch job", http.StatusInternalServerError)
+			return
+		}
+		if job == nil {
+			http.Error(w, "Job not found", http.StatusNotFound)
+			return
+		}
+
+		if r.Method == http.MethodDelete {
+			if job.Status != database.JobRunning {
+				http.Error(w, fmt.Sprintf("Job is not running: %s", job.Status), http.StatusConflict)
+				return
+			}
+			if err := jobs.Cancel(r.Context(), id); err != nil {
+				log.Printf("Failed to cancel job %d: %v", id, err)
+				http.Error(w, "Failed to cancel job", http.StatusInternalServerError)
+				return
+			}
+			job.CancelRequested = true
+			writeJob(w, http.StatusAccepted, job)
+			return
+		}
+		writeJob(w, http.StatusOK, job)
+	}
+}
+
+// writeJob writes a job or list of jobs as JSON with status
+func writeJob(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Failed to encode job to JSON: %v", err)
+	}
+}
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/AndersKaae/legaldesk_psp_sync/database"
+	"github.com/AndersKaae/legaldesk_psp_sync/syncer"
+)
+
+// Lock is a job lease with the job type and account it locks
+type Lock struct {
+	database.Lease
+	JobType string `json:"job_type"`
+	Account string `json:"account"`
+	Expired bool   `json:"expired"` // Free to be taken, its holder stopped renewing it
+}
+
+// LocksResponse lists the job locks and names this process, to tell its own locks apart
+type LocksResponse struct {
+	Holder string `json:"holder"`
+	Locks  []Lock `json:"locks"`
+}
+
+// Locks returns which process holds the lock of each job type and account on GET
+func Locks(s *syncer.Syncer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		leases, err := s.Store().GetLeases(r.Context())
+		if err != nil {
+			log.Printf("Failed to fetch leases: %v", err)
+			http.Error(w, "Failed to fetch locks", http.StatusInternalServerError)
+			return
+		}
+		resp := LocksResponse{Holder: s.Holder(), Locks: []Lock{}}
+		now := time.Now()
+		for _, lease := range leases {
+			jobType, account, _ := strings.Cut(lease.Name, ":")
+			resp.Locks = append(resp.Locks, Lock{
+				Lease: lease, JobType: jobType, Account: account, Expired: lease.ExpiresAt.Before(now),
+			})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			log.Printf("Failed to encode locks to JSON: %v", err)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/AndersKaae/legaldesk_psp_sync/database"
+)
+
+// ReconcileRuns lists the latest reconcile runs without their reports, up to the 'limit'
+// query parameter (default 50)
+func ReconcileRuns(store database.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		limit := 50
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				http.Error(w, "'limit' must be a positive integer", http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+
+		runs, err := store.GetReconcileRuns(r.Context(), limit)
+		if err != nil {
+			log.Printf("Failed to fetch reconcile runs: %v", err)
+			http.Error(w, "Failed to fetch reconcile runs", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(runs); err != nil {
+			log.Printf("Failed to encode reconcile runs to JSON: %v", err)
+			http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		}
+	}
+}
+
+// ReconcileRun returns the reconcile run in the {id} path value with its report of per day
+// totals and differing invoices
+func ReconcileRun(store database.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid reconcile run id", http.StatusBadRequest)
+			return
+		}
+		run, err := store.GetReconcileRun(r.Context(), id)
+		if err != nil {
+			log.Printf("Failed to fetch reconcile run %d: %v", id, err)
+			http.Error(w, "Failed to fetch reconcile run", http.StatusInternalServerError)
+			return
+		}
+		if run == nil {
+			http.Error(w, "Reconcile run not found", http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(run); err != nil {
+			log.Printf("Failed to encode reconcile run to JSON: %v", err)
+			http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"encoding/json"
+	"github.com/AndersKaae/legaldesk_psp_sync/database"
+	"log"
+	"net/http"
+	"time"
+)
+
+// DiscountReportResponse is the JSON body of the discount report
+type DiscountReportResponse struct {
+	From             string                           `json:"from"`
+	To               string                           `json:"to"`
+	Redemptions      []database.CouponRedemptions     `json:"redemptions"`
+	DiscountedAmount []JSONCouponDiscountedAmount     `json:"discounted_amount"`
+	TrialConversions []database.CouponTrialConversion `json:"trial_conversions"`
+}
+
+// JSONCouponDiscountedAmount is the representation of a coupon's discounted amount for JSON
+// responses
+type JSONCouponDiscountedAmount struct {
+	Coupon   string     `json:"coupon"`
+	Discount string     `json:"discount"`
+	Month    string     `json:"month"`
+	Currency string     `json:"currency"`
+	Amount   JSONAmount `json:"amount"`
+	Invoices int        `json:"invoices"`
+}
+
+// DiscountReport reports coupon redemptions, discounted amounts per coupon per month and
+// conversion of discounted trials to paid subscriptions
+func DiscountReport(store database.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		from, to, ok := parseDateRange(w, r)
+		if !ok {
+			return
+		}
+		end := to.Add(24 * time.Hour)
+		decimal, ok := parseAmounts(w, r)
+		if !ok {
+			return
+		}
+
+		redemptions, err := store.GetCouponRedemptions(r.Context(), from, end)
+		if err != nil {
+			log.Printf("Failed to fetch coupon redemptions: %v", err)
+			http.Error(w, "Failed to fetch discount report", http.StatusInternalServerError)
+			return
+		}
+
+		amounts, err := store.GetCouponDiscountedAmounts(r.Context(), from, end)
+		if err != nil {
+			log.Printf("Failed to fetch coupon discounted amounts: %v", err)
+			http.Error(w, "Failed to fetch discount report", http.StatusInternalServerError)
+			return
+		}
+
+		conversions, err := store.GetCouponTrialConversions(r.Context(), from, end)
+		if err != nil {
+			log.Printf("Failed to fetch coupon trial conversions: %v", err)
+			http.Error(w, "Failed to fetch discount report", http.StatusInternalServerError)
+			return
+		}
+
+		discounted := make([]JSONCouponDiscountedAmount, len(amounts))
+		for i, a := range amounts {
+			discounted[i] = JSONCouponDiscountedAmount{
+				Coupon: a.Coupon, Discount: a.Discount, Month: a.Month, Currency: a.Amount.Currency,
+				Amount: JSONAmount{Money: a.Amount, Decimal: decimal}, Invoices: a.Invoices,
+			}
+		}
+
+		report := DiscountReportResponse{
+			From:             from.Format("2006-01-02"),
+			To:               to.Format("2006-01-02"),
+			Redemptions:      redemptions,
+			DiscountedAmount: discounted,
+			TrialConversions: conversions,
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(report); err != nil {
+			log.Printf("Failed to encode discount report to JSON: %v", err)
+			http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		}
+	}
+}
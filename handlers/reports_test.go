@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/AndersKaae/legaldesk_psp_sync/database"
+	"github.com/AndersKaae/legaldesk_psp_sync/money"
+)
+
+func TestDiscountReportAmounts(t *testing.T) {
+	ctx := context.Background()
+	created := time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC)
+	store := database.NewMemoryStore()
+	if err := store.CreateOrUpdateSubscriptionDiscount(ctx, &database.SubscriptionDiscount{
+		Handle: "sd-1", Subscription: "sub-1", Discount: "half", Coupon: "SPRING", Created: created, Country: "DK",
+	}); err != nil {
+		t.Fatalf("CreateOrUpdateSubscriptionDiscount: %v", err)
+	}
+	invoice := database.Invoice{
+		ID: "inv_1", Handle: "inv-1", Customer: "cust-1", Subscription: "sub-1", Currency: "DKK", Created: created,
+		OrgAmount: money.New(1250, "DKK"), Country: "DK", Plan: "plan-basic", State: "settled",
+		Discounts: []database.InvoiceDiscount{{OrderLineID: "ol-1", SubscriptionDiscount: "sd-1", Amount: money.New(-1250, "DKK")}},
+	}
+	if err := store.CreateOrUpdateInvoice(ctx, &invoice, "test"); err != nil {
+		t.Fatalf("CreateOrUpdateInvoice: %v", err)
+	}
+
+	for query, want := range map[string]string{
+		"":                 "1250",
+		"&amounts=minor":   "1250",
+		"&amounts=decimal": `"12.50"`,
+	} {
+		rec := httptest.NewRecorder()
+		DiscountReport(store)(rec, httptest.NewRequest(http.MethodGet, "/reports/discounts?from=2025-03-01&to=2025-03-31"+query, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%q: status = %d: %s", query, rec.Code, rec.Body)
+		}
+		var report struct {
+			DiscountedAmount []struct {
+				Coupon   string          `json:"coupon"`
+				Currency string          `json:"currency"`
+				Amount   json.RawMessage `json:"amount"`
+			} `json:"discounted_amount"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+			t.Fatalf("%q: unmarshal report: %v", query, err)
+		}
+		if len(report.DiscountedAmount) != 1 {
+			t.Fatalf("%q: discounted amounts = %+v, want one", query, report.DiscountedAmount)
+		}
+		if got := report.DiscountedAmount[0]; got.Coupon != "SPRING" || got.Currency != "DKK" || string(got.Amount) != want {
+			t.Errorf("%q: discounted amount = %s %s of %s, want %s", query, got.Amount, got.Currency, got.Coupon, want)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	DiscountReport(store)(rec, httptest.NewRequest(http.MethodGet, "/reports/discounts?from=2025-03-01&to=2025-03-31&amounts=cents", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("amounts=cents: status = %d, want 400", rec.Code)
+	}
+}
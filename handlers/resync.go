@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+
+	"github.com/AndersKaae/legaldesk_psp_sync/api"
+	"github.com/AndersKaae/legaldesk_psp_sync/syncer"
+)
+
+// ResyncRequest lists the invoices or customers of a bulk resync
+type ResyncRequest struct {
+	Handles []string `json:"handles"` // Invoice ids or handles, or customer handles
+}
+
+// accountParam returns the 'account' query parameter, which must be one of syncer.Countries
+// if set
+func accountParam(r *http.Request, required bool) (string, error) {
+	account := r.URL.Query().Get("account")
+	if account == "" {
+		if required {
+			return "", errors.New("'account' is required, e.g. account=DK")
+		}
+		return "", nil
+	}
+	if !slices.Contains(syncer.Countries, account) {
+		return "", fmt.Errorf("unknown account %s, expected one of %v", account, syncer.Countries)
+	}
+	return account, nil
+}
+
+// ResyncInvoice refetches the invoice in the {id} path value, by id or handle, from the
+// account in the 'account' query parameter, saves it and returns the before/after diff
+func ResyncInvoice(s *syncer.Syncer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		account, err := accountParam(r, true)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		diff, err := s.ResyncInvoice(r.Context(), account, r.PathValue("id"))
+		writeResync(w, "invoice", diff, err)
+	}
+}
+
+// ResyncCustomer refetches the customer in the {handle} path value, saves it and returns
+// the before/after diff. Without the 'account' query parameter every account is tried.
+func ResyncCustomer(s *syncer.Syncer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		account, err := accountParam(r, false)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		diff, err := s.ResyncCustomer(r.Context(), account, r.PathValue("handle"))
+		writeResync(w, "customer", diff, err)
+	}
+}
+
+// ResyncInvoices refetches and saves the invoices listed in the request body for the
+// account in the 'account' query parameter and returns a result per invoice
+func ResyncInvoices(s *syncer.Syncer) http.HandlerFunc {
+	return resyncBulk(true, s.ResyncInvoices)
+}
+
+// ResyncCustomers refetches and saves the customers listed in the request body and returns
+// a result per customer. Without the 'account' query parameter every account is tried.
+func ResyncCustomers(s *syncer.Syncer) http.HandlerFunc {
+	return resyncBulk(false, s.ResyncCustomers)
+}
+
+func resyncBulk(accountRequired bool, resync func(ctx context.Context, country string, keys []string) []syncer.ResyncResult) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		account, err := accountParam(r, accountRequired)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var req ResyncRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		defer r.Body.Close()
+		if len(req.Handles) == 0 || len(req.Handles) > syncer.MaxResync {
+			http.Error(w, fmt.Sprintf("'handles' must list 1 to %d handles", syncer.MaxResync), http.StatusBadRequest)
+			return
+		}
+
+		results := resync(r.Context(), account, req.Handles)
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(results); err != nil {
+			log.Printf("Failed to encode resync results to JSON: %v", err)
+			http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		}
+	}
+}
+
+// writeResync writes the diff of a single resync, or its error
+func writeResync(w http.ResponseWriter, entity string, diff syncer.RecordDiff, err error) {
+	if errors.Is(err, api.ErrNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to resync %s: %v", entity, err)
+		http.Error(w, fmt.Sprintf("Failed to resync %s: %v", entity, err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(diff); err != nil {
+		log.Printf("Failed to encode resync diff to JSON: %v", err)
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+	}
+}
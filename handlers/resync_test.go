@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AndersKaae/legaldesk_psp_sync/api"
+	"github.com/AndersKaae/legaldesk_psp_sync/api/frisbiitest"
+	"github.com/AndersKaae/legaldesk_psp_sync/database"
+	"github.com/AndersKaae/legaldesk_psp_sync/syncer"
+)
+
+var resyncCreated = time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC)
+
+// newResyncSyncer returns a syncer against a fake Frisbii API holding a settled DK invoice,
+// a DK customer and an SE customer
+func newResyncSyncer(t *testing.T) (*frisbiitest.Server, *database.MemoryStore, *syncer.Syncer) {
+	t.Helper()
+	fake := frisbiitest.NewServer()
+	t.Cleanup(fake.Close)
+	t.Cleanup(fake.Install())
+
+	fake.AddInvoice("DK", api.InvoiceResponse{
+		ID: "inv_1", Handle: "inv-1", Customer: "cust-dk", Currency: "DKK", Created: resyncCreated,
+		Plan: "plan-basic", OrgAmount: 12500, State: "settled", Settled: resyncCreated.Add(time.Minute),
+	})
+	fake.AddCustomer("DK", api.CustomerResponse{Handle: "cust-dk", Email: "dk@example.com", Created: resyncCreated})
+	fake.AddCustomer("SE", api.CustomerResponse{Handle: "cust-se", Email: "se@example.com", Created: resyncCreated})
+
+	store := database.NewMemoryStore()
+	return fake, store, syncer.New(store, syncer.Options{})
+}
+
+// serveResync serves a POST to target with handler, setting the path value name to value
+func serveResync(handler http.HandlerFunc, target, name, value, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+	if name != "" {
+		r.SetPathValue(name, value)
+	}
+	rec := httptest.NewRecorder()
+	handler(rec, r)
+	return rec
+}
+
+func TestResyncInvoice(t *testing.T) {
+	_, store, s := newResyncSyncer(t)
+	pending := database.Invoice{
+		ID: "inv_1", Handle: "inv-1", Customer: "cust-dk", Currency: "DKK", Created: resyncCreated,
+		Country: "DK", Plan: "plan-basic", State: "pending", States: database.InvoiceStates{"created": &resyncCreated},
+	}
+	pending.OrgAmount.Amount = 12500
+	storeInvoices(t, store, pending)
+
+	// By handle as well as by id
+	rec := serveResync(ResyncInvoice(s), "/admin/resync/invoices/inv-1?account=DK", "id", "inv-1", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var diff syncer.RecordDiff
+	if err := json.Unmarshal(rec.Body.Bytes(), &diff); err != nil {
+		t.Fatalf("unmarshal diff: %v", err)
+	}
+	if diff.Kind != syncer.DiffChanged || diff.Account != "DK" || diff.Key != "inv-1" {
+		t.Errorf("diff = %+v, want inv-1 of DK changed", diff)
+	}
+	transitions, err := store.GetInvoiceStateTransitions(context.Background(), "inv_1")
+	if err != nil {
+		t.Fatalf("GetInvoiceStateTransitions: %v", err)
+	}
+	if last := transitions[len(transitions)-1]; last.ToState != "settled" || last.Source != database.SourceManual {
+		t.Errorf("last transition = %+v, want to settled from %s", last, database.SourceManual)
+	}
+
+	rec = serveResync(ResyncInvoice(s), "/admin/resync/invoices/inv_1?account=DK", "id", "inv_1", "")
+	if err := json.Unmarshal(rec.Body.Bytes(), &diff); err != nil || diff.Kind != syncer.DiffUnchanged {
+		t.Errorf("second resync = %s, %v; want unchanged", rec.Body, err)
+	}
+}
+
+func TestResyncInvoiceErrors(t *testing.T) {
+	_, _, s := newResyncSyncer(t)
+	for target, want := range map[string]int{
+		"/admin/resync/invoices/inv_1":            http.StatusBadRequest,
+		"/admin/resync/invoices/inv_1?account=US": http.StatusBadRequest,
+		"/admin/resync/invoices/inv_1?account=SE": http.StatusNotFound,
+	} {
+		if rec := serveResync(ResyncInvoice(s), target, "id", "inv_1", ""); rec.Code != want {
+			t.Errorf("%s: status = %d, want %d: %s", target, rec.Code, want, rec.Body)
+		}
+	}
+	r := httptest.NewRequest(http.MethodGet, "/admin/resync/invoices/inv_1?account=DK", nil)
+	rec := httptest.NewRecorder()
+	ResyncInvoice(s)(rec, r)
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET: status = %d, want 405", rec.Code)
+	}
+}
+
+func TestResyncCustomerInAnyAccount(t *testing.T) {
+	fake, store, s := newResyncSyncer(t)
+
+	rec := serveResync(ResyncCustomer(s), "/admin/resync/customers/cust-se", "handle", "cust-se", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var diff syncer.RecordDiff
+	if err := json.Unmarshal(rec.Body.Bytes(), &diff); err != nil {
+		t.Fatalf("unmarshal diff: %v", err)
+	}
+	if diff.Kind != syncer.DiffNew || diff.Account != "SE" {
+		t.Errorf("diff = %+v, want a new SE customer", diff)
+	}
+	customers, err := store.GetCustomersByHandle(context.Background(), []string{"cust-se"})
+	if err != nil || len(customers) != 1 || customers[0].Email != "se@example.com" {
+		t.Errorf("stored customers = %+v, %v", customers, err)
+	}
+	var accounts []string
+	for _, req := range fake.Requests() {
+		accounts = append(accounts, req.Account)
+	}
+	if got := strings.Join(accounts, ","); got != "DK,SE" {
+		t.Errorf("customer looked up in %s, want DK then SE", got)
+	}
+
+	// With an account only that account is asked
+	if rec := serveResync(ResyncCustomer(s), "/admin/resync/customers/cust-se?account=DK", "handle", "cust-se", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("cust-se in DK: status = %d, want 404", rec.Code)
+	}
+	rec = serveResync(ResyncCustomer(s), "/admin/resync/customers/cust-none", "handle", "cust-none", "")
+	if rec.Code != http.StatusNotFound || !strings.Contains(rec.Body.String(), "cust-none") {
+		t.Errorf("unknown customer: status = %d: %s; want 404", rec.Code, rec.Body)
+	}
+}
+
+func TestResyncBulk(t *testing.T) {
+	_, store, s := newResyncSyncer(t)
+
+	rec := serveResync(ResyncInvoices(s), "/admin/resync/invoices?account=DK", "", "", `{"handles": ["inv-1", "inv-none"]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var results []syncer.ResyncResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &results); err != nil {
+		t.Fatalf("unmarshal results: %v", err)
+	}
+	if len(results) != 2 || results[0].Key != "inv-1" || results[0].Diff == nil || results[0].Diff.Kind != syncer.DiffNew ||
+		results[1].Key != "inv-none" || results[1].Diff != nil || results[1].Error == "" {
+		t.Errorf("results = %+v, want inv-1 saved and inv-none failed", results)
+	}
+	if invoices, err := store.GetInvoicesByID(context.Background(), []string{"inv_1"}); err != nil || len(invoices) != 1 {
+		t.Errorf("stored invoices = %+v, %v", invoices, err)
+	}
+
+	rec = serveResync(ResyncCustomers(s), "/admin/resync/customers", "", "", `{"handles": ["cust-dk", "cust-se"]}`)
+	results = nil
+	if err := json.Unmarshal(rec.Body.Bytes(), &results); err != nil {
+		t.Fatalf("unmarshal results: %s: %v", rec.Body, err)
+	}
+	if len(results) != 2 || results[0].Diff == nil || results[0].Diff.Account != "DK" || results[1].Diff == nil || results[1].Diff.Account != "SE" {
+		t.Errorf("customer results = %+v, want cust-dk from DK and cust-se from SE", results)
+	}
+}
+
+func TestResyncBulkLimits(t *testing.T) {
+	_, _, s := newResyncSyncer(t)
+	tooMany := make([]string, syncer.MaxResync+1)
+	for i := range tooMany {
+		tooMany[i] = fmt.Sprintf("inv-%d", i)
+	}
+	body, _ := json.Marshal(ResyncRequest{Handles: tooMany})
+
+	for name, tc := range map[string]struct{ target, body string }{
+		"no account":   {"/admin/resync/invoices", `{"handles": ["inv-1"]}`},
+		"invalid JSON": {"/admin/resync/invoices?account=DK", `{"handles": `},
+		"no handles":   {"/admin/resync/invoices?account=DK", `{"handles": []}`},
+		"too many":     {"/admin/resync/invoices?account=DK", string(body)},
+	} {
+		if rec := serveResync(ResyncInvoices(s), tc.target, "", "", tc.body); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", name, rec.Code)
+		}
+	}
+
+	body, _ = json.Marshal(ResyncRequest{Handles: tooMany[:syncer.MaxResync]})
+	if rec := serveResync(ResyncCustomers(s), "/admin/resync/customers?account=DK", "", "", string(body)); rec.Code != http.StatusOK {
+		t.Errorf("%d customers: status = %d, want 200", syncer.MaxResync, rec.Code)
+	}
+}
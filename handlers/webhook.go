@@ -1,11 +1,15 @@
 package handlers
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
-	"github.com/AndersKaae/legaldesk_psp_sync/api"
-	"github.com/AndersKaae/legaldesk_psp_sync/database"
 	"log"
 	"net/http"
+
+	"github.com/AndersKaae/legaldesk_psp_sync/database"
+	"github.com/AndersKaae/legaldesk_psp_sync/syncer"
 )
 
 type WebhookPayload struct {
@@ -34,7 +38,21 @@ func findStatus(statuses []string, target string) (string, bool) {
 	return "", false
 }
 
-func Webhook(country string) http.HandlerFunc {
+// validSignature reports whether the webhook was signed with secret, which Frisbii does as
+// hex(HMAC-SHA256(secret, timestamp + id))
+func validSignature(payload WebhookPayload, secret string) bool {
+	signature, err := hex.DecodeString(payload.Signature)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload.Timestamp + payload.ID))
+	return hmac.Equal(signature, mac.Sum(nil))
+}
+
+// Webhook syncs the invoice or customer a Frisbii webhook is about. With a secret, webhooks
+// without a valid signature are rejected.
+func Webhook(s *syncer.Syncer, country, secret string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -48,6 +66,12 @@ func Webhook(country string) http.HandlerFunc {
 		}
 		defer r.Body.Close()
 
+		if secret != "" && !validSignature(payload, secret) {
+			log.Printf("Rejected webhook %s for country %s: invalid signature", payload.ID, country)
+			http.Error(w, "Invalid signature", http.StatusUnauthorized)
+			return
+		}
+
 		log.Printf("EventType: %s\nReceived webhook for country %s: %+v\n",
 			payload.EventType, country, payload)
 
@@ -56,94 +80,21 @@ func Webhook(country string) http.HandlerFunc {
 		customerStatus := []string{"customer_created", "customer_deleted", "customer_changed"}
 
 		if _, found := findStatus(invoiceStatus, payload.EventType); found {
-			apiInvoice, err := api.GetInvoice(payload.Invoice, country)
+			dbInvoice, err := s.SyncInvoice(r.Context(), country, payload.Invoice, database.SourceWebhook)
 			if err != nil {
-				log.Printf("Error fetching invoice: %v", err)
-				http.Error(w, "Failed to fetch invoice", http.StatusInternalServerError)
-				return
-			}
-			log.Printf("Recieved invoice from webhook: %+v\n", apiInvoice.Handle)
-
-			dbInvoice := database.Invoice{
-				ID:               apiInvoice.ID,
-				Handle:           apiInvoice.Handle,
-				Customer:         apiInvoice.Customer,
-				Currency:         apiInvoice.Currency,
-				Created:          apiInvoice.Created,
-				DiscountAmount:   apiInvoice.DiscountAmount,
-				OrgAmount:        apiInvoice.OrgAmount,
-				AmountVAT:        apiInvoice.AmountVAT,
-				AmountExVAT:      apiInvoice.AmountExVAT,
-				RefundedAmount:   apiInvoice.RefundedAmount,
-				AuthorizedAmount: apiInvoice.AuthorizedAmount,
-				Country:          apiInvoice.Country,
-				Plan:             apiInvoice.Plan,
-				States:           database.InvoiceStates(apiInvoice.States),
-			}
-
-			if err := database.CreateOrUpdateInvoice(&dbInvoice); err != nil {
-				log.Printf("Error saving invoice to DB: %v", err)
-				http.Error(w, "Failed to save invoice", http.StatusInternalServerError)
+				log.Printf("Error syncing invoice from webhook: %v", err)
+				http.Error(w, "Failed to sync invoice", http.StatusInternalServerError)
 				return
 			}
+			log.Printf("Recieved invoice from webhook: %+v\n", dbInvoice.Handle)
 		} else if _, found := findStatus(customerStatus, payload.EventType); found {
-			apiCustomer, err := api.GetCustomer(payload.Customer, country)
+			dbCustomer, err := s.SyncCustomer(r.Context(), country, payload.Customer)
 			if err != nil {
-				log.Printf("Error fetching customer: %v", err)
-				http.Error(w, "Failed to fetch customer", http.StatusInternalServerError)
-				return
-			}
-			log.Printf("Fetched customer from API: %+v\n", apiCustomer)
-
-			dbCustomer := database.Customer{
-				ActiveSubscriptions:             apiCustomer.ActiveSubscriptions,
-				Address:                         apiCustomer.Address,
-				Address2:                        apiCustomer.Address2,
-				CancelledAmount:                 apiCustomer.CancelledAmount,
-				CancelledInvoices:               apiCustomer.CancelledInvoices,
-				CancelledSubscriptions:          apiCustomer.CancelledSubscriptions,
-				City:                            apiCustomer.City,
-				Company:                         apiCustomer.Company,
-				Country:                         apiCustomer.Country,
-				Created:                         apiCustomer.Created,
-				DunningAmount:                   apiCustomer.DunningAmount,
-				DunningInvoices:                 apiCustomer.DunningInvoices,
-				Email:                           apiCustomer.Email,
-				ExpiredSubscriptions:            apiCustomer.ExpiredSubscriptions,
-				FailedAmount:                    apiCustomer.FailedAmount,
-				FailedInvoices:                  apiCustomer.FailedInvoices,
-				FirstName:                       apiCustomer.FirstName,
-				Handle:                          apiCustomer.Handle,
-				LastName:                        apiCustomer.LastName,
-				NonRenewingSubscriptions:        apiCustomer.NonRenewingSubscriptions,
-				OnHoldSubscriptions:             apiCustomer.OnHoldSubscriptions,
-				PendingAdditionalCostAmount:     apiCustomer.PendingAdditionalCostAmount,
-				PendingAdditionalCosts:          apiCustomer.PendingAdditionalCosts,
-				PendingAmount:                   apiCustomer.PendingAmount,
-				PendingCreditAmount:             apiCustomer.PendingCreditAmount,
-				PendingCredits:                  apiCustomer.PendingCredits,
-				PendingInvoices:                 apiCustomer.PendingInvoices,
-				Phone:                           apiCustomer.Phone,
-				PostalCode:                      apiCustomer.PostalCode,
-				RefundedAmount:                  apiCustomer.RefundedAmount,
-				SettledAmount:                   apiCustomer.SettledAmount,
-				SettledInvoices:                 apiCustomer.SettledInvoices,
-				Subscriptions:                   apiCustomer.Subscriptions,
-				Test:                            apiCustomer.Test,
-				TransferredAdditionalCostAmount: apiCustomer.TransferredAdditionalCostAmount,
-				TransferredAdditionalCosts:      apiCustomer.TransferredAdditionalCosts,
-				TransferredCreditAmount:         apiCustomer.TransferredCreditAmount,
-				TransferredCredits:              apiCustomer.TransferredCredits,
-				TrialActiveSubscriptions:        apiCustomer.TrialActiveSubscriptions,
-				TrialCancelledSubscriptions:     apiCustomer.TrialCancelledSubscriptions,
-			}
-
-			if err := database.CreateOrUpdateCustomer(&dbCustomer); err != nil {
-				log.Printf("Error saving customer to DB: %v", err)
-				http.Error(w, "Failed to save customer", http.StatusInternalServerError)
+				log.Printf("Error syncing customer from webhook: %v", err)
+				http.Error(w, "Failed to sync customer", http.StatusInternalServerError)
 				return
 			}
-			log.Printf("Saved customer to DB: %+v\n", dbCustomer)
+			log.Printf("Saved customer to DB: %+v\n", dbCustomer.Handle)
 		} else {
 			log.Printf("Unknown event type: %s\n", payload.EventType)
 		}
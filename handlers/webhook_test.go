@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/AndersKaae/legaldesk_psp_sync/api"
+	"github.com/AndersKaae/legaldesk_psp_sync/api/frisbiitest"
+	"github.com/AndersKaae/legaldesk_psp_sync/database"
+	"github.com/AndersKaae/legaldesk_psp_sync/syncer"
+)
+
+// newWebhookServer serves Webhook for DK against a fake Frisbii API holding one settled invoice
+func newWebhookServer(t *testing.T, secret string) (*frisbiitest.Server, *database.MemoryStore, *httptest.Server) {
+	t.Helper()
+	fake := frisbiitest.NewServer()
+	t.Cleanup(fake.Close)
+	t.Cleanup(fake.Install())
+
+	created := time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC)
+	fake.AddInvoice("DK", api.InvoiceResponse{
+		ID: "inv_1", Handle: "inv-1", Customer: "cust-1", Currency: "DKK", Created: created,
+		Plan: "plan-basic", OrgAmount: 12500, State: "settled", Settled: created.Add(time.Minute),
+	})
+
+	store := database.NewMemoryStore()
+	srv := httptest.NewServer(Webhook(syncer.New(store, syncer.Options{}), "DK", secret))
+	t.Cleanup(srv.Close)
+	return fake, store, srv
+}
+
+func storedInvoices(t *testing.T, store database.Store) []database.Invoice {
+	t.Helper()
+	invoices, err := store.GetInvoicesByID(context.Background(), []string{"inv_1"})
+	if err != nil {
+		t.Fatalf("GetInvoicesByID: %v", err)
+	}
+	return invoices
+}
+
+func TestWebhookSyncsSignedInvoice(t *testing.T) {
+	fake, store, srv := newWebhookServer(t, "webhook_secret_test")
+
+	resp, err := fake.SendWebhook(srv.URL, frisbiitest.InvoiceWebhook("invoice_settled", "inv_1", "cust-1"))
+	if err != nil {
+		t.Fatalf("SendWebhook: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want 200", resp.StatusCode)
+	}
+
+	invoices := storedInvoices(t, store)
+	if len(invoices) != 1 {
+		t.Fatalf("stored %d invoices, want 1", len(invoices))
+	}
+	if got := invoices[0]; got.Handle != "inv-1" || got.Country != "DK" || got.OrgAmount.Amount != 12500 || got.States["settled"] == nil {
+		t.Errorf("stored invoice = %+v", got)
+	}
+}
+
+func TestWebhookRejectsInvalidSignature(t *testing.T) {
+	fake, store, srv := newWebhookServer(t, "webhook_secret_test")
+
+	for name, signature := range map[string]string{
+		"wrong secret": frisbiitest.Sign("another_secret", "2025-03-01T10:00:00.000Z", "wh_forged"),
+		"not hex":      "not-a-signature",
+	} {
+		wh := frisbiitest.InvoiceWebhook("invoice_settled", "inv_1", "cust-1")
+		wh.ID, wh.Timestamp, wh.Signature = "wh_forged", "2025-03-01T10:00:00.000Z", signature
+		resp, err := fake.SendWebhook(srv.URL, wh)
+		if err != nil {
+			t.Fatalf("%s: SendWebhook: %v", name, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("%s: status = %d, want 401", name, resp.StatusCode)
+		}
+	}
+
+	if len(fake.Requests()) != 0 {
+		t.Errorf("fetched from Frisbii for rejected webhooks: %+v", fake.Requests())
+	}
+	if invoices := storedInvoices(t, store); len(invoices) != 0 {
+		t.Errorf("stored %d invoices for rejected webhooks", len(invoices))
+	}
+}
+
+func TestWebhookWithoutSecretAcceptsUnsigned(t *testing.T) {
+	fake, store, srv := newWebhookServer(t, "")
+
+	wh := frisbiitest.InvoiceWebhook("invoice_settled", "inv_1", "cust-1")
+	wh.Signature = "unsigned"
+	resp, err := fake.SendWebhook(srv.URL, wh)
+	if err != nil {
+		t.Fatalf("SendWebhook: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want 200", resp.StatusCode)
+	}
+	if invoices := storedInvoices(t, store); len(invoices) != 1 {
+		t.Errorf("stored %d invoices, want 1", len(invoices))
+	}
+}
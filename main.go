@@ -1,21 +1,29 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
+	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/AndersKaae/legaldesk_psp_sync/api"
 	"github.com/AndersKaae/legaldesk_psp_sync/config"
 	"github.com/AndersKaae/legaldesk_psp_sync/database"
 	"github.com/AndersKaae/legaldesk_psp_sync/handlers"
+	"github.com/AndersKaae/legaldesk_psp_sync/syncer"
 )
 
-var backfillFlag = flag.Bool("backfill", false, "Run backfill process to populate old data")
+var backfillFlag = flag.Bool("backfill", false, "Run backfill process to populate old data while serving; same as serve -backfill")
 
 func setupLogging() {
 	logFile, err := os.OpenFile("app.log", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
@@ -28,162 +36,563 @@ func setupLogging() {
 	log.SetOutput(mw)
 }
 
-// backfillCustomersForCountry fetches and saves all customers for a given country
-func backfillCustomersForCountry(country string) int {
-	log.Printf("Starting customer backfill for country: %s", country)
-	customerCount := 0
-	nextPage := ""
-	for {
-		customers, newNextPage, err := api.GetCustomerList(nextPage, country)
+// runMigrate handles "migrate up|down [steps]|status" and exits
+func runMigrate(cfg config.Config, args []string) {
+	store, err := database.Connect(cfg)
+	if err != nil {
+		log.Fatalf("Failed to open database: %v", err)
+	}
+	defer store.Close()
+
+	if len(args) == 0 {
+		log.Fatal("Usage: migrate up|down [steps]|status")
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := store.MigrateUp()
+		for _, m := range applied {
+			log.Printf("Applied migration %d_%s", m.Version, m.Name)
+		}
 		if err != nil {
-			log.Printf("Error fetching customer list for %s (nextPage: %s): %v", country, nextPage, err)
-			break // Stop backfill for this country on error
-		}
-
-		for _, apiCustomer := range customers {
-			dbCustomer := database.Customer{
-				ActiveSubscriptions:             apiCustomer.ActiveSubscriptions,
-				Address:                         apiCustomer.Address,
-				Address2:                        apiCustomer.Address2,
-				CancelledAmount:                 apiCustomer.CancelledAmount,
-				CancelledInvoices:               apiCustomer.CancelledInvoices,
-				CancelledSubscriptions:          apiCustomer.CancelledSubscriptions,
-				City:                            apiCustomer.City,
-				Company:                         apiCustomer.Company,
-				Country:                         apiCustomer.Country,
-				Created:                         apiCustomer.Created,
-				DunningAmount:                   apiCustomer.DunningAmount,
-				DunningInvoices:                 apiCustomer.DunningInvoices,
-				Email:                           apiCustomer.Email,
-				ExpiredSubscriptions:            apiCustomer.ExpiredSubscriptions,
-				FailedAmount:                    apiCustomer.FailedAmount,
-				FailedInvoices:                  apiCustomer.FailedInvoices,
-				FirstName:                       apiCustomer.FirstName,
-				Handle:                          apiCustomer.Handle,
-				LastName:                        apiCustomer.LastName,
-				NonRenewingSubscriptions:        apiCustomer.NonRenewingSubscriptions,
-				OnHoldSubscriptions:             apiCustomer.OnHoldSubscriptions,
-				PendingAdditionalCostAmount:     apiCustomer.PendingAdditionalCostAmount,
-				PendingAdditionalCosts:          apiCustomer.PendingAdditionalCosts,
-				PendingAmount:                   apiCustomer.PendingAmount,
-				PendingCreditAmount:             apiCustomer.PendingCreditAmount,
-				PendingCredits:                  apiCustomer.PendingCredits,
-				PendingInvoices:                 apiCustomer.PendingInvoices,
-				Phone:                           apiCustomer.Phone,
-				PostalCode:                      apiCustomer.PostalCode,
-				RefundedAmount:                  apiCustomer.RefundedAmount,
-				SettledAmount:                   apiCustomer.SettledAmount,
-				SettledInvoices:                 apiCustomer.SettledInvoices,
-				Subscriptions:                   apiCustomer.Subscriptions,
-				Test:                            apiCustomer.Test,
-				TransferredAdditionalCostAmount: apiCustomer.TransferredAdditionalCostAmount,
-				TransferredAdditionalCosts:      apiCustomer.TransferredAdditionalCosts,
-				TransferredCreditAmount:         apiCustomer.TransferredCreditAmount,
-				TransferredCredits:              apiCustomer.TransferredCredits,
-				TrialActiveSubscriptions:        apiCustomer.TrialActiveSubscriptions,
-				TrialCancelledSubscriptions:     apiCustomer.TrialCancelledSubscriptions,
+			log.Fatalf("Migrate up failed: %v", err)
+		}
+		log.Printf("Applied %d migration(s)", len(applied))
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				log.Fatalf("Invalid number of steps: %s", args[1])
 			}
-			if err := database.CreateOrUpdateCustomer(&dbCustomer); err != nil {
-				log.Printf("Error saving backfilled customer %s to DB for %s: %v", apiCustomer.Handle, country, err)
-			} else {
-				log.Printf("Backfilled customer %s for %s", apiCustomer.Handle, country)
-				customerCount++
+			steps = n
+		}
+		reverted, err := store.MigrateDown(steps)
+		for _, m := range reverted {
+			log.Printf("Reverted migration %d_%s", m.Version, m.Name)
+		}
+		if err != nil {
+			log.Fatalf("Migrate down failed: %v", err)
+		}
+		log.Printf("Reverted %d migration(s)", len(reverted))
+	case "status":
+		statuses, err := store.GetMigrationStatus()
+		if err != nil {
+			log.Fatalf("Failed to get migration status: %v", err)
+		}
+		for _, s := range statuses {
+			state := "pending"
+			if s.Applied {
+				state = "applied " + s.AppliedAt.Format(time.RFC3339)
+			}
+			if s.Modified {
+				state += " (MODIFIED)"
 			}
+			if s.Unknown {
+				state += " (UNKNOWN)"
+			}
+			fmt.Printf("%04d_%-30s %s\n", s.Version, s.Name, state)
 		}
+	default:
+		log.Fatalf("Unknown migrate command: %s", args[0])
+	}
+}
+
+// runCheckpoints handles "checkpoints list|reset <account> [entity]" and exits
+func runCheckpoints(cfg config.Config, args []string) {
+	if len(args) == 0 {
+		log.Fatal("Usage: checkpoints list|reset <account> [entity]")
+	}
 
-		if newNextPage == "" {
-			break // No more pages
+	store, err := database.Open(cfg)
+	if err != nil {
+		log.Fatalf("Failed to open database: %v", err)
+	}
+	defer store.Close()
+	ctx := context.Background()
+
+	switch args[0] {
+	case "list":
+		checkpoints, err := store.GetSyncCheckpoints(ctx)
+		if err != nil {
+			log.Fatalf("Failed to get checkpoints: %v", err)
+		}
+		for _, cp := range checkpoints {
+			state := "in progress"
+			if cp.Completed {
+				state = "completed"
+			}
+			lastCreated := "-"
+			if cp.LastCreated != nil {
+				lastCreated = cp.LastCreated.Format(time.RFC3339)
+			}
+			windows, err := store.GetSyncWindows(ctx, cp.Account, cp.Entity)
+			if err != nil {
+				log.Fatalf("Failed to get windows: %v", err)
+			}
+			completed := 0
+			for _, w := range windows {
+				if w.Completed {
+					completed++
+				}
+			}
+			fmt.Printf("%-3s %-22s %-11s pages=%d items=%d last_created=%s windows=%d/%d\n",
+				cp.Account, cp.Entity, state, cp.Pages, cp.Items, lastCreated, completed, len(windows))
+			for _, w := range windows {
+				if !w.Completed {
+					fmt.Printf("    window from %s attempts=%d pages=%d items=%d %s\n",
+						w.From.Format(time.DateOnly), w.Attempts, w.Pages, w.Items, w.LastError)
+				}
+			}
+		}
+	case "reset":
+		if len(args) < 2 {
+			log.Fatal("Usage: checkpoints reset <account> [entity]")
+		}
+		account, entity := args[1], ""
+		if len(args) > 2 {
+			var err error
+			if entity, err = syncer.ParseEntity(args[2]); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if err := store.ResetSyncCheckpoint(ctx, account, entity); err != nil {
+			log.Fatalf("Failed to reset checkpoint: %v", err)
 		}
-		nextPage = newNextPage
-		time.Sleep(100 * time.Millisecond) // Be nice to the API
+		log.Printf("Reset backfill checkpoint for %s %s", account, entity)
+	default:
+		log.Fatalf("Unknown checkpoints command: %s", args[0])
 	}
-	log.Printf("Finished customer backfill for country: %s", country)
-	return customerCount
 }
 
-// backfillInvoicesForCountry fetches and saves all invoices for a given country
-func backfillInvoicesForCountry(country string) int {
-	log.Printf("Starting invoice backfill for country: %s", country)
-	invoiceCount := 0
-	nextPage := ""
+// usage describes the commands and global flags
+func usage() {
+	fmt.Fprint(flag.CommandLine.Output(), `Usage: psp-sync [flags] [command] [arguments]
+
+Commands:
+  serve [-addr :6969] [-backfill]
+        Serve the invoice API and receive webhooks until SIGINT or SIGTERM; the default command
+  backfill [-entity invoices,customers] [-account SE] [-from 2025-01-01] [-to 2025-03-31] [-window month] [-dry-run]
+        Backfill the selected entities and accounts window by window and exit; all of them by default
+  reconcile [-account DK] [-from 2025-01-01] [-to 2025-01-31] [-repair] [-dry-run]
+        Compare invoices per day with Frisbii, yesterday by default, and store the run
+  sync invoice|customer <id or handle> -account DK [-dry-run]
+        Fetch one invoice or customer from Frisbii, save it and exit
+  migrate up|down [steps]|status
+        Apply every pending database migration, roll back the last steps (default 1) or list them
+  checkpoints list|reset <account> [entity]
+        List backfill checkpoints with their unfinished windows, or reset them so the next backfill starts over
+
+With -dry-run nothing is written; the new, changed and unchanged records are printed as JSON.
+
+Exit status is 0 on success, including serve stopped by SIGINT or SIGTERM, 1 when a backfill
+stopped early, a sync or reconcile failed or the server failed, 2 for an invalid command line,
+3 when a backfill finished but some items could not be saved and 4 when a reconcile found
+differences that were not repaired.
+
+Flags:
+`)
+	flag.PrintDefaults()
+}
+
+// Exit statuses of the commands
+const (
+	exitOK          = 0
+	exitFailed      = 1 // A backfill stopped early, a sync or reconcile failed or the server failed
+	exitUsage       = 2 // Invalid command line, as for flag parse errors
+	exitPartial     = 3 // A backfill finished but some items could not be saved
+	exitDifferences = 4 // A reconcile found differences that were not repaired
+)
+
+func usageError(format string, args ...interface{}) int {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	return exitUsage
+}
+
+// parseInterleaved parses flags of fs that may come before, between or after the
+// positional arguments, as in "sync invoice inv-123 -account DK", and returns the
+// positional arguments
+func parseInterleaved(fs *flag.FlagSet, args []string) []string {
+	var positional []string
 	for {
-		invoices, newNextPage, err := api.GetInvoiceList(nextPage, country)
+		fs.Parse(args) // Exits on error
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// splitList splits a comma separated flag value, ignoring empty items
+func splitList(v string) []string {
+	var items []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parseAccounts validates a comma separated list of accounts such as "DK,se"
+func parseAccounts(v string) ([]string, error) {
+	var accounts []string
+	for _, account := range splitList(v) {
+		account = strings.ToUpper(account)
+		if !slices.Contains(syncer.Countries, account) {
+			return nil, fmt.Errorf("unknown account %s, expected one of %v", account, syncer.Countries)
+		}
+		accounts = append(accounts, account)
+	}
+	return accounts, nil
+}
+
+// parseDays parses -from and -to flags, the first and last day to include as YYYY-MM-DD,
+// into a date range; either may be empty to leave that end open
+func parseDays(from, to string) (api.DateRange, error) {
+	var dateRange api.DateRange
+	var err error
+	if from != "" {
+		if dateRange.From, err = time.Parse(time.DateOnly, from); err != nil {
+			return dateRange, fmt.Errorf("invalid -from date %s, expected YYYY-MM-DD", from)
+		}
+	}
+	if to != "" {
+		last, err := time.Parse(time.DateOnly, to)
 		if err != nil {
-			log.Printf("Error fetching invoice list for %s (nextPage: %s): %v", country, nextPage, err)
-			break // Stop backfill for this country on error
-		}
-
-		for _, apiInvoice := range invoices {
-			dbInvoice := database.Invoice{
-				ID:               apiInvoice.ID,
-				Handle:           apiInvoice.Handle,
-				Customer:         apiInvoice.Customer,
-				Currency:         apiInvoice.Currency,
-				Created:          apiInvoice.Created,
-				DiscountAmount:   apiInvoice.DiscountAmount,
-				OrgAmount:        apiInvoice.OrgAmount,
-				AmountVAT:        apiInvoice.AmountVAT,
-				AmountExVAT:      apiInvoice.AmountExVAT,
-				RefundedAmount:   apiInvoice.RefundedAmount,
-				AuthorizedAmount: apiInvoice.AuthorizedAmount,
-				Country:          apiInvoice.Country,
-				Plan:             apiInvoice.Plan,
-				States:           database.InvoiceStates(apiInvoice.States),
-			}
-			jsonInvoice, _ := json.Marshal(apiInvoice)
-			if err := database.CreateOrUpdateInvoice(&dbInvoice); err != nil {
-				log.Printf("API Invoice for %s: %s", apiInvoice.ID, string(jsonInvoice))
-				log.Printf("Error saving backfilled invoice %s to DB for %s: %v", apiInvoice.ID, country, err)
-			} else {
-				log.Printf("(%s) Backfilled invoice %s for %s", apiInvoice.Created, apiInvoice.Handle, country)
-				invoiceCount++
-			}
+			return dateRange, fmt.Errorf("invalid -to date %s, expected YYYY-MM-DD", to)
+		}
+		dateRange.To = last.AddDate(0, 0, 1) // The list API's to is exclusive
+		if !dateRange.From.IsZero() && !dateRange.From.Before(dateRange.To) {
+			return dateRange, fmt.Errorf("-from %s is after -to %s", from, to)
+		}
+	}
+	return dateRange, nil
+}
+
+// shutdownTimeout is how long a stopping server waits for requests in flight
+const shutdownTimeout = 30 * time.Second
+
+// runServe handles "serve [-addr addr] [-backfill]" and returns when the server stops. On
+// SIGINT or SIGTERM it stops accepting connections, waits for requests in flight, cancels
+// the running jobs and waits for them to store their status, and returns exitOK.
+func runServe(cfg config.Config, store database.Store, sync *syncer.Syncer, args []string) int {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := fs.String("addr", ":6969", "Address to listen on")
+	backfill := fs.Bool("backfill", *backfillFlag, "Run a full backfill in the background")
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		return usageError("Usage: serve [-addr :6969] [-backfill]")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	jobs := syncer.NewJobs(sync)
+	go jobs.WatchInterrupted(ctx)
+	if *backfill {
+		if _, err := jobs.Start(context.Background(), database.JobBackfill, syncer.JobParams{}); err != nil {
+			log.Printf("Failed to start backfill job: %v", err)
+			return exitFailed
 		}
+	}
+	if cfg.SyncInterval > 0 {
+		go syncer.NewScheduler(sync, cfg.SyncInterval, cfg.SyncJitter).Run(ctx)
+	}
+
+	http.HandleFunc("/invoices/", handlers.RequireBasicAuth(handlers.Invoices(store, "all"), cfg.BasicAuthUser, cfg.BasicAuthPass))
+	http.HandleFunc("/invoices/{id}/history", handlers.RequireBasicAuth(handlers.InvoiceHistory(store), cfg.BasicAuthUser, cfg.BasicAuthPass))
 
-		if newNextPage == "" {
-			break // No more pages
+	http.HandleFunc("/virtual-office-invoices/", handlers.RequireBasicAuth(handlers.Invoices(store, "virtualOffice"), cfg.BasicAuthUser, cfg.BasicAuthPass))
+	http.HandleFunc("/customers/{handle}", handlers.RequireBasicAuth(handlers.CustomerAsOf(store), cfg.BasicAuthUser, cfg.BasicAuthPass))
+	http.HandleFunc("/customers/{handle}/diff", handlers.RequireBasicAuth(handlers.CustomerDiff(store), cfg.BasicAuthUser, cfg.BasicAuthPass))
+	http.HandleFunc("/backfill/progress", handlers.RequireBasicAuth(handlers.BackfillProgress(store), cfg.BasicAuthUser, cfg.BasicAuthPass))
+	http.HandleFunc("/reconcile/runs", handlers.RequireBasicAuth(handlers.ReconcileRuns(store), cfg.BasicAuthUser, cfg.BasicAuthPass))
+	http.HandleFunc("/reconcile/runs/{id}", handlers.RequireBasicAuth(handlers.ReconcileRun(store), cfg.BasicAuthUser, cfg.BasicAuthPass))
+	http.HandleFunc("/reports/discounts", handlers.RequireBasicAuth(handlers.DiscountReport(store), cfg.BasicAuthUser, cfg.BasicAuthPass))
+	// The admin API changes data, so it has its own credentials and is off without them
+	if cfg.AdminAuthUser == "" || cfg.AdminAuthPass == "" {
+		log.Println("ADMIN_AUTH_USER or ADMIN_AUTH_PASS is not set, the admin API is disabled")
+	} else {
+		http.HandleFunc("/admin/jobs", handlers.RequireBasicAuth(handlers.Jobs(jobs), cfg.AdminAuthUser, cfg.AdminAuthPass))
+		http.HandleFunc("/admin/jobs/{id}", handlers.RequireBasicAuth(handlers.Job(jobs), cfg.AdminAuthUser, cfg.AdminAuthPass))
+		http.HandleFunc("/admin/resync/invoices", handlers.RequireBasicAuth(handlers.ResyncInvoices(sync), cfg.AdminAuthUser, cfg.AdminAuthPass))
+		http.HandleFunc("/admin/resync/invoices/{id}", handlers.RequireBasicAuth(handlers.ResyncInvoice(sync), cfg.AdminAuthUser, cfg.AdminAuthPass))
+		http.HandleFunc("/admin/resync/customers", handlers.RequireBasicAuth(handlers.ResyncCustomers(sync), cfg.AdminAuthUser, cfg.AdminAuthPass))
+		http.HandleFunc("/admin/resync/customers/{handle}", handlers.RequireBasicAuth(handlers.ResyncCustomer(sync), cfg.AdminAuthUser, cfg.AdminAuthPass))
+		http.HandleFunc("/admin/locks", handlers.RequireBasicAuth(handlers.Locks(sync), cfg.AdminAuthUser, cfg.AdminAuthPass))
+	}
+	for _, hook := range []struct{ path, country, secret string }{
+		{"/webhook/denmark", "DK", cfg.WebhookSecretDK},
+		{"/webhook/sweden", "SE", cfg.WebhookSecretSE},
+		{"/webhook/norway", "NO", cfg.WebhookSecretNO},
+	} {
+		if hook.secret == "" {
+			log.Printf("WEBHOOK_SECRET_%s is not set, accepting unsigned webhooks for %s", hook.country, hook.country)
 		}
-		nextPage = newNextPage
-		time.Sleep(100 * time.Millisecond) // Be nice to the API
+		http.HandleFunc(hook.path, handlers.Webhook(sync, hook.country, hook.secret))
+	}
+
+	srv := &http.Server{Addr: *addr}
+	serveErr := make(chan error, 1)
+	go func() {
+		log.Printf("Listening on %s\n", *addr)
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
+		log.Printf("Server stopped: %v", err)
+		return exitFailed
+	case <-ctx.Done():
 	}
-	log.Printf("Finished invoice backfill for country: %s", country)
-	return invoiceCount
+	log.Println("Shutting down server...")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	status := exitOK
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Server did not shut down cleanly: %v", err)
+		status = exitFailed
+	}
+	if err := jobs.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Jobs did not stop in time: %v", err)
+		status = exitFailed
+	}
+	log.Println("Server stopped")
+	return status
 }
 
-func runBackfill() {
-	log.Println("Starting full backfill process...")
-	totalCustomersBackfilled := 0
-	totalInvoicesBackfilled := 0
-	countries := []string{"DK", "SE", "NO"}
-	for _, country := range countries {
-		totalCustomersBackfilled += backfillCustomersForCountry(country)
-		totalInvoicesBackfilled += backfillInvoicesForCountry(country)
+// runBackfill handles "backfill [-entity e,...] [-account a,...] [-from date] [-to date] [-window size] [-dry-run]"
+func runBackfill(ctx context.Context, sync *syncer.Syncer, args []string) int {
+	fs := flag.NewFlagSet("backfill", flag.ExitOnError)
+	entityFlag := fs.String("entity", "", "Comma separated entities to backfill, e.g. invoices,customers (default all)")
+	accountFlag := fs.String("account", "", "Comma separated accounts to backfill, e.g. DK,SE (default all)")
+	fromFlag := fs.String("from", "", "First day to backfill, YYYY-MM-DD (default BACKFILL_FROM, checkpointed)")
+	toFlag := fs.String("to", "", "Last day to backfill, YYYY-MM-DD (default no end)")
+	windowFlag := fs.String("window", "", "Size of the date windows, e.g. week, month or 10d (default BACKFILL_WINDOW)")
+	dryRunFlag := fs.Bool("dry-run", false, "Print how Frisbii differs from the database without saving anything")
+	if rest := parseInterleaved(fs, args); len(rest) > 0 {
+		return usageError("Unexpected arguments to backfill: %v", rest)
+	}
+
+	opts := syncer.BackfillOptions{DryRun: *dryRunFlag}
+	for _, name := range splitList(*entityFlag) {
+		entity, err := syncer.ParseEntity(name)
+		if err != nil {
+			return usageError("%v", err)
+		}
+		opts.Entities = append(opts.Entities, entity)
+	}
+	accounts, err := parseAccounts(*accountFlag)
+	if err != nil {
+		return usageError("%v", err)
+	}
+	opts.Accounts = accounts
+	if opts.DateRange, err = parseDays(*fromFlag, *toFlag); err != nil {
+		return usageError("%v", err)
+	}
+	if opts.Window, err = syncer.ParseWindow(*windowFlag); err != nil {
+		return usageError("%v", err)
+	}
+
+	report := sync.Backfill(ctx, opts)
+	if opts.DryRun {
+		if status := printJSON(report.Results); status != exitOK {
+			return status
+		}
 	}
-	log.Printf("Full backfill process finished. Total Customers Backfilled: %d, Total Invoices Backfilled: %d", totalCustomersBackfilled, totalInvoicesBackfilled)
+	_, failed, stopped := report.Totals()
+	switch {
+	case stopped > 0 || report.LockedBy() != "":
+		return exitFailed
+	case failed > 0:
+		return exitPartial
+	}
+	return exitOK
+}
+
+// runReconcile handles "reconcile [-account a,...] [-from date] [-to date] [-repair] [-dry-run]"
+func runReconcile(ctx context.Context, sync *syncer.Syncer, args []string) int {
+	fs := flag.NewFlagSet("reconcile", flag.ExitOnError)
+	accountFlag := fs.String("account", "", "Comma separated accounts to reconcile, e.g. DK,SE (default all)")
+	fromFlag := fs.String("from", "", "First day to reconcile, YYYY-MM-DD (default yesterday)")
+	toFlag := fs.String("to", "", "Last day to reconcile, YYYY-MM-DD (default the -from day)")
+	repairFlag := fs.Bool("repair", false, "Save missing and mismatched invoices as they are in Frisbii")
+	dryRunFlag := fs.Bool("dry-run", false, "Print the reports without repairing or storing runs")
+	if rest := parseInterleaved(fs, args); len(rest) > 0 {
+		return usageError("Unexpected arguments to reconcile: %v", rest)
+	}
+
+	accounts, err := parseAccounts(*accountFlag)
+	if err != nil {
+		return usageError("%v", err)
+	}
+	if *fromFlag == "" {
+		*fromFlag = time.Now().UTC().AddDate(0, 0, -1).Format(time.DateOnly)
+	}
+	if *toFlag == "" {
+		*toFlag = *fromFlag
+	}
+	days, err := parseDays(*fromFlag, *toFlag)
+	if err != nil {
+		return usageError("%v", err)
+	}
+
+	runs, err := sync.Reconcile(ctx, syncer.ReconcileOptions{
+		Accounts: accounts, From: days.From, To: days.To, Repair: *repairFlag, DryRun: *dryRunFlag,
+	})
+	if err != nil {
+		log.Printf("Failed to store reconcile run: %v", err)
+		return exitFailed
+	}
+	if *dryRunFlag {
+		if status := printJSON(runs); status != exitOK {
+			return status
+		}
+	}
+	status := exitOK
+	for _, run := range runs {
+		fmt.Printf("%-3s run %-5d %-11s missing=%d extra=%d mismatched=%d repaired=%d %s\n",
+			run.Account, run.ID, run.Status, run.Missing, run.Extra, run.Mismatched, run.Repaired, run.Error)
+		switch run.Status {
+		case database.ReconcileFailed:
+			status = exitFailed
+		case database.ReconcileDifferences:
+			if status == exitOK {
+				status = exitDifferences
+			}
+		}
+	}
+	return status
+}
+
+// runSync handles "sync invoice|customer <id or handle> -account <account> [-dry-run]"
+func runSync(ctx context.Context, sync *syncer.Syncer, args []string) int {
+	fs := flag.NewFlagSet("sync", flag.ExitOnError)
+	accountFlag := fs.String("account", "", "Account of the invoice or customer, e.g. DK")
+	dryRunFlag := fs.Bool("dry-run", false, "Print how Frisbii differs from the database without saving")
+	rest := parseInterleaved(fs, args)
+	if len(rest) != 2 || *accountFlag == "" {
+		return usageError("Usage: sync invoice|customer <id or handle> -account DK [-dry-run]")
+	}
+	accounts, err := parseAccounts(*accountFlag)
+	if err != nil {
+		return usageError("%v", err)
+	}
+	account, kind, id := accounts[0], rest[0], rest[1]
+
+	var synced interface{}
+	switch {
+	case (kind == "invoice" || kind == "invoices") && *dryRunFlag:
+		synced, err = sync.DiffInvoice(ctx, account, id)
+	case kind == "invoice" || kind == "invoices":
+		synced, err = sync.SyncInvoice(ctx, account, id, database.SourceManual)
+	case (kind == "customer" || kind == "customers") && *dryRunFlag:
+		synced, err = sync.DiffCustomer(ctx, account, id)
+	case kind == "customer" || kind == "customers":
+		synced, err = sync.SyncCustomer(ctx, account, id)
+	default:
+		return usageError("Unknown entity %s, expected invoice or customer", kind)
+	}
+	if err != nil {
+		log.Printf("Failed to sync %s %s for %s: %v", kind, id, account, err)
+		return exitFailed
+	}
+
+	return printJSON(synced)
+}
+
+// printJSON writes v to stdout as indented JSON
+func printJSON(v interface{}) int {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		log.Printf("Failed to encode JSON: %v", err)
+		return exitFailed
+	}
+	return exitOK
 }
 
 func main() {
+	flag.Usage = usage
 	flag.Parse() // Parse command-line flags
 
 	setupLogging()
 
 	cfg := config.LoadConfig()
-	if err := database.InitDB(cfg); err != nil {
-		log.Fatalf("Failed to initialize database: %v", err)
+
+	command, args := "serve", flag.Args()
+	if len(args) > 0 {
+		command, args = args[0], args[1:]
+	}
+	switch command {
+	case "migrate":
+		runMigrate(cfg, args)
+		return
+	case "checkpoints":
+		runCheckpoints(cfg, args)
+		return
+	case "serve", "backfill", "reconcile", "sync":
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command: %s\n\n", command)
+		flag.Usage()
+		os.Exit(exitUsage)
+	}
+	os.Exit(run(cfg, command, args))
+}
+
+// run sets up the Frisbii client, the database and a syncer, and runs command with them
+func run(cfg config.Config, command string, args []string) int {
+	api.Configure(cfg)
+	if cfg.PspAPIURL != "" {
+		api.SetBaseURL(cfg.PspAPIURL)
+		log.Printf("Using Frisbii API at %s", cfg.PspAPIURL)
 	}
 
-	if *backfillFlag {
-		go runBackfill() // Run backfill in a goroutine
+	if cfg.PspHTTPMode != "" {
+		closeCassette, err := api.UseCassette(cfg.PspHTTPMode, cfg.PspCassette, cfg)
+		if err != nil {
+			log.Printf("Failed to set up %s of Frisbii traffic: %v", cfg.PspHTTPMode, err)
+			return exitFailed
+		}
+		defer closeCassette()
+		log.Printf("Frisbii traffic %s mode using %s", cfg.PspHTTPMode, cfg.PspCassette)
 	}
 
-	http.HandleFunc("/invoices/", handlers.RequireBasicAuth(handlers.Invoices("all"), cfg.BasicAuthUser, cfg.BasicAuthPass))
+	// Errors return instead of exiting, so the deferred close still writes a recorded cassette
+	store, err := database.Open(cfg)
+	if err != nil {
+		log.Printf("Failed to initialize database: %v", err)
+		return exitFailed
+	}
+	defer store.Close()
+	window, err := syncer.ParseWindow(cfg.BackfillWindow)
+	if err != nil {
+		log.Printf("Invalid BACKFILL_WINDOW: %v", err)
+		return exitFailed
+	}
+	sync := syncer.New(store, syncer.Options{
+		BatchSize:         cfg.BackfillBatchSize,
+		Workers:           cfg.BackfillWorkers,
+		RequestsPerSecond: cfg.BackfillRateLimit,
+		Window:            window,
+		RefreshLookback:   cfg.RefreshLookback,
+	})
 
-	http.HandleFunc("/virtual-office-invoices/", handlers.RequireBasicAuth(handlers.Invoices("virtualOffice"), cfg.BasicAuthUser, cfg.BasicAuthPass))
-	http.HandleFunc("/webhook/denmark", handlers.Webhook("DK"))
-	http.HandleFunc("/webhook/sweden", handlers.Webhook("SE"))
-	http.HandleFunc("/webhook/norway", handlers.Webhook("NO"))
+	if command == "serve" {
+		return runServe(cfg, store, sync, args)
+	}
 
-	addr := ":6969"
-	log.Printf("Listening on %s\n", addr)
-	log.Fatal(http.ListenAndServe(addr, nil))
+	// Stop on Ctrl-C after the current page; a checkpointed backfill resumes from there
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	switch command {
+	case "backfill":
+		return runBackfill(ctx, sync, args)
+	case "reconcile":
+		return runReconcile(ctx, sync, args)
+	}
+	return runSync(ctx, sync, args)
 }
@@ -0,0 +1,85 @@
+// Package money represents amounts as integer minor units together with their ISO 4217
+// currency, as Frisbii reports them.
+package money
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// exponents lists ISO 4217 currencies whose minor unit is not 1/100 of the major unit
+var exponents = map[string]int{
+	"BIF": 0, "CLP": 0, "DJF": 0, "GNF": 0, "ISK": 0, "JPY": 0, "KMF": 0, "KRW": 0,
+	"PYG": 0, "RWF": 0, "UGX": 0, "UYI": 0, "VND": 0, "VUV": 0, "XAF": 0, "XOF": 0, "XPF": 0,
+	"BHD": 3, "IQD": 3, "JOD": 3, "KWD": 3, "LYD": 3, "OMR": 3, "TND": 3,
+	"CLF": 4, "UYW": 4,
+}
+
+// Money is an amount in minor units (e.g. øre) of Currency
+type Money struct {
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+// New returns amount minor units of currency
+func New(amount int64, currency string) Money {
+	return Money{Amount: amount, Currency: strings.ToUpper(currency)}
+}
+
+// Exponent returns the number of decimals of a currency's minor unit, 2 unless listed
+func Exponent(currency string) int {
+	if e, ok := exponents[strings.ToUpper(currency)]; ok {
+		return e
+	}
+	return 2
+}
+
+// Decimal renders the amount in major units with a '.' decimal separator, e.g. "1234.50"
+func (m Money) Decimal() string {
+	return m.Format(".")
+}
+
+// Format renders the amount in major units with the given decimal separator and no
+// thousands separator, e.g. "1234,50" for Danish spreadsheets
+func (m Money) Format(decimalSeparator string) string {
+	exp := Exponent(m.Currency)
+	amount := m.Amount
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+	digits := strconv.FormatUint(uint64(amount), 10)
+	if exp == 0 {
+		return sign + digits
+	}
+	if len(digits) <= exp {
+		digits = strings.Repeat("0", exp-len(digits)+1) + digits
+	}
+	split := len(digits) - exp
+	return sign + digits[:split] + decimalSeparator + digits[split:]
+}
+
+// String renders the amount with its currency, e.g. "1234.50 DKK"
+func (m Money) String() string {
+	return m.Decimal() + " " + m.Currency
+}
+
+// Add returns the sum of two amounts in the same currency, or an error if the sum does
+// not fit in an int64
+func (m Money) Add(o Money) (Money, error) {
+	if m.Currency != o.Currency {
+		return Money{}, fmt.Errorf("cannot add %s to %s", o.Currency, m.Currency)
+	}
+	sum := m.Amount + o.Amount
+	if (o.Amount > 0 && sum < m.Amount) || (o.Amount < 0 && sum > m.Amount) {
+		return Money{}, fmt.Errorf("adding %s to %s overflows", o, m)
+	}
+	return Money{Amount: sum, Currency: m.Currency}, nil
+}
+
+// IsZero reports whether the amount is zero
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
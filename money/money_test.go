@@ -0,0 +1,81 @@
+package money
+
+import (
+	"math"
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	for _, tc := range []struct {
+		money     Money
+		separator string
+		want      string
+	}{
+		{New(123450, "DKK"), ".", "1234.50"},
+		{New(123450, "dkk"), ",", "1234,50"},
+		{New(0, "DKK"), ".", "0.00"},
+		{New(5, "DKK"), ".", "0.05"},
+		{New(-5, "DKK"), ".", "-0.05"},
+		{New(50, "DKK"), ".", "0.50"},
+		{New(-100, "SEK"), ",", "-1,00"},
+		{New(100, ""), ".", "1.00"},
+		{New(1234, "JPY"), ".", "1234"},
+		{New(-7, "ISK"), ",", "-7"},
+		{New(0, "JPY"), ".", "0"},
+		{New(1234, "KWD"), ".", "1.234"},
+		{New(-5, "BHD"), ".", "-0.005"},
+		{New(12345, "CLF"), ",", "1,2345"},
+		{New(1, "UYW"), ".", "0.0001"},
+		{New(math.MaxInt64, "DKK"), ".", "92233720368547758.07"},
+		{New(math.MinInt64, "DKK"), ".", "-92233720368547758.08"},
+	} {
+		if got := tc.money.Format(tc.separator); got != tc.want {
+			t.Errorf("%d %s Format(%q) = %q, want %q", tc.money.Amount, tc.money.Currency, tc.separator, got, tc.want)
+		}
+	}
+}
+
+func TestStringAndDecimal(t *testing.T) {
+	m := New(-123450, "nok")
+	if got := m.Decimal(); got != "-1234.50" {
+		t.Errorf("Decimal() = %q, want -1234.50", got)
+	}
+	if got := m.String(); got != "-1234.50 NOK" {
+		t.Errorf("String() = %q, want -1234.50 NOK", got)
+	}
+}
+
+func TestExponent(t *testing.T) {
+	for currency, want := range map[string]int{"DKK": 2, "EUR": 2, "": 2, "JPY": 0, "jpy": 0, "KWD": 3, "CLF": 4} {
+		if got := Exponent(currency); got != want {
+			t.Errorf("Exponent(%q) = %d, want %d", currency, got, want)
+		}
+	}
+}
+
+func TestAdd(t *testing.T) {
+	for _, tc := range []struct {
+		a, b    Money
+		want    Money
+		wantErr bool
+	}{
+		{New(100, "DKK"), New(250, "DKK"), New(350, "DKK"), false},
+		{New(100, "DKK"), New(-250, "DKK"), New(-150, "DKK"), false},
+		{New(100, "DKK"), New(100, "SEK"), Money{}, true},
+		{New(math.MaxInt64, "DKK"), New(1, "DKK"), Money{}, true},
+		{New(math.MinInt64, "DKK"), New(-1, "DKK"), Money{}, true},
+		{New(math.MaxInt64, "DKK"), New(math.MinInt64, "DKK"), New(-1, "DKK"), false},
+		{New(math.MaxInt64-1, "DKK"), New(1, "DKK"), New(math.MaxInt64, "DKK"), false},
+	} {
+		got, err := tc.a.Add(tc.b)
+		if (err != nil) != tc.wantErr || got != tc.want {
+			t.Errorf("%v.Add(%v) = %v, %v; want %v, error %v", tc.a, tc.b, got, err, tc.want, tc.wantErr)
+		}
+	}
+}
+
+func TestIsZero(t *testing.T) {
+	if !New(0, "DKK").IsZero() || New(-1, "DKK").IsZero() {
+		t.Error("IsZero is wrong for 0 or -1")
+	}
+}
@@ -0,0 +1,747 @@
+package syncer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AndersKaae/legaldesk_psp_sync/api"
+	"github.com/AndersKaae/legaldesk_psp_sync/database"
+)
+
+// Countries are the Frisbii accounts that are backfilled
+var Countries = []string{"DK", "SE", "NO"}
+
+// Entities as named by the Frisbii list API and in sync checkpoints, in backfill order
+const (
+	EntityCustomer             = "customer"
+	EntityDiscount             = "discount"
+	EntityCoupon               = "coupon"
+	EntitySubscriptionDiscount = "subscription_discount"
+	EntityInvoice              = "invoice"
+	EntityAdditionalCost       = "additional_cost"
+	EntityCredit               = "credit"
+)
+
+// Entities lists every backfilled entity in backfill order
+var Entities = []string{
+	EntityCustomer, EntityDiscount, EntityCoupon, EntitySubscriptionDiscount,
+	EntityInvoice, EntityAdditionalCost, EntityCredit,
+}
+
+// ParseEntity returns the entity named name, accepting plurals and dashes as in
+// "invoices" or "additional-costs"
+func ParseEntity(name string) (string, error) {
+	normalized := strings.ReplaceAll(strings.ToLower(name), "-", "_")
+	for _, entity := range Entities {
+		if normalized == entity || normalized == entity+"s" {
+			return entity, nil
+		}
+	}
+	return "", fmt.Errorf("unknown entity %s, expected one of %v", name, Entities)
+}
+
+// progressInterval is how often a running Backfill logs its combined progress
+const progressInterval = 30 * time.Second
+
+// entity describes how to page through one Frisbii list endpoint and store its rows.
+// Entities with savePage store a page in one transaction instead of item by item.
+type entity[T any] struct {
+	entity   string // Checkpoint name, one of the Entity constants
+	name     string // For logs
+	list     func(nextPage, country string, dateRange api.DateRange) ([]T, string, error)
+	created  func(item T) time.Time
+	save     func(ctx context.Context, item T) error
+	savePage func(ctx context.Context, items []T) ([]database.RowError, error)
+	diff     func(ctx context.Context, items []T) ([]RecordDiff, error) // For dry runs; nil if not supported
+	key      func(item T) string
+}
+
+// savePageOf saves a page of items and returns how many were saved and how many failed;
+// failures are logged
+func (e entity[T]) savePageOf(ctx context.Context, items []T, country string) (saved, failed int, err error) {
+	if e.savePage != nil {
+		rowErrors, err := e.savePage(ctx, items)
+		if err != nil {
+			return 0, 0, err
+		}
+		for _, f := range rowErrors {
+			log.Printf("Error saving backfilled %s %s to DB for %s: %v", e.name, f.Key, country, f.Err)
+		}
+		log.Printf("Backfilled %d of %d %ss for %s", len(items)-len(rowErrors), len(items), e.name, country)
+		return len(items) - len(rowErrors), len(rowErrors), nil
+	}
+
+	for _, item := range items {
+		if err := e.save(ctx, item); err != nil {
+			log.Printf("Error saving backfilled %s %s to DB for %s: %v", e.name, e.key(item), country, err)
+			failed++
+			continue
+		}
+		log.Printf("Backfilled %s %s for %s", e.name, e.key(item), country)
+		saved++
+	}
+	return saved, failed, nil
+}
+
+// BackfillResult is the outcome of backfilling one entity of one account
+type BackfillResult struct {
+	Account  string        `json:"account"`
+	Entity   string        `json:"entity"`
+	Pages    int           `json:"pages"`
+	Saved    int           `json:"saved"`
+	Failed   int           `json:"failed"`              // Items that could not be saved
+	Skipped  bool          `json:"skipped"`             // Running in another process
+	LockedBy string        `json:"locked_by,omitempty"` // The process holding the lease of the account, if skipped for it
+	Error    string        `json:"error,omitempty"`     // Why the backfill stopped or which windows failed
+	Duration time.Duration `json:"duration"`
+
+	Windows []WindowResult `json:"windows,omitempty"` // Coverage of the range, window by window
+	DryRun  *DryRunReport  `json:"dry_run,omitempty"` // What a dry run would have saved
+}
+
+// Statuses of a WindowResult
+const (
+	WindowCompleted = "completed"
+	WindowSkipped   = "skipped" // Completed by an earlier run that did not complete every window
+	WindowFailed    = "failed"
+)
+
+// WindowResult is the coverage of one date window of a backfill
+type WindowResult struct {
+	From     time.Time  `json:"from"`
+	To       *time.Time `json:"to,omitempty"` // Nil for an open ended window
+	Status   string     `json:"status"`
+	Pages    int        `json:"pages"`
+	Saved    int        `json:"saved"`
+	Failed   int        `json:"failed"`
+	Attempts int        `json:"attempts"`        // Failed attempts in this run
+	Total    int        `json:"total_attempts"`  // Failed attempts in every run so far
+	Error    string     `json:"error,omitempty"` // Of the last failed attempt of a failed window
+}
+
+// A failing window is tried windowAttempts times per run, waiting windowRetryDelay times the
+// number of failed attempts in between, before the backfill moves on to the next window
+const windowAttempts = 3
+
+var windowRetryDelay = 5 * time.Second
+
+// backfillRun is how the items of one account and entity are backfilled
+type backfillRun struct {
+	dateRange api.DateRange        // Zero for the checkpointed default range
+	window    Window               // Size of the windows the range is split into
+	dryRun    bool                 // Compare items with the stored rows instead of saving them
+	report    func(BackfillResult) // Called with the result so far after every page, if not nil
+}
+
+// backfillEntity pages through every item of e for country created within the range of run
+// and saves it, or in a dry run compares it with its stored row. The range is split into
+// windows, each its own pagination chain; a failing window is retried on its own and the
+// backfill moves on to the next window once its attempts are used up.
+//
+// A backfill of the default range checkpoints every window after every page, so a later run
+// resumes the windows this one did not complete. Once every window completed, the next run
+// starts over. Backfills of other ranges always start over. It stops when ctx is
+// cancelled. List requests wait for the rate limiter of the account.
+func backfillEntity[T any](ctx context.Context, s *Syncer, e entity[T], country string, run backfillRun) BackfillResult {
+	started := time.Now()
+	result := BackfillResult{Account: country, Entity: e.entity}
+	stop := func(format string, args ...interface{}) BackfillResult {
+		result.Error = fmt.Sprintf(format, args...)
+		log.Printf("Stopping %s backfill for %s: %s", e.name, country, result.Error)
+		result.Duration = time.Since(started)
+		return result
+	}
+
+	if run.dryRun {
+		if e.diff == nil {
+			return stop("dry run is not supported for %ss", e.name)
+		}
+		result.DryRun = &DryRunReport{}
+	}
+	checkpointed := run.dateRange.IsZero() && !run.dryRun
+	cp := &database.SyncCheckpoint{Account: country, Entity: e.entity}
+	storedWindows := make(map[time.Time]database.SyncWindow)
+	if checkpointed {
+		saved, err := s.store.GetSyncCheckpoint(ctx, country, e.entity)
+		if err != nil {
+			return stop("read checkpoint: %v", err)
+		}
+		switch {
+		case saved != nil && saved.Completed:
+			log.Printf("Starting %s backfill for %s over; the previous one completed %s",
+				e.name, country, saved.UpdatedAt.Format(time.RFC3339))
+			if err := s.store.ResetSyncCheckpoint(ctx, country, e.entity); err != nil {
+				return stop("reset checkpoint: %v", err)
+			}
+		case saved != nil:
+			cp = saved
+		}
+		windows, err := s.store.GetSyncWindows(ctx, country, e.entity)
+		if err != nil {
+			return stop("read windows: %v", err)
+		}
+		for _, w := range windows {
+			storedWindows[w.From.UTC()] = w
+		}
+	}
+	if cp.NextPageToken != "" {
+		log.Printf("Restarting %s backfill for %s in windows; its checkpoint predates them", e.name, country)
+		cp.NextPageToken = ""
+	}
+
+	dateRange := run.dateRange
+	if dateRange.From.IsZero() {
+		from, err := api.BackfillFrom()
+		if err != nil {
+			return stop("%v", err)
+		}
+		dateRange.From = from
+	}
+	windows := run.window.split(dateRange, time.Now().UTC())
+	verb := "backfill"
+	if run.dryRun {
+		verb = "dry run"
+	}
+	log.Printf("Starting %s %s for country: %s created %s in %d windows of %s",
+		e.name, verb, country, formatDateRange(dateRange), len(windows), run.window)
+
+	limiter := s.limiter(country)
+	// fetchWindow pages through one window from sw.NextPageToken until its last page
+	fetchWindow := func(window api.DateRange, sw *database.SyncWindow, wr *WindowResult) error {
+		nextPage := sw.NextPageToken
+		for {
+			if err := limiter.Wait(ctx); err != nil {
+				return err
+			}
+
+			items, newNextPage, err := e.list(nextPage, country, window)
+			if err != nil {
+				return fmt.Errorf("fetch list (nextPage: %s): %w", nextPage, err)
+			}
+
+			if run.dryRun {
+				diffs, err := e.diff(ctx, items)
+				if err != nil {
+					return fmt.Errorf("compare page (nextPage: %s): %w", nextPage, err)
+				}
+				for _, d := range diffs {
+					result.DryRun.add(d)
+				}
+			}
+			saved, failed := 0, 0
+			if !run.dryRun {
+				saved, failed, err = e.savePageOf(ctx, items, country)
+				if err != nil {
+					return fmt.Errorf("save page (nextPage: %s): %w", nextPage, err) // The page was rolled back
+				}
+			}
+			result.Pages++
+			result.Saved += saved
+			result.Failed += failed
+			wr.Pages++
+			wr.Saved += saved
+			wr.Failed += failed
+
+			sw.Pages++
+			sw.Items += int64(saved)
+			sw.Failed += int64(failed)
+			sw.NextPageToken = newNextPage
+			sw.Completed = newNextPage == ""
+			if sw.Completed {
+				sw.LastError = ""
+			}
+			cp.Pages++
+			cp.Items += int64(saved)
+			for _, item := range items {
+				if created := e.created(item); cp.LastCreated == nil || created.After(*cp.LastCreated) {
+					cp.LastCreated = &created
+				}
+			}
+			if checkpointed {
+				if err := s.store.SaveSyncWindow(ctx, sw); err != nil {
+					return fmt.Errorf("save window: %w", err)
+				}
+				if err := s.store.SaveSyncCheckpoint(ctx, cp); err != nil {
+					return fmt.Errorf("save checkpoint: %w", err)
+				}
+			}
+			if run.report != nil {
+				result.Duration = time.Since(started)
+				run.report(result)
+			}
+
+			if newNextPage == "" {
+				return nil // No more pages
+			}
+			nextPage = newNextPage
+		}
+	}
+
+	failedWindows := 0
+	for _, window := range windows {
+		sw := database.SyncWindow{Account: country, Entity: e.entity, From: window.From}
+		if !window.To.IsZero() {
+			to := window.To
+			sw.To = &to
+		}
+		if prev, ok := storedWindows[window.From.UTC()]; ok && sameWindowEnd(prev.To, sw.To) {
+			sw = prev // A window of another size starts over
+		}
+		wr := WindowResult{From: sw.From, To: sw.To, Total: sw.Attempts}
+		if sw.Completed {
+			wr.Status = WindowSkipped
+			result.Windows = append(result.Windows, wr)
+			continue
+		}
+		if len(windows) > 1 {
+			log.Printf("Backfilling %ss for %s created %s", e.name, country, formatDateRange(window))
+		}
+
+		for {
+			err := fetchWindow(window, &sw, &wr)
+			if err == nil {
+				wr.Status = WindowCompleted
+				break
+			}
+			if ctx.Err() != nil {
+				result.Windows = append(result.Windows, wr)
+				return stop("%v", ctx.Err())
+			}
+			wr.Attempts++
+			wr.Total++
+			sw.Attempts++
+			wr.Error, sw.LastError = err.Error(), err.Error()
+			if len(sw.LastError) > maxLastError {
+				sw.LastError = sw.LastError[:maxLastError]
+			}
+			if checkpointed {
+				if err := s.store.SaveSyncWindow(ctx, &sw); err != nil {
+					log.Printf("Failed to save %s window for %s: %v", e.name, country, err)
+				}
+			}
+			if wr.Attempts >= windowAttempts {
+				wr.Status = WindowFailed
+				failedWindows++
+				log.Printf("Giving up on %ss for %s created %s after %d attempts: %v",
+					e.name, country, formatDateRange(window), wr.Attempts, err)
+				break
+			}
+			delay := windowRetryDelay * time.Duration(wr.Attempts)
+			log.Printf("Retrying %ss for %s created %s in %s: %v", e.name, country, formatDateRange(window), delay, err)
+			select {
+			case <-ctx.Done():
+				result.Windows = append(result.Windows, wr)
+				return stop("%v", ctx.Err())
+			case <-time.After(delay):
+			}
+		}
+		if wr.Status == WindowCompleted {
+			wr.Error = ""
+		}
+		result.Windows = append(result.Windows, wr)
+	}
+
+	cp.Completed = failedWindows == 0
+	if checkpointed {
+		if err := s.store.SaveSyncCheckpoint(ctx, cp); err != nil {
+			return stop("save checkpoint: %v", err)
+		}
+	}
+	if failedWindows > 0 {
+		return stop("%d of %d windows failed", failedWindows, len(windows))
+	}
+	log.Printf("Finished %s %s for country: %s", e.name, verb, country)
+	result.Duration = time.Since(started)
+	return result
+}
+
+// sameWindowEnd reports whether two window ends, nil when open ended, are equal
+func sameWindowEnd(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+// backfillCustomers saves all customers for a given country
+func (s *Syncer) backfillCustomers(ctx context.Context, country string, run backfillRun) BackfillResult {
+	return backfillEntity(ctx, s, entity[api.Customer]{
+		entity: EntityCustomer,
+		name:   "customer",
+		list:   api.GetCustomerList,
+		savePage: func(ctx context.Context, customers []api.Customer) ([]database.RowError, error) {
+			dbCustomers := make([]database.Customer, len(customers))
+			for i, c := range customers {
+				dbCustomers[i] = CustomerToDB(c)
+			}
+			return s.store.CreateOrUpdateCustomers(ctx, dbCustomers, s.opts.BatchSize)
+		},
+		diff: func(ctx context.Context, customers []api.Customer) ([]RecordDiff, error) {
+			return s.diffCustomers(ctx, country, customers)
+		},
+		created: func(c api.Customer) time.Time { return c.Created },
+		key:     func(c api.Customer) string { return c.Handle },
+	}, country, run)
+}
+
+// backfillInvoices saves all invoices for a given country
+func (s *Syncer) backfillInvoices(ctx context.Context, country string, run backfillRun) BackfillResult {
+	return backfillEntity(ctx, s, entity[api.Invoice]{
+		entity: EntityInvoice,
+		name:   "invoice",
+		list:   api.GetInvoiceList,
+		savePage: func(ctx context.Context, invoices []api.Invoice) ([]database.RowError, error) {
+			dbInvoices := make([]database.Invoice, len(invoices))
+			byID := make(map[string]api.Invoice, len(invoices))
+			for i, inv := range invoices {
+				dbInvoices[i] = InvoiceToDB(inv)
+				byID[inv.ID] = inv
+			}
+			failed, err := s.store.CreateOrUpdateInvoices(ctx, dbInvoices, database.SourceBackfill, s.opts.BatchSize)
+			for _, f := range failed {
+				jsonInvoice, _ := json.Marshal(byID[f.Key])
+				log.Printf("API Invoice for %s: %s", f.Key, string(jsonInvoice))
+			}
+			return failed, err
+		},
+		diff: func(ctx context.Context, invoices []api.Invoice) ([]RecordDiff, error) {
+			return s.diffInvoices(ctx, country, invoices)
+		},
+		created: func(inv api.Invoice) time.Time { return inv.Created },
+		key:     func(inv api.Invoice) string { return inv.Handle },
+	}, country, run)
+}
+
+// backfillDiscounts saves all discounts for a given country
+func (s *Syncer) backfillDiscounts(ctx context.Context, country string, run backfillRun) BackfillResult {
+	return backfillEntity(ctx, s, entity[api.Discount]{
+		entity: EntityDiscount,
+		name:   "discount",
+		list:   api.GetDiscountList,
+		save: func(ctx context.Context, d api.Discount) error {
+			dbDiscount := DiscountToDB(d)
+			return s.store.CreateOrUpdateDiscount(ctx, &dbDiscount)
+		},
+		created: func(d api.Discount) time.Time { return d.Created },
+		key:     func(d api.Discount) string { return d.Handle },
+	}, country, run)
+}
+
+// backfillCoupons saves all coupons for a given country
+func (s *Syncer) backfillCoupons(ctx context.Context, country string, run backfillRun) BackfillResult {
+	return backfillEntity(ctx, s, entity[api.Coupon]{
+		entity: EntityCoupon,
+		name:   "coupon",
+		list:   api.GetCouponList,
+		save: func(ctx context.Context, c api.Coupon) error {
+			dbCoupon := CouponToDB(c)
+			return s.store.CreateOrUpdateCoupon(ctx, &dbCoupon)
+		},
+		created: func(c api.Coupon) time.Time { return c.Created },
+		key:     func(c api.Coupon) string { return c.Handle },
+	}, country, run)
+}
+
+// backfillSubscriptionDiscounts saves all subscription discounts for a given country
+func (s *Syncer) backfillSubscriptionDiscounts(ctx context.Context, country string, run backfillRun) BackfillResult {
+	return backfillEntity(ctx, s, entity[api.SubscriptionDiscount]{
+		entity: EntitySubscriptionDiscount,
+		name:   "subscription discount",
+		list:   api.GetSubscriptionDiscountList,
+		save: func(ctx context.Context, sd api.SubscriptionDiscount) error {
+			dbSubscriptionDiscount := SubscriptionDiscountToDB(sd)
+			return s.store.CreateOrUpdateSubscriptionDiscount(ctx, &dbSubscriptionDiscount)
+		},
+		created: func(sd api.SubscriptionDiscount) time.Time { return sd.Created },
+		key:     func(sd api.SubscriptionDiscount) string { return sd.Handle },
+	}, country, run)
+}
+
+// backfillAdditionalCosts saves all additional costs for a given country
+func (s *Syncer) backfillAdditionalCosts(ctx context.Context, country string, run backfillRun) BackfillResult {
+	return backfillEntity(ctx, s, entity[api.AdditionalCost]{
+		entity: EntityAdditionalCost,
+		name:   "additional cost",
+		list:   api.GetAdditionalCostList,
+		save: func(ctx context.Context, ac api.AdditionalCost) error {
+			dbAdditionalCost := AdditionalCostToDB(ac)
+			return s.store.CreateOrUpdateAdditionalCost(ctx, &dbAdditionalCost)
+		},
+		created: func(ac api.AdditionalCost) time.Time { return ac.Created },
+		key:     func(ac api.AdditionalCost) string { return ac.Handle },
+	}, country, run)
+}
+
+// backfillCredits saves all credits for a given country
+func (s *Syncer) backfillCredits(ctx context.Context, country string, run backfillRun) BackfillResult {
+	return backfillEntity(ctx, s, entity[api.Credit]{
+		entity: EntityCredit,
+		name:   "credit",
+		list:   api.GetCreditList,
+		save: func(ctx context.Context, c api.Credit) error {
+			dbCredit := CreditToDB(c)
+			return s.store.CreateOrUpdateCredit(ctx, &dbCredit)
+		},
+		created: func(c api.Credit) time.Time { return c.Created },
+		key:     func(c api.Credit) string { return c.Handle },
+	}, country, run)
+}
+
+// formatDateRange describes a list date range for logs
+func formatDateRange(r api.DateRange) string {
+	from, to := "from BACKFILL_FROM", ""
+	if !r.From.IsZero() {
+		from = "from " + r.From.Format(time.RFC3339)
+	}
+	if !r.To.IsZero() {
+		to = " until " + r.To.Format(time.RFC3339)
+	}
+	return from + to
+}
+
+func (s *Syncer) backfillEntity(ctx context.Context, country, entity string, run backfillRun) (BackfillResult, error) {
+	switch entity {
+	case EntityCustomer:
+		return s.backfillCustomers(ctx, country, run), nil
+	case EntityDiscount:
+		return s.backfillDiscounts(ctx, country, run), nil
+	case EntityCoupon:
+		return s.backfillCoupons(ctx, country, run), nil
+	case EntitySubscriptionDiscount:
+		return s.backfillSubscriptionDiscounts(ctx, country, run), nil
+	case EntityInvoice:
+		return s.backfillInvoices(ctx, country, run), nil
+	case EntityAdditionalCost:
+		return s.backfillAdditionalCosts(ctx, country, run), nil
+	case EntityCredit:
+		return s.backfillCredits(ctx, country, run), nil
+	default:
+		return BackfillResult{}, fmt.Errorf("unknown entity: %s", entity)
+	}
+}
+
+// BackfillOptions select what Backfill saves
+type BackfillOptions struct {
+	Accounts  []string      // Countries to backfill; empty means all Countries
+	Entities  []string      // Entities to backfill; empty means all Entities
+	DateRange api.DateRange // Only entities created in this range; zero means the checkpointed default
+	DryRun    bool          // Compare with the stored rows without writing; entities default to DryRunEntities
+	Window    Window        // Size of the windows the range is split into; zero means Options.Window
+
+	Progress func([]BackfillResult) // Called with the results so far after every page, if not nil
+}
+
+// DryRunEntities are the entities a dry run can compare with their stored rows
+var DryRunEntities = []string{EntityCustomer, EntityInvoice}
+
+// BackfillReport is the outcome of a full backfill, with one result per account and entity
+type BackfillReport struct {
+	Started  time.Time        `json:"started"`
+	Duration time.Duration    `json:"duration"`
+	Results  []BackfillResult `json:"results"`
+}
+
+// Totals sums the results of every account and entity; stopped counts the pairs whose
+// backfill stopped before the last page
+func (r BackfillReport) Totals() (saved, failed, stopped int) {
+	for _, res := range r.Results {
+		saved += res.Saved
+		failed += res.Failed
+		if res.Error != "" {
+			stopped++
+		}
+	}
+	return saved, failed, stopped
+}
+
+// LockedBy returns the process holding the lease of the first account skipped for one, or
+// an empty string if none was
+func (r BackfillReport) LockedBy() string {
+	return lockedBy(r.Results)
+}
+
+func lockedBy(results []BackfillResult) string {
+	for _, result := range results {
+		if result.LockedBy != "" {
+			return result.LockedBy
+		}
+	}
+	return ""
+}
+
+// Log writes a line per account and entity followed by the totals
+func (r BackfillReport) Log() {
+	for _, res := range r.Results {
+		status := "completed"
+		switch {
+		case res.DryRun != nil && res.Error == "":
+			status = fmt.Sprintf("dry run: %d new, %d changed, %d unchanged", res.DryRun.New, res.DryRun.Changed, res.DryRun.Unchanged)
+		case res.LockedBy != "":
+			status = "skipped, running in " + res.LockedBy
+		case res.Skipped:
+			status = "skipped"
+		case res.Error != "":
+			status = "stopped: " + res.Error
+		}
+		log.Printf("Backfill %s %-22s saved %6d failed %4d pages %4d in %-10s %s",
+			res.Account, res.Entity, res.Saved, res.Failed, res.Pages, res.Duration.Round(time.Millisecond), status)
+		if len(res.Windows) > 1 {
+			for _, w := range res.Windows {
+				if w.Status == WindowCompleted && w.Attempts == 0 {
+					continue // Only windows that were skipped, retried or failed
+				}
+				to := "now"
+				if w.To != nil {
+					to = w.To.Format(time.DateOnly)
+				}
+				log.Printf("  window %s to %-10s %-9s saved %6d failed %4d pages %4d attempts %d (%d in all runs) %s",
+					w.From.Format(time.DateOnly), to, w.Status, w.Saved, w.Failed, w.Pages, w.Attempts, w.Total, w.Error)
+			}
+		}
+	}
+	saved, failed, stopped := r.Totals()
+	log.Printf("Backfill process finished in %s. Saved: %d, Failed: %d, Stopped early: %d of %d",
+		r.Duration.Round(time.Millisecond), saved, failed, stopped, len(r.Results))
+}
+
+// Backfill saves the selected entities for the selected countries. Account/entity pairs are backfilled by a
+// pool of Options.Workers goroutines; pairs of the same account share its rate limit. Accounts
+// another process is backfilling are skipped, with that process as LockedBy. The
+// combined progress is logged every progressInterval and the report once it is done.
+func (s *Syncer) Backfill(ctx context.Context, opts BackfillOptions) BackfillReport {
+	accounts, entities := opts.Accounts, opts.Entities
+	if len(accounts) == 0 {
+		accounts = Countries
+	}
+	if len(entities) == 0 {
+		entities = Entities
+		if opts.DryRun {
+			entities = DryRunEntities
+		}
+	}
+	window := opts.Window
+	if window.IsZero() {
+		window = s.opts.Window
+	}
+	log.Printf("Starting backfill process of %v for %v in windows of %s...", entities, accounts, window)
+	report := BackfillReport{Started: time.Now()}
+	for _, country := range accounts {
+		for _, entity := range entities {
+			report.Results = append(report.Results, BackfillResult{Account: country, Entity: entity})
+		}
+	}
+
+	// Each account is backfilled by one process at a time; dry runs write nothing and need
+	// no lease. Pairs of accounts whose lease is held elsewhere are not run.
+	accountCtx := make(map[string]context.Context)
+	blocked := make(map[int]bool)
+	for _, country := range accounts {
+		if opts.DryRun {
+			accountCtx[country] = ctx
+			continue
+		}
+		lctx, release, holder, err := s.acquireLease(ctx, leaseName(database.JobBackfill, country))
+		if err == nil && lctx != nil {
+			defer release()
+			accountCtx[country] = lctx
+			continue
+		}
+		if err == nil {
+			log.Printf("Skipping backfill of %s: %s is backfilling it", country, holder)
+		}
+		for i, pair := range report.Results {
+			if pair.Account != country {
+				continue
+			}
+			blocked[i] = true
+			if err != nil {
+				report.Results[i].Error = err.Error()
+			} else {
+				report.Results[i].Skipped, report.Results[i].LockedBy = true, holder
+			}
+		}
+	}
+
+	var mu sync.Mutex
+	running := make(map[int]bool)
+	done := len(blocked)
+	logProgress := func() {
+		mu.Lock()
+		defer mu.Unlock()
+		saved, failed, _ := report.Totals()
+		log.Printf("Backfill progress: %d of %d done, %d running, %d saved, %d failed",
+			done, len(report.Results), len(running), saved, failed)
+	}
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for range max(s.opts.Workers, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				pair := report.Results[i]
+				mu.Lock()
+				running[i] = true
+				mu.Unlock()
+				result, err := s.backfillEntity(accountCtx[pair.Account], pair.Account, pair.Entity, backfillRun{
+					dateRange: opts.DateRange,
+					window:    window,
+					dryRun:    opts.DryRun,
+					report: func(progress BackfillResult) {
+						mu.Lock()
+						defer mu.Unlock()
+						report.Results[i] = progress
+						if opts.Progress != nil {
+							opts.Progress(append([]BackfillResult(nil), report.Results...))
+						}
+					},
+				})
+				if err != nil {
+					result = pair
+					result.Error = err.Error()
+				}
+				mu.Lock()
+				report.Results[i] = result
+				delete(running, i)
+				done++
+				mu.Unlock()
+			}
+		}()
+	}
+
+	go func() {
+		for i := range report.Results {
+			if !blocked[i] {
+				jobs <- i
+			}
+		}
+		close(jobs)
+	}()
+	finished := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+wait:
+	for {
+		select {
+		case <-ticker.C:
+			logProgress()
+		case <-finished:
+			break wait
+		}
+	}
+
+	report.Duration = time.Since(report.Started)
+	report.Log()
+	return report
+}
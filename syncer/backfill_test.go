@@ -0,0 +1,164 @@
+package syncer
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/AndersKaae/legaldesk_psp_sync/api"
+	"github.com/AndersKaae/legaldesk_psp_sync/api/frisbiitest"
+	"github.com/AndersKaae/legaldesk_psp_sync/database"
+)
+
+// newFakeFrisbii starts a fake Frisbii API and points the api package at it for the test
+func newFakeFrisbii(t *testing.T) *frisbiitest.Server {
+	t.Helper()
+	fake := frisbiitest.NewServer()
+	t.Cleanup(fake.Close)
+	t.Cleanup(fake.Install())
+	return fake
+}
+
+// addInvoices adds n settled invoices for account, one an hour from start
+func addInvoices(fake *frisbiitest.Server, account string, n int, start time.Time) []string {
+	ids := make([]string, n)
+	for i := range ids {
+		created := start.Add(time.Duration(i) * time.Hour)
+		ids[i] = fmt.Sprintf("inv_%s_%d", account, i)
+		fake.AddInvoice(account, api.InvoiceResponse{
+			ID: ids[i], Handle: fmt.Sprintf("inv-%s-%d", account, i), Customer: "cust-1", Currency: "DKK",
+			Created: created, Plan: "plan-basic", OrgAmount: int64(1000 + i), State: "settled", Settled: created.Add(time.Minute),
+		})
+	}
+	return ids
+}
+
+func TestBackfillAgainstFakeFrisbii(t *testing.T) {
+	fake := newFakeFrisbii(t)
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	ids := addInvoices(fake, "DK", 2*frisbiitest.DefaultPageSize+5, start)
+	addInvoices(fake, "SE", 3, start)
+	fake.AddCustomer("DK", api.CustomerResponse{Handle: "cust-1", Email: "a@example.com", Created: start})
+
+	store := database.NewMemoryStore()
+	s := New(store, Options{BatchSize: 10})
+	report := s.Backfill(context.Background(), BackfillOptions{
+		Accounts: []string{"DK"},
+		Entities: []string{EntityInvoice, EntityCustomer},
+	})
+
+	saved, failed, stopped := report.Totals()
+	if saved != len(ids)+1 || failed != 0 || stopped != 0 {
+		t.Fatalf("Totals() = %d saved, %d failed, %d stopped; want %d, 0, 0: %+v", saved, failed, stopped, len(ids)+1, report.Results)
+	}
+	for _, result := range report.Results {
+		if result.Entity == EntityInvoice && result.Pages != 3 {
+			t.Errorf("invoice backfill fetched %d pages, want 3", result.Pages)
+		}
+	}
+
+	invoices, err := store.GetInvoicesByID(context.Background(), append(ids, "inv_SE_0"))
+	if err != nil {
+		t.Fatalf("GetInvoicesByID: %v", err)
+	}
+	if len(invoices) != len(ids) {
+		t.Errorf("stored %d invoices, want the %d of DK only", len(invoices), len(ids))
+	}
+	customers, err := store.GetCustomersByHandle(context.Background(), []string{"cust-1"})
+	if err != nil {
+		t.Fatalf("GetCustomersByHandle: %v", err)
+	}
+	if len(customers) != 1 || customers[0].Email != "a@example.com" {
+		t.Errorf("stored customers = %+v", customers)
+	}
+
+	cp, err := store.GetSyncCheckpoint(context.Background(), "DK", EntityInvoice)
+	if err != nil {
+		t.Fatalf("GetSyncCheckpoint: %v", err)
+	}
+	if cp == nil || !cp.Completed || cp.Items != int64(len(ids)) {
+		t.Errorf("invoice checkpoint = %+v, want completed with %d items", cp, len(ids))
+	}
+}
+
+func TestBackfillResumesOnlyIncompleteCheckpoints(t *testing.T) {
+	fake := newFakeFrisbii(t)
+	ids := addInvoices(fake, "DK", 2*frisbiitest.DefaultPageSize+5, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	store := database.NewMemoryStore()
+	s := New(store, Options{})
+	backfill := func(ctx context.Context, progress func([]BackfillResult)) BackfillResult {
+		t.Helper()
+		report := s.Backfill(ctx, BackfillOptions{Accounts: []string{"DK"}, Entities: []string{EntityInvoice}, Progress: progress})
+		if len(report.Results) != 1 {
+			t.Fatalf("results = %+v, want one", report.Results)
+		}
+		return report.Results[0]
+	}
+
+	// Interrupted after its first page
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if result := backfill(ctx, func([]BackfillResult) { cancel() }); result.Pages != 1 || result.Error == "" {
+		t.Fatalf("interrupted backfill = %+v, want stopped after 1 page", result)
+	}
+
+	if result := backfill(context.Background(), nil); result.Pages != 2 || result.Error != "" || result.Skipped {
+		t.Errorf("resumed backfill = %+v, want the 2 remaining pages", result)
+	}
+	cp, err := store.GetSyncCheckpoint(context.Background(), "DK", EntityInvoice)
+	if err != nil {
+		t.Fatalf("GetSyncCheckpoint: %v", err)
+	}
+	if cp == nil || !cp.Completed || cp.Items != int64(len(ids)) {
+		t.Fatalf("checkpoint after resuming = %+v, want completed with %d items", cp, len(ids))
+	}
+
+	// A completed checkpoint is not resumed; the next backfill starts over
+	if result := backfill(context.Background(), nil); result.Pages != 3 || result.Saved != len(ids) || result.Skipped {
+		t.Errorf("backfill after completing = %+v, want all 3 pages again", result)
+	}
+	cp, err = store.GetSyncCheckpoint(context.Background(), "DK", EntityInvoice)
+	if err != nil {
+		t.Fatalf("GetSyncCheckpoint: %v", err)
+	}
+	if cp == nil || !cp.Completed || cp.Items != int64(len(ids)) || cp.Pages != 3 {
+		t.Errorf("checkpoint after starting over = %+v, want completed with 3 pages and %d items", cp, len(ids))
+	}
+}
+
+func TestBackfillRetriesWindowFailedInEarlierRuns(t *testing.T) {
+	defer func(delay time.Duration) { windowRetryDelay = delay }(windowRetryDelay)
+	windowRetryDelay = time.Millisecond
+
+	fake := newFakeFrisbii(t)
+	ids := addInvoices(fake, "DK", 5, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	store := database.NewMemoryStore()
+	ctx := context.Background()
+	// An earlier run used up its attempts on the window
+	from, err := api.BackfillFrom()
+	if err != nil {
+		t.Fatalf("BackfillFrom: %v", err)
+	}
+	if err := store.SaveSyncCheckpoint(ctx, &database.SyncCheckpoint{Account: "DK", Entity: EntityInvoice}); err != nil {
+		t.Fatalf("SaveSyncCheckpoint: %v", err)
+	}
+	if err := store.SaveSyncWindow(ctx, &database.SyncWindow{
+		Account: "DK", Entity: EntityInvoice, From: from, Attempts: windowAttempts, LastError: "unexpected status 500",
+	}); err != nil {
+		t.Fatalf("SaveSyncWindow: %v", err)
+	}
+	fake.InjectFault(frisbiitest.Fault{Path: "/", Account: "DK", Status: 500, Times: 1})
+
+	report := New(store, Options{}).Backfill(ctx, BackfillOptions{Accounts: []string{"DK"}, Entities: []string{EntityInvoice}})
+	if len(report.Results) != 1 || len(report.Results[0].Windows) != 1 {
+		t.Fatalf("results = %+v, want one with one window", report.Results)
+	}
+	result, window := report.Results[0], report.Results[0].Windows[0]
+	if result.Error != "" || result.Saved != len(ids) {
+		t.Errorf("backfill = %+v, want all %d invoices saved", result, len(ids))
+	}
+	if window.Status != WindowCompleted || window.Attempts != 1 || window.Total != windowAttempts+1 {
+		t.Errorf("window = %+v, want completed after 1 failed attempt in this run and %d in all", window, windowAttempts+1)
+	}
+}
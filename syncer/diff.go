@@ -0,0 +1,206 @@
+package syncer
+
+import (
+	"context"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/AndersKaae/legaldesk_psp_sync/api"
+	"github.com/AndersKaae/legaldesk_psp_sync/database"
+	"github.com/AndersKaae/legaldesk_psp_sync/money"
+)
+
+// Kinds of RecordDiff
+const (
+	DiffNew       = "new"       // Not stored yet
+	DiffChanged   = "changed"   // Stored with different values
+	DiffUnchanged = "unchanged" // Stored as it is in Frisbii
+)
+
+// FieldChange is a field whose stored value differs from the value in Frisbii
+type FieldChange struct {
+	Field   string      `json:"field"`
+	Stored  interface{} `json:"stored"`
+	Frisbii interface{} `json:"frisbii"`
+}
+
+// RecordDiff is what saving one record from Frisbii would change
+type RecordDiff struct {
+	Entity  string        `json:"entity"`
+	Account string        `json:"account"`
+	Key     string        `json:"key"` // Invoice or customer handle
+	Kind    string        `json:"kind"`
+	Changes []FieldChange `json:"changes,omitempty"`
+}
+
+// DryRunReport counts the records a dry run compared and lists the new and changed ones
+type DryRunReport struct {
+	New       int          `json:"new"`
+	Changed   int          `json:"changed"`
+	Unchanged int          `json:"unchanged"`
+	Records   []RecordDiff `json:"records"`
+}
+
+// add counts d, listing it unless it is unchanged
+func (r *DryRunReport) add(d RecordDiff) {
+	switch d.Kind {
+	case DiffNew:
+		r.New++
+	case DiffChanged:
+		r.Changed++
+	default:
+		r.Unchanged++
+		return
+	}
+	r.Records = append(r.Records, d)
+}
+
+// diffRecord compares a stored record, nil if there is none, with the record from Frisbii
+func diffRecord[T any](entity, account, key string, stored *T, frisbii T) RecordDiff {
+	d := RecordDiff{Entity: entity, Account: account, Key: key, Kind: DiffNew}
+	if stored == nil {
+		return d
+	}
+	d.Changes = diffFields(*stored, frisbii)
+	d.Kind = DiffUnchanged
+	if len(d.Changes) > 0 {
+		d.Kind = DiffChanged
+	}
+	return d
+}
+
+// diffFields returns the fields of two structs of the same type that differ, named by their
+// JSON tag or else in snake case. Times are compared to the second, as MySQL stores them.
+// Fields that are not stored with the record, such as an invoice's customer email, are skipped.
+func diffFields(stored, frisbii interface{}) []FieldChange {
+	var changes []FieldChange
+	a, b := reflect.ValueOf(stored), reflect.ValueOf(frisbii)
+	for i := 0; i < a.NumField(); i++ {
+		field := a.Type().Field(i)
+		name := fieldName(field)
+		if name == "customer_email" {
+			continue
+		}
+		x, y := a.Field(i).Interface(), b.Field(i).Interface()
+		if !sameValue(x, y) {
+			changes = append(changes, FieldChange{Field: name, Stored: x, Frisbii: y})
+		}
+	}
+	return changes
+}
+
+func fieldName(field reflect.StructField) string {
+	if tag, _, _ := strings.Cut(field.Tag.Get("json"), ","); tag != "" && tag != "-" {
+		return tag
+	}
+	var b strings.Builder
+	for i, r := range field.Name {
+		if unicode.IsUpper(r) && i > 0 {
+			b.WriteByte('_')
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
+
+func sameValue(x, y interface{}) bool {
+	switch x := x.(type) {
+	case time.Time:
+		return sameTime(x, y.(time.Time))
+	case money.Money:
+		return x.Amount == y.(money.Money).Amount
+	case database.InvoiceStates:
+		return sameStates(x, y.(database.InvoiceStates))
+	case []database.InvoiceDiscount:
+		return sameDiscounts(x, y.([]database.InvoiceDiscount))
+	}
+	return reflect.DeepEqual(x, y)
+}
+
+// sameTime compares stored times, which MySQL keeps to the second
+func sameTime(a, b time.Time) bool {
+	return a.Sub(b).Abs() < time.Second
+}
+
+func sameStates(a, b database.InvoiceStates) bool {
+	for state, at := range a {
+		other := b[state]
+		if (at == nil) != (other == nil) || (at != nil && !sameTime(*at, *other)) {
+			return false
+		}
+	}
+	for state, at := range b {
+		if _, ok := a[state]; !ok && at != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// sameDiscounts compares discount links regardless of their order
+func sameDiscounts(a, b []database.InvoiceDiscount) bool {
+	byLine := func(x, y database.InvoiceDiscount) int { return strings.Compare(x.OrderLineID, y.OrderLineID) }
+	a, b = slices.Clone(a), slices.Clone(b)
+	slices.SortFunc(a, byLine)
+	slices.SortFunc(b, byLine)
+	return slices.Equal(a, b)
+}
+
+// diffInvoices compares invoices from Frisbii with their stored rows
+func (s *Syncer) diffInvoices(ctx context.Context, country string, invoices []api.Invoice) ([]RecordDiff, error) {
+	ids := make([]string, len(invoices))
+	for i, invoice := range invoices {
+		ids[i] = invoice.ID
+	}
+	stored, err := s.store.GetInvoicesByID(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[string]*database.Invoice, len(stored))
+	for i := range stored {
+		byID[stored[i].ID] = &stored[i]
+	}
+
+	diffs := make([]RecordDiff, len(invoices))
+	for i, invoice := range invoices {
+		diffs[i] = diffRecord(EntityInvoice, country, invoice.Handle, byID[invoice.ID], InvoiceToDB(invoice))
+	}
+	return diffs, nil
+}
+
+// diffCustomers compares customers from Frisbii with their stored rows
+func (s *Syncer) diffCustomers(ctx context.Context, country string, customers []api.Customer) ([]RecordDiff, error) {
+	handles := make([]string, len(customers))
+	for i, customer := range customers {
+		handles[i] = customer.Handle
+	}
+	stored, err := s.store.GetCustomersByHandle(ctx, handles)
+	if err != nil {
+		return nil, err
+	}
+	byHandle := make(map[string]*database.Customer, len(stored))
+	for i := range stored {
+		byHandle[stored[i].Handle] = &stored[i]
+	}
+
+	diffs := make([]RecordDiff, len(customers))
+	for i, customer := range customers {
+		diffs[i] = diffRecord(EntityCustomer, country, customer.Handle, byHandle[customer.Handle], CustomerToDB(customer))
+	}
+	return diffs, nil
+}
+
+// DiffInvoice fetches one invoice and compares it with its stored row without saving it
+func (s *Syncer) DiffInvoice(ctx context.Context, country, invoiceID string) (RecordDiff, error) {
+	_, diff, err := s.fetchInvoiceDiff(ctx, country, invoiceID)
+	return diff, err
+}
+
+// DiffCustomer fetches one customer and compares it with its stored row without saving it
+func (s *Syncer) DiffCustomer(ctx context.Context, country, handle string) (RecordDiff, error) {
+	_, diff, err := s.fetchCustomerDiff(ctx, country, handle)
+	return diff, err
+}
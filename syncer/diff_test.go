@@ -0,0 +1,163 @@
+package syncer
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/AndersKaae/legaldesk_psp_sync/api"
+	"github.com/AndersKaae/legaldesk_psp_sync/database"
+	"github.com/AndersKaae/legaldesk_psp_sync/money"
+)
+
+func TestDiffFields(t *testing.T) {
+	created := time.Date(2025, 1, 1, 10, 0, 0, 0, time.UTC)
+	settled := created.Add(time.Minute)
+	base := database.Invoice{
+		ID: "inv_1", Handle: "inv-1", Currency: "DKK", Created: created, State: "settled",
+		OrgAmount: money.New(1000, "DKK"), States: database.InvoiceStates{"created": &created, "settled": &settled},
+		Discounts: []database.InvoiceDiscount{{OrderLineID: "ol-1", Amount: money.New(-100, "DKK")}, {OrderLineID: "ol-2"}},
+	}
+
+	for _, tc := range []struct {
+		name   string
+		change func(i *database.Invoice)
+		want   []string
+	}{
+		{"identical", func(i *database.Invoice) {}, nil},
+		{"customer email is not stored with the invoice", func(i *database.Invoice) {
+			i.CustomerEmail = sql.NullString{String: "a@example.com", Valid: true}
+		}, nil},
+		{"money compares amounts only", func(i *database.Invoice) { i.OrgAmount = money.New(1000, "") }, nil},
+		{"different amount", func(i *database.Invoice) { i.OrgAmount = money.New(1001, "DKK") }, []string{"org_amount"}},
+		{"times within a second", func(i *database.Invoice) { i.Created = created.Add(999 * time.Millisecond) }, nil},
+		{"times a second apart", func(i *database.Invoice) { i.Created = created.Add(time.Second) }, []string{"created"}},
+		{"state times within a second", func(i *database.Invoice) {
+			at := settled.Add(500 * time.Millisecond)
+			i.States = database.InvoiceStates{"created": &created, "settled": &at}
+		}, nil},
+		{"nil state times", func(i *database.Invoice) {
+			i.States = database.InvoiceStates{"created": &created, "settled": &settled, "failed": nil}
+		}, nil},
+		{"new state", func(i *database.Invoice) {
+			i.States = database.InvoiceStates{"created": &created, "settled": &settled, "failed": &settled}
+		}, []string{"states"}},
+		{"discounts in another order", func(i *database.Invoice) {
+			i.Discounts = []database.InvoiceDiscount{i.Discounts[1], i.Discounts[0]}
+		}, nil},
+		{"different discount", func(i *database.Invoice) {
+			i.Discounts = []database.InvoiceDiscount{i.Discounts[0]}
+		}, []string{"discounts"}},
+		{"several fields", func(i *database.Invoice) { i.State, i.Plan = "failed", "plan-pro" }, []string{"plan", "state"}},
+	} {
+		frisbii := base
+		frisbii.States = database.InvoiceStates{"created": &created, "settled": &settled}
+		frisbii.Discounts = slices.Clone(base.Discounts)
+		tc.change(&frisbii)
+		var got []string
+		for _, c := range diffFields(base, frisbii) {
+			got = append(got, c.Field)
+		}
+		if !slices.Equal(got, tc.want) {
+			t.Errorf("%s: changed fields = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestFieldName(t *testing.T) {
+	type record struct {
+		AmountVAT  int `json:"amount_vat"`
+		OrgAmount  int
+		Handle     string `json:"-"`
+		Subscribed bool   `json:"subscribed,omitempty"`
+	}
+	var got []string
+	for _, c := range diffFields(record{}, record{1, 2, "a", true}) {
+		got = append(got, c.Field)
+	}
+	if want := []string{"amount_vat", "org_amount", "handle", "subscribed"}; !slices.Equal(got, want) {
+		t.Errorf("field names = %v, want %v", got, want)
+	}
+}
+
+// TestDryRunBackfillMatchesStoredRows backfills each store and then dry runs the same
+// backfill: every record must come back unchanged and nothing may be written. A field that
+// does not survive a store round trip would show up here as changed.
+func TestDryRunBackfillMatchesStoredRows(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	sqlite, err := database.OpenSQLite(filepath.Join(t.TempDir(), "psp.db"))
+	if err != nil {
+		t.Fatalf("OpenSQLite: %v", err)
+	}
+	defer sqlite.Close()
+	if _, err := sqlite.MigrateUp(); err != nil {
+		t.Fatalf("MigrateUp: %v", err)
+	}
+
+	for name, store := range map[string]database.Store{"memory": database.NewMemoryStore(), "sqlite": sqlite} {
+		t.Run(name, func(t *testing.T) {
+			fake := newFakeFrisbii(t)
+			ids := addInvoices(fake, "DK", 5, start)
+			fake.AddCustomer("DK", api.CustomerResponse{Handle: "cust-1", Email: "a@example.com", Created: start, SettledAmount: 12345})
+			ctx := context.Background()
+			s := New(store, Options{})
+			opts := BackfillOptions{Accounts: []string{"DK"}, Entities: DryRunEntities}
+			if saved, failed, stopped := s.Backfill(ctx, opts).Totals(); saved != len(ids)+1 || failed+stopped != 0 {
+				t.Fatalf("Backfill = %d saved, %d failed, %d stopped", saved, failed, stopped)
+			}
+
+			// Changed in Frisbii after the backfill, so the dry run has something to report
+			fake.AddInvoice("DK", api.InvoiceResponse{
+				ID: ids[0], Handle: "inv-DK-0", Customer: "cust-1", Currency: "DKK", Created: start,
+				Plan: "plan-basic", OrgAmount: 999, State: "settled", Settled: start.Add(time.Minute),
+			})
+			fake.AddInvoice("DK", api.InvoiceResponse{
+				ID: "inv_new", Handle: "inv-new", Customer: "cust-1", Currency: "DKK", Created: start.Add(time.Hour),
+				Plan: "plan-basic", OrgAmount: 500, State: "created",
+			})
+			before, err := store.GetInvoicesByID(ctx, []string{ids[0], "inv_new"})
+			if err != nil {
+				t.Fatalf("GetInvoicesByID: %v", err)
+			}
+			checkpoint, err := store.GetSyncCheckpoint(ctx, "DK", EntityInvoice)
+			if err != nil || checkpoint == nil {
+				t.Fatalf("GetSyncCheckpoint = %+v, %v", checkpoint, err)
+			}
+
+			opts.DryRun = true
+			report := s.Backfill(ctx, opts)
+			var dryRun DryRunReport
+			for _, result := range report.Results {
+				if result.DryRun == nil || result.Error != "" {
+					t.Fatalf("dry run result = %+v", result)
+				}
+				dryRun.New += result.DryRun.New
+				dryRun.Changed += result.DryRun.Changed
+				dryRun.Unchanged += result.DryRun.Unchanged
+				dryRun.Records = append(dryRun.Records, result.DryRun.Records...)
+			}
+			if dryRun.New != 1 || dryRun.Changed != 1 || dryRun.Unchanged != len(ids) {
+				t.Errorf("dry run = %+v, want 1 new, 1 changed and %d unchanged", dryRun, len(ids))
+			}
+			for _, d := range dryRun.Records {
+				if d.Kind == DiffChanged && (d.Key != "inv-DK-0" || len(d.Changes) != 1 || d.Changes[0].Field != "org_amount") {
+					t.Errorf("changed record = %+v, want only the org_amount of inv-DK-0", d)
+				}
+			}
+
+			after, err := store.GetInvoicesByID(ctx, []string{ids[0], "inv_new"})
+			if err != nil {
+				t.Fatalf("GetInvoicesByID: %v", err)
+			}
+			if len(after) != len(before) || len(after) != 1 || after[0].OrgAmount.Amount != 1000 {
+				t.Errorf("stored invoices after the dry run = %+v, want them unchanged", after)
+			}
+			if got, err := store.GetSyncCheckpoint(ctx, "DK", EntityInvoice); err != nil || got == nil || !got.UpdatedAt.Equal(checkpoint.UpdatedAt) || got.Items != checkpoint.Items {
+				t.Errorf("checkpoint after the dry run = %+v, %v; want %+v", got, err, checkpoint)
+			}
+		})
+	}
+}
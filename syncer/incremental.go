@@ -0,0 +1,244 @@
+package syncer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"sync/atomic"
+	"time"
+
+	"github.com/AndersKaae/legaldesk_psp_sync/api"
+	"github.com/AndersKaae/legaldesk_psp_sync/database"
+)
+
+// IncrementalEntities are the entities pulled by SyncChanges on a schedule
+var IncrementalEntities = []string{EntityCustomer, EntityInvoice}
+
+// incrementalOverlap is how far before its high-water mark a run starts, so items that
+// show up in the list API a little late are not missed
+const incrementalOverlap = 5 * time.Minute
+
+// maxLastError is the length of the last_error column
+const maxLastError = 1024
+
+// openInvoiceStates are the invoice states that can still change
+var openInvoiceStates = []string{"created", "pending", "dunning", "authorized"}
+
+// openInvoicePageSize is how many stored open invoices are read at a time to be refreshed
+const openInvoicePageSize = 500
+
+// SyncChanges saves the items of entity for country created since its high-water mark and
+// moves the mark to the start of the run once every item is saved. Without a mark it
+// starts from BACKFILL_FROM. The Frisbii list API filters on creation time, so for invoices
+// it also refreshes the older stored invoices in an open state created within
+// Options.RefreshLookback, repairing state changes whose webhook was missed. Changes to
+// older customers and invoices still only arrive through webhooks.
+func (s *Syncer) SyncChanges(ctx context.Context, country, entity string) (BackfillResult, error) {
+	hwm, err := s.store.GetHighWaterMark(ctx, country, entity)
+	if err != nil {
+		return BackfillResult{}, fmt.Errorf("read high-water mark: %w", err)
+	}
+	if hwm == nil {
+		hwm = &database.HighWaterMark{Account: country, Entity: entity}
+	}
+
+	started := time.Now().UTC()
+	dateRange := api.DateRange{To: started}
+	if hwm.Mark != nil {
+		dateRange.From = hwm.Mark.Add(-incrementalOverlap)
+	}
+	result, err := s.backfillEntity(ctx, country, entity, backfillRun{dateRange: dateRange})
+	if err != nil {
+		return result, err
+	}
+	refreshFrom := started.Add(-s.opts.RefreshLookback)
+	if entity == EntityInvoice && result.Error == "" && !dateRange.From.IsZero() && refreshFrom.Before(dateRange.From) {
+		if err := s.refreshOpenInvoices(ctx, country, api.DateRange{From: refreshFrom, To: dateRange.From}, &result); err != nil {
+			result.Error = fmt.Sprintf("refresh open invoices: %v", err)
+		}
+	}
+
+	hwm.LastRun = &started
+	hwm.Items = int64(result.Saved)
+	switch {
+	case result.Error != "":
+		hwm.LastError = result.Error
+	case result.Failed > 0:
+		hwm.LastError = fmt.Sprintf("%d items could not be saved", result.Failed)
+	default:
+		hwm.Mark = &started
+		hwm.LastSuccess = &started
+		hwm.LastError = ""
+	}
+	if len(hwm.LastError) > maxLastError {
+		hwm.LastError = hwm.LastError[:maxLastError]
+	}
+	// Record the run even when it was cancelled
+	if err := s.store.SaveHighWaterMark(context.WithoutCancel(ctx), hwm); err != nil {
+		return result, fmt.Errorf("save high-water mark: %w", err)
+	}
+	return result, nil
+}
+
+// refreshOpenInvoices fetches and saves every stored invoice of country created within
+// created that is in an open state, adding them to the saved or failed items of result.
+// Invoices Frisbii no longer has are skipped.
+func (s *Syncer) refreshOpenInvoices(ctx context.Context, country string, created api.DateRange, result *BackfillResult) error {
+	limiter := s.limiter(country)
+	query := database.InvoiceQuery{From: created.From, To: created.To, Accounts: []string{country}, States: openInvoiceStates, Limit: openInvoicePageSize}
+	refreshed := 0
+	for {
+		invoices, err := s.store.GetInvoicesPage(ctx, query)
+		if err != nil {
+			return fmt.Errorf("read open invoices: %w", err)
+		}
+		for _, invoice := range invoices {
+			if err := limiter.Wait(ctx); err != nil {
+				return err
+			}
+			_, err := s.SyncInvoice(ctx, country, invoice.ID, database.SourceSync)
+			switch {
+			case errors.Is(err, api.ErrNotFound):
+				log.Printf("Skipping open invoice %s for %s: %v", invoice.Handle, country, err)
+			case err != nil:
+				log.Printf("Error refreshing open invoice %s for %s: %v", invoice.Handle, country, err)
+				result.Failed++
+			default:
+				result.Saved++
+				refreshed++
+			}
+		}
+		if len(invoices) < openInvoicePageSize {
+			break
+		}
+		last := invoices[len(invoices)-1]
+		query.After = &database.InvoiceCursor{Created: last.Created, ID: last.ID}
+	}
+	log.Printf("Refreshed %d open invoices for %s", refreshed, country)
+	return nil
+}
+
+// Scheduler runs SyncChanges for IncrementalEntities of every country periodically
+type Scheduler struct {
+	syncer   *Syncer
+	interval time.Duration
+	jitter   time.Duration
+	running  atomic.Bool
+}
+
+// NewScheduler returns a Scheduler that runs every interval plus up to jitter
+func NewScheduler(s *Syncer, interval, jitter time.Duration) *Scheduler {
+	return &Scheduler{syncer: s, interval: interval, jitter: jitter}
+}
+
+// Run starts a run after every interval plus a random delay of up to jitter until ctx is
+// cancelled. A run is skipped when the previous one is still going.
+func (sc *Scheduler) Run(ctx context.Context) {
+	log.Printf("Scheduling incremental sync every %s with up to %s jitter", sc.interval, sc.jitter)
+	for {
+		delay := sc.interval
+		if sc.jitter > 0 {
+			delay += rand.N(sc.jitter)
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		if !sc.running.CompareAndSwap(false, true) {
+			log.Println("Skipping incremental sync: the previous run is still going")
+			continue
+		}
+		go func() {
+			defer sc.running.Store(false)
+			sc.runOnce(ctx)
+		}()
+	}
+}
+
+// runOnce syncs the changes of every incremental entity of every country
+func (sc *Scheduler) runOnce(ctx context.Context) {
+	started := time.Now()
+	log.Println("Starting incremental sync...")
+	results := sc.syncer.CatchUp(ctx, CatchUpOptions{})
+	if ctx.Err() != nil {
+		log.Printf("Stopping incremental sync: %v", ctx.Err())
+		return
+	}
+	saved, failed := 0, 0
+	for _, result := range results {
+		saved += result.Saved
+		if result.Error != "" || result.Failed > 0 {
+			failed++
+		}
+	}
+	log.Printf("Incremental sync finished in %s. Saved: %d, Failed account/entity pairs: %d",
+		time.Since(started).Round(time.Millisecond), saved, failed)
+}
+
+// CatchUpOptions select what CatchUp syncs
+type CatchUpOptions struct {
+	Accounts []string               // Countries to sync; empty means all Countries
+	Entities []string               // Entities to sync; empty means IncrementalEntities
+	Progress func([]BackfillResult) // Called with the results so far after every pair, if not nil
+}
+
+// CatchUp runs SyncChanges for the selected entities of the selected accounts one pair
+// after the other and returns a result per pair; an error syncing a pair is set as its
+// Error. Accounts another process is catching up are skipped, with that process as
+// LockedBy. It stops when ctx is cancelled.
+func (s *Syncer) CatchUp(ctx context.Context, opts CatchUpOptions) []BackfillResult {
+	accounts, entities := opts.Accounts, opts.Entities
+	if len(accounts) == 0 {
+		accounts = Countries
+	}
+	if len(entities) == 0 {
+		entities = IncrementalEntities
+	}
+	var results []BackfillResult
+	add := func(result BackfillResult) {
+		results = append(results, result)
+		if opts.Progress != nil {
+			opts.Progress(results)
+		}
+	}
+	for _, country := range accounts {
+		if ctx.Err() != nil {
+			return results
+		}
+		accountCtx, release, holder, err := s.acquireLease(ctx, leaseName(database.JobCatchUp, country))
+		if err != nil || accountCtx == nil {
+			if err != nil {
+				log.Printf("Error syncing changes for %s: %v", country, err)
+			} else {
+				log.Printf("Skipping changes for %s: %s is syncing them", country, holder)
+			}
+			for _, entity := range entities {
+				result := BackfillResult{Account: country, Entity: entity, Skipped: err == nil, LockedBy: holder}
+				if err != nil {
+					result.Error = err.Error()
+				}
+				add(result)
+			}
+			continue
+		}
+		for _, entity := range entities {
+			if accountCtx.Err() != nil {
+				break
+			}
+			result, err := s.SyncChanges(accountCtx, country, entity)
+			if err != nil {
+				log.Printf("Error syncing %s changes for %s: %v", entity, country, err)
+				result.Account, result.Entity, result.Error = country, entity, err.Error()
+			}
+			add(result)
+		}
+		release()
+	}
+	return results
+}
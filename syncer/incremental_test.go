@@ -0,0 +1,95 @@
+package syncer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/AndersKaae/legaldesk_psp_sync/api"
+	"github.com/AndersKaae/legaldesk_psp_sync/database"
+)
+
+func TestSyncChangesRefreshesOpenInvoices(t *testing.T) {
+	fake := newFakeFrisbii(t)
+	ctx := context.Background()
+	created := time.Date(2025, 1, 1, 10, 0, 0, 0, time.UTC)
+	pending := api.InvoiceResponse{
+		ID: "inv_open", Handle: "inv-open", Customer: "cust-1", Currency: "DKK", Created: created,
+		Plan: "plan-basic", OrgAmount: 1000, State: "pending",
+	}
+	fake.AddInvoice("DK", pending)
+	fake.AddInvoice("DK", api.InvoiceResponse{
+		ID: "inv_settled", Handle: "inv-settled", Customer: "cust-1", Currency: "DKK", Created: created,
+		Plan: "plan-basic", OrgAmount: 1000, State: "settled", Settled: created.Add(time.Minute),
+	})
+
+	// Open longer than the lookback, so no longer refreshed
+	abandoned := api.InvoiceResponse{
+		ID: "inv_abandoned", Handle: "inv-abandoned", Customer: "cust-1", Currency: "DKK", Created: created.AddDate(0, 0, -2),
+		Plan: "plan-basic", OrgAmount: 1000, State: "dunning",
+	}
+	fake.AddInvoice("DK", abandoned)
+
+	store := database.NewMemoryStore()
+	s := New(store, Options{RefreshLookback: time.Since(created) + time.Hour})
+	if result, err := s.SyncChanges(ctx, "DK", EntityInvoice); err != nil || result.Saved != 3 {
+		t.Fatalf("first SyncChanges = %+v, %v; want 3 saved", result, err)
+	}
+
+	// Settled later, but the webhook never arrived
+	settled := pending
+	settled.State, settled.Settled = "settled", created.Add(24*time.Hour)
+	fake.AddInvoice("DK", settled)
+	abandoned.State, abandoned.Cancelled = "cancelled", created.Add(24*time.Hour)
+	fake.AddInvoice("DK", abandoned)
+
+	result, err := s.SyncChanges(ctx, "DK", EntityInvoice)
+	if err != nil || result.Error != "" {
+		t.Fatalf("second SyncChanges = %+v, %v", result, err)
+	}
+	if result.Saved != 1 {
+		t.Errorf("second SyncChanges saved %d, want only the open invoice refreshed", result.Saved)
+	}
+	invoices, err := store.GetInvoicesByID(ctx, []string{"inv_open", "inv_abandoned"})
+	if err != nil {
+		t.Fatalf("GetInvoicesByID: %v", err)
+	}
+	states := map[string]string{}
+	for _, invoice := range invoices {
+		states[invoice.ID] = invoice.State
+	}
+	if states["inv_open"] != "settled" || states["inv_abandoned"] != "dunning" {
+		t.Fatalf("stored states = %v, want inv_open settled and inv_abandoned untouched", states)
+	}
+	transitions, err := store.GetInvoiceStateTransitions(ctx, "inv_open")
+	if err != nil {
+		t.Fatalf("GetInvoiceStateTransitions: %v", err)
+	}
+	if last := transitions[len(transitions)-1]; last.ToState != "settled" || last.Source != database.SourceSync {
+		t.Errorf("last transition = %+v, want to settled from %s", last, database.SourceSync)
+	}
+}
+
+func TestSyncChangesWithoutLookbackRefreshesNothing(t *testing.T) {
+	fake := newFakeFrisbii(t)
+	ctx := context.Background()
+	pending := api.InvoiceResponse{
+		ID: "inv_open", Handle: "inv-open", Customer: "cust-1", Currency: "DKK", Created: time.Date(2025, 1, 1, 10, 0, 0, 0, time.UTC),
+		Plan: "plan-basic", OrgAmount: 1000, State: "pending",
+	}
+	fake.AddInvoice("DK", pending)
+
+	s := New(database.NewMemoryStore(), Options{})
+	if result, err := s.SyncChanges(ctx, "DK", EntityInvoice); err != nil || result.Saved != 1 {
+		t.Fatalf("first SyncChanges = %+v, %v; want 1 saved", result, err)
+	}
+	before := len(fake.Requests())
+	if result, err := s.SyncChanges(ctx, "DK", EntityInvoice); err != nil || result.Saved != 0 {
+		t.Fatalf("second SyncChanges = %+v, %v; want nothing saved", result, err)
+	}
+	for _, req := range fake.Requests()[before:] {
+		if req.Path != "/v1/list/invoice" {
+			t.Errorf("second SyncChanges requested %s, want only the invoice list", req.Path)
+		}
+	}
+}
@@ -0,0 +1,366 @@
+package syncer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/AndersKaae/legaldesk_psp_sync/api"
+	"github.com/AndersKaae/legaldesk_psp_sync/database"
+)
+
+// ErrInvalidJob is wrapped by the errors Jobs.Start returns for an unknown job type or
+// invalid parameters
+var ErrInvalidJob = errors.New("invalid job")
+
+// JobParams are what a job is started with; fields a job type does not use are ignored
+type JobParams struct {
+	Accounts []string `json:"accounts,omitempty"` // Empty means all Countries
+	Entities []string `json:"entities,omitempty"` // Backfill and catch-up; empty means their defaults
+	From     string   `json:"from,omitempty"`     // First day, YYYY-MM-DD; reconcile defaults to yesterday
+	To       string   `json:"to,omitempty"`       // Last day, YYYY-MM-DD
+	Window   string   `json:"window,omitempty"`   // Backfill window size, e.g. month
+	Repair   bool     `json:"repair,omitempty"`   // Reconcile
+	DryRun   bool     `json:"dry_run,omitempty"`  // Backfill and reconcile
+}
+
+// jobFunc runs a job, reporting its progress along the way, and returns its result and,
+// if it did not succeed, why
+type jobFunc func(ctx context.Context, progress func(v interface{})) (result interface{}, problem string)
+
+// The process running a job stores a heartbeat every jobHeartbeat, which also picks up a
+// cancellation requested through another process. A running job without a heartbeat for
+// jobStaleAfter is marked interrupted.
+var (
+	jobHeartbeat  = 10 * time.Second
+	jobStaleAfter = 6 * jobHeartbeat
+)
+
+// runningJob is a job running in this process
+type runningJob struct {
+	cancel   context.CancelFunc
+	progress json.RawMessage
+}
+
+// Jobs runs backfill, reconcile and catch-up jobs in the background and keeps their history
+// in the store. A running job can be cancelled through its context, or through the store
+// from any process.
+type Jobs struct {
+	syncer *Syncer
+
+	mu      sync.Mutex
+	running map[int64]*runningJob
+	wg      sync.WaitGroup // Jobs started and not yet stored as ended
+}
+
+// NewJobs returns a Jobs running jobs with s
+func NewJobs(s *Syncer) *Jobs {
+	return &Jobs{syncer: s, running: make(map[int64]*runningJob)}
+}
+
+// Start validates params, stores the job as running and runs it in the background
+func (j *Jobs) Start(ctx context.Context, jobType string, params JobParams) (database.Job, error) {
+	run, err := j.prepare(jobType, params)
+	if err != nil {
+		return database.Job{}, err
+	}
+	encoded, err := json.Marshal(params)
+	if err != nil {
+		return database.Job{}, fmt.Errorf("marshal job params: %w", err)
+	}
+	job := database.Job{Type: jobType, Params: encoded, Status: database.JobRunning, StartedAt: time.Now().UTC()}
+	if err := j.syncer.store.CreateJob(ctx, &job); err != nil {
+		return database.Job{}, err
+	}
+
+	jobCtx, cancel := context.WithCancel(context.Background())
+	rj := &runningJob{cancel: cancel}
+	j.mu.Lock()
+	j.running[job.ID] = rj
+	j.mu.Unlock()
+	j.wg.Add(1)
+	log.Printf("Starting %s job %d with %s", jobType, job.ID, encoded)
+	go j.run(jobCtx, job, run, rj)
+	return job, nil
+}
+
+// run runs a started job and stores how it ended, also when it was cancelled
+func (j *Jobs) run(ctx context.Context, job database.Job, run jobFunc, rj *runningJob) {
+	defer j.wg.Done()
+	defer func() {
+		j.mu.Lock()
+		delete(j.running, job.ID)
+		j.mu.Unlock()
+		rj.cancel()
+	}()
+	stopHeartbeat := j.heartbeat(ctx, job.ID, rj.cancel)
+
+	result, problem := run(ctx, func(v interface{}) {
+		progress, err := json.Marshal(v)
+		if err != nil {
+			log.Printf("Failed to encode progress of job %d: %v", job.ID, err)
+			return
+		}
+		j.mu.Lock()
+		rj.progress = progress
+		j.mu.Unlock()
+	})
+	stopHeartbeat()
+
+	finished := time.Now().UTC()
+	job.FinishedAt = &finished
+	switch {
+	case ctx.Err() != nil:
+		job.Status = database.JobCancelled
+	case problem != "":
+		job.Status, job.Error = database.JobFailed, problem
+	default:
+		job.Status = database.JobSucceeded
+	}
+	if len(job.Error) > maxLastError {
+		job.Error = job.Error[:maxLastError]
+	}
+	var err error
+	if job.Result, err = json.Marshal(result); err != nil {
+		log.Printf("Failed to encode result of job %d: %v", job.ID, err)
+	}
+	if err := j.syncer.store.UpdateJob(context.Background(), &job); err != nil {
+		log.Printf("Failed to store %s job %d: %v", job.Type, job.ID, err)
+	}
+	log.Printf("Job %d (%s) %s in %s %s", job.ID, job.Type, job.Status,
+		finished.Sub(job.StartedAt).Round(time.Millisecond), job.Error)
+}
+
+// heartbeat stores a heartbeat for a running job every jobHeartbeat and cancels it when
+// another process asked to, until ctx is cancelled or the returned function is called
+func (j *Jobs) heartbeat(ctx context.Context, id int64, cancel context.CancelFunc) func() {
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(jobHeartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+			cancelRequested, err := j.syncer.store.HeartbeatJob(ctx, id)
+			if err != nil {
+				log.Printf("Failed to store heartbeat of job %d: %v", id, err)
+				continue
+			}
+			if cancelRequested {
+				log.Printf("Cancelling job %d as requested", id)
+				cancel()
+				return
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-done
+	}
+}
+
+// Get returns a job, with its progress so far as its result while it runs in this process,
+// or nil if there is no such job
+func (j *Jobs) Get(ctx context.Context, id int64) (*database.Job, error) {
+	job, err := j.syncer.store.GetJob(ctx, id)
+	if err != nil || job == nil {
+		return job, err
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if rj, ok := j.running[id]; ok && job.Status == database.JobRunning {
+		job.Result = rj.progress
+	}
+	return job, nil
+}
+
+// Cancel cancels a running job. A job running in this process is cancelled at once; a job
+// running in another process is asked to cancel through the store, which that process
+// picks up with its next heartbeat. The job stops after its current page and is then
+// stored as cancelled.
+func (j *Jobs) Cancel(ctx context.Context, id int64) error {
+	j.mu.Lock()
+	rj, ok := j.running[id]
+	j.mu.Unlock()
+	if ok {
+		log.Printf("Cancelling job %d", id)
+		rj.cancel()
+		return nil
+	}
+	log.Printf("Requesting cancellation of job %d", id)
+	return j.syncer.store.RequestJobCancel(ctx, id)
+}
+
+// Shutdown cancels the jobs running in this process and waits until each has stored how it
+// ended, so none is left running with its lease held. It returns ctx's error if ctx ends
+// first.
+func (j *Jobs) Shutdown(ctx context.Context) error {
+	j.mu.Lock()
+	for id, rj := range j.running {
+		log.Printf("Cancelling job %d for shutdown", id)
+		rj.cancel()
+	}
+	j.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		j.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// WatchInterrupted marks running jobs whose process stopped, as they no longer store
+// heartbeats, as interrupted: once at startup and then every jobStaleAfter until ctx is
+// cancelled
+func (j *Jobs) WatchInterrupted(ctx context.Context) {
+	for {
+		n, err := j.syncer.store.InterruptStaleJobs(ctx, time.Now().UTC().Add(-jobStaleAfter))
+		switch {
+		case err != nil:
+			log.Printf("Failed to mark interrupted jobs: %v", err)
+		case n > 0:
+			log.Printf("Marked %d jobs interrupted: their process stopped", n)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(jobStaleAfter):
+		}
+	}
+}
+
+// prepare validates params for a job type and returns the function running the job
+func (j *Jobs) prepare(jobType string, params JobParams) (jobFunc, error) {
+	for _, account := range params.Accounts {
+		if !slices.Contains(Countries, account) {
+			return nil, fmt.Errorf("%w: unknown account %s, expected one of %v", ErrInvalidJob, account, Countries)
+		}
+	}
+	var entities []string
+	for _, name := range params.Entities {
+		entity, err := ParseEntity(name)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidJob, err)
+		}
+		entities = append(entities, entity)
+	}
+	if jobType == database.JobReconcile && params.From == "" {
+		params.From = time.Now().UTC().AddDate(0, 0, -1).Format(time.DateOnly)
+	}
+	dateRange, err := parseJobDays(params.From, params.To)
+	if err != nil {
+		return nil, err
+	}
+
+	switch jobType {
+	case database.JobBackfill:
+		window, err := ParseWindow(params.Window)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidJob, err)
+		}
+		opts := BackfillOptions{
+			Accounts: params.Accounts, Entities: entities, DateRange: dateRange, DryRun: params.DryRun, Window: window,
+		}
+		return func(ctx context.Context, progress func(v interface{})) (interface{}, string) {
+			opts.Progress = func(results []BackfillResult) { progress(results) }
+			report := j.syncer.Backfill(ctx, opts)
+			_, failed, stopped := report.Totals()
+			switch {
+			case report.LockedBy() != "":
+				return report, "accounts are being backfilled by " + report.LockedBy()
+			case stopped > 0:
+				return report, fmt.Sprintf("%d of %d account/entity pairs stopped early", stopped, len(report.Results))
+			case failed > 0:
+				return report, fmt.Sprintf("%d items could not be saved", failed)
+			}
+			return report, ""
+		}, nil
+
+	case database.JobReconcile:
+		if dateRange.To.IsZero() {
+			dateRange.To = dateRange.From.AddDate(0, 0, 1)
+		}
+		opts := ReconcileOptions{
+			Accounts: params.Accounts, From: dateRange.From, To: dateRange.To, Repair: params.Repair, DryRun: params.DryRun,
+		}
+		return func(ctx context.Context, progress func(v interface{})) (interface{}, string) {
+			runs, err := j.syncer.Reconcile(ctx, opts)
+			if err != nil {
+				return runs, err.Error()
+			}
+			for _, run := range runs {
+				if run.Status == database.ReconcileFailed {
+					return runs, fmt.Sprintf("reconcile of %s failed: %s", run.Account, run.Error)
+				}
+			}
+			return runs, ""
+		}, nil
+
+	case database.JobCatchUp:
+		opts := CatchUpOptions{Accounts: params.Accounts, Entities: entities}
+		return func(ctx context.Context, progress func(v interface{})) (interface{}, string) {
+			opts.Progress = func(results []BackfillResult) { progress(results) }
+			results := j.syncer.CatchUp(ctx, opts)
+			if holder := lockedBy(results); holder != "" {
+				return results, "accounts are being caught up by " + holder
+			}
+			for _, result := range results {
+				if result.Error != "" {
+					return results, fmt.Sprintf("catch-up of %s %s stopped: %s", result.Account, result.Entity, result.Error)
+				}
+				if result.Failed > 0 {
+					return results, fmt.Sprintf("%d %ss of %s could not be saved", result.Failed, result.Entity, result.Account)
+				}
+			}
+			return results, ""
+		}, nil
+	}
+	return nil, fmt.Errorf("%w: unknown job type %s, expected %s, %s or %s",
+		ErrInvalidJob, jobType, database.JobBackfill, database.JobReconcile, database.JobCatchUp)
+}
+
+// parseJobDays parses the first and last day of a job; the returned To is the day after
+// the last, as list date ranges are exclusive
+func parseJobDays(from, to string) (api.DateRange, error) {
+	var dateRange api.DateRange
+	var err error
+	if from != "" {
+		if dateRange.From, err = time.Parse(time.DateOnly, from); err != nil {
+			return dateRange, fmt.Errorf("%w: invalid from date %s, expected YYYY-MM-DD", ErrInvalidJob, from)
+		}
+	}
+	if to != "" {
+		last, err := time.Parse(time.DateOnly, to)
+		if err != nil {
+			return dateRange, fmt.Errorf("%w: invalid to date %s, expected YYYY-MM-DD", ErrInvalidJob, to)
+		}
+		dateRange.To = last.AddDate(0, 0, 1)
+		if !dateRange.From.IsZero() && !dateRange.From.Before(dateRange.To) {
+			return dateRange, fmt.Errorf("%w: from %s is after to %s", ErrInvalidJob, from, to)
+		}
+	}
+	return dateRange, nil
+}
+
+// List returns the latest limit jobs, newest first, without their results
+func (j *Jobs) List(ctx context.Context, limit int) ([]database.Job, error) {
+	return j.syncer.store.GetJobs(ctx, limit)
+}
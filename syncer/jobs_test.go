@@ -0,0 +1,109 @@
+package syncer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/AndersKaae/legaldesk_psp_sync/api/frisbiitest"
+	"github.com/AndersKaae/legaldesk_psp_sync/database"
+)
+
+// waitForJob waits until a job is no longer running and returns it
+func waitForJob(t *testing.T, store database.Store, id int64) *database.Job {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		job, err := store.GetJob(context.Background(), id)
+		if err != nil || job == nil {
+			t.Fatalf("GetJob(%d) = %v, %v", id, job, err)
+		}
+		if job.Status != database.JobRunning {
+			return job
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job %d still running: %+v", id, job)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestJobCancelledFromAnotherProcess(t *testing.T) {
+	defer func(heartbeat time.Duration) { jobHeartbeat = heartbeat }(jobHeartbeat)
+	jobHeartbeat = 10 * time.Millisecond
+
+	fake := newFakeFrisbii(t)
+	addInvoices(fake, "DK", 10*frisbiitest.DefaultPageSize, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	fake.InjectFault(frisbiitest.Fault{Path: "/", Delay: 50 * time.Millisecond})
+	store := database.NewMemoryStore()
+	owner := NewJobs(New(store, Options{Holder: "owner"}))
+	other := NewJobs(New(store, Options{Holder: "other"}))
+
+	job, err := owner.Start(context.Background(), database.JobBackfill, JobParams{Accounts: []string{"DK"}, Entities: []string{"invoices"}})
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if err := other.Cancel(context.Background(), job.ID); err != nil {
+		t.Fatalf("Cancel from another process: %v", err)
+	}
+
+	if got := waitForJob(t, store, job.ID); got.Status != database.JobCancelled {
+		t.Errorf("job = %+v, want cancelled", got)
+	}
+}
+
+func TestWatchInterruptedMarksStaleJobs(t *testing.T) {
+	store := database.NewMemoryStore()
+	ctx := context.Background()
+	stale := database.Job{Type: database.JobBackfill, Status: database.JobRunning, StartedAt: time.Now().UTC().Add(-time.Hour)}
+	if err := store.CreateJob(ctx, &stale); err != nil {
+		t.Fatalf("CreateJob: %v", err)
+	}
+
+	// A restarted process marks the job of its predecessor once it starts watching
+	watchCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	NewJobs(New(store, Options{})).WatchInterrupted(watchCtx)
+
+	if got := waitForJob(t, store, stale.ID); got.Status != database.JobInterrupted || got.FinishedAt == nil {
+		t.Errorf("job = %+v, want interrupted", got)
+	}
+}
+
+func TestJobsShutdownCancelsRunningJobs(t *testing.T) {
+	fake := newFakeFrisbii(t)
+	addInvoices(fake, "DK", 10*frisbiitest.DefaultPageSize, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	fake.InjectFault(frisbiitest.Fault{Path: "/", Delay: 50 * time.Millisecond})
+	store := database.NewMemoryStore()
+	jobs := NewJobs(New(store, Options{Holder: "owner"}))
+	ctx := context.Background()
+
+	job, err := jobs.Start(ctx, database.JobBackfill, JobParams{Accounts: []string{"DK"}, Entities: []string{"invoices"}})
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	shutdownCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if err := jobs.Shutdown(shutdownCtx); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+
+	// Stored as cancelled by the time Shutdown returns, with its lease released
+	got, err := store.GetJob(ctx, job.ID)
+	if err != nil || got == nil || got.Status != database.JobCancelled || got.FinishedAt == nil {
+		t.Errorf("job after Shutdown = %+v, %v; want cancelled", got, err)
+	}
+	leases, err := store.GetLeases(ctx)
+	if err != nil {
+		t.Fatalf("GetLeases: %v", err)
+	}
+	for _, lease := range leases {
+		if lease.Holder == "owner" && lease.ExpiresAt.After(time.Now()) {
+			t.Errorf("lease %+v still held after Shutdown", lease)
+		}
+	}
+
+	if err := jobs.Shutdown(ctx); err != nil {
+		t.Errorf("Shutdown without running jobs: %v", err)
+	}
+}
@@ -0,0 +1,86 @@
+package syncer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// leaseTTL is how long a lease is held without renewal; holders renew it every leaseTTL/3,
+// so a crashed process blocks others for at most leaseTTL
+const leaseTTL = 2 * time.Minute
+
+// defaultHolder identifies this process in leases by host name and process ID
+func defaultHolder() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s:%d", host, os.Getpid())
+}
+
+// leaseName is the name of the lease of a job type for an account, e.g. "backfill:DK"
+func leaseName(jobType, country string) string {
+	return jobType + ":" + country
+}
+
+// Holder returns the name this Syncer holds leases under
+func (s *Syncer) Holder() string {
+	return s.opts.Holder
+}
+
+// acquireLease takes the lease name and renews it in the background until release is called.
+// The returned context is cancelled when the lease is released or lost, so work done with
+// it stops once another process may have taken over. If another process holds the lease,
+// it returns that holder and no context.
+func (s *Syncer) acquireLease(ctx context.Context, name string) (leaseCtx context.Context, release func(), holder string, err error) {
+	ok, holder, err := s.store.AcquireLease(ctx, name, s.opts.Holder, leaseTTL)
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("acquire lease %s: %w", name, err)
+	}
+	if !ok {
+		return nil, nil, holder, nil
+	}
+
+	leaseCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		renewed := time.Now()
+		ticker := time.NewTicker(leaseTTL / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-leaseCtx.Done():
+				return
+			case <-ticker.C:
+			}
+			ok, holder, err := s.store.AcquireLease(leaseCtx, name, s.opts.Holder, leaseTTL)
+			switch {
+			case err != nil && time.Since(renewed) < leaseTTL:
+				log.Printf("Failed to renew lease %s, retrying: %v", name, err)
+			case err != nil:
+				log.Printf("Lease %s expired after failing to renew it: %v", name, err)
+				cancel()
+				return
+			case !ok:
+				log.Printf("Lost lease %s to %s", name, holder)
+				cancel()
+				return
+			default:
+				renewed = time.Now()
+			}
+		}
+	}()
+
+	release = func() {
+		cancel()
+		<-done
+		if err := s.store.ReleaseLease(context.WithoutCancel(ctx), name, s.opts.Holder); err != nil {
+			log.Printf("Failed to release lease %s: %v", name, err)
+		}
+	}
+	return leaseCtx, release, holder, nil
+}
@@ -0,0 +1,172 @@
+package syncer
+
+import (
+	"github.com/AndersKaae/legaldesk_psp_sync/api"
+	"github.com/AndersKaae/legaldesk_psp_sync/database"
+	"github.com/AndersKaae/legaldesk_psp_sync/money"
+)
+
+// InvoiceToDB maps an API invoice to its stored form
+func InvoiceToDB(apiInvoice api.Invoice) database.Invoice {
+	dbInvoice := database.Invoice{
+		ID:               apiInvoice.ID,
+		Handle:           apiInvoice.Handle,
+		Customer:         apiInvoice.Customer,
+		Subscription:     apiInvoice.Subscription,
+		Currency:         apiInvoice.Currency,
+		Created:          apiInvoice.Created,
+		DiscountAmount:   apiInvoice.DiscountAmount,
+		OrgAmount:        apiInvoice.OrgAmount,
+		AmountVAT:        apiInvoice.AmountVAT,
+		AmountExVAT:      apiInvoice.AmountExVAT,
+		RefundedAmount:   apiInvoice.RefundedAmount,
+		AuthorizedAmount: apiInvoice.AuthorizedAmount,
+		Country:          apiInvoice.Country,
+		Plan:             apiInvoice.Plan,
+		State:            apiInvoice.State,
+		States:           database.InvoiceStates(apiInvoice.States),
+	}
+	for _, line := range apiInvoice.DiscountLines() {
+		dbInvoice.Discounts = append(dbInvoice.Discounts, database.InvoiceDiscount{
+			OrderLineID:          line.ID,
+			SubscriptionDiscount: line.OriginHandle,
+			Amount:               money.New(line.Amount, apiInvoice.Currency),
+		})
+	}
+	return dbInvoice
+}
+
+// CustomerToDB maps an API customer to its stored form
+func CustomerToDB(apiCustomer api.Customer) database.Customer {
+	return database.Customer{
+		ActiveSubscriptions:             apiCustomer.ActiveSubscriptions,
+		Address:                         apiCustomer.Address,
+		Address2:                        apiCustomer.Address2,
+		CancelledAmount:                 apiCustomer.CancelledAmount,
+		CancelledInvoices:               apiCustomer.CancelledInvoices,
+		CancelledSubscriptions:          apiCustomer.CancelledSubscriptions,
+		City:                            apiCustomer.City,
+		Company:                         apiCustomer.Company,
+		Country:                         apiCustomer.Country,
+		Created:                         apiCustomer.Created,
+		Currency:                        apiCustomer.Currency,
+		DunningAmount:                   apiCustomer.DunningAmount,
+		DunningInvoices:                 apiCustomer.DunningInvoices,
+		Email:                           apiCustomer.Email,
+		ExpiredSubscriptions:            apiCustomer.ExpiredSubscriptions,
+		FailedAmount:                    apiCustomer.FailedAmount,
+		FailedInvoices:                  apiCustomer.FailedInvoices,
+		FirstName:                       apiCustomer.FirstName,
+		Handle:                          apiCustomer.Handle,
+		LastName:                        apiCustomer.LastName,
+		NonRenewingSubscriptions:        apiCustomer.NonRenewingSubscriptions,
+		OnHoldSubscriptions:             apiCustomer.OnHoldSubscriptions,
+		PendingAdditionalCostAmount:     apiCustomer.PendingAdditionalCostAmount,
+		PendingAdditionalCosts:          apiCustomer.PendingAdditionalCosts,
+		PendingAmount:                   apiCustomer.PendingAmount,
+		PendingCreditAmount:             apiCustomer.PendingCreditAmount,
+		PendingCredits:                  apiCustomer.PendingCredits,
+		PendingInvoices:                 apiCustomer.PendingInvoices,
+		Phone:                           apiCustomer.Phone,
+		PostalCode:                      apiCustomer.PostalCode,
+		RefundedAmount:                  apiCustomer.RefundedAmount,
+		SettledAmount:                   apiCustomer.SettledAmount,
+		SettledInvoices:                 apiCustomer.SettledInvoices,
+		Subscriptions:                   apiCustomer.Subscriptions,
+		Test:                            apiCustomer.Test,
+		TransferredAdditionalCostAmount: apiCustomer.TransferredAdditionalCostAmount,
+		TransferredAdditionalCosts:      apiCustomer.TransferredAdditionalCosts,
+		TransferredCreditAmount:         apiCustomer.TransferredCreditAmount,
+		TransferredCredits:              apiCustomer.TransferredCredits,
+		TrialActiveSubscriptions:        apiCustomer.TrialActiveSubscriptions,
+		TrialCancelledSubscriptions:     apiCustomer.TrialCancelledSubscriptions,
+	}
+}
+
+// DiscountToDB maps an API discount to its stored form
+func DiscountToDB(apiDiscount api.Discount) database.Discount {
+	return database.Discount{
+		Handle:          apiDiscount.Handle,
+		Name:            apiDiscount.Name,
+		Description:     apiDiscount.Description,
+		State:           apiDiscount.State,
+		Amount:          apiDiscount.Amount,
+		Percentage:      apiDiscount.Percentage,
+		FixedCount:      apiDiscount.FixedCount,
+		FixedPeriodUnit: apiDiscount.FixedPeriodUnit,
+		FixedPeriod:     apiDiscount.FixedPeriod,
+		Created:         apiDiscount.Created,
+		Deleted:         apiDiscount.Deleted,
+		Country:         apiDiscount.Country,
+	}
+}
+
+// CouponToDB maps an API coupon to its stored form
+func CouponToDB(apiCoupon api.Coupon) database.Coupon {
+	return database.Coupon{
+		Handle:         apiCoupon.Handle,
+		Name:           apiCoupon.Name,
+		Code:           apiCoupon.Code,
+		Discount:       apiCoupon.Discount,
+		State:          apiCoupon.State,
+		Redemptions:    apiCoupon.Redemptions,
+		MaxRedemptions: apiCoupon.MaxRedemptions,
+		ValidUntil:     apiCoupon.ValidUntil,
+		Expired:        apiCoupon.Expired,
+		Created:        apiCoupon.Created,
+		Country:        apiCoupon.Country,
+	}
+}
+
+// SubscriptionDiscountToDB maps an API subscription discount to its stored form
+func SubscriptionDiscountToDB(apiSubscriptionDiscount api.SubscriptionDiscount) database.SubscriptionDiscount {
+	return database.SubscriptionDiscount{
+		Handle:       apiSubscriptionDiscount.Handle,
+		Subscription: apiSubscriptionDiscount.Subscription,
+		Discount:     apiSubscriptionDiscount.Discount,
+		Coupon:       apiSubscriptionDiscount.Coupon,
+		State:        apiSubscriptionDiscount.State,
+		Amount:       apiSubscriptionDiscount.Amount,
+		Percentage:   apiSubscriptionDiscount.Percentage,
+		Created:      apiSubscriptionDiscount.Created,
+		Deleted:      apiSubscriptionDiscount.Deleted,
+		Country:      apiSubscriptionDiscount.Country,
+	}
+}
+
+// AdditionalCostToDB maps an API additional cost to its stored form
+func AdditionalCostToDB(apiAdditionalCost api.AdditionalCost) database.AdditionalCost {
+	return database.AdditionalCost{
+		Handle:        apiAdditionalCost.Handle,
+		Customer:      apiAdditionalCost.Customer,
+		Subscription:  apiAdditionalCost.Subscription,
+		State:         apiAdditionalCost.State,
+		Invoice:       apiAdditionalCost.Invoice,
+		Amount:        apiAdditionalCost.Amount,
+		Vat:           apiAdditionalCost.Vat,
+		Quantity:      apiAdditionalCost.Quantity,
+		Ordertext:     apiAdditionalCost.Ordertext,
+		AmountInclVat: apiAdditionalCost.AmountInclVat,
+		Created:       apiAdditionalCost.Created,
+		Transferred:   apiAdditionalCost.Transferred,
+		Cancelled:     apiAdditionalCost.Cancelled,
+		Country:       apiAdditionalCost.Country,
+	}
+}
+
+// CreditToDB maps an API credit to its stored form
+func CreditToDB(apiCredit api.Credit) database.Credit {
+	return database.Credit{
+		Handle:       apiCredit.Handle,
+		Customer:     apiCredit.Customer,
+		Subscription: apiCredit.Subscription,
+		State:        apiCredit.State,
+		Invoice:      apiCredit.Invoice,
+		Amount:       apiCredit.Amount,
+		Text:         apiCredit.Text,
+		Created:      apiCredit.Created,
+		Transferred:  apiCredit.Transferred,
+		Cancelled:    apiCredit.Cancelled,
+		Country:      apiCredit.Country,
+	}
+}
@@ -0,0 +1,312 @@
+package syncer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/AndersKaae/legaldesk_psp_sync/api"
+	"github.com/AndersKaae/legaldesk_psp_sync/database"
+)
+
+// ReconcileOptions select what Reconcile compares
+type ReconcileOptions struct {
+	Accounts []string  // Countries to reconcile; empty means all Countries
+	From     time.Time // Start of the first day, in UTC
+	To       time.Time // Start of the day after the last day, in UTC
+	Repair   bool      // Save missing and mismatched invoices as they are in Frisbii
+	DryRun   bool      // Only compare; neither repair nor store a run
+}
+
+// ReconcileTotals are the count and sums, in minor units, of invoices of one day and currency
+type ReconcileTotals struct {
+	Count          int   `json:"count"`
+	OrgAmount      int64 `json:"org_amount"`
+	AmountVAT      int64 `json:"amount_vat"`
+	SettledAmount  int64 `json:"settled_amount"` // Org amount of settled invoices
+	RefundedAmount int64 `json:"refunded_amount"`
+}
+
+func (t *ReconcileTotals) add(invoice database.Invoice) {
+	t.Count++
+	t.OrgAmount += invoice.OrgAmount.Amount
+	t.AmountVAT += invoice.AmountVAT.Amount
+	if invoice.State == "settled" {
+		t.SettledAmount += invoice.OrgAmount.Amount
+	}
+	t.RefundedAmount += invoice.RefundedAmount.Amount
+}
+
+// ReconcileDay compares the totals of one day and currency
+type ReconcileDay struct {
+	Day      string          `json:"day"`
+	Currency string          `json:"currency"`
+	Frisbii  ReconcileTotals `json:"frisbii"`
+	Database ReconcileTotals `json:"database"`
+	Matches  bool            `json:"matches"`
+}
+
+// Kinds of ReconcileDifference
+const (
+	DifferenceMissing    = "missing"    // In Frisbii but not in the database
+	DifferenceExtra      = "extra"      // In the database but not in Frisbii
+	DifferenceMismatched = "mismatched" // In both with different values
+)
+
+// ReconcileDifference is an invoice that differs between Frisbii and the database
+type ReconcileDifference struct {
+	InvoiceID   string        `json:"invoice_id"`
+	Handle      string        `json:"handle"`
+	Day         string        `json:"day"`
+	Kind        string        `json:"kind"`
+	Fields      []string      `json:"fields,omitempty"`  // Differing fields of a mismatched invoice
+	Changes     []FieldChange `json:"changes,omitempty"` // Stored and Frisbii values of those fields
+	Repaired    bool          `json:"repaired,omitempty"`
+	RepairError string        `json:"repair_error,omitempty"`
+}
+
+// ReconcileReport is the report stored with a reconcile run
+type ReconcileReport struct {
+	Days        []ReconcileDay        `json:"days"`
+	Differences []ReconcileDifference `json:"differences"`
+}
+
+// count returns how many differences are of kind
+func (r ReconcileReport) count(kind string) int {
+	n := 0
+	for _, d := range r.Differences {
+		if d.Kind == kind {
+			n++
+		}
+	}
+	return n
+}
+
+// fieldNames returns the names of the changed fields
+func fieldNames(changes []FieldChange) []string {
+	fields := make([]string, len(changes))
+	for i, c := range changes {
+		fields[i] = c.Field
+	}
+	return fields
+}
+
+// compareInvoices compares the invoices of an account in Frisbii with the stored ones,
+// per UTC day and currency and invoice by invoice
+func compareInvoices(frisbii, stored []database.Invoice) ReconcileReport {
+	type dayKey struct{ day, currency string }
+	days := make(map[dayKey]*ReconcileDay)
+	dayOf := func(invoice database.Invoice) *ReconcileDay {
+		key := dayKey{invoice.Created.UTC().Format(time.DateOnly), invoice.Currency}
+		if days[key] == nil {
+			days[key] = &ReconcileDay{Day: key.day, Currency: key.currency}
+		}
+		return days[key]
+	}
+
+	var report ReconcileReport
+	storedByID := make(map[string]database.Invoice, len(stored))
+	for _, invoice := range stored {
+		storedByID[invoice.ID] = invoice
+		dayOf(invoice).Database.add(invoice)
+	}
+	seen := make(map[string]bool, len(frisbii))
+	for _, invoice := range frisbii {
+		seen[invoice.ID] = true
+		dayOf(invoice).Frisbii.add(invoice)
+		diff := ReconcileDifference{
+			InvoiceID: invoice.ID, Handle: invoice.Handle, Day: invoice.Created.UTC().Format(time.DateOnly),
+		}
+		if s, ok := storedByID[invoice.ID]; !ok {
+			diff.Kind = DifferenceMissing
+		} else if changes := diffFields(s, invoice); len(changes) > 0 {
+			diff.Kind, diff.Fields, diff.Changes = DifferenceMismatched, fieldNames(changes), changes
+		} else {
+			continue
+		}
+		report.Differences = append(report.Differences, diff)
+	}
+	for _, invoice := range stored {
+		if !seen[invoice.ID] {
+			report.Differences = append(report.Differences, ReconcileDifference{
+				InvoiceID: invoice.ID, Handle: invoice.Handle, Day: invoice.Created.UTC().Format(time.DateOnly), Kind: DifferenceExtra,
+			})
+		}
+	}
+
+	for _, day := range days {
+		day.Matches = day.Frisbii == day.Database
+		report.Days = append(report.Days, *day)
+	}
+	sort.Slice(report.Days, func(i, j int) bool {
+		if report.Days[i].Day != report.Days[j].Day {
+			return report.Days[i].Day < report.Days[j].Day
+		}
+		return report.Days[i].Currency < report.Days[j].Currency
+	})
+	sort.SliceStable(report.Differences, func(i, j int) bool {
+		if report.Differences[i].Day != report.Differences[j].Day {
+			return report.Differences[i].Day < report.Differences[j].Day
+		}
+		return report.Differences[i].InvoiceID < report.Differences[j].InvoiceID
+	})
+	return report
+}
+
+// listInvoices fetches every invoice of an account created within dateRange
+func (s *Syncer) listInvoices(ctx context.Context, country string, dateRange api.DateRange) ([]api.Invoice, error) {
+	var invoices []api.Invoice
+	nextPage := ""
+	for {
+		if err := s.limiter(country).Wait(ctx); err != nil {
+			return nil, err
+		}
+		page, newNextPage, err := api.GetInvoiceList(nextPage, country, dateRange)
+		if err != nil {
+			return nil, fmt.Errorf("fetch invoice list (nextPage: %s): %w", nextPage, err)
+		}
+		invoices = append(invoices, page...)
+		if newNextPage == "" {
+			return invoices, nil
+		}
+		nextPage = newNextPage
+	}
+}
+
+// Reconcile compares the invoices of each account created in [From, To) between Frisbii
+// and the database, optionally repairs missing and mismatched invoices, and stores a run
+// per account. Extra invoices are only reported, as Frisbii never deletes invoices. A dry
+// run returns the runs without storing or repairing anything.
+func (s *Syncer) Reconcile(ctx context.Context, opts ReconcileOptions) ([]database.ReconcileRun, error) {
+	accounts := opts.Accounts
+	if len(accounts) == 0 {
+		accounts = Countries
+	}
+	var runs []database.ReconcileRun
+	for _, country := range accounts {
+		run, err := s.reconcileAccount(ctx, country, opts)
+		if err != nil {
+			return runs, err
+		}
+		runs = append(runs, run)
+	}
+	return runs, nil
+}
+
+// reconcileAccount reconciles one account; errors comparing it are stored with its run,
+// only failing to take its lease or store the run is returned
+func (s *Syncer) reconcileAccount(ctx context.Context, country string, opts ReconcileOptions) (database.ReconcileRun, error) {
+	run := database.ReconcileRun{
+		Account: country, From: opts.From.UTC(), To: opts.To.UTC(), Repair: opts.Repair,
+		Status: database.ReconcileRunning, StartedAt: time.Now().UTC(),
+	}
+	if opts.DryRun {
+		run.Repair = false
+	} else {
+		// Only one process reconciles an account at a time; a run skipped for that is
+		// returned as failed without being stored
+		leaseCtx, release, holder, err := s.acquireLease(ctx, leaseName(database.JobReconcile, country))
+		if err != nil {
+			return run, err
+		}
+		if leaseCtx == nil {
+			log.Printf("Skipping reconcile of %s: %s is reconciling it", country, holder)
+			finished := time.Now().UTC()
+			run.Status, run.Error, run.FinishedAt = database.ReconcileFailed, "running in "+holder, &finished
+			return run, nil
+		}
+		defer release()
+		ctx = leaseCtx
+		if err := s.store.CreateReconcileRun(ctx, &run); err != nil {
+			return run, err
+		}
+	}
+	log.Printf("Starting reconcile run %d of %s invoices created %s to %s", run.ID, country,
+		run.From.Format(time.DateOnly), run.To.Format(time.DateOnly))
+
+	report, err := s.reconcileInvoices(ctx, country, opts)
+	finished := time.Now().UTC()
+	run.FinishedAt = &finished
+	if err != nil {
+		run.Status, run.Error = database.ReconcileFailed, err.Error()
+		log.Printf("Reconcile run %d of %s failed: %v", run.ID, country, err)
+	} else {
+		run.Missing = report.count(DifferenceMissing)
+		run.Extra = report.count(DifferenceExtra)
+		run.Mismatched = report.count(DifferenceMismatched)
+		for _, d := range report.Differences {
+			if d.Repaired {
+				run.Repaired++
+			}
+		}
+		switch {
+		case len(report.Differences) == 0:
+			run.Status = database.ReconcileMatched
+		case run.Repaired == len(report.Differences):
+			run.Status = database.ReconcileRepaired
+		default:
+			run.Status = database.ReconcileDifferences
+		}
+		if run.Report, err = json.Marshal(report); err != nil {
+			return run, fmt.Errorf("marshal reconcile report: %w", err)
+		}
+		log.Printf("Reconcile run %d of %s %s: %d missing, %d extra, %d mismatched, %d repaired", run.ID, country,
+			run.Status, run.Missing, run.Extra, run.Mismatched, run.Repaired)
+	}
+	if len(run.Error) > maxLastError {
+		run.Error = run.Error[:maxLastError]
+	}
+
+	if opts.DryRun {
+		return run, nil
+	}
+	// Store the outcome even when the run was cancelled
+	if err := s.store.UpdateReconcileRun(context.WithoutCancel(ctx), &run); err != nil {
+		return run, err
+	}
+	return run, nil
+}
+
+// reconcileInvoices compares one account and repairs it if asked to
+func (s *Syncer) reconcileInvoices(ctx context.Context, country string, opts ReconcileOptions) (ReconcileReport, error) {
+	apiInvoices, err := s.listInvoices(ctx, country, api.DateRange{From: opts.From, To: opts.To})
+	if err != nil {
+		return ReconcileReport{}, err
+	}
+	stored, err := s.store.GetAccountInvoices(ctx, country, opts.From, opts.To)
+	if err != nil {
+		return ReconcileReport{}, err
+	}
+	frisbii := make([]database.Invoice, len(apiInvoices))
+	for i, invoice := range apiInvoices {
+		frisbii[i] = InvoiceToDB(invoice)
+	}
+
+	report := compareInvoices(frisbii, stored)
+	if !opts.Repair || opts.DryRun {
+		return report, nil
+	}
+	byID := make(map[string]database.Invoice, len(frisbii))
+	for _, invoice := range frisbii {
+		byID[invoice.ID] = invoice
+	}
+	for i := range report.Differences {
+		d := &report.Differences[i]
+		if d.Kind == DifferenceExtra {
+			continue
+		}
+		invoice := byID[d.InvoiceID]
+		if err := s.store.CreateOrUpdateInvoice(ctx, &invoice, database.SourceReconcile); err != nil {
+			d.RepairError = err.Error()
+			log.Printf("Error repairing %s invoice %s for %s: %v", d.Kind, d.Handle, country, err)
+			continue
+		}
+		d.Repaired = true
+		log.Printf("Repaired %s invoice %s for %s %s", d.Kind, d.Handle, country, strings.Join(d.Fields, ", "))
+	}
+	return report, nil
+}
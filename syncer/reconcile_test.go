@@ -0,0 +1,189 @@
+package syncer
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/AndersKaae/legaldesk_psp_sync/api"
+	"github.com/AndersKaae/legaldesk_psp_sync/database"
+	"github.com/AndersKaae/legaldesk_psp_sync/money"
+)
+
+var reconcileDay = time.Date(2025, 2, 3, 0, 0, 0, 0, time.UTC)
+
+// reconcileInvoice returns a DKK invoice of DK created hours into reconcileDay
+func reconcileInvoice(id, state string, amount int64, hours int) api.InvoiceResponse {
+	created := reconcileDay.Add(time.Duration(hours) * time.Hour)
+	invoice := api.InvoiceResponse{
+		ID: id, Handle: "inv-" + id, Customer: "cust-1", Currency: "DKK", Created: created,
+		Plan: "plan-basic", OrgAmount: amount, AmountVAT: amount / 5, State: state,
+	}
+	if state == "settled" {
+		invoice.Settled = created.Add(time.Minute)
+	}
+	return invoice
+}
+
+// reconcileFixture stores a matching, a mismatched and, if extra, an extra invoice, and
+// leaves one invoice only in Frisbii
+func reconcileFixture(t *testing.T, extra bool) *Syncer {
+	t.Helper()
+	fake := newFakeFrisbii(t)
+	ctx := context.Background()
+	fake.AddInvoice("DK", reconcileInvoice("match", "settled", 1000, 1))
+	fake.AddInvoice("DK", reconcileInvoice("mismatch", "pending", 1500, 2))
+
+	store := database.NewMemoryStore()
+	s := New(store, Options{})
+	if result, err := s.SyncChanges(ctx, "DK", EntityInvoice); err != nil || result.Saved != 2 {
+		t.Fatalf("SyncChanges = %+v, %v; want 2 saved", result, err)
+	}
+	if extra {
+		invoice := database.Invoice{
+			ID: "extra", Handle: "inv-extra", Customer: "cust-1", Currency: "DKK", Created: reconcileDay.Add(4 * time.Hour),
+			OrgAmount: money.New(500, "DKK"), Country: "DK", Plan: "plan-basic", State: "created",
+		}
+		if err := store.CreateOrUpdateInvoice(ctx, &invoice, database.SourceSync); err != nil {
+			t.Fatalf("CreateOrUpdateInvoice: %v", err)
+		}
+	}
+
+	// Settled with another amount, and a new invoice, neither of which reached the database
+	fake.AddInvoice("DK", reconcileInvoice("mismatch", "settled", 2000, 2))
+	fake.AddInvoice("DK", reconcileInvoice("missing", "settled", 3000, 3))
+	return s
+}
+
+func reconcileDK(t *testing.T, s *Syncer, opts ReconcileOptions) (database.ReconcileRun, ReconcileReport) {
+	t.Helper()
+	opts.Accounts, opts.From, opts.To = []string{"DK"}, reconcileDay, reconcileDay.Add(24*time.Hour)
+	runs, err := s.Reconcile(context.Background(), opts)
+	if err != nil || len(runs) != 1 {
+		t.Fatalf("Reconcile = %+v, %v", runs, err)
+	}
+	var report ReconcileReport
+	if len(runs[0].Report) > 0 {
+		if err := json.Unmarshal(runs[0].Report, &report); err != nil {
+			t.Fatalf("unmarshal report: %v", err)
+		}
+	}
+	return runs[0], report
+}
+
+func TestReconcileClassifiesDifferences(t *testing.T) {
+	s := reconcileFixture(t, true)
+	run, report := reconcileDK(t, s, ReconcileOptions{DryRun: true})
+
+	if run.Status != database.ReconcileDifferences || run.Missing != 1 || run.Extra != 1 || run.Mismatched != 1 || run.Repaired != 0 {
+		t.Errorf("run = %+v, want one missing, extra and mismatched invoice", run)
+	}
+	kinds := map[string]string{}
+	for _, d := range report.Differences {
+		kinds[d.InvoiceID] = d.Kind
+		if d.InvoiceID == "mismatch" && !slices.Equal(d.Fields, []string{"org_amount", "amount_vat", "state", "states"}) {
+			t.Errorf("mismatched fields = %v", d.Fields)
+		}
+	}
+	want := map[string]string{"missing": DifferenceMissing, "extra": DifferenceExtra, "mismatch": DifferenceMismatched}
+	if len(kinds) != len(want) {
+		t.Errorf("differences = %v, want %v", kinds, want)
+	}
+	for id, kind := range want {
+		if kinds[id] != kind {
+			t.Errorf("%s is %q, want %q", id, kinds[id], kind)
+		}
+	}
+
+	wantDay := ReconcileDay{
+		Day: "2025-02-03", Currency: "DKK",
+		Frisbii:  ReconcileTotals{Count: 3, OrgAmount: 6000, AmountVAT: 1200, SettledAmount: 6000},
+		Database: ReconcileTotals{Count: 3, OrgAmount: 3000, AmountVAT: 500, SettledAmount: 1000},
+	}
+	if len(report.Days) != 1 || report.Days[0] != wantDay {
+		t.Errorf("days = %+v, want %+v", report.Days, wantDay)
+	}
+
+	// A dry run neither repairs nor stores a run
+	if runs, err := s.store.GetReconcileRuns(context.Background(), 10); err != nil || len(runs) != 0 {
+		t.Errorf("stored runs = %+v, %v; want none", runs, err)
+	}
+	if invoices, err := s.store.GetInvoicesByID(context.Background(), []string{"missing"}); err != nil || len(invoices) != 0 {
+		t.Errorf("missing invoice = %+v, %v; want it still missing", invoices, err)
+	}
+}
+
+func TestReconcileRepair(t *testing.T) {
+	s := reconcileFixture(t, true)
+	ctx := context.Background()
+
+	// Extra invoices are only reported, so the run still has differences
+	run, report := reconcileDK(t, s, ReconcileOptions{Repair: true})
+	if run.Status != database.ReconcileDifferences || run.Repaired != 2 || run.ID == 0 {
+		t.Errorf("run = %+v, want 2 repaired with differences left", run)
+	}
+	for _, d := range report.Differences {
+		if d.Repaired != (d.Kind != DifferenceExtra) {
+			t.Errorf("%s %s repaired = %v", d.Kind, d.InvoiceID, d.Repaired)
+		}
+	}
+	invoices, err := s.store.GetInvoicesByID(ctx, []string{"missing", "mismatch"})
+	if err != nil || len(invoices) != 2 {
+		t.Fatalf("repaired invoices = %+v, %v", invoices, err)
+	}
+	for _, invoice := range invoices {
+		if invoice.State != "settled" {
+			t.Errorf("%s state = %q, want settled", invoice.ID, invoice.State)
+		}
+	}
+	transitions, err := s.store.GetInvoiceStateTransitions(ctx, "mismatch")
+	if err != nil || len(transitions) == 0 || transitions[len(transitions)-1].Source != database.SourceReconcile {
+		t.Errorf("transitions of mismatch = %+v, %v; want the last from %s", transitions, err, database.SourceReconcile)
+	}
+	if stored, err := s.store.GetReconcileRun(ctx, run.ID); err != nil || stored == nil || stored.Status != run.Status {
+		t.Errorf("stored run = %+v, %v", stored, err)
+	}
+
+	if again, _ := reconcileDK(t, s, ReconcileOptions{}); again.Status != database.ReconcileDifferences || again.Extra != 1 || again.Missing+again.Mismatched != 0 {
+		t.Errorf("run after repair = %+v, want only the extra invoice", again)
+	}
+}
+
+func TestReconcileRepairsEveryDifference(t *testing.T) {
+	s := reconcileFixture(t, false)
+
+	if run, _ := reconcileDK(t, s, ReconcileOptions{Repair: true}); run.Status != database.ReconcileRepaired || run.Repaired != 2 {
+		t.Errorf("run = %+v, want repaired", run)
+	}
+	if run, report := reconcileDK(t, s, ReconcileOptions{}); run.Status != database.ReconcileMatched || len(report.Differences) != 0 || !report.Days[0].Matches {
+		t.Errorf("run after repair = %+v, %+v; want matched", run, report)
+	}
+}
+
+func TestCompareInvoicesPerDayAndCurrency(t *testing.T) {
+	invoice := func(id, currency string, day int, amount int64) database.Invoice {
+		return database.Invoice{
+			ID: id, Currency: currency, Created: reconcileDay.AddDate(0, 0, day).Add(23 * time.Hour), State: "created",
+			OrgAmount: money.New(amount, currency),
+		}
+	}
+	frisbii := []database.Invoice{invoice("a", "DKK", 0, 100), invoice("b", "EUR", 0, 200), invoice("c", "DKK", 1, 300)}
+	stored := []database.Invoice{invoice("a", "DKK", 0, 100), invoice("b", "EUR", 0, 200), invoice("c", "DKK", 1, 300)}
+
+	report := compareInvoices(frisbii, stored)
+	if len(report.Differences) != 0 {
+		t.Errorf("differences = %+v, want none", report.Differences)
+	}
+	var days []string
+	for _, day := range report.Days {
+		days = append(days, day.Day+" "+day.Currency)
+		if !day.Matches || day.Frisbii.Count != 1 {
+			t.Errorf("day = %+v, want one matching invoice", day)
+		}
+	}
+	if want := []string{"2025-02-03 DKK", "2025-02-03 EUR", "2025-02-04 DKK"}; !slices.Equal(days, want) {
+		t.Errorf("days = %v, want %v", days, want)
+	}
+}
@@ -0,0 +1,121 @@
+package syncer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/AndersKaae/legaldesk_psp_sync/api"
+	"github.com/AndersKaae/legaldesk_psp_sync/database"
+)
+
+// MaxResync is the most invoices or customers one bulk resync accepts
+const MaxResync = 100
+
+// ResyncResult is the outcome of resyncing one invoice or customer of a bulk resync
+type ResyncResult struct {
+	Key   string      `json:"key"`            // Invoice id or handle, or customer handle, as requested
+	Diff  *RecordDiff `json:"diff,omitempty"` // How the stored row changed
+	Error string      `json:"error,omitempty"`
+}
+
+// fetchInvoiceDiff fetches one invoice by id or handle and compares it with its stored row
+func (s *Syncer) fetchInvoiceDiff(ctx context.Context, country, invoiceID string) (api.Invoice, RecordDiff, error) {
+	apiInvoice, err := api.GetInvoice(invoiceID, country)
+	if err != nil {
+		return api.Invoice{}, RecordDiff{}, fmt.Errorf("fetch invoice: %w", err)
+	}
+	diffs, err := s.diffInvoices(ctx, country, []api.Invoice{apiInvoice})
+	if err != nil {
+		return api.Invoice{}, RecordDiff{}, fmt.Errorf("compare invoice: %w", err)
+	}
+	return apiInvoice, diffs[0], nil
+}
+
+// fetchCustomerDiff fetches one customer and compares it with its stored row. An empty
+// country looks the customer up in every account and uses the first that has it.
+func (s *Syncer) fetchCustomerDiff(ctx context.Context, country, handle string) (api.Customer, RecordDiff, error) {
+	accounts := Countries
+	if country != "" {
+		accounts = []string{country}
+	}
+	for _, account := range accounts {
+		apiCustomer, err := api.GetCustomer(handle, account)
+		if errors.Is(err, api.ErrNotFound) && country == "" {
+			continue
+		}
+		if err != nil {
+			return api.Customer{}, RecordDiff{}, fmt.Errorf("fetch customer: %w", err)
+		}
+		diffs, err := s.diffCustomers(ctx, account, []api.Customer{apiCustomer})
+		if err != nil {
+			return api.Customer{}, RecordDiff{}, fmt.Errorf("compare customer: %w", err)
+		}
+		return apiCustomer, diffs[0], nil
+	}
+	return api.Customer{}, RecordDiff{}, fmt.Errorf("fetch customer: customer %s %w in any of %v", handle, api.ErrNotFound, Countries)
+}
+
+// ResyncInvoice fetches one invoice by id or handle, saves it and returns how its stored row
+// changed
+func (s *Syncer) ResyncInvoice(ctx context.Context, country, invoiceID string) (RecordDiff, error) {
+	apiInvoice, diff, err := s.fetchInvoiceDiff(ctx, country, invoiceID)
+	if err != nil {
+		return RecordDiff{}, err
+	}
+	dbInvoice := InvoiceToDB(apiInvoice)
+	if err := s.store.CreateOrUpdateInvoice(ctx, &dbInvoice, database.SourceManual); err != nil {
+		return RecordDiff{}, fmt.Errorf("save invoice: %w", err)
+	}
+	log.Printf("Resynced invoice %s for %s: %s", apiInvoice.Handle, country, diff.Kind)
+	return diff, nil
+}
+
+// ResyncCustomer fetches one customer, saves it and returns how its stored row changed. An
+// empty country looks the customer up in every account.
+func (s *Syncer) ResyncCustomer(ctx context.Context, country, handle string) (RecordDiff, error) {
+	apiCustomer, diff, err := s.fetchCustomerDiff(ctx, country, handle)
+	if err != nil {
+		return RecordDiff{}, err
+	}
+	dbCustomer := CustomerToDB(apiCustomer)
+	if err := s.store.CreateOrUpdateCustomer(ctx, &dbCustomer); err != nil {
+		return RecordDiff{}, fmt.Errorf("save customer: %w", err)
+	}
+	log.Printf("Resynced customer %s for %s: %s", handle, diff.Account, diff.Kind)
+	return diff, nil
+}
+
+// ResyncInvoices resyncs invoices one after the other within the rate limit of the account
+// and returns a result per invoice
+func (s *Syncer) ResyncInvoices(ctx context.Context, country string, invoiceIDs []string) []ResyncResult {
+	return s.resyncAll(ctx, country, invoiceIDs, s.ResyncInvoice)
+}
+
+// ResyncCustomers resyncs customers one after the other and returns a result per customer
+func (s *Syncer) ResyncCustomers(ctx context.Context, country string, handles []string) []ResyncResult {
+	return s.resyncAll(ctx, country, handles, s.ResyncCustomer)
+}
+
+// resyncAll resyncs every key within the rate limit of country; once ctx is cancelled the
+// remaining keys fail with its error
+func (s *Syncer) resyncAll(ctx context.Context, country string, keys []string,
+	resync func(ctx context.Context, country, key string) (RecordDiff, error)) []ResyncResult {
+	results := make([]ResyncResult, 0, len(keys))
+	for _, key := range keys {
+		result := ResyncResult{Key: key}
+		err := s.limiter(country).Wait(ctx)
+		if err == nil {
+			var diff RecordDiff
+			if diff, err = resync(ctx, country, key); err == nil {
+				result.Diff = &diff
+			}
+		}
+		if err != nil {
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+	return results
+}
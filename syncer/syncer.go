@@ -0,0 +1,76 @@
+// Package syncer copies Frisbii data into a database.Store, both for single entities
+// (webhooks) and in bulk (backfill).
+package syncer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/AndersKaae/legaldesk_psp_sync/api"
+	"github.com/AndersKaae/legaldesk_psp_sync/database"
+)
+
+// Options tune how a Syncer writes
+type Options struct {
+	BatchSize         int           // Rows per multi-row upsert when backfilling; 0 saves a page in one statement
+	Workers           int           // Account/entity pairs backfilled concurrently; 0 means one at a time
+	RequestsPerSecond int           // List requests per second per account during backfill; 0 means no limit
+	Window            Window        // Default size of the date windows a backfill is split into
+	Holder            string        // Name of this process in leases; empty means host name and process ID
+	RefreshLookback   time.Duration // Age of the oldest open invoice SyncChanges refreshes; 0 refreshes none
+}
+
+// Syncer fetches entities from the api package and writes them to a store
+type Syncer struct {
+	store database.Store
+	opts  Options
+
+	limitersMu sync.Mutex
+	limiters   map[string]*rateLimiter // By country
+}
+
+// New returns a Syncer writing to store
+func New(store database.Store, opts Options) *Syncer {
+	if opts.Holder == "" {
+		opts.Holder = defaultHolder()
+	}
+	return &Syncer{store: store, opts: opts, limiters: make(map[string]*rateLimiter)}
+}
+
+// Store returns the store the Syncer writes to
+func (s *Syncer) Store() database.Store {
+	return s.store
+}
+
+// SyncInvoice fetches one invoice and upserts it
+func (s *Syncer) SyncInvoice(ctx context.Context, country, invoiceID, source string) (database.Invoice, error) {
+	apiInvoice, err := api.GetInvoice(invoiceID, country)
+	if err != nil {
+		return database.Invoice{}, fmt.Errorf("fetch invoice: %w", err)
+	}
+	log.Printf("Fetched invoice %s for %s", apiInvoice.Handle, country)
+
+	dbInvoice := InvoiceToDB(apiInvoice)
+	if err := s.store.CreateOrUpdateInvoice(ctx, &dbInvoice, source); err != nil {
+		return database.Invoice{}, fmt.Errorf("save invoice: %w", err)
+	}
+	return dbInvoice, nil
+}
+
+// SyncCustomer fetches one customer and upserts it
+func (s *Syncer) SyncCustomer(ctx context.Context, country, handle string) (database.Customer, error) {
+	apiCustomer, err := api.GetCustomer(handle, country)
+	if err != nil {
+		return database.Customer{}, fmt.Errorf("fetch customer: %w", err)
+	}
+	log.Printf("Fetched customer %s for %s", apiCustomer.Handle, country)
+
+	dbCustomer := CustomerToDB(apiCustomer)
+	if err := s.store.CreateOrUpdateCustomer(ctx, &dbCustomer); err != nil {
+		return database.Customer{}, fmt.Errorf("save customer: %w", err)
+	}
+	return dbCustomer, nil
+}
@@ -0,0 +1,91 @@
+package syncer
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AndersKaae/legaldesk_psp_sync/api"
+)
+
+// Window is the size of the date windows a backfill splits its range into. Each window is
+// its own pagination chain, checkpointed and retried independently. The zero Window
+// backfills the whole range in one chain.
+type Window struct {
+	Months int
+	Days   int
+}
+
+// ParseWindow parses a window size: day, week, month, quarter, year, none, or a number of
+// days or months such as 10d or 2m
+func ParseWindow(value string) (Window, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "", "none":
+		return Window{}, nil
+	case "day":
+		return Window{Days: 1}, nil
+	case "week":
+		return Window{Days: 7}, nil
+	case "month":
+		return Window{Months: 1}, nil
+	case "quarter":
+		return Window{Months: 3}, nil
+	case "year":
+		return Window{Months: 12}, nil
+	}
+	if n, err := strconv.Atoi(strings.TrimSuffix(value, "d")); err == nil && n > 0 && strings.HasSuffix(value, "d") {
+		return Window{Days: n}, nil
+	}
+	if n, err := strconv.Atoi(strings.TrimSuffix(value, "m")); err == nil && n > 0 && strings.HasSuffix(value, "m") {
+		return Window{Months: n}, nil
+	}
+	return Window{}, fmt.Errorf("invalid backfill window %s, expected day, week, month, quarter, year, none or a number of days or months such as 10d or 2m", value)
+}
+
+// IsZero reports whether the window is the whole range
+func (w Window) IsZero() bool {
+	return w.Months <= 0 && w.Days <= 0
+}
+
+func (w Window) String() string {
+	switch {
+	case w.Months > 0:
+		return fmt.Sprintf("%dm", w.Months)
+	case w.Days > 0:
+		return fmt.Sprintf("%dd", w.Days)
+	}
+	return "none"
+}
+
+// end returns the end of the window starting at t. Month windows end at the start of a
+// calendar month, so their bounds do not depend on the day the range starts.
+func (w Window) end(t time.Time) time.Time {
+	if w.Months > 0 {
+		year, month, _ := t.Date()
+		return time.Date(year, month+time.Month(w.Months), 1, 0, 0, 0, 0, time.UTC)
+	}
+	return t.AddDate(0, 0, w.Days)
+}
+
+// split returns consecutive windows covering r. An open ended range ends with an open ended
+// window starting no later than now, so items created during the backfill are included.
+func (w Window) split(r api.DateRange, now time.Time) []api.DateRange {
+	if w.IsZero() || r.From.IsZero() {
+		return []api.DateRange{r}
+	}
+	limit := r.To
+	if limit.IsZero() {
+		limit = now
+	}
+	var windows []api.DateRange
+	from := r.From.UTC()
+	for {
+		to := w.end(from)
+		if !to.Before(limit) {
+			return append(windows, api.DateRange{From: from, To: r.To})
+		}
+		windows = append(windows, api.DateRange{From: from, To: to})
+		from = to
+	}
+}